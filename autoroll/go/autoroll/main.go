@@ -25,6 +25,7 @@ import (
 	"github.com/gorilla/mux"
 	"golang.org/x/oauth2"
 
+	"go.skia.org/infra/autoroll/go/chat_commands"
 	"go.skia.org/infra/autoroll/go/google3"
 	"go.skia.org/infra/autoroll/go/roller"
 	"go.skia.org/infra/go/chatbot"
@@ -48,8 +49,9 @@ const (
 )
 
 var (
-	arb AutoRollerI = nil
-	cfg roller.AutoRollerConfig
+	arb          AutoRollerI = nil
+	cfg          roller.AutoRollerConfig
+	chatCommands *chat_commands.Commands = nil
 
 	mainTemplate *template.Template = nil
 )
@@ -217,6 +219,9 @@ func runServer(serverURL string) {
 	r.HandleFunc("/json/strategy", strategyJsonHandler).Methods("POST")
 	r.HandleFunc("/json/unthrottle", unthrottleHandler).Methods("POST")
 	r.HandleFunc("/json/version", skiaversion.JsonHandler)
+	if chatCommands != nil {
+		r.HandleFunc("/chat", chatCommands.Handler).Methods("POST")
+	}
 	r.HandleFunc("/oauth2callback/", login.OAuth2CallbackHandler)
 	r.HandleFunc("/logout/", login.LogoutHandler)
 	r.HandleFunc("/loginstatus/", login.StatusHandler)
@@ -349,6 +354,15 @@ func main() {
 		sklog.Fatal(err)
 	}
 
+	// Allow authorized users to control the roller via chat.
+	if cfg.ChatCommands != nil {
+		token := ""
+		if !*local {
+			token = metadata.Must(metadata.ProjectGet(chat_commands.VERIFICATION_TOKEN_METADATA_KEY))
+		}
+		chatCommands = chat_commands.New(arb, cfg.ChatCommands, token)
+	}
+
 	// Start the roller.
 	arb.Start(ctx, time.Minute /* tickFrequency */, 15*time.Minute /* repoFrequency */)
 
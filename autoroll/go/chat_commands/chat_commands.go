@@ -0,0 +1,223 @@
+// Package chat_commands allows authorized users to control an AutoRoller by
+// sending messages to its chat bot.
+package chat_commands
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go.skia.org/infra/autoroll/go/modes"
+	"go.skia.org/infra/go/allowed"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+const (
+	// Metadata key for the token used to verify that requests were sent by
+	// the chat server.
+	VERIFICATION_TOKEN_METADATA_KEY = "autoroll_chat_verification_token"
+
+	// Types of events sent by the chat server.
+	EVENT_TYPE_ADDED_TO_SPACE     = "ADDED_TO_SPACE"
+	EVENT_TYPE_MESSAGE            = "MESSAGE"
+	EVENT_TYPE_REMOVED_FROM_SPACE = "REMOVED_FROM_SPACE"
+
+	// Commands.
+	CMD_DRY_RUN  = "dry-run"
+	CMD_HELP     = "help"
+	CMD_RESUME   = "resume"
+	CMD_STOP     = "stop"
+	CMD_STRATEGY = "strategy"
+
+	// Message used for mode and strategy changes when the user does not
+	// provide one.
+	DEFAULT_MESSAGE = "Requested via chat."
+
+	HELP_TEXT = `Available commands:
+  stop [message]: Stop the roller.
+  resume [message]: Resume normal operation of the roller.
+  dry-run [message]: Put the roller into dry run mode.
+  strategy <strategy>[: message]: Change the next-roll-revision strategy.
+  help: Show this message.`
+)
+
+var (
+	// Maps commands to the modes they set.
+	cmdModes = map[string]string{
+		CMD_DRY_RUN: modes.MODE_DRY_RUN,
+		CMD_RESUME:  modes.MODE_RUNNING,
+		CMD_STOP:    modes.MODE_STOPPED,
+	}
+)
+
+// Config provides configuration for chat commands.
+type Config struct {
+	// Email addresses and domains of users who are allowed to control the
+	// roller via chat.
+	AuthorizedUsers []string `json:"authorizedUsers"`
+}
+
+// Validate the Config.
+func (c *Config) Validate() error {
+	if len(c.AuthorizedUsers) == 0 {
+		return fmt.Errorf("AuthorizedUsers is required.")
+	}
+	return nil
+}
+
+// AutoRoller is the subset of the AutoRoller's methods used by chat commands.
+type AutoRoller interface {
+	// SetMode sets the desired mode of the roller.
+	SetMode(ctx context.Context, mode, user, message string) error
+	// SetStrategy sets the desired next-roll-rev strategy.
+	SetStrategy(ctx context.Context, strategy, user, message string) error
+}
+
+// User is a chat user.
+type User struct {
+	DisplayName string `json:"displayName"`
+	Email       string `json:"email"`
+	Name        string `json:"name"`
+}
+
+// Message is a chat message.
+type Message struct {
+	// The text of the message, excluding mentions of the bot.
+	ArgumentText string `json:"argumentText"`
+	Sender       *User  `json:"sender"`
+	Text         string `json:"text"`
+}
+
+// Event is an event sent by the chat server.
+type Event struct {
+	Message *Message `json:"message"`
+	Token   string   `json:"token"`
+	Type    string   `json:"type"`
+	User    *User    `json:"user"`
+}
+
+// Response is a synchronous reply to an Event.
+type Response struct {
+	Text string `json:"text"`
+}
+
+// Commands handles chat commands for an AutoRoller.
+type Commands struct {
+	allowed allowed.Allow
+	roller  AutoRoller
+	token   string
+}
+
+// New returns a Commands instance. If token is empty, requests are not
+// verified; this should only be used for local testing.
+func New(r AutoRoller, c *Config, token string) *Commands {
+	return &Commands{
+		allowed: allowed.NewAllowedFromList(c.AuthorizedUsers),
+		roller:  r,
+		token:   token,
+	}
+}
+
+// splitMessage splits the given text into the first word and the remainder,
+// with whitespace trimmed.
+func splitMessage(text string) (string, string) {
+	text = strings.TrimSpace(text)
+	idx := strings.IndexFunc(text, unicode.IsSpace)
+	if idx < 0 {
+		return strings.ToLower(text), ""
+	}
+	return strings.ToLower(text[:idx]), strings.TrimSpace(text[idx:])
+}
+
+// Run executes the given command text on behalf of the given user and returns
+// the reply to send.
+func (c *Commands) Run(ctx context.Context, user, text string) string {
+	cmd, rest := splitMessage(text)
+	if cmd == "" || cmd == CMD_HELP {
+		return HELP_TEXT
+	}
+	if !c.allowed.Member(user) {
+		return fmt.Sprintf("Sorry, %s is not authorized to control this roller.", user)
+	}
+	if mode, ok := cmdModes[cmd]; ok {
+		message := rest
+		if message == "" {
+			message = DEFAULT_MESSAGE
+		}
+		if err := c.roller.SetMode(ctx, mode, user, message); err != nil {
+			sklog.Errorf("Failed to set mode via chat: %s", err)
+			return fmt.Sprintf("Failed to set mode: %s", err)
+		}
+		return fmt.Sprintf("Mode changed to %q.", mode)
+	} else if cmd == CMD_STRATEGY {
+		// Strategy names may contain spaces, so the message is
+		// separated from the strategy by a colon.
+		split := strings.SplitN(rest, ":", 2)
+		strategy := strings.TrimSpace(split[0])
+		message := DEFAULT_MESSAGE
+		if len(split) == 2 && strings.TrimSpace(split[1]) != "" {
+			message = strings.TrimSpace(split[1])
+		}
+		if strategy == "" {
+			return "Usage: strategy <strategy>[: message]"
+		}
+		if err := c.roller.SetStrategy(ctx, strategy, user, message); err != nil {
+			sklog.Errorf("Failed to set strategy via chat: %s", err)
+			return fmt.Sprintf("Failed to set strategy: %s", err)
+		}
+		return fmt.Sprintf("Strategy changed to %q.", strategy)
+	}
+	return fmt.Sprintf("Unknown command %q.\n%s", cmd, HELP_TEXT)
+}
+
+// HandleEvent handles the given Event and returns the reply to send, if any.
+func (c *Commands) HandleEvent(ctx context.Context, e *Event) string {
+	switch e.Type {
+	case EVENT_TYPE_ADDED_TO_SPACE:
+		return HELP_TEXT
+	case EVENT_TYPE_MESSAGE:
+		if e.Message == nil {
+			return ""
+		}
+		user := ""
+		if e.Message.Sender != nil {
+			user = e.Message.Sender.Email
+		} else if e.User != nil {
+			user = e.User.Email
+		}
+		text := e.Message.ArgumentText
+		if text == "" {
+			text = e.Message.Text
+		}
+		return c.Run(ctx, user, text)
+	default:
+		return ""
+	}
+}
+
+// Handler is an HTTP handler which receives Events from the chat server.
+func (c *Commands) Handler(w http.ResponseWriter, r *http.Request) {
+	var e Event
+	defer util.Close(r.Body)
+	if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+		httputils.ReportError(w, r, err, "Failed to decode request body.")
+		return
+	}
+	if c.token != "" && subtle.ConstantTimeCompare([]byte(c.token), []byte(e.Token)) != 1 {
+		sklog.Errorf("Received chat event with invalid verification token.")
+		http.Error(w, "Invalid verification token.", http.StatusForbidden)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&Response{
+		Text: c.HandleEvent(r.Context(), &e),
+	}); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode response.")
+	}
+}
@@ -0,0 +1,148 @@
+package chat_commands
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/autoroll/go/modes"
+	"go.skia.org/infra/go/testutils"
+)
+
+// change records a call to SetMode or SetStrategy.
+type change struct {
+	Value   string
+	User    string
+	Message string
+}
+
+// mockRoller is an AutoRoller implementation used for testing.
+type mockRoller struct {
+	modes      []change
+	strategies []change
+}
+
+// See documentation for AutoRoller interface.
+func (r *mockRoller) SetMode(ctx context.Context, mode, user, message string) error {
+	if mode != modes.MODE_RUNNING && mode != modes.MODE_STOPPED && mode != modes.MODE_DRY_RUN {
+		return fmt.Errorf("Invalid mode: %s", mode)
+	}
+	r.modes = append(r.modes, change{mode, user, message})
+	return nil
+}
+
+// See documentation for AutoRoller interface.
+func (r *mockRoller) SetStrategy(ctx context.Context, strategy, user, message string) error {
+	if strategy == "bogus" {
+		return fmt.Errorf("Invalid strategy: %s", strategy)
+	}
+	r.strategies = append(r.strategies, change{strategy, user, message})
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ctx := context.Background()
+	r := &mockRoller{}
+	c := New(r, &Config{
+		AuthorizedUsers: []string{"google.com", "friend@example.com"},
+	}, "")
+
+	// Help is available to everyone.
+	assert.Equal(t, HELP_TEXT, c.Run(ctx, "stranger@example.com", ""))
+	assert.Equal(t, HELP_TEXT, c.Run(ctx, "stranger@example.com", "help"))
+
+	// Unauthorized users can't change anything.
+	assert.Equal(t, "Sorry, stranger@example.com is not authorized to control this roller.", c.Run(ctx, "stranger@example.com", "stop"))
+	assert.Equal(t, 0, len(r.modes))
+
+	// Mode changes.
+	assert.Equal(t, "Mode changed to \"stopped\".", c.Run(ctx, "me@google.com", "  Stop   Broken tree\nwill resume later"))
+	assert.Equal(t, "Mode changed to \"dry run\".", c.Run(ctx, "friend@example.com", "dry-run"))
+	assert.Equal(t, "Mode changed to \"running\".", c.Run(ctx, "me@google.com", "resume Tree is green."))
+	assert.Equal(t, []change{
+		{modes.MODE_STOPPED, "me@google.com", "Broken tree\nwill resume later"},
+		{modes.MODE_DRY_RUN, "friend@example.com", DEFAULT_MESSAGE},
+		{modes.MODE_RUNNING, "me@google.com", "Tree is green."},
+	}, r.modes)
+
+	// Strategy changes.
+	assert.Equal(t, "Strategy changed to \"single\".", c.Run(ctx, "me@google.com", "strategy single"))
+	assert.Equal(t, "Strategy changed to \"remote batch\".", c.Run(ctx, "me@google.com", "strategy remote batch: Back to normal."))
+	assert.Equal(t, "Usage: strategy <strategy>[: message]", c.Run(ctx, "me@google.com", "strategy"))
+	assert.Equal(t, "Failed to set strategy: Invalid strategy: bogus", c.Run(ctx, "me@google.com", "strategy bogus"))
+	assert.Equal(t, []change{
+		{"single", "me@google.com", DEFAULT_MESSAGE},
+		{"remote batch", "me@google.com", "Back to normal."},
+	}, r.strategies)
+
+	// Unknown commands.
+	assert.Equal(t, "Unknown command \"explode\".\n"+HELP_TEXT, c.Run(ctx, "me@google.com", "explode now"))
+}
+
+func TestHandler(t *testing.T) {
+	testutils.SmallTest(t)
+
+	r := &mockRoller{}
+	c := New(r, &Config{
+		AuthorizedUsers: []string{"me@google.com"},
+	}, "secret")
+
+	post := func(e *Event, expectCode int, expectText string) {
+		b, err := json.Marshal(e)
+		assert.NoError(t, err)
+		req := httptest.NewRequest("POST", "/chat", bytes.NewReader(b))
+		w := httptest.NewRecorder()
+		c.Handler(w, req)
+		assert.Equal(t, expectCode, w.Code)
+		if expectCode == http.StatusOK {
+			var resp Response
+			assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, expectText, resp.Text)
+		}
+	}
+
+	msg := &Message{
+		ArgumentText: " stop Testing",
+		Sender: &User{
+			Email: "me@google.com",
+		},
+		Text: "@AutoRoller stop Testing",
+	}
+
+	// Requests with a missing or invalid token are rejected.
+	post(&Event{
+		Message: msg,
+		Type:    EVENT_TYPE_MESSAGE,
+	}, http.StatusForbidden, "")
+	post(&Event{
+		Message: msg,
+		Token:   "wrong",
+		Type:    EVENT_TYPE_MESSAGE,
+	}, http.StatusForbidden, "")
+	assert.Equal(t, 0, len(r.modes))
+
+	// Valid requests.
+	post(&Event{
+		Token: "secret",
+		Type:  EVENT_TYPE_ADDED_TO_SPACE,
+	}, http.StatusOK, HELP_TEXT)
+	post(&Event{
+		Message: msg,
+		Token:   "secret",
+		Type:    EVENT_TYPE_MESSAGE,
+	}, http.StatusOK, "Mode changed to \"stopped\".")
+	assert.Equal(t, []change{
+		{modes.MODE_STOPPED, "me@google.com", "Testing"},
+	}, r.modes)
+	post(&Event{
+		Token: "secret",
+		Type:  EVENT_TYPE_REMOVED_FROM_SPACE,
+	}, http.StatusOK, "")
+}
@@ -32,6 +32,9 @@ const (
 
 	subjectLastNFailed = "The last {{.N}} {{.ChildName}} into {{.ParentName}} rolls have failed"
 	bodyLastNFailed    = "The roll is failing consistently. Time to investigate. The most recent roll attempt is here: {{.IssueURL}}"
+
+	subjectBisectCulprit = "The {{.ChildName}} into {{.ParentName}} AutoRoller found the culprit of a failed roll"
+	bodyBisectCulprit    = "Bisection found that rolls began failing with {{.Commit}} by {{.Author}}: \"{{.CommitSubject}}\". A failed roll to that commit is here: {{.IssueURL}}"
 )
 
 var (
@@ -55,12 +58,18 @@ var (
 
 	subjectTmplLastNFailed = template.Must(template.New("subjectLastNFailed").Parse(subjectLastNFailed))
 	bodyTmplLastNFailed    = template.Must(template.New("bodyLastNFailed").Parse(bodyLastNFailed))
+
+	subjectTmplBisectCulprit = template.Must(template.New("subjectBisectCulprit").Parse(subjectBisectCulprit))
+	bodyTmplBisectCulprit    = template.Must(template.New("bodyBisectCulprit").Parse(bodyBisectCulprit))
 )
 
 // tmplVars is a struct which contains information used to fill
 // text templates in the Subject and Body fields of messages.
 type tmplVars struct {
+	Author         string
 	ChildName      string
+	Commit         string
+	CommitSubject  string
 	IssueID        string
 	IssueURL       string
 	Mode           string
@@ -190,3 +199,14 @@ func (a *AutoRollNotifier) SendLastNFailed(ctx context.Context, n int, url strin
 		N:        n,
 	}, subjectTmplLastNFailed, bodyTmplLastNFailed, notifier.SEVERITY_ERROR)
 }
+
+// Send a notification that bisection found the child commit which caused rolls
+// to fail.
+func (a *AutoRollNotifier) SendBisectCulprit(ctx context.Context, commit, author, subject, url string) {
+	a.send(ctx, &tmplVars{
+		Author:        author,
+		Commit:        commit,
+		CommitSubject: subject,
+		IssueURL:      url,
+	}, subjectTmplBisectCulprit, bodyTmplBisectCulprit, notifier.SEVERITY_ERROR)
+}
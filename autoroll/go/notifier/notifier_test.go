@@ -54,4 +54,10 @@ func TestNotifier(t *testing.T) {
 	assert.Equal(t, "The childRepo into parentRepo AutoRoller is throttled", t1.msgs[2].subject)
 	assert.Equal(t, fmt.Sprintf("The roller is throttled because it attempted to upload too many CLs in too short a time.  The roller will unthrottle at %s.", now.Format(time.RFC1123)), t1.msgs[2].m.Body)
 	assert.Equal(t, notifier.SEVERITY_ERROR, t1.msgs[2].m.Severity)
+
+	n.SendBisectCulprit(ctx, "abc123", "me@google.com", "Break everything", "https://codereview/456")
+	assert.Equal(t, 4, len(t1.msgs))
+	assert.Equal(t, "The childRepo into parentRepo AutoRoller found the culprit of a failed roll", t1.msgs[3].subject)
+	assert.Equal(t, "Bisection found that rolls began failing with abc123 by me@google.com: \"Break everything\". A failed roll to that commit is here: https://codereview/456", t1.msgs[3].m.Body)
+	assert.Equal(t, notifier.SEVERITY_ERROR, t1.msgs[3].m.Severity)
 }
@@ -134,6 +134,7 @@ func (r *androidRepoManager) Update(ctx context.Context) error {
 	r.lastRollRev = lastRollRev
 	r.nextRollRev = nextRollRev
 	r.commitsNotRolled = len(notRolled)
+	r.notRolled = notRolled
 	return nil
 }
 
@@ -310,15 +311,10 @@ func (r *androidRepoManager) CreateNewRoll(ctx context.Context, from, to string,
 		return 0, fmt.Errorf("Failed to create repo branch: %s", repoBranchErr)
 	}
 
-	// Get list of changes.
-	changeSummaries := []string{}
-	for _, c := range commits {
-		d, err := cr.Details(ctx, c)
-		if err != nil {
-			return 0, err
-		}
-		changeSummary := fmt.Sprintf("%s %s %s", d.Timestamp.Format("2006-01-02"), AUTHOR_EMAIL_RE.FindStringSubmatch(d.Author)[1], d.Subject)
-		changeSummaries = append(changeSummaries, changeSummary)
+	// Get the changelog.
+	changelog, err := r.getChangelog(ctx, from, to)
+	if err != nil {
+		return 0, err
 	}
 
 	// Create commit message.
@@ -335,7 +331,7 @@ https://%s.googlesource.com/%s.git/+log/%s
 
 Test: Presubmit checks will test this change.
 Exempt-From-Owner-Approval: The autoroll bot does not require owner approval.
-`, r.childPath, commitRange, len(commits), childRepoName, childRepoName, commitRange, strings.Join(changeSummaries, "\n"), fmt.Sprintf(COMMIT_MSG_FOOTER_TMPL, r.serverURL))
+`, r.childPath, commitRange, len(commits), childRepoName, childRepoName, commitRange, changelog.String(), fmt.Sprintf(COMMIT_MSG_FOOTER_TMPL, r.serverURL))
 
 	// Loop through all commits:
 	// * Collect all bugs from b/xyz to add the commit message later.
@@ -477,8 +473,8 @@ func (r *androidRepoManager) getCommitsNotRolled(ctx context.Context, lastRollRe
 }
 
 // See documentation for RepoManager interface.
-func (r *androidRepoManager) CreateNextRollStrategy(ctx context.Context, s string) (strategy.NextRollStrategy, error) {
-	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, UPSTREAM_REMOTE_NAME, r.childRepo, nil)
+func (r *androidRepoManager) CreateNextRollStrategy(ctx context.Context, s string, nBatchMaxCommits int) (strategy.NextRollStrategy, error) {
+	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, UPSTREAM_REMOTE_NAME, nBatchMaxCommits, r.childRepo, nil)
 }
 
 // See documentation for RepoManager interface.
@@ -76,7 +76,7 @@ func TestAndroidRepoManager(t *testing.T) {
 	g := &gerrit.MockedGerrit{IssueID: androidIssueNum}
 	rm, err := NewAndroidRepoManager(ctx, androidCfg(), wd, g, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	assert.Equal(t, fmt.Sprintf("%s/android_repo/%s", wd, childPath), rm.(*androidRepoManager).childDir)
@@ -93,7 +93,7 @@ func TestCreateNewAndroidRoll(t *testing.T) {
 	g := &gerrit.MockedGerrit{IssueID: androidIssueNum}
 	rm, err := NewAndroidRepoManager(ctx, androidCfg(), wd, g, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	issue, err := rm.CreateNewRoll(ctx, rm.LastRollRev(), rm.NextRollRev(), androidEmails, "", false)
@@ -161,7 +161,7 @@ func TestRanPreUploadStepsAndroid(t *testing.T) {
 	g := &gerrit.MockedGerrit{IssueID: androidIssueNum}
 	rm, err := NewAndroidRepoManager(ctx, androidCfg(), wd, g, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_REMOTE_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	ran := false
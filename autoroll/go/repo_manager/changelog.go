@@ -0,0 +1,277 @@
+package repo_manager
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"go.skia.org/infra/go/git"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+const (
+	// Component used for commits whose subject does not name a component.
+	COMPONENT_OTHER = "other"
+
+	// Components are only parsed from "component: subject" style subjects
+	// if they are at most this long, to avoid treating sentences as
+	// components.
+	MAX_COMPONENT_LENGTH = 30
+)
+
+var (
+	// Parses the component from subjects like "[component] subject".
+	componentBracketRegex = regexp.MustCompile(`^\[([^\]]+)\]`)
+	// Parses the component from subjects like "component: subject".
+	componentColonRegex = regexp.MustCompile(`^([\w./-]+):\s`)
+	// Parses the reverted commit from the body of a revert commit.
+	revertRegex = regexp.MustCompile(`(?m)^This reverts commit ([0-9a-f]{7,40})`)
+
+	// Words which look like components but are not.
+	notComponents = map[string]bool{
+		"reland": true,
+		"revert": true,
+	}
+)
+
+// ChangelogEntry describes a single commit included in a roll.
+type ChangelogEntry struct {
+	Author    string    `json:"author"`
+	Bugs      []string  `json:"bugs"`
+	Component string    `json:"component"`
+	Hash      string    `json:"hash"`
+	Subject   string    `json:"subject"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// String returns a one-line summary of the ChangelogEntry.
+func (e *ChangelogEntry) String() string {
+	rv := fmt.Sprintf("%s %s %s", e.Timestamp.Format("2006-01-02"), e.Author, e.Subject)
+	if len(e.Bugs) > 0 {
+		rv += fmt.Sprintf(" (%s)", strings.Join(e.Bugs, ", "))
+	}
+	return rv
+}
+
+// ChangelogGroup contains the commits in a roll which belong to one component.
+type ChangelogGroup struct {
+	Component string            `json:"component"`
+	Entries   []*ChangelogEntry `json:"entries"`
+}
+
+// ChangelogRevert describes a commit which was reverted by another commit in
+// the same roll. The pair has no net effect and is collapsed in the changelog.
+type ChangelogRevert struct {
+	Original *ChangelogEntry `json:"original"`
+	Revert   *ChangelogEntry `json:"revert"`
+}
+
+// Changelog is a structured summary of the commits included in a roll.
+type Changelog struct {
+	// All authors of commits in the roll, sorted.
+	Authors []string `json:"authors"`
+	// All bugs referenced by commits in the roll, sorted.
+	Bugs []string `json:"bugs"`
+	From string   `json:"from"`
+	// Commits, excluding reverted pairs, grouped by component. Groups are
+	// sorted by component, with COMPONENT_OTHER last, and entries within a
+	// group are in reverse chronological order.
+	Groups     []*ChangelogGroup  `json:"groups"`
+	NumCommits int                `json:"numCommits"`
+	Reverts    []*ChangelogRevert `json:"reverts"`
+	To         string             `json:"to"`
+}
+
+// shortHash returns an abbreviated version of the given commit hash.
+func shortHash(hash string) string {
+	if len(hash) > 12 {
+		return hash[:12]
+	}
+	return hash
+}
+
+// changelogAuthor returns the email address of the given commit author, which
+// may be of the form "Name (email)".
+func changelogAuthor(author string) string {
+	if m := AUTHOR_EMAIL_RE.FindStringSubmatch(author); m != nil {
+		return m[1]
+	}
+	return author
+}
+
+// changelogComponent returns the component named in the given commit subject,
+// or COMPONENT_OTHER if there is none.
+func changelogComponent(subject string) string {
+	m := componentBracketRegex.FindStringSubmatch(subject)
+	if m == nil {
+		m = componentColonRegex.FindStringSubmatch(subject)
+	}
+	if m == nil {
+		return COMPONENT_OTHER
+	}
+	component := strings.ToLower(strings.TrimSpace(m[1]))
+	if component == "" || len(component) > MAX_COMPONENT_LENGTH || notComponents[component] {
+		return COMPONENT_OTHER
+	}
+	return component
+}
+
+// changelogBugs returns the bugs referenced in the given commit message.
+func changelogBugs(body string) []string {
+	rv := []string{}
+	for project, bugs := range util.BugsFromCommitMsg(body) {
+		for _, bug := range bugs {
+			if strings.HasPrefix(bug, "b/") {
+				// Buganizer bugs are referenced as "b/1234".
+				rv = append(rv, bug)
+			} else {
+				rv = append(rv, fmt.Sprintf("%s:%s", project, bug))
+			}
+		}
+	}
+	sort.Strings(rv)
+	return rv
+}
+
+// NewChangelog returns a Changelog for a roll from one revision to another,
+// including the given commits, which are in reverse chronological order.
+func NewChangelog(from, to string, commits []*vcsinfo.LongCommit) *Changelog {
+	entries := make([]*ChangelogEntry, 0, len(commits))
+	authors := map[string]bool{}
+	bugs := map[string]bool{}
+	// Maps each reverted entry to the entry which reverts it.
+	revertedBy := map[*ChangelogEntry]*ChangelogEntry{}
+	reverts := map[*ChangelogEntry]bool{}
+	for _, c := range commits {
+		e := &ChangelogEntry{
+			Author:    changelogAuthor(c.Author),
+			Bugs:      changelogBugs(c.Body),
+			Component: changelogComponent(c.Subject),
+			Hash:      c.Hash,
+			Subject:   c.Subject,
+			Timestamp: c.Timestamp,
+		}
+		entries = append(entries, e)
+		authors[e.Author] = true
+		for _, b := range e.Bugs {
+			bugs[b] = true
+		}
+	}
+
+	// Find reverts of commits which are also in this roll. Commits are in
+	// reverse chronological order, so the original commit always follows
+	// its revert.
+	for i, e := range entries {
+		if reverts[e] {
+			continue
+		}
+		for _, m := range revertRegex.FindAllStringSubmatch(commits[i].Body, -1) {
+			for _, orig := range entries[i+1:] {
+				if strings.HasPrefix(orig.Hash, m[1]) && revertedBy[orig] == nil && !reverts[orig] {
+					revertedBy[orig] = e
+					reverts[e] = true
+					break
+				}
+			}
+		}
+	}
+
+	rv := &Changelog{
+		Authors:    util.StringSet(authors).Keys(),
+		Bugs:       util.StringSet(bugs).Keys(),
+		From:       from,
+		Groups:     []*ChangelogGroup{},
+		NumCommits: len(commits),
+		Reverts:    []*ChangelogRevert{},
+		To:         to,
+	}
+	sort.Strings(rv.Authors)
+	sort.Strings(rv.Bugs)
+	groups := map[string]*ChangelogGroup{}
+	for _, e := range entries {
+		if revert, ok := revertedBy[e]; ok {
+			rv.Reverts = append(rv.Reverts, &ChangelogRevert{
+				Original: e,
+				Revert:   revert,
+			})
+			continue
+		}
+		if reverts[e] {
+			continue
+		}
+		g, ok := groups[e.Component]
+		if !ok {
+			g = &ChangelogGroup{
+				Component: e.Component,
+				Entries:   []*ChangelogEntry{},
+			}
+			groups[e.Component] = g
+			rv.Groups = append(rv.Groups, g)
+		}
+		g.Entries = append(g.Entries, e)
+	}
+	sort.Slice(rv.Groups, func(i, j int) bool {
+		a, b := rv.Groups[i].Component, rv.Groups[j].Component
+		if a == COMPONENT_OTHER || b == COMPONENT_OTHER {
+			return b == COMPONENT_OTHER && a != COMPONENT_OTHER
+		}
+		return a < b
+	})
+	return rv
+}
+
+// String returns a human-readable version of the Changelog, suitable for
+// inclusion in a commit message.
+func (c *Changelog) String() string {
+	lines := []string{}
+	for _, g := range c.Groups {
+		lines = append(lines, fmt.Sprintf("%s:", g.Component))
+		for _, e := range g.Entries {
+			lines = append(lines, "  "+e.String())
+		}
+	}
+	if len(c.Reverts) > 0 {
+		if len(lines) > 0 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, "Reverted within this roll:")
+		for _, r := range c.Reverts {
+			lines = append(lines, fmt.Sprintf("  %s %s (reverted by %s)", shortHash(r.Original.Hash), r.Original.Subject, shortHash(r.Revert.Hash)))
+		}
+	}
+	if len(c.Authors) > 0 {
+		lines = append(lines, "", fmt.Sprintf("Authors: %s", strings.Join(c.Authors, ", ")))
+	}
+	if len(c.Bugs) > 0 {
+		lines = append(lines, fmt.Sprintf("Bugs: %s", strings.Join(c.Bugs, ", ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// getChangelog returns a Changelog for a roll from one revision of the child
+// repo to another.
+func (r *commonRepoManager) getChangelog(ctx context.Context, from, to string) (*Changelog, error) {
+	return getChangelog(ctx, r.childRepo, from, to)
+}
+
+// getChangelog returns a Changelog for a roll from one revision of the given
+// child repo to another.
+func getChangelog(ctx context.Context, childRepo *git.Checkout, from, to string) (*Changelog, error) {
+	hashes, err := childRepo.RevList(ctx, "--no-merges", fmt.Sprintf("%s..%s", from, to))
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list revisions: %s", err)
+	}
+	commits := make([]*vcsinfo.LongCommit, 0, len(hashes))
+	for _, h := range hashes {
+		d, err := childRepo.Details(ctx, h)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to obtain commit details: %s", err)
+		}
+		commits = append(commits, d)
+	}
+	return NewChangelog(from, to, commits), nil
+}
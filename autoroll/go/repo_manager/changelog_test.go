@@ -0,0 +1,95 @@
+package repo_manager
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+func TestChangelogComponent(t *testing.T) {
+	testutils.SmallTest(t)
+
+	assert.Equal(t, "gpu", changelogComponent("[gpu] Fix the thing"))
+	assert.Equal(t, "gpu", changelogComponent("GPU: Fix the thing"))
+	assert.Equal(t, "src/core", changelogComponent("src/core: Fix the thing"))
+	assert.Equal(t, COMPONENT_OTHER, changelogComponent("Fix the thing"))
+	assert.Equal(t, COMPONENT_OTHER, changelogComponent("Reland: Fix the thing"))
+	assert.Equal(t, COMPONENT_OTHER, changelogComponent("Fix the thing: for real this time"))
+	assert.Equal(t, COMPONENT_OTHER, changelogComponent("[] Fix the thing"))
+	assert.Equal(t, COMPONENT_OTHER, changelogComponent("[this is not really a component name] Fix"))
+}
+
+func TestChangelog(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ts := time.Date(2018, 6, 1, 12, 0, 0, 0, time.UTC)
+	commit := func(hash, author, subject, body string) *vcsinfo.LongCommit {
+		ts = ts.Add(-time.Hour)
+		return &vcsinfo.LongCommit{
+			ShortCommit: &vcsinfo.ShortCommit{
+				Hash:    hash,
+				Author:  author,
+				Subject: subject,
+			},
+			Body:      body,
+			Timestamp: ts,
+		}
+	}
+	// Commits are in reverse chronological order.
+	commits := []*vcsinfo.LongCommit{
+		commit("aaaaaaaaaaaaaaaaaaaa", "Alice (alice@google.com)", "[gpu] Add a feature", "BUG=skia:123\n"),
+		commit("bbbbbbbbbbbbbbbbbbbb", "Bob (bob@google.com)", "Revert \"[pdf] Break things\"", "This reverts commit cccccccccccc.\n\nBug: chromium:456"),
+		commit("dddddddddddddddddddd", "Carol (carol@google.com)", "Update docs", "Bug: b/789"),
+		commit("cccccccccccccccccccc", "Bob (bob@google.com)", "[pdf] Break things", ""),
+		commit("eeeeeeeeeeeeeeeeeeee", "Alice (alice@google.com)", "gpu: Prepare for a feature", "BUG=skia:123,skia:124\n"),
+		commit("ffffffffffffffffffff", "Dave (dave@google.com)", "Revert \"Something from an older roll\"", "This reverts commit 0123456789ab.\n"),
+	}
+	c := NewChangelog("0000000000000000", "aaaaaaaaaaaaaaaaaaaa", commits)
+	assert.Equal(t, 6, c.NumCommits)
+	assert.Equal(t, []string{"alice@google.com", "bob@google.com", "carol@google.com", "dave@google.com"}, c.Authors)
+	assert.Equal(t, []string{"b/789", "chromium:456", "skia:123", "skia:124"}, c.Bugs)
+
+	// The revert and the reverted commit are collapsed.
+	assert.Equal(t, 1, len(c.Reverts))
+	assert.Equal(t, "cccccccccccccccccccc", c.Reverts[0].Original.Hash)
+	assert.Equal(t, "bbbbbbbbbbbbbbbbbbbb", c.Reverts[0].Revert.Hash)
+
+	// The remaining commits are grouped by component.
+	assert.Equal(t, 2, len(c.Groups))
+	assert.Equal(t, "gpu", c.Groups[0].Component)
+	assert.Equal(t, 2, len(c.Groups[0].Entries))
+	assert.Equal(t, "aaaaaaaaaaaaaaaaaaaa", c.Groups[0].Entries[0].Hash)
+	assert.Equal(t, "eeeeeeeeeeeeeeeeeeee", c.Groups[0].Entries[1].Hash)
+	assert.Equal(t, COMPONENT_OTHER, c.Groups[1].Component)
+	assert.Equal(t, 2, len(c.Groups[1].Entries))
+	assert.Equal(t, "dddddddddddddddddddd", c.Groups[1].Entries[0].Hash)
+	assert.Equal(t, "ffffffffffffffffffff", c.Groups[1].Entries[1].Hash)
+
+	expect := `gpu:
+  2018-06-01 alice@google.com [gpu] Add a feature (skia:123)
+  2018-06-01 alice@google.com gpu: Prepare for a feature (skia:123, skia:124)
+other:
+  2018-06-01 carol@google.com Update docs (b/789)
+  2018-06-01 dave@google.com Revert "Something from an older roll"
+
+Reverted within this roll:
+  cccccccccccc [pdf] Break things (reverted by bbbbbbbbbbbb)
+
+Authors: alice@google.com, bob@google.com, carol@google.com, dave@google.com
+Bugs: b/789, chromium:456, skia:123, skia:124`
+	assert.Equal(t, expect, c.String())
+
+	// The Changelog can be serialized for the status endpoint.
+	b, err := json.Marshal(c)
+	assert.NoError(t, err)
+	var c2 Changelog
+	assert.NoError(t, json.Unmarshal(b, &c2))
+	assert.Equal(t, c.String(), c2.String())
+
+	// Empty rolls produce an empty changelog.
+	assert.Equal(t, "", NewChangelog("a", "a", nil).String())
+}
@@ -265,8 +265,8 @@ func (rm *afdoRepoManager) CommitsNotRolled() int {
 }
 
 // See documentation for RepoManager interface.
-func (r *afdoRepoManager) CreateNextRollStrategy(ctx context.Context, s string) (strategy.NextRollStrategy, error) {
-	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, r.childRepo, r.authClient)
+func (r *afdoRepoManager) CreateNextRollStrategy(ctx context.Context, s string, nBatchMaxCommits int) (strategy.NextRollStrategy, error) {
+	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, nBatchMaxCommits, r.childRepo, r.authClient)
 }
 
 // See documentation for RepoManager interface.
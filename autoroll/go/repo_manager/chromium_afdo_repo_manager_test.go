@@ -153,7 +153,7 @@ func TestAFDORepoManager(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewAFDORepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com", urlmock.Client())
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_AFDO))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_AFDO, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, mockUser, rm.User())
 	assert.Equal(t, afdoRevBase, rm.LastRollRev())
@@ -134,39 +134,20 @@ func (rm *copyRepoManager) Update(ctx context.Context) error {
 	rm.lastRollRev = lastRollRev
 	rm.nextRollRev = nextRollRev
 	rm.commitsNotRolled = len(notRolled)
+	rm.notRolled = notRolled
 	return nil
 }
 
-// See documentation for RepoManager interface.
-func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
-	rm.repoMtx.Lock()
-	defer rm.repoMtx.Unlock()
-
-	// Clean the checkout, get onto a fresh branch.
-	if err := rm.cleanParent(ctx); err != nil {
-		return 0, err
-	}
+// prepareRoll copies the child repo at the given revision into the parent
+// checkout, which must be on a fresh roll branch, and stages the changes.
+// Returns the commit message for the roll.
+func (rm *copyRepoManager) prepareRoll(ctx context.Context, from, to, cqExtraTrybots string) (string, error) {
 	parentRepo := git.GitDir(rm.parentDir)
-	if _, err := parentRepo.Git(ctx, "checkout", "-b", ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", rm.parentBranch), "-f"); err != nil {
-		return 0, err
-	}
-
-	// Defer some more cleanup.
-	defer func() {
-		util.LogErr(rm.cleanParent(ctx))
-	}()
 
 	// List the revisions in the roll.
 	commits, err := rm.childRepo.RevList(ctx, fmt.Sprintf("%s..%s", from, to))
 	if err != nil {
-		return 0, fmt.Errorf("Failed to list revisions: %s", err)
-	}
-
-	if _, err := parentRepo.Git(ctx, "config", "user.name", rm.user); err != nil {
-		return 0, err
-	}
-	if _, err := parentRepo.Git(ctx, "config", "user.email", rm.user); err != nil {
-		return 0, err
+		return "", fmt.Errorf("Failed to list revisions: %s", err)
 	}
 
 	// Find relevant bugs.
@@ -178,7 +159,7 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 		for _, c := range commits {
 			d, err := rm.childRepo.Details(ctx, c)
 			if err != nil {
-				return 0, fmt.Errorf("Failed to obtain commit details: %s", err)
+				return "", fmt.Errorf("Failed to obtain commit details: %s", err)
 			}
 			b := util.BugsFromCommitMsg(d.Body)
 			for _, bug := range b[monorailProject] {
@@ -189,18 +170,18 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 
 	// Roll the dependency.
 	if _, err := rm.childRepo.Git(ctx, "reset", "--hard", to); err != nil {
-		return 0, err
+		return "", err
 	}
 	childFullPath := path.Join(rm.workdir, rm.childPath)
 	childRelPath, err := filepath.Rel(parentRepo.Dir(), childFullPath)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 	if _, err := parentRepo.Git(ctx, "rm", "-r", childRelPath); err != nil {
-		return 0, err
+		return "", err
 	}
 	if err := os.MkdirAll(path.Dir(childFullPath), os.ModePerm); err != nil {
-		return 0, err
+		return "", err
 	}
 	if len(rm.whitelist) > 0 {
 		for _, w := range rm.whitelist {
@@ -208,25 +189,25 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 			dst := path.Join(childFullPath, w)
 			dstDir := path.Dir(dst)
 			if err := os.MkdirAll(dstDir, os.ModePerm); err != nil {
-				return 0, err
+				return "", err
 			}
 			if _, err := exec.RunCwd(ctx, rm.workdir, "cp", "-rT", src, dst); err != nil {
-				return 0, err
+				return "", err
 			}
 		}
 	} else {
 		if _, err := exec.RunCwd(ctx, rm.workdir, "cp", "-rT", rm.childRepo.Dir(), childFullPath); err != nil {
-			return 0, err
+			return "", err
 		}
 	}
 	if err := os.RemoveAll(path.Join(childFullPath, ".git")); err != nil {
-		return 0, err
+		return "", err
 	}
 	if err := ioutil.WriteFile(rm.versionFile, []byte(to), os.ModePerm); err != nil {
-		return 0, err
+		return "", err
 	}
 	if _, err := parentRepo.Git(ctx, "add", childFullPath); err != nil {
-		return 0, err
+		return "", err
 	}
 
 	// Get list of changes.
@@ -236,7 +217,7 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 		for _, c := range commits {
 			d, err := rm.childRepo.Details(ctx, c)
 			if err != nil {
-				return 0, err
+				return "", err
 			}
 			changeSummary := fmt.Sprintf("%s %s %s", d.Timestamp.Format("2006-01-02"), AUTHOR_EMAIL_RE.FindStringSubmatch(d.Author)[1], d.Subject)
 			changeSummaries = append(changeSummaries, changeSummary)
@@ -250,6 +231,37 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 	if cqExtraTrybots != "" {
 		commitMsg += "\n" + fmt.Sprintf(TMPL_CQ_INCLUDE_TRYBOTS, cqExtraTrybots)
 	}
+	return commitMsg, nil
+}
+
+// See documentation for RepoManager interface.
+func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	rm.repoMtx.Lock()
+	defer rm.repoMtx.Unlock()
+
+	// Clean the checkout, get onto a fresh branch.
+	if err := rm.checkoutRollBranch(ctx); err != nil {
+		return 0, err
+	}
+
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(rm.cleanParent(ctx))
+	}()
+
+	commitMsg, err := rm.prepareRoll(ctx, from, to, cqExtraTrybots)
+	if err != nil {
+		return 0, err
+	}
+	parentRepo := git.GitDir(rm.parentDir)
+	if _, err := parentRepo.Git(ctx, "config", "user.name", rm.user); err != nil {
+		return 0, err
+	}
+	if _, err := parentRepo.Git(ctx, "config", "user.email", rm.user); err != nil {
+		return 0, err
+	}
+
+	// Commit.
 	if _, err := parentRepo.Git(ctx, "commit", "-a", "-m", commitMsg); err != nil {
 		return 0, err
 	}
@@ -314,3 +326,33 @@ func (rm *copyRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 	}
 	return issue.Issue, nil
 }
+
+// See documentation for RollPreviewer interface.
+func (rm *copyRepoManager) PreviewRoll(ctx context.Context, from, to, cqExtraTrybots string) (*RollPreview, error) {
+	rm.repoMtx.Lock()
+	defer rm.repoMtx.Unlock()
+
+	if err := rm.checkoutRollBranch(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		util.LogErr(rm.cleanParent(ctx))
+	}()
+	commitMsg, err := rm.prepareRoll(ctx, from, to, cqExtraTrybots)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range rm.PreUploadSteps() {
+		if err := s(ctx, rm.parentDir); err != nil {
+			return nil, fmt.Errorf("Failed pre-upload step: %s", err)
+		}
+	}
+	diff, err := rm.diffParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RollPreview{
+		CommitMsg: commitMsg,
+		Diff:      diff,
+	}, nil
+}
@@ -92,7 +92,7 @@ func TestCopyRepoManager(t *testing.T) {
 	cfg.ChildPath = path.Join(path.Base(parent.RepoUrl()), childPath)
 	rm, err := NewCopyRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[0], rm.LastRollRev())
 	assert.Equal(t, childCommits[len(childCommits)-1], rm.NextRollRev())
@@ -137,7 +137,7 @@ func TestCopyCreateNewDEPSRoll(t *testing.T) {
 	cfg.ChildPath = path.Join(path.Base(parent.RepoUrl()), childPath)
 	rm, err := NewCopyRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	// Create a roll, assert that it's at tip of tree.
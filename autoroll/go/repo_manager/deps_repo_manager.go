@@ -3,14 +3,10 @@ package repo_manager
 import (
 	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"os"
 	"path"
 	"strings"
 	"text/template"
-	"time"
 
 	"go.skia.org/infra/go/depot_tools"
 	"go.skia.org/infra/go/exec"
@@ -27,7 +23,7 @@ const (
 
 {{.ChildRepo}}/+log/{{.From}}..{{.To}}
 
-{{.LogStr}}
+{{.Changelog}}
 Created with:
   gclient setdep -r {{.ChildPath}}@{{.To}}
 
@@ -134,6 +130,7 @@ func (dr *depsRepoManager) Update(ctx context.Context) error {
 	dr.lastRollRev = lastRollRev
 	dr.nextRollRev = nextRollRev
 	dr.commitsNotRolled = len(notRolled)
+	dr.notRolled = notRolled
 	return nil
 }
 
@@ -154,25 +151,26 @@ func getLocalPartOfEmailAddress(emailAddress string) string {
 	return strings.SplitN(emailAddress, "@", 2)[0]
 }
 
-// Helper function for building the commit message.
-func buildCommitMsg(from, to, childPath, cqExtraTrybots, remoteUrl, serverURL, logStr string, bugs []string, numCommits int, includeLog bool) (string, error) {
+// Helper function for building the commit message. The changelog is only
+// included if includeLog is true.
+func buildCommitMsg(from, to, childPath, cqExtraTrybots, remoteUrl, serverURL string, changelog *Changelog, bugs []string, includeLog bool) (string, error) {
 	data := struct {
 		ChildPath  string
 		ChildRepo  string
+		Changelog  string
 		From       string
 		To         string
 		NumCommits int
 		LogURL     string
-		LogStr     string
 		ServerURL  string
 		Footer     string
 	}{
 		ChildPath:  childPath,
 		ChildRepo:  remoteUrl,
+		Changelog:  "",
 		From:       from[:12],
 		To:         to[:12],
-		NumCommits: numCommits,
-		LogStr:     "",
+		NumCommits: changelog.NumCommits,
 		ServerURL:  serverURL,
 		Footer:     "",
 	}
@@ -182,9 +180,8 @@ func buildCommitMsg(from, to, childPath, cqExtraTrybots, remoteUrl, serverURL, l
 	if len(bugs) > 0 {
 		data.Footer += "\n\nBUG=" + strings.Join(bugs, ",")
 	}
-	if includeLog {
-		data.LogStr = fmt.Sprintf("\ngit log %s..%s --date=short --no-merges --format='%%ad %%ae %%s'\n", from[:12], to[:12])
-		data.LogStr += logStr + "\n"
+	if includeLog && changelog.NumCommits > 0 {
+		data.Changelog = "\n" + changelog.String() + "\n"
 	}
 	var buf bytes.Buffer
 	if err := commitMsgTmpl.Execute(&buf, data); err != nil {
@@ -196,50 +193,26 @@ func buildCommitMsg(from, to, childPath, cqExtraTrybots, remoteUrl, serverURL, l
 
 // Helper function for building the commit message.
 func (dr *depsRepoManager) buildCommitMsg(ctx context.Context, from, to, cqExtraTrybots string, bugs []string) (string, error) {
-	logStr, err := exec.RunCwd(ctx, dr.childDir, "git", "log", fmt.Sprintf("%s..%s", from, to), "--date=short", "--no-merges", "--format=%ad %ae %s")
+	remoteUrl, err := exec.RunCwd(ctx, dr.childDir, "git", "remote", "get-url", "origin")
 	if err != nil {
 		return "", err
 	}
-	logStr = strings.TrimSpace(logStr)
-	numCommits := len(strings.Split(logStr, "\n"))
-	remoteUrl, err := exec.RunCwd(ctx, dr.childDir, "git", "remote", "get-url", "origin")
+	remoteUrl = strings.TrimSpace(remoteUrl)
+	changelog, err := dr.getChangelog(ctx, from, to)
 	if err != nil {
 		return "", err
 	}
-	remoteUrl = strings.TrimSpace(remoteUrl)
-	return buildCommitMsg(from, to, dr.childPath, cqExtraTrybots, remoteUrl, dr.serverURL, logStr, bugs, numCommits, dr.includeLog)
+	return buildCommitMsg(from, to, dr.childPath, cqExtraTrybots, remoteUrl, dr.serverURL, changelog, bugs, dr.includeLog)
 }
 
-// See documentation for RepoManager interface.
-func (dr *depsRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
-	dr.repoMtx.Lock()
-	defer dr.repoMtx.Unlock()
-
-	// Clean the checkout, get onto a fresh branch.
-	if err := dr.cleanParent(ctx); err != nil {
-		return 0, err
-	}
-	if _, err := exec.RunCwd(ctx, dr.parentDir, "git", "checkout", "-b", ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", dr.parentBranch), "-f"); err != nil {
-		return 0, err
-	}
-
-	// Defer some more cleanup.
-	defer func() {
-		util.LogErr(dr.cleanParent(ctx))
-	}()
-
-	// Create the roll CL.
+// prepareRoll performs the roll from one revision to another in the parent
+// checkout, which must be on a fresh roll branch, and runs the pre-upload
+// steps. Returns the commit message for the roll. Nothing is committed.
+func (dr *depsRepoManager) prepareRoll(ctx context.Context, from, to, cqExtraTrybots string) (string, error) {
 	cr := dr.childRepo
 	commits, err := cr.RevList(ctx, fmt.Sprintf("%s..%s", from, to))
 	if err != nil {
-		return 0, fmt.Errorf("Failed to list revisions: %s", err)
-	}
-
-	if _, err := exec.RunCwd(ctx, dr.parentDir, "git", "config", "user.name", getLocalPartOfEmailAddress(dr.user)); err != nil {
-		return 0, err
-	}
-	if _, err := exec.RunCwd(ctx, dr.parentDir, "git", "config", "user.email", dr.user); err != nil {
-		return 0, err
+		return "", fmt.Errorf("Failed to list revisions: %s", err)
 	}
 
 	// Find relevant bugs.
@@ -252,7 +225,7 @@ func (dr *depsRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 			for _, c := range commits {
 				d, err := cr.Details(ctx, c)
 				if err != nil {
-					return 0, fmt.Errorf("Failed to obtain commit details: %s", err)
+					return "", fmt.Errorf("Failed to obtain commit details: %s", err)
 				}
 				b := util.BugsFromCommitMsg(d.Body)
 				for _, bug := range b[monorailProject] {
@@ -271,78 +244,68 @@ func (dr *depsRepoManager) CreateNewRoll(ctx context.Context, from, to string, e
 		Name: dr.gclient,
 		Args: args,
 	}); err != nil {
-		return 0, err
+		return "", err
 	}
 
 	// Build the commit message.
 	commitMsg, err := dr.buildCommitMsg(ctx, from, to, cqExtraTrybots, bugs)
 	if err != nil {
-		return 0, err
+		return "", err
 	}
 
 	// Run the pre-upload steps.
 	for _, s := range dr.PreUploadSteps() {
 		if err := s(ctx, dr.parentDir); err != nil {
-			return 0, fmt.Errorf("Failed pre-upload step: %s", err)
+			return "", fmt.Errorf("Failed pre-upload step: %s", err)
 		}
 	}
+	return commitMsg, nil
+}
 
-	// Commit.
-	if _, err := exec.RunCwd(ctx, dr.parentDir, "git", "commit", "-a", "-m", commitMsg); err != nil {
-		return 0, err
-	}
-
-	// Upload the CL.
-	uploadCmd := &exec.Command{
-		Dir:     dr.parentDir,
-		Env:     depot_tools.Env(dr.depotTools),
-		Name:    "git",
-		Args:    []string{"cl", "upload", "--bypass-hooks", "-f", "-v", "-v"},
-		Timeout: 2 * time.Minute,
-	}
-	if dryRun {
-		uploadCmd.Args = append(uploadCmd.Args, "--cq-dry-run")
-	} else {
-		uploadCmd.Args = append(uploadCmd.Args, "--use-commit-queue")
-	}
-	uploadCmd.Args = append(uploadCmd.Args, "--gerrit")
-	tbr := "\nTBR="
-	if emails != nil && len(emails) > 0 {
-		emailStr := strings.Join(emails, ",")
-		tbr += emailStr
-		uploadCmd.Args = append(uploadCmd.Args, "--send-mail", "--cc", emailStr)
-	}
-	commitMsg += tbr
-	uploadCmd.Args = append(uploadCmd.Args, "-m", commitMsg)
+// See documentation for RepoManager interface.
+func (dr *depsRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	dr.repoMtx.Lock()
+	defer dr.repoMtx.Unlock()
 
-	// Upload the CL.
-	sklog.Infof("Running command: git %s", strings.Join(uploadCmd.Args, " "))
-	if _, err := exec.RunCommand(ctx, uploadCmd); err != nil {
+	// Clean the checkout, get onto a fresh branch.
+	if err := dr.checkoutRollBranch(ctx); err != nil {
 		return 0, err
 	}
 
-	// Obtain the issue number.
-	tmp, err := ioutil.TempDir("", "")
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(dr.cleanParent(ctx))
+	}()
+
+	// Create the roll CL.
+	commitMsg, err := dr.prepareRoll(ctx, from, to, cqExtraTrybots)
 	if err != nil {
 		return 0, err
 	}
-	defer util.RemoveAll(tmp)
-	jsonFile := path.Join(tmp, "issue.json")
-	if _, err := exec.RunCommand(ctx, &exec.Command{
-		Dir:  dr.parentDir,
-		Env:  depot_tools.Env(dr.depotTools),
-		Name: "git",
-		Args: []string{"cl", "issue", fmt.Sprintf("--json=%s", jsonFile)},
-	}); err != nil {
-		return 0, err
+	return dr.commitAndUpload(ctx, commitMsg, emails, dryRun)
+}
+
+// See documentation for RollPreviewer interface.
+func (dr *depsRepoManager) PreviewRoll(ctx context.Context, from, to, cqExtraTrybots string) (*RollPreview, error) {
+	dr.repoMtx.Lock()
+	defer dr.repoMtx.Unlock()
+
+	if err := dr.checkoutRollBranch(ctx); err != nil {
+		return nil, err
 	}
-	f, err := os.Open(jsonFile)
+	defer func() {
+		util.LogErr(dr.cleanParent(ctx))
+	}()
+	commitMsg, err := dr.prepareRoll(ctx, from, to, cqExtraTrybots)
 	if err != nil {
-		return 0, err
+		return nil, err
 	}
-	var issue issueJson
-	if err := json.NewDecoder(f).Decode(&issue); err != nil {
-		return 0, err
+	diff, err := dr.diffParent(ctx)
+	if err != nil {
+		return nil, err
 	}
-	return issue.Issue, nil
+	return &RollPreview{
+		CommitMsg: commitMsg,
+		Diff:      diff,
+	}, nil
 }
@@ -134,11 +134,18 @@ func TestDEPSRepoManager(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[0], rm.LastRollRev())
 	assert.Equal(t, childCommits[len(childCommits)-1], rm.NextRollRev())
 
+	// The not-rolled commits are listed in reverse chronological order.
+	notRolled := rm.(NotRolledLister).NotRolledCommits()
+	assert.Equal(t, len(childCommits)-1, len(notRolled))
+	for i, c := range notRolled {
+		assert.Equal(t, childCommits[len(childCommits)-1-i], c.Hash)
+	}
+
 	// Test FullChildHash.
 	for _, c := range childCommits {
 		h, err := rm.FullChildHash(ctx, c[:12])
@@ -165,11 +172,11 @@ func TestDEPSRepoManager(t *testing.T) {
 	assert.Equal(t, mockUser, rm.User())
 
 	// Switch next-roll-rev strategies.
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_SINGLE))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_SINGLE, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[1], rm.NextRollRev())
 	// And back again.
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, lastCommit, rm.NextRollRev())
 }
@@ -186,7 +193,7 @@ func testCreateNewDEPSRoll(t *testing.T, strategy string, expectIdx int) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	// Create a roll, assert that it's at tip of tree.
@@ -226,7 +233,7 @@ func TestRanPreUploadStepsDeps(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	ran := false
@@ -243,6 +250,50 @@ func TestRanPreUploadStepsDeps(t *testing.T) {
 	assert.True(t, ran)
 }
 
+// Verify that we can preview a roll without a Gerrit instance.
+func TestDEPSRepoManagerPreviewRoll(t *testing.T) {
+	testutils.LargeTest(t)
+
+	ctx, wd, _, childCommits, parent, mockRun, _, cleanup := setup(t)
+	defer cleanup()
+	recipesCfg := filepath.Join(testutils.GetRepoRoot(t), recipe_cfg.RECIPE_CFG_PATH)
+
+	cfg := depsCfg()
+	cfg.ParentRepo = parent.RepoUrl()
+	rm, err := NewDEPSRepoManager(ctx, cfg, wd, nil, recipesCfg, "fake.server.com")
+	assert.NoError(t, err)
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
+	assert.NoError(t, rm.Update(ctx))
+
+	ran := false
+	rm.(*depsRepoManager).preUploadSteps = []PreUploadStep{
+		func(context.Context, string) error {
+			ran = true
+			return nil
+		},
+	}
+
+	from, to := rm.LastRollRev(), rm.NextRollRev()
+	preview, err := rm.(RollPreviewer).PreviewRoll(ctx, from, to, "extra-bot")
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.True(t, strings.HasPrefix(preview.CommitMsg, fmt.Sprintf("Roll %s %s..%s (%d commits)", childPath, from[:12], to[:12], numChildCommits-1)))
+	assert.Contains(t, preview.CommitMsg, "CQ_INCLUDE_TRYBOTS=extra-bot")
+	assert.Contains(t, preview.Diff, fmt.Sprintf("-  \"%s\": \"", childPath))
+	assert.Contains(t, preview.Diff, to)
+
+	// Nothing was uploaded.
+	for _, cmd := range mockRun.Commands() {
+		assert.False(t, cmd.Name == "git" && len(cmd.Args) > 0 && cmd.Args[0] == "cl")
+	}
+
+	// The parent checkout was cleaned up.
+	out, err := git.GitDir(rm.(*depsRepoManager).parentDir).Git(ctx, "status", "--porcelain")
+	assert.NoError(t, err)
+	assert.Equal(t, "", strings.TrimSpace(out))
+	assert.Equal(t, childCommits[0], rm.LastRollRev())
+}
+
 // Verify that we respect the includeLog parameter.
 func TestDEPSRepoManagerIncludeLog(t *testing.T) {
 	testutils.LargeTest(t)
@@ -258,16 +309,16 @@ func TestDEPSRepoManagerIncludeLog(t *testing.T) {
 		cfg.IncludeLog = includeLog
 		rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 		assert.NoError(t, err)
-		assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+		assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 		assert.NoError(t, rm.Update(ctx))
 
 		// Create a roll.
 		_, err = rm.CreateNewRoll(ctx, rm.LastRollRev(), rm.NextRollRev(), emails, cqExtraTrybots, false)
 		assert.NoError(t, err)
 
-		// Ensure that we included the log, or not, as appropriate.
+		// Ensure that we included the changelog, or not, as appropriate.
 		assert.NoError(t, err)
-		assert.Equal(t, includeLog, strings.Contains(lastUpload.Body, "git log"))
+		assert.Equal(t, includeLog, strings.Contains(lastUpload.Body, "Authors: "))
 	}
 
 	test(true)
@@ -304,7 +355,7 @@ cache_dir=None
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	// Create a roll.
@@ -343,7 +394,7 @@ func TestDEPSRepoManagerBugs(t *testing.T) {
 		cfg.ParentRepo = parent.RepoUrl()
 		rm, err := NewDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 		assert.NoError(t, err)
-		assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+		assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 		assert.NoError(t, rm.Update(ctx))
 
 		// Insert a fake entry into the repo mapping.
@@ -101,7 +101,7 @@ func TestFuchsiaSDKRepoManager(t *testing.T) {
 	cfg.ParentRepo = gb.RepoUrl()
 	rm, err := NewFuchsiaSDKRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com", urlmock.Client())
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_FUCHSIA_SDK))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_FUCHSIA_SDK, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, mockUser, rm.User())
 	assert.Equal(t, fuchsiaSDKRevBase, rm.LastRollRev())
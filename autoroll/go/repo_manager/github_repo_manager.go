@@ -32,6 +32,9 @@ type GithubRepoManagerConfig struct {
 	// Optional config to use if parent path is different than
 	// workdir + parent repo.
 	GithubParentPath string `json:"githubParentPath"`
+
+	// If false, roll CLs do not include a git log.
+	IncludeLog bool `json:"includeLog"`
 }
 
 // Validate the config.
@@ -58,6 +61,7 @@ func newGithubRepoManager(ctx context.Context, c *GithubRepoManagerConfig, workd
 	}
 	dr := &depsRepoManager{
 		depotToolsRepoManager: drm,
+		includeLog:            c.IncludeLog,
 	}
 	if c.GithubParentPath != "" {
 		dr.parentDir = path.Join(wd, c.GithubParentPath)
@@ -150,6 +154,7 @@ func (rm *githubRepoManager) Update(ctx context.Context) error {
 	rm.lastRollRev = lastRollRev
 	rm.nextRollRev = nextRollRev
 	rm.commitsNotRolled = len(notRolled)
+	rm.notRolled = notRolled
 
 	sklog.Infof("lastRollRev is: %s", rm.lastRollRev)
 	sklog.Infof("nextRollRev is: %s", nextRollRev)
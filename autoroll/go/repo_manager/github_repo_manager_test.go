@@ -45,6 +45,7 @@ func githubCfg() *GithubRepoManagerConfig {
 				ParentBranch: "master",
 			},
 		},
+		IncludeLog: true,
 	}
 }
 
@@ -145,7 +146,7 @@ func TestGithubRepoManager(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewGithubRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[0], rm.LastRollRev())
 	assert.Equal(t, childCommits[len(childCommits)-1], rm.NextRollRev())
@@ -186,7 +187,7 @@ func TestCreateNewGithubRoll(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewGithubRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	// Create a roll, assert that it's at tip of tree.
@@ -221,7 +222,7 @@ func TestRanPreUploadStepsGithub(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewGithubRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	ran := false
 	rm.(*githubRepoManager).preUploadSteps = []PreUploadStep{
@@ -251,7 +252,7 @@ func TestErrorPreUploadStepsGithub(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewGithubRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	ran := false
 	expectedErr := errors.New("Expected error")
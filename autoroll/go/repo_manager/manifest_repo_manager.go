@@ -96,6 +96,7 @@ func (mr *manifestRepoManager) Update(ctx context.Context) error {
 	mr.lastRollRev = lastRollRev
 	mr.nextRollRev = nextRollRev
 	mr.commitsNotRolled = len(notRolled)
+	mr.notRolled = notRolled
 	return nil
 }
 
@@ -117,59 +118,32 @@ func (mr *manifestRepoManager) getLastRollRev() (string, error) {
 	return m[len(m)-1], nil
 }
 
-// See documentation for RepoManager interface.
-func (mr *manifestRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
-	mr.repoMtx.Lock()
-	defer mr.repoMtx.Unlock()
-
-	// Clean the checkout, get onto a fresh branch.
-	if err := mr.cleanParent(ctx); err != nil {
-		return 0, err
-	}
-	if _, err := exec.RunCwd(ctx, mr.parentDir, "git", "checkout", "-b", ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", mr.parentBranch), "-f"); err != nil {
-		return 0, err
-	}
-
-	// Defer some more cleanup.
-	defer func() {
-		util.LogErr(mr.cleanParent(ctx))
-	}()
-
-	// Create the roll CL.
+// prepareRoll performs the roll from one revision to another in the parent
+// checkout, which must be on a fresh roll branch, and runs the pre-upload
+// steps. Returns the commit message for the roll. Nothing is committed.
+func (mr *manifestRepoManager) prepareRoll(ctx context.Context, from, to string) (string, error) {
 	cr := mr.childRepo
 	commits, err := cr.RevList(ctx, fmt.Sprintf("%s..%s", from, to))
 	if err != nil {
-		return 0, fmt.Errorf("Failed to list revisions: %s", err)
-	}
-
-	if _, err := exec.RunCwd(ctx, mr.parentDir, "git", "config", "user.name", getLocalPartOfEmailAddress(mr.user)); err != nil {
-		return 0, err
-	}
-	if _, err := exec.RunCwd(ctx, mr.parentDir, "git", "config", "user.email", mr.user); err != nil {
-		return 0, err
+		return "", fmt.Errorf("Failed to list revisions: %s", err)
 	}
 
 	// Update the manifest file.
 	if err := mr.updateManifestFile(mr.lastRollRev, to); err != nil {
-		return 0, err
+		return "", err
 	}
 
 	// Run the pre-upload steps.
 	for _, s := range mr.PreUploadSteps() {
 		if err := s(ctx, mr.parentDir); err != nil {
-			return 0, fmt.Errorf("Failed pre-upload step: %s", err)
+			return "", fmt.Errorf("Failed pre-upload step: %s", err)
 		}
 	}
 
-	// Get list of changes.
-	changeSummaries := []string{}
-	for _, c := range commits {
-		d, err := cr.Details(ctx, c)
-		if err != nil {
-			return 0, err
-		}
-		changeSummary := fmt.Sprintf("%s %s %s", d.Timestamp.Format("2006-01-02"), AUTHOR_EMAIL_RE.FindStringSubmatch(d.Author)[1], d.Subject)
-		changeSummaries = append(changeSummaries, changeSummary)
+	// Get the changelog.
+	changelog, err := mr.getChangelog(ctx, from, to)
+	if err != nil {
+		return "", err
 	}
 
 	// Create commit message.
@@ -184,7 +158,37 @@ https://%s.googlesource.com/%s.git/+log/%s
 
 %s
 TEST=CQ
-`, mr.childPath, commitRange, len(commits), childRepoName, childRepoName, commitRange, strings.Join(changeSummaries, "\n"), fmt.Sprintf(COMMIT_MSG_FOOTER_TMPL, mr.serverURL))
+`, mr.childPath, commitRange, len(commits), childRepoName, childRepoName, commitRange, changelog.String(), fmt.Sprintf(COMMIT_MSG_FOOTER_TMPL, mr.serverURL))
+	return commitMsg, nil
+}
+
+// See documentation for RepoManager interface.
+func (mr *manifestRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	mr.repoMtx.Lock()
+	defer mr.repoMtx.Unlock()
+
+	// Clean the checkout, get onto a fresh branch.
+	if err := mr.checkoutRollBranch(ctx); err != nil {
+		return 0, err
+	}
+
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(mr.cleanParent(ctx))
+	}()
+
+	if _, err := exec.RunCwd(ctx, mr.parentDir, "git", "config", "user.name", getLocalPartOfEmailAddress(mr.user)); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(ctx, mr.parentDir, "git", "config", "user.email", mr.user); err != nil {
+		return 0, err
+	}
+
+	// Create the roll CL.
+	commitMsg, err := mr.prepareRoll(ctx, from, to)
+	if err != nil {
+		return 0, err
+	}
 
 	// Commit the change with the above message.
 	if _, addErr := exec.RunCwd(ctx, mr.parentDir, "git", "add", manifestFileName); addErr != nil {
@@ -250,6 +254,31 @@ TEST=CQ
 	return issue.Issue, nil
 }
 
+// See documentation for RollPreviewer interface.
+func (mr *manifestRepoManager) PreviewRoll(ctx context.Context, from, to, cqExtraTrybots string) (*RollPreview, error) {
+	mr.repoMtx.Lock()
+	defer mr.repoMtx.Unlock()
+
+	if err := mr.checkoutRollBranch(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		util.LogErr(mr.cleanParent(ctx))
+	}()
+	commitMsg, err := mr.prepareRoll(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := mr.diffParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RollPreview{
+		CommitMsg: commitMsg,
+		Diff:      diff,
+	}, nil
+}
+
 // setChangeLabels sets the appropriate labels on the Gerrit change.
 // It uses the Gerrit REST API to set the following labels on the change:
 // * Code-Review=2
@@ -164,7 +164,7 @@ func TestManifestRepoManager(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewManifestRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[0], rm.LastRollRev())
 	assert.Equal(t, childCommits[len(childCommits)-1], rm.NextRollRev())
@@ -192,7 +192,7 @@ func TestCreateNewManifestRoll(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewManifestRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	// Create a roll, assert that it's at tip of tree.
@@ -214,7 +214,7 @@ func TestRanPreUploadStepsManifest(t *testing.T) {
 	cfg.ParentRepo = parent.RepoUrl()
 	rm, err := NewManifestRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com")
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	assert.NoError(t, rm.Update(ctx))
 	ran := false
 	rm.(*manifestRepoManager).preUploadSteps = []PreUploadStep{
@@ -242,3 +242,40 @@ func TestManifestConfigValidation(t *testing.T) {
 	cfg = &ManifestRepoManagerConfig{}
 	assert.Error(t, cfg.Validate())
 }
+
+// Verify that we can preview a roll without a Gerrit instance.
+func TestManifestRepoManagerPreviewRoll(t *testing.T) {
+	testutils.LargeTest(t)
+
+	ctx, wd, _, childCommits, parent, cleanup := setupManifest(t)
+	defer cleanup()
+	recipesCfg := filepath.Join(testutils.GetRepoRoot(t), recipe_cfg.RECIPE_CFG_PATH)
+
+	cfg := manifestCfg()
+	cfg.ParentRepo = parent.RepoUrl()
+	rm, err := NewManifestRepoManager(ctx, cfg, wd, nil, recipesCfg, "fake.server.com")
+	assert.NoError(t, err)
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
+	assert.NoError(t, rm.Update(ctx))
+	ran := false
+	rm.(*manifestRepoManager).preUploadSteps = []PreUploadStep{
+		func(context.Context, string) error {
+			ran = true
+			return nil
+		},
+	}
+
+	from, to := rm.LastRollRev(), rm.NextRollRev()
+	preview, err := rm.(RollPreviewer).PreviewRoll(ctx, from, to, "")
+	assert.NoError(t, err)
+	assert.True(t, ran)
+	assert.True(t, strings.HasPrefix(preview.CommitMsg, fmt.Sprintf("[manifest] Roll %s %s..%s (%d commits)", childPath, from[:9], to[:9], numChildCommits-1)))
+	assert.Contains(t, preview.Diff, fmt.Sprintf("-             revision=\"%s\"/>", from))
+	assert.Contains(t, preview.Diff, fmt.Sprintf("+             revision=\"%s\"/>", to))
+
+	// The parent checkout was cleaned up.
+	out, err := git.GitDir(rm.(*manifestRepoManager).parentDir).Git(ctx, "status", "--porcelain")
+	assert.NoError(t, err)
+	assert.Equal(t, "", strings.TrimSpace(out))
+	assert.Equal(t, childCommits[0], rm.LastRollRev())
+}
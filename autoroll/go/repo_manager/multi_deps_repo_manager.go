@@ -0,0 +1,563 @@
+package repo_manager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+
+	"go.skia.org/infra/go/depot_tools"
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/gerrit"
+	"go.skia.org/infra/go/git"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+const (
+	TMPL_MULTI_COMMIT_MESSAGE = `Roll {{len .Children}} dependencies {{.From}}..{{.To}} ({{.NumCommits}} commits)
+{{range .Children}}
+{{.Path}}: {{.Repo}}/+log/{{.From}}..{{.To}} ({{.NumCommits}} commits)
+{{- end}}
+{{range .Children}}
+{{.Path}}:
+{{.Changelog}}
+{{end}}
+Created with:
+  gclient setdep{{range .Children}} -r {{.Path}}@{{.To}}{{end}}
+
+The AutoRoll server is located here: {{.ServerURL}}
+
+Documentation for the AutoRoller is here:
+https://skia.googlesource.com/buildbot/+/master/autoroll/README.md
+
+If the roll is causing failures, please contact the current sheriff, who should
+be CC'd on the roll, and stop the roller if necessary.
+
+{{.Footer}}
+`
+)
+
+var (
+	// Use this function to instantiate a RepoManager. This is able to be
+	// overridden for testing.
+	NewMultiDEPSRepoManager func(context.Context, *MultiDEPSRepoManagerConfig, string, *gerrit.Gerrit, string, string) (RepoManager, error) = newMultiDEPSRepoManager
+
+	multiCommitMsgTmpl = template.Must(template.New("multiCommitMsg").Parse(TMPL_MULTI_COMMIT_MESSAGE))
+)
+
+// MultiDEPSChildConfig provides configuration for one child of the multi-DEPS
+// RepoManager.
+type MultiDEPSChildConfig struct {
+	// Branch of the child repo we want to roll.
+	ChildBranch string `json:"childBranch"`
+	// Path of the child repo within the parent repo.
+	ChildPath string `json:"childPath"`
+}
+
+// Validate the config.
+func (c *MultiDEPSChildConfig) Validate() error {
+	if c.ChildBranch == "" {
+		return errors.New("ChildBranch is required.")
+	}
+	if c.ChildPath == "" {
+		return errors.New("ChildPath is required.")
+	}
+	if strings.ContainsAny(c.ChildPath, ",@ ") {
+		return fmt.Errorf("ChildPath %q may not contain commas, spaces, or '@'.", c.ChildPath)
+	}
+	return nil
+}
+
+// MultiDEPSRepoManagerConfig provides configuration for the multi-DEPS
+// RepoManager, which rolls several DEPS entries in a single CL.
+type MultiDEPSRepoManagerConfig struct {
+	// Required fields.
+
+	// Children to roll. Each child is tracked separately, and a roll CL
+	// updates all of the children which have new commits.
+	Children []*MultiDEPSChildConfig `json:"children"`
+	// Branch of the parent repo we want to roll into.
+	ParentBranch string `json:"parentBranch"`
+	// URL of the parent repo.
+	ParentRepo string `json:"parentRepo"`
+
+	// Optional fields.
+
+	// ChildSubdir indicates the subdirectory of the workdir in which the
+	// children's paths should be rooted. See CommonRepoManagerConfig.
+	ChildSubdir string `json:"childSubdir"`
+	// Override the default gclient spec with this string.
+	GClientSpec string `json:"gclientSpec"`
+	// Named steps to run before uploading roll CLs.
+	PreUploadSteps []string `json:"preUploadSteps"`
+}
+
+// Validate the config.
+func (c *MultiDEPSRepoManagerConfig) Validate() error {
+	if len(c.Children) < 2 {
+		return fmt.Errorf("At least two children are required, but got %d", len(c.Children))
+	}
+	paths := map[string]bool{}
+	for _, child := range c.Children {
+		if err := child.Validate(); err != nil {
+			return err
+		}
+		if paths[child.ChildPath] {
+			return fmt.Errorf("Duplicate child path %q", child.ChildPath)
+		}
+		paths[child.ChildPath] = true
+	}
+	return c.depotToolsConfig().Validate()
+}
+
+// depotToolsConfig returns a DepotToolsRepoManagerConfig based on the
+// MultiDEPSRepoManagerConfig, using the first child as the primary child.
+func (c *MultiDEPSRepoManagerConfig) depotToolsConfig() DepotToolsRepoManagerConfig {
+	rv := DepotToolsRepoManagerConfig{
+		CommonRepoManagerConfig: CommonRepoManagerConfig{
+			ChildSubdir:    c.ChildSubdir,
+			ParentBranch:   c.ParentBranch,
+			PreUploadSteps: c.PreUploadSteps,
+		},
+		GClientSpec: c.GClientSpec,
+		ParentRepo:  c.ParentRepo,
+	}
+	if len(c.Children) > 0 {
+		rv.ChildBranch = c.Children[0].ChildBranch
+		rv.ChildPath = c.Children[0].ChildPath
+	}
+	return rv
+}
+
+// ChildStatus describes the state of one child of a RepoManager which rolls
+// multiple children.
+type ChildStatus struct {
+	LastRollRev  string `json:"lastRollRev"`
+	NextRollRev  string `json:"nextRollRev"`
+	NumNotRolled int    `json:"numNotRolled"`
+	Path         string `json:"path"`
+}
+
+// Copy returns a copy of the ChildStatus.
+func (s *ChildStatus) Copy() *ChildStatus {
+	return &ChildStatus{
+		LastRollRev:  s.LastRollRev,
+		NextRollRev:  s.NextRollRev,
+		NumNotRolled: s.NumNotRolled,
+		Path:         s.Path,
+	}
+}
+
+// MultiChildRepoManager is implemented by RepoManagers which roll multiple
+// children in a single CL. The revisions used by these RepoManagers are
+// composites of the revisions of each child; see EncodeMultiRev.
+type MultiChildRepoManager interface {
+	// ChildStatuses returns the status of each child as of the last call
+	// to Update.
+	ChildStatuses() []*ChildStatus
+}
+
+// EncodeMultiRev returns a composite revision for multiple children, given as
+// child paths, in order, and a map of child path to revision. The result is of
+// the form "path1@rev1,path2@rev2".
+func EncodeMultiRev(paths []string, revs map[string]string) string {
+	parts := make([]string, 0, len(paths))
+	for _, p := range paths {
+		parts = append(parts, fmt.Sprintf("%s@%s", p, revs[p]))
+	}
+	return strings.Join(parts, ",")
+}
+
+// DecodeMultiRev parses the given composite revision and returns a map of child
+// path to revision.
+func DecodeMultiRev(rev string) (map[string]string, error) {
+	rv := map[string]string{}
+	for _, part := range strings.Split(rev, ",") {
+		split := strings.SplitN(part, "@", 2)
+		if len(split) != 2 || split[0] == "" || split[1] == "" {
+			return nil, fmt.Errorf("Invalid multi-child revision %q", rev)
+		}
+		if _, ok := rv[split[0]]; ok {
+			return nil, fmt.Errorf("Duplicate child %q in multi-child revision %q", split[0], rev)
+		}
+		rv[split[0]] = split[1]
+	}
+	return rv, nil
+}
+
+// multiDEPSChild tracks one child of a multiDEPSRepoManager.
+type multiDEPSChild struct {
+	branch      string
+	lastRollRev string
+	nextRollRev string
+	notRolled   []*vcsinfo.LongCommit
+	path        string
+	repo        *git.Checkout
+}
+
+// multiDEPSRepoManager is a RepoManager which rolls several DEPS entries in a
+// single CL. The embedded depotToolsRepoManager's child is the first child.
+type multiDEPSRepoManager struct {
+	*depotToolsRepoManager
+	children []*multiDEPSChild
+}
+
+// newMultiDEPSRepoManager returns a RepoManager instance which operates in the
+// given working directory and updates at the given frequency.
+func newMultiDEPSRepoManager(ctx context.Context, c *MultiDEPSRepoManagerConfig, workdir string, g *gerrit.Gerrit, recipeCfgFile, serverURL string) (RepoManager, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	drm, err := newDepotToolsRepoManager(ctx, c.depotToolsConfig(), path.Join(workdir, "repo_manager"), recipeCfgFile, serverURL, g)
+	if err != nil {
+		return nil, err
+	}
+	children := make([]*multiDEPSChild, 0, len(c.Children))
+	for _, child := range c.Children {
+		dir := path.Join(drm.workdir, c.ChildSubdir, child.ChildPath)
+		children = append(children, &multiDEPSChild{
+			branch: child.ChildBranch,
+			path:   child.ChildPath,
+			repo:   &git.Checkout{GitDir: git.GitDir(dir)},
+		})
+	}
+	return &multiDEPSRepoManager{
+		depotToolsRepoManager: drm,
+		children:              children,
+	}, nil
+}
+
+// paths returns the paths of the children, in order.
+func (r *multiDEPSRepoManager) paths() []string {
+	rv := make([]string, 0, len(r.children))
+	for _, c := range r.children {
+		rv = append(rv, c.path)
+	}
+	return rv
+}
+
+// decodeRev parses the given composite revision and verifies that it contains
+// a revision for each child.
+func (r *multiDEPSRepoManager) decodeRev(rev string) (map[string]string, error) {
+	revs, err := DecodeMultiRev(rev)
+	if err != nil {
+		return nil, err
+	}
+	if len(revs) != len(r.children) {
+		return nil, fmt.Errorf("Expected revisions for %d children but got %d in %q", len(r.children), len(revs), rev)
+	}
+	for _, c := range r.children {
+		if _, ok := revs[c.path]; !ok {
+			return nil, fmt.Errorf("No revision for child %q in %q", c.path, rev)
+		}
+	}
+	return revs, nil
+}
+
+// shortRev returns an abbreviated version of the given composite revision.
+func (r *multiDEPSRepoManager) shortRev(revs map[string]string) string {
+	short := make(map[string]string, len(revs))
+	for p, rev := range revs {
+		short[p] = shortHash(rev)
+	}
+	return EncodeMultiRev(r.paths(), short)
+}
+
+// notRolledSteps returns synthetic commits which represent rolling each
+// child in turn, in reverse chronological order, such that each commit's hash
+// is the composite revision obtained by rolling the corresponding child and
+// all of the children before it. This allows a failed roll of multiple
+// children to be bisected to find the child which caused the failure. The
+// author of each synthetic commit is the path of the child it rolls.
+func notRolledSteps(children []*multiDEPSChild, paths []string) []*vcsinfo.LongCommit {
+	revs := make(map[string]string, len(children))
+	for _, c := range children {
+		revs[c.path] = c.lastRollRev
+	}
+	steps := []*vcsinfo.LongCommit{}
+	for _, c := range children {
+		if c.nextRollRev == c.lastRollRev {
+			continue
+		}
+		revs[c.path] = c.nextRollRev
+		numCommits := 0
+		for i, commit := range c.notRolled {
+			if commit.Hash == c.nextRollRev {
+				numCommits = len(c.notRolled) - i
+				break
+			}
+		}
+		step := &vcsinfo.LongCommit{
+			ShortCommit: &vcsinfo.ShortCommit{
+				Hash:    EncodeMultiRev(paths, revs),
+				Author:  c.path,
+				Subject: fmt.Sprintf("Roll %s %s..%s (%d commits)", c.path, shortHash(c.lastRollRev), shortHash(c.nextRollRev), numCommits),
+			},
+		}
+		if len(c.notRolled) > 0 {
+			step.Timestamp = c.notRolled[0].Timestamp
+		}
+		steps = append([]*vcsinfo.LongCommit{step}, steps...)
+	}
+	return steps
+}
+
+// See documentation for RepoManager interface.
+func (r *multiDEPSRepoManager) Update(ctx context.Context) error {
+	// Sync the projects.
+	r.repoMtx.Lock()
+	defer r.repoMtx.Unlock()
+
+	if err := r.createAndSyncParent(ctx); err != nil {
+		return fmt.Errorf("Could not create and sync parent repo: %s", err)
+	}
+
+	lastRollRevs := map[string]string{}
+	nextRollRevs := map[string]string{}
+	children := make([]*multiDEPSChild, 0, len(r.children))
+	numNotRolled := 0
+	for _, c := range r.children {
+		// Get the last roll revision.
+		output, err := exec.RunCwd(ctx, r.parentDir, "python", r.gclient, "getdep", "-r", c.path)
+		if err != nil {
+			return err
+		}
+		lastRollRev := strings.TrimSpace(output)
+		if len(lastRollRev) != 40 {
+			return fmt.Errorf("Got invalid output for `gclient getdep` for %s: %s", c.path, output)
+		}
+
+		// Find the not-rolled child repo commits.
+		notRolled, err := getCommitsNotRolled(ctx, c.repo, c.branch, lastRollRev)
+		if err != nil {
+			return err
+		}
+
+		// Get the next roll revision.
+		nextRollRev, err := r.getNextRollRev(ctx, notRolled, lastRollRev)
+		if err != nil {
+			return err
+		}
+
+		lastRollRevs[c.path] = lastRollRev
+		nextRollRevs[c.path] = nextRollRev
+		numNotRolled += len(notRolled)
+		children = append(children, &multiDEPSChild{
+			branch:      c.branch,
+			lastRollRev: lastRollRev,
+			nextRollRev: nextRollRev,
+			notRolled:   notRolled,
+			path:        c.path,
+			repo:        c.repo,
+		})
+	}
+
+	r.infoMtx.Lock()
+	defer r.infoMtx.Unlock()
+	r.children = children
+	r.lastRollRev = EncodeMultiRev(r.paths(), lastRollRevs)
+	r.nextRollRev = EncodeMultiRev(r.paths(), nextRollRevs)
+	r.commitsNotRolled = numNotRolled
+	r.notRolled = notRolledSteps(children, r.paths())
+	return nil
+}
+
+// See documentation for MultiChildRepoManager interface.
+func (r *multiDEPSRepoManager) ChildStatuses() []*ChildStatus {
+	r.infoMtx.RLock()
+	defer r.infoMtx.RUnlock()
+	rv := make([]*ChildStatus, 0, len(r.children))
+	for _, c := range r.children {
+		rv = append(rv, &ChildStatus{
+			LastRollRev:  c.lastRollRev,
+			NextRollRev:  c.nextRollRev,
+			NumNotRolled: len(c.notRolled),
+			Path:         c.path,
+		})
+	}
+	return rv
+}
+
+// See documentation for RepoManager interface.
+func (r *multiDEPSRepoManager) FullChildHash(ctx context.Context, shortRev string) (string, error) {
+	r.repoMtx.RLock()
+	defer r.repoMtx.RUnlock()
+	revs, err := r.decodeRev(shortRev)
+	if err != nil {
+		return "", err
+	}
+	for _, c := range r.children {
+		full, err := c.repo.FullHash(ctx, revs[c.path])
+		if err != nil {
+			return "", err
+		}
+		revs[c.path] = full
+	}
+	return EncodeMultiRev(r.paths(), revs), nil
+}
+
+// See documentation for RepoManager interface.
+func (r *multiDEPSRepoManager) RolledPast(ctx context.Context, rev string) (bool, error) {
+	r.repoMtx.RLock()
+	defer r.repoMtx.RUnlock()
+	revs, err := r.decodeRev(rev)
+	if err != nil {
+		return false, err
+	}
+	r.infoMtx.RLock()
+	defer r.infoMtx.RUnlock()
+	for _, c := range r.children {
+		rolledPast, err := c.repo.IsAncestor(ctx, revs[c.path], c.lastRollRev)
+		if err != nil {
+			return false, err
+		}
+		if !rolledPast {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// prepareRoll performs the roll from one composite revision to another in the
+// parent checkout, which must be on a fresh roll branch, and runs the
+// pre-upload steps. Returns the commit message for the roll. Nothing is
+// committed.
+func (r *multiDEPSRepoManager) prepareRoll(ctx context.Context, from, to, cqExtraTrybots string) (string, error) {
+	fromRevs, err := r.decodeRev(from)
+	if err != nil {
+		return "", err
+	}
+	toRevs, err := r.decodeRev(to)
+	if err != nil {
+		return "", err
+	}
+
+	type childData struct {
+		Changelog  string
+		From       string
+		NumCommits int
+		Path       string
+		Repo       string
+		To         string
+	}
+	data := struct {
+		Children   []*childData
+		Footer     string
+		From       string
+		NumCommits int
+		ServerURL  string
+		To         string
+	}{
+		From:      r.shortRev(fromRevs),
+		ServerURL: r.serverURL,
+		To:        r.shortRev(toRevs),
+	}
+	args := []string{"setdep"}
+	for _, c := range r.children {
+		if fromRevs[c.path] == toRevs[c.path] {
+			continue
+		}
+		changelog, err := getChangelog(ctx, c.repo, fromRevs[c.path], toRevs[c.path])
+		if err != nil {
+			return "", err
+		}
+		remoteUrl, err := c.repo.Git(ctx, "remote", "get-url", "origin")
+		if err != nil {
+			return "", err
+		}
+		data.Children = append(data.Children, &childData{
+			Changelog:  changelog.String(),
+			From:       shortHash(fromRevs[c.path]),
+			NumCommits: changelog.NumCommits,
+			Path:       c.path,
+			Repo:       strings.TrimSpace(remoteUrl),
+			To:         shortHash(toRevs[c.path]),
+		})
+		data.NumCommits += changelog.NumCommits
+		args = append(args, "-r", fmt.Sprintf("%s@%s", c.path, toRevs[c.path]))
+	}
+	if len(data.Children) == 0 {
+		return "", fmt.Errorf("No children to roll from %s to %s", from, to)
+	}
+	if cqExtraTrybots != "" {
+		data.Footer += fmt.Sprintf(TMPL_CQ_INCLUDE_TRYBOTS, cqExtraTrybots)
+	}
+
+	// Run "gclient setdep".
+	sklog.Infof("Running command: gclient %s", strings.Join(args, " "))
+	if _, err := exec.RunCommand(ctx, &exec.Command{
+		Dir:  r.parentDir,
+		Env:  depot_tools.Env(r.depotTools),
+		Name: r.gclient,
+		Args: args,
+	}); err != nil {
+		return "", err
+	}
+
+	// Build the commit message.
+	var buf bytes.Buffer
+	if err := multiCommitMsgTmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	// Run the pre-upload steps.
+	for _, s := range r.PreUploadSteps() {
+		if err := s(ctx, r.parentDir); err != nil {
+			return "", fmt.Errorf("Failed pre-upload step: %s", err)
+		}
+	}
+	return buf.String(), nil
+}
+
+// See documentation for RepoManager interface.
+func (r *multiDEPSRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	r.repoMtx.Lock()
+	defer r.repoMtx.Unlock()
+
+	// Clean the checkout, get onto a fresh branch.
+	if err := r.checkoutRollBranch(ctx); err != nil {
+		return 0, err
+	}
+
+	// Defer some more cleanup.
+	defer func() {
+		util.LogErr(r.cleanParent(ctx))
+	}()
+
+	// Create the roll CL.
+	commitMsg, err := r.prepareRoll(ctx, from, to, cqExtraTrybots)
+	if err != nil {
+		return 0, err
+	}
+	return r.commitAndUpload(ctx, commitMsg, emails, dryRun)
+}
+
+// See documentation for RollPreviewer interface.
+func (r *multiDEPSRepoManager) PreviewRoll(ctx context.Context, from, to, cqExtraTrybots string) (*RollPreview, error) {
+	r.repoMtx.Lock()
+	defer r.repoMtx.Unlock()
+
+	if err := r.checkoutRollBranch(ctx); err != nil {
+		return nil, err
+	}
+	defer func() {
+		util.LogErr(r.cleanParent(ctx))
+	}()
+	commitMsg, err := r.prepareRoll(ctx, from, to, cqExtraTrybots)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := r.diffParent(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &RollPreview{
+		CommitMsg: commitMsg,
+		Diff:      diff,
+	}, nil
+}
@@ -0,0 +1,375 @@
+package repo_manager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/autoroll/go/strategy"
+	"go.skia.org/infra/go/autoroll"
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/git"
+	git_testutils "go.skia.org/infra/go/git/testutils"
+	"go.skia.org/infra/go/recipe_cfg"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+var (
+	multiChildPaths = []string{"path/to/childA", "path/to/childB"}
+)
+
+func multiDepsCfg(parentRepo string) *MultiDEPSRepoManagerConfig {
+	children := make([]*MultiDEPSChildConfig, 0, len(multiChildPaths))
+	for _, p := range multiChildPaths {
+		children = append(children, &MultiDEPSChildConfig{
+			ChildBranch: "master",
+			ChildPath:   p,
+		})
+	}
+	return &MultiDEPSRepoManagerConfig{
+		Children:     children,
+		ParentBranch: "master",
+		ParentRepo:   parentRepo,
+	}
+}
+
+// setupMultiDEPS creates a parent repo which DEPSes in one child repo for each
+// of multiChildPaths. Returns the child repos and their commits, in the same
+// order as multiChildPaths.
+func setupMultiDEPS(t *testing.T) (context.Context, string, []*git_testutils.GitBuilder, [][]string, *git_testutils.GitBuilder, *exec.CommandCollector, *vcsinfo.LongCommit, func()) {
+	wd, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+
+	// Create child and parent repos.
+	children := make([]*git_testutils.GitBuilder, 0, len(multiChildPaths))
+	childCommits := make([][]string, 0, len(multiChildPaths))
+	deps := "deps = {\n"
+	for _, p := range multiChildPaths {
+		child := git_testutils.GitInit(t, context.Background())
+		commits := make([]string, 0, numChildCommits)
+		for i := 0; i < numChildCommits; i++ {
+			commits = append(commits, child.CommitGen(context.Background(), "somefile.txt"))
+		}
+		children = append(children, child)
+		childCommits = append(childCommits, commits)
+		deps += fmt.Sprintf("  \"%s\": \"%s@%s\",\n", p, child.RepoUrl(), commits[0])
+	}
+	deps += "}"
+
+	parent := git_testutils.GitInit(t, context.Background())
+	parent.Add(context.Background(), "DEPS", deps)
+	parent.Commit(context.Background())
+
+	lastUpload := new(vcsinfo.LongCommit)
+	mockRun := &exec.CommandCollector{}
+	ctx := exec.NewContext(context.Background(), mockRun.Run)
+	mockRun.SetDelegateRun(func(cmd *exec.Command) error {
+		if cmd.Name == "git" && cmd.Args[0] == "cl" {
+			if cmd.Args[1] == "upload" {
+				d, err := git.GitDir(cmd.Dir).Details(ctx, "HEAD")
+				if err != nil {
+					return err
+				}
+				*lastUpload = *d
+				return nil
+			} else if cmd.Args[1] == "issue" {
+				json := testutils.MarshalJSON(t, &issueJson{
+					Issue:    issueNum,
+					IssueUrl: "???",
+				})
+				f := strings.Split(cmd.Args[2], "=")[1]
+				testutils.WriteFile(t, f, json)
+				return nil
+			}
+		}
+		return exec.DefaultRun(cmd)
+	})
+
+	cleanup := func() {
+		testutils.RemoveAll(t, wd)
+		for _, child := range children {
+			child.Cleanup()
+		}
+		parent.Cleanup()
+	}
+
+	return ctx, wd, children, childCommits, parent, mockRun, lastUpload, cleanup
+}
+
+// multiRev returns the composite revision for the commits with the given
+// index in each child.
+func multiRev(childCommits [][]string, idx ...int) string {
+	revs := make(map[string]string, len(multiChildPaths))
+	for i, p := range multiChildPaths {
+		revs[p] = childCommits[i][idx[i]]
+	}
+	return EncodeMultiRev(multiChildPaths, revs)
+}
+
+func TestMultiRev(t *testing.T) {
+	testutils.SmallTest(t)
+
+	paths := []string{"src/third_party/a", "src/third_party/b"}
+	revs := map[string]string{
+		"src/third_party/a": "aaaa",
+		"src/third_party/b": "bbbb",
+	}
+	rev := EncodeMultiRev(paths, revs)
+	assert.Equal(t, "src/third_party/a@aaaa,src/third_party/b@bbbb", rev)
+	decoded, err := DecodeMultiRev(rev)
+	assert.NoError(t, err)
+	assert.Equal(t, revs, decoded)
+
+	for _, bad := range []string{"", "aaaa", "a@", "@aaaa", "a@aaaa,", "a@aaaa,a@bbbb"} {
+		_, err := DecodeMultiRev(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMultiDEPSConfigValidation(t *testing.T) {
+	testutils.SmallTest(t)
+
+	cfg := func() *MultiDEPSRepoManagerConfig {
+		return &MultiDEPSRepoManagerConfig{
+			Children: []*MultiDEPSChildConfig{
+				{ChildBranch: "master", ChildPath: "src/third_party/a"},
+				{ChildBranch: "master", ChildPath: "src/third_party/b"},
+			},
+			ParentBranch: "master",
+			ParentRepo:   "https://fake.googlesource.com/parent",
+		}
+	}
+	assert.NoError(t, cfg().Validate())
+
+	c := cfg()
+	c.Children = c.Children[:1]
+	testutils.AssertErrorContains(t, c.Validate(), "At least two children are required")
+
+	c = cfg()
+	c.Children[1].ChildPath = c.Children[0].ChildPath
+	testutils.AssertErrorContains(t, c.Validate(), "Duplicate child path")
+
+	c = cfg()
+	c.Children[1].ChildPath = "src/third_party/b@c"
+	testutils.AssertErrorContains(t, c.Validate(), "may not contain")
+
+	c = cfg()
+	c.Children[0].ChildBranch = ""
+	testutils.AssertErrorContains(t, c.Validate(), "ChildBranch is required")
+
+	c = cfg()
+	c.ParentRepo = ""
+	assert.Error(t, c.Validate())
+}
+
+func TestNotRolledSteps(t *testing.T) {
+	testutils.SmallTest(t)
+
+	commit := func(hash string) *vcsinfo.LongCommit {
+		return &vcsinfo.LongCommit{
+			ShortCommit: &vcsinfo.ShortCommit{
+				Hash: hash,
+			},
+		}
+	}
+	children := []*multiDEPSChild{
+		{
+			lastRollRev: "a0",
+			nextRollRev: "a2",
+			notRolled:   []*vcsinfo.LongCommit{commit("a3"), commit("a2"), commit("a1")},
+			path:        "a",
+		},
+		{
+			// No new commits for this child.
+			lastRollRev: "b0",
+			nextRollRev: "b0",
+			path:        "b",
+		},
+		{
+			lastRollRev: "c0",
+			nextRollRev: "c1",
+			notRolled:   []*vcsinfo.LongCommit{commit("c1")},
+			path:        "c",
+		},
+	}
+	steps := notRolledSteps(children, []string{"a", "b", "c"})
+
+	// Steps are in reverse chronological order, and each step includes the
+	// steps before it.
+	assert.Equal(t, 2, len(steps))
+	assert.Equal(t, "a@a2,b@b0,c@c1", steps[0].Hash)
+	assert.Equal(t, "c", steps[0].Author)
+	assert.Equal(t, "Roll c c0..c1 (1 commits)", steps[0].Subject)
+	assert.Equal(t, "a@a2,b@b0,c@c0", steps[1].Hash)
+	assert.Equal(t, "a", steps[1].Author)
+	assert.Equal(t, "Roll a a0..a2 (2 commits)", steps[1].Subject)
+
+	// Nothing to roll.
+	children[0].nextRollRev = children[0].lastRollRev
+	children[2].nextRollRev = children[2].lastRollRev
+	assert.Equal(t, 0, len(notRolledSteps(children, []string{"a", "b", "c"})))
+}
+
+// TestMultiDEPSRepoManager tests all aspects of the multiDEPSRepoManager except
+// for CreateNewRoll and PreviewRoll.
+func TestMultiDEPSRepoManager(t *testing.T) {
+	testutils.LargeTest(t)
+
+	ctx, wd, children, childCommits, parent, _, _, cleanup := setupMultiDEPS(t)
+	defer cleanup()
+	recipesCfg := filepath.Join(testutils.GetRepoRoot(t), recipe_cfg.RECIPE_CFG_PATH)
+
+	g := setupFakeGerrit(t, wd)
+	rm, err := NewMultiDEPSRepoManager(ctx, multiDepsCfg(parent.RepoUrl()), wd, g, recipesCfg, "fake.server.com")
+	assert.NoError(t, err)
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
+	assert.NoError(t, rm.Update(ctx))
+	assert.Equal(t, multiRev(childCommits, 0, 0), rm.LastRollRev())
+	assert.Equal(t, multiRev(childCommits, numChildCommits-1, numChildCommits-1), rm.NextRollRev())
+	assert.Equal(t, 2*(numChildCommits-1), rm.CommitsNotRolled())
+
+	// Each child is tracked separately.
+	statuses := rm.(MultiChildRepoManager).ChildStatuses()
+	assert.Equal(t, len(multiChildPaths), len(statuses))
+	for i, s := range statuses {
+		assert.Equal(t, &ChildStatus{
+			LastRollRev:  childCommits[i][0],
+			NextRollRev:  childCommits[i][numChildCommits-1],
+			NumNotRolled: numChildCommits - 1,
+			Path:         multiChildPaths[i],
+		}, s)
+	}
+
+	// Each not-rolled step rolls one more child.
+	notRolled := rm.(NotRolledLister).NotRolledCommits()
+	assert.Equal(t, 2, len(notRolled))
+	assert.Equal(t, rm.NextRollRev(), notRolled[0].Hash)
+	assert.Equal(t, multiRev(childCommits, numChildCommits-1, 0), notRolled[1].Hash)
+
+	// Test FullChildHash.
+	for i := range childCommits[0] {
+		short := EncodeMultiRev(multiChildPaths, map[string]string{
+			multiChildPaths[0]: childCommits[0][i][:12],
+			multiChildPaths[1]: childCommits[1][i][:12],
+		})
+		h, err := rm.FullChildHash(ctx, short)
+		assert.NoError(t, err)
+		assert.Equal(t, multiRev(childCommits, i, i), h)
+	}
+	_, err = rm.FullChildHash(ctx, childCommits[0][0])
+	assert.Error(t, err)
+
+	// Test update.
+	lastCommit := children[1].CommitGen(context.Background(), "abc.txt")
+	assert.NoError(t, rm.Update(ctx))
+	assert.Equal(t, EncodeMultiRev(multiChildPaths, map[string]string{
+		multiChildPaths[0]: childCommits[0][numChildCommits-1],
+		multiChildPaths[1]: lastCommit,
+	}), rm.NextRollRev())
+
+	// RolledPast.
+	rp, err := rm.RolledPast(ctx, multiRev(childCommits, 0, 0))
+	assert.NoError(t, err)
+	assert.True(t, rp)
+	rp, err = rm.RolledPast(ctx, multiRev(childCommits, 0, 1))
+	assert.NoError(t, err)
+	assert.False(t, rp)
+
+	// Switch next-roll-rev strategies.
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_SINGLE, 0))
+	assert.NoError(t, rm.Update(ctx))
+	assert.Equal(t, multiRev(childCommits, 1, 1), rm.NextRollRev())
+}
+
+// Verify that the revisions of a roll can be recovered from its subject.
+func TestMultiDEPSRepoManagerCreateNewRoll(t *testing.T) {
+	testutils.LargeTest(t)
+
+	ctx, wd, children, childCommits, parent, _, lastUpload, cleanup := setupMultiDEPS(t)
+	defer cleanup()
+	recipesCfg := filepath.Join(testutils.GetRepoRoot(t), recipe_cfg.RECIPE_CFG_PATH)
+
+	g := setupFakeGerrit(t, wd)
+	rm, err := NewMultiDEPSRepoManager(ctx, multiDepsCfg(parent.RepoUrl()), wd, g, recipesCfg, "fake.server.com")
+	assert.NoError(t, err)
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
+	assert.NoError(t, rm.Update(ctx))
+
+	// Create a roll.
+	issue, err := rm.CreateNewRoll(ctx, rm.LastRollRev(), rm.NextRollRev(), emails, cqExtraTrybots, false)
+	assert.NoError(t, err)
+	assert.Equal(t, issueNum, issue)
+	msg, err := ioutil.ReadFile(path.Join(rm.(*multiDEPSRepoManager).parentDir, ".git", "COMMIT_EDITMSG"))
+	assert.NoError(t, err)
+	subject := strings.Split(string(msg), "\n")[0]
+	assert.Equal(t, subject, lastUpload.Subject)
+	shortRev := func(idx int) string {
+		return EncodeMultiRev(multiChildPaths, map[string]string{
+			multiChildPaths[0]: childCommits[0][idx][:12],
+			multiChildPaths[1]: childCommits[1][idx][:12],
+		})
+	}
+	assert.Equal(t, fmt.Sprintf("Roll %d dependencies %s..%s (%d commits)", len(multiChildPaths), shortRev(0), shortRev(numChildCommits-1), 2*(numChildCommits-1)), subject)
+
+	// The abbreviated composite revisions round-trip through the subject.
+	matches := autoroll.ROLL_REV_REGEX.FindStringSubmatch(subject)
+	assert.Equal(t, 3, len(matches))
+	from, to, err := autoroll.RollRev(subject, func(h string) (string, error) {
+		return rm.FullChildHash(ctx, h)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, multiRev(childCommits, 0, 0), from)
+	assert.Equal(t, multiRev(childCommits, numChildCommits-1, numChildCommits-1), to)
+
+	// Both children were rolled.
+	for i, p := range multiChildPaths {
+		assert.Contains(t, lastUpload.Body, fmt.Sprintf("%s: %s/+log/%s..%s (%d commits)", p, children[i].RepoUrl(), childCommits[i][0][:12], childCommits[i][numChildCommits-1][:12], numChildCommits-1))
+	}
+}
+
+// Verify that we can preview a roll of multiple children without a Gerrit
+// instance.
+func TestMultiDEPSRepoManagerPreviewRoll(t *testing.T) {
+	testutils.LargeTest(t)
+
+	ctx, wd, _, childCommits, parent, mockRun, _, cleanup := setupMultiDEPS(t)
+	defer cleanup()
+	recipesCfg := filepath.Join(testutils.GetRepoRoot(t), recipe_cfg.RECIPE_CFG_PATH)
+
+	rm, err := NewMultiDEPSRepoManager(ctx, multiDepsCfg(parent.RepoUrl()), wd, nil, recipesCfg, "fake.server.com")
+	assert.NoError(t, err)
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
+	assert.NoError(t, rm.Update(ctx))
+
+	// Only roll the second child.
+	from, to := rm.LastRollRev(), multiRev(childCommits, 0, numChildCommits-1)
+	preview, err := rm.(RollPreviewer).PreviewRoll(ctx, from, to, "extra-bot")
+	assert.NoError(t, err)
+	subject := strings.Split(preview.CommitMsg, "\n")[0]
+	from, to, err = autoroll.RollRev(subject, func(h string) (string, error) {
+		return rm.FullChildHash(ctx, h)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, rm.LastRollRev(), from)
+	assert.Equal(t, multiRev(childCommits, 0, numChildCommits-1), to)
+	assert.Contains(t, preview.CommitMsg, fmt.Sprintf("gclient setdep -r %s@%s\n", multiChildPaths[1], childCommits[1][numChildCommits-1]))
+	assert.Contains(t, preview.CommitMsg, "CQ_INCLUDE_TRYBOTS=extra-bot")
+	assert.NotContains(t, preview.Diff, childCommits[0][numChildCommits-1])
+	assert.Contains(t, preview.Diff, childCommits[1][numChildCommits-1])
+
+	// Nothing was uploaded.
+	for _, cmd := range mockRun.Commands() {
+		assert.False(t, cmd.Name == "git" && len(cmd.Args) > 0 && cmd.Args[0] == "cl")
+	}
+
+	// The parent checkout was cleaned up.
+	out, err := git.GitDir(rm.(*multiDEPSRepoManager).parentDir).Git(ctx, "status", "--porcelain")
+	assert.NoError(t, err)
+	assert.Equal(t, "", strings.TrimSpace(out))
+}
@@ -12,6 +12,7 @@ import (
 	"strings"
 	"sync"
 
+	"github.com/pmezard/go-difflib/difflib"
 	"go.skia.org/infra/autoroll/go/strategy"
 	"go.skia.org/infra/go/depot_tools"
 	"go.skia.org/infra/go/exec"
@@ -85,6 +86,7 @@ func (c *NoCheckoutDEPSRepoManagerConfig) Validate() error {
 
 type noCheckoutDEPSRepoManager struct {
 	baseCommit          string
+	baseDEPSContent     []byte
 	childBranch         string
 	childPath           string
 	childRepo           *gitiles.Repo
@@ -101,6 +103,7 @@ type noCheckoutDEPSRepoManager struct {
 	nextRollCommits     []*vcsinfo.LongCommit
 	nextRollDEPSContent []byte
 	nextRollRev         string
+	notRolled           []*vcsinfo.LongCommit
 	parentBranch        string
 	parentRepo          *gitiles.Repo
 	parentRepoUrl       string
@@ -169,29 +172,16 @@ func (rm *noCheckoutDEPSRepoManager) CommitsNotRolled() int {
 	return rm.commitsNotRolled
 }
 
-// See documentation for RepoManager interface.
-func (rm *noCheckoutDEPSRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
-	rm.infoMtx.RLock()
-	defer rm.infoMtx.RUnlock()
-
-	// Build the commit message.
+// buildCommitMsg returns the commit message for the roll from one revision to
+// another. The caller must hold infoMtx.
+func (rm *noCheckoutDEPSRepoManager) buildCommitMsg(from, to, cqExtraTrybots string) (string, error) {
 	bugs := []string{}
 	monorailProject := issues.REPO_PROJECT_MAPPING[rm.parentRepoUrl]
 	if monorailProject == "" {
 		sklog.Warningf("Found no entry in issues.REPO_PROJECT_MAPPING for %q", rm.parentRepoUrl)
 	}
-	logStr := ""
-	for _, c := range rm.nextRollCommits {
-		date := c.Timestamp.Format("2006-01-02")
-		author := c.Author
-		authorSplit := strings.Split(c.Author, "(")
-		if len(authorSplit) > 1 {
-			author = strings.TrimRight(strings.TrimSpace(authorSplit[1]), ")")
-		}
-		logStr += fmt.Sprintf("%s %s %s\n", date, author, c.Subject)
-
-		// Bugs list.
-		if rm.includeBugs && monorailProject != "" {
+	if rm.includeBugs && monorailProject != "" {
+		for _, c := range rm.nextRollCommits {
 			b := util.BugsFromCommitMsg(c.Body)
 			for _, bug := range b[monorailProject] {
 				bugs = append(bugs, fmt.Sprintf("%s:%s", monorailProject, bug))
@@ -199,9 +189,25 @@ func (rm *noCheckoutDEPSRepoManager) CreateNewRoll(ctx context.Context, from, to
 		}
 	}
 
-	commitMsg, err := buildCommitMsg(from, to, rm.childPath, cqExtraTrybots, rm.childRepoUrl, rm.serverURL, logStr, bugs, len(rm.nextRollCommits), rm.includeLog)
+	// There is no local checkout of the child repo, so the changelog is
+	// built from the commits retrieved in Update.
+	changelog := NewChangelog(from, to, rm.nextRollCommits)
+	commitMsg, err := buildCommitMsg(from, to, rm.childPath, cqExtraTrybots, rm.childRepoUrl, rm.serverURL, changelog, bugs, rm.includeLog)
 	if err != nil {
-		return 0, fmt.Errorf("Failed to build commit msg: %s", err)
+		return "", fmt.Errorf("Failed to build commit msg: %s", err)
+	}
+	return commitMsg, nil
+}
+
+// See documentation for RepoManager interface.
+func (rm *noCheckoutDEPSRepoManager) CreateNewRoll(ctx context.Context, from, to string, emails []string, cqExtraTrybots string, dryRun bool) (int64, error) {
+	rm.infoMtx.RLock()
+	defer rm.infoMtx.RUnlock()
+
+	// Build the commit message.
+	commitMsg, err := rm.buildCommitMsg(from, to, cqExtraTrybots)
+	if err != nil {
+		return 0, err
 	}
 	commitMsg += "TBR=" + strings.Join(emails, ",")
 
@@ -237,6 +243,37 @@ func (rm *noCheckoutDEPSRepoManager) CreateNewRoll(ctx context.Context, from, to
 	return ci.Issue, nil
 }
 
+// PreviewRoll returns the commit message and the DEPS diff of the roll. There
+// is no local checkout, so the pre-upload steps are not run, and only the roll
+// to the next roll revision found by Update can be previewed. See
+// documentation for RollPreviewer interface.
+func (rm *noCheckoutDEPSRepoManager) PreviewRoll(ctx context.Context, from, to, cqExtraTrybots string) (*RollPreview, error) {
+	rm.infoMtx.RLock()
+	defer rm.infoMtx.RUnlock()
+
+	if from != rm.lastRollRev || to != rm.nextRollRev {
+		return nil, fmt.Errorf("Can only preview the roll from %s to %s, not %s to %s.", rm.lastRollRev, rm.nextRollRev, from, to)
+	}
+	commitMsg, err := rm.buildCommitMsg(from, to, cqExtraTrybots)
+	if err != nil {
+		return nil, err
+	}
+	diff, err := difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(string(rm.baseDEPSContent)),
+		B:        difflib.SplitLines(string(rm.nextRollDEPSContent)),
+		FromFile: "a/DEPS",
+		ToFile:   "b/DEPS",
+		Context:  3,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to diff DEPS: %s", err)
+	}
+	return &RollPreview{
+		CommitMsg: commitMsg,
+		Diff:      diff,
+	}, nil
+}
+
 // See documentation for RepoManager interface.
 func (rm *noCheckoutDEPSRepoManager) FullChildHash(ctx context.Context, ref string) (string, error) {
 	c, err := rm.childRepo.GetCommit(ref)
@@ -386,9 +423,11 @@ func (rm *noCheckoutDEPSRepoManager) Update(ctx context.Context) error {
 	rm.infoMtx.Lock()
 	defer rm.infoMtx.Unlock()
 	rm.baseCommit = baseCommit.Hash
+	rm.baseDEPSContent = buf.Bytes()
 	rm.lastRollRev = lastRollRev
 	rm.nextRollRev = nextRollRev
 	rm.commitsNotRolled = notRolledCount
+	rm.notRolled = notRolled
 	rm.nextRollCommits = nextRollCommits
 	rm.nextRollDEPSContent = newDEPSContent
 	return nil
@@ -413,8 +452,8 @@ func (rm *noCheckoutDEPSRepoManager) GetIssueUrlBase() string {
 }
 
 // See documentation for RepoManager interface.
-func (r *noCheckoutDEPSRepoManager) CreateNextRollStrategy(ctx context.Context, s string) (strategy.NextRollStrategy, error) {
-	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, nil, nil)
+func (r *noCheckoutDEPSRepoManager) CreateNextRollStrategy(ctx context.Context, s string, nBatchMaxCommits int) (strategy.NextRollStrategy, error) {
+	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, nBatchMaxCommits, nil, nil)
 }
 
 // See documentation for RepoManager interface.
@@ -424,6 +463,13 @@ func (r *noCheckoutDEPSRepoManager) SetStrategy(s strategy.NextRollStrategy) {
 	r.strategy = s
 }
 
+// See documentation for NotRolledLister interface.
+func (r *noCheckoutDEPSRepoManager) NotRolledCommits() []*vcsinfo.LongCommit {
+	r.infoMtx.RLock()
+	defer r.infoMtx.RUnlock()
+	return r.notRolled
+}
+
 // See documentation for RepoManager interface.
 func (r *noCheckoutDEPSRepoManager) DefaultStrategy() string {
 	return strategy.ROLL_STRATEGY_BATCH
@@ -433,6 +479,7 @@ func (r *noCheckoutDEPSRepoManager) DefaultStrategy() string {
 func (r *noCheckoutDEPSRepoManager) ValidStrategies() []string {
 	return []string{
 		strategy.ROLL_STRATEGY_BATCH,
+		strategy.ROLL_STRATEGY_N_BATCH,
 		strategy.ROLL_STRATEGY_SINGLE,
 	}
 }
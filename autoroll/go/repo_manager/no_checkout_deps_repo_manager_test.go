@@ -20,6 +20,7 @@ import (
 	"go.skia.org/infra/go/mockhttpclient"
 	"go.skia.org/infra/go/recipe_cfg"
 	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
 )
 
 func setupNoCheckout(t *testing.T, cfg *NoCheckoutDEPSRepoManagerConfig, strategy string) (context.Context, string, RepoManager, *git_testutils.GitBuilder, *git_testutils.GitBuilder, *gitiles_testutils.MockRepo, *gitiles_testutils.MockRepo, []string, *mockhttpclient.URLMock, func()) {
@@ -77,7 +78,7 @@ func setupNoCheckout(t *testing.T, cfg *NoCheckoutDEPSRepoManagerConfig, strateg
 
 	rm, err := NewNoCheckoutDEPSRepoManager(ctx, cfg, wd, g, recipesCfg, "fake.server.com", "", urlmock.Client())
 	assert.NoError(t, err)
-	assert.NoError(t, SetStrategy(ctx, rm, strategy))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy, 0))
 	assert.NoError(t, rm.Update(ctx))
 
 	cleanup := func() {
@@ -131,14 +132,14 @@ func TestNoCheckoutDEPSRepoManagerStrategies(t *testing.T) {
 	assert.Equal(t, rm.NextRollRev(), nextRollRev)
 
 	// Switch next-roll-rev strategies.
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_BATCH, 0))
 	mockParent.MockGetCommit(ctx, "master")
 	mockParent.MockReadFile(ctx, "DEPS", parentMaster)
 	mockChild.MockLog(ctx, childCommits[0], "master")
 	assert.NoError(t, rm.Update(ctx))
 	assert.Equal(t, childCommits[len(childCommits)-1], rm.NextRollRev())
 	// And back again.
-	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_SINGLE))
+	assert.NoError(t, SetStrategy(ctx, rm, strategy.ROLL_STRATEGY_SINGLE, 0))
 	mockParent.MockGetCommit(ctx, "master")
 	mockParent.MockReadFile(ctx, "DEPS", parentMaster)
 	mockChild.MockLog(ctx, childCommits[0], "master")
@@ -186,27 +187,21 @@ func TestNoCheckoutDEPSRepoManagerCreateNewRoll(t *testing.T) {
 	lastRollRev := childCommits[0]
 
 	// Mock the initial change creation.
-	logStr := ""
 	childGitRepo := git.GitDir(childRepo.Dir())
 	commitsToRoll, err := childGitRepo.RevList(ctx, fmt.Sprintf("%s..%s", lastRollRev, nextRollRev))
 	assert.NoError(t, err)
+	details := make([]*vcsinfo.LongCommit, 0, len(commitsToRoll))
 	for _, c := range commitsToRoll {
-		details, err := childGitRepo.Details(ctx, c)
+		d, err := childGitRepo.Details(ctx, c)
 		assert.NoError(t, err)
-		ts := details.Timestamp.Format("2006-01-02")
-		author := details.Author
-		authorSplit := strings.Split(details.Author, "(")
-		if len(authorSplit) > 1 {
-			author = strings.TrimRight(strings.TrimSpace(authorSplit[1]), ")")
-		}
-		logStr += fmt.Sprintf("%s %s %s\n", ts, author, details.Subject)
+		details = append(details, d)
 	}
+	changelog := NewChangelog(lastRollRev, nextRollRev, details)
 	commitMsg := fmt.Sprintf(`Roll %s %s..%s (%d commits)
 
 %s/+log/%s..%s
 
 
-git log %s..%s --date=short --no-merges --format='%%ad %%ae %%s'
 %s
 
 Created with:
@@ -221,7 +216,7 @@ If the roll is causing failures, please contact the current sheriff, who should
 be CC'd on the roll, and stop the roller if necessary.
 
 
-TBR=me@google.com`, childPath, lastRollRev[:12], nextRollRev[:12], rm.CommitsNotRolled(), childRepo.RepoUrl(), lastRollRev[:12], nextRollRev[:12], lastRollRev[:12], nextRollRev[:12], logStr, childPath, nextRollRev[:12], "fake.server.com")
+TBR=me@google.com`, childPath, lastRollRev[:12], nextRollRev[:12], rm.CommitsNotRolled(), childRepo.RepoUrl(), lastRollRev[:12], nextRollRev[:12], changelog.String(), childPath, nextRollRev[:12], "fake.server.com")
 	subject := strings.Split(commitMsg, "\n")[0]
 	reqBody := []byte(fmt.Sprintf(`{"project":"%s","subject":"%s","branch":"%s","topic":"","status":"NEW","base_commit":"%s"}`, cfg.GerritProject, subject, cfg.ParentBranch, parentMaster))
 	ci := gerrit.ChangeInfo{
@@ -268,3 +263,26 @@ TBR=me@google.com`, childPath, lastRollRev[:12], nextRollRev[:12], rm.CommitsNot
 	assert.NoError(t, err)
 	assert.NotEqual(t, 0, issue)
 }
+
+func TestNoCheckoutDEPSRepoManagerPreviewRoll(t *testing.T) {
+	cfg := noCheckoutDEPSCfg()
+	ctx, _, rm, childRepo, _, _, _, childCommits, _, cleanup := setupNoCheckout(t, cfg, strategy.ROLL_STRATEGY_BATCH)
+	defer cleanup()
+
+	// Nothing is requested from Gerrit or Gitiles; the cleanup function
+	// verifies that no mocked requests remain.
+	from, to := rm.LastRollRev(), rm.NextRollRev()
+	assert.Equal(t, childCommits[0], from)
+	assert.Equal(t, childCommits[len(childCommits)-1], to)
+	preview, err := rm.(RollPreviewer).PreviewRoll(ctx, from, to, "extra-bot")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(preview.CommitMsg, fmt.Sprintf("Roll %s %s..%s (%d commits)", childPath, from[:12], to[:12], numChildCommits-1)))
+	assert.Contains(t, preview.CommitMsg, "CQ_INCLUDE_TRYBOTS=extra-bot")
+	assert.NotContains(t, preview.CommitMsg, "TBR=")
+	assert.Contains(t, preview.Diff, fmt.Sprintf("-  \"%s\": \"%s@%s\",", childPath, childRepo.RepoUrl(), from))
+	assert.Contains(t, preview.Diff, fmt.Sprintf("+  \"%s\": \"%s@%s\",", childPath, childRepo.RepoUrl(), to))
+
+	// Only the roll found by Update can be previewed.
+	_, err = rm.(RollPreviewer).PreviewRoll(ctx, from, childCommits[1], "")
+	assert.EqualError(t, err, fmt.Sprintf("Can only preview the roll from %s to %s, not %s to %s.", from, to, from, childCommits[1]))
+}
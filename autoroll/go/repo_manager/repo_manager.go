@@ -2,8 +2,10 @@ package repo_manager
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"strings"
@@ -80,8 +82,9 @@ type RepoManager interface {
 	// Return the base URL used for building the URLs of uploaded rolls.
 	GetIssueUrlBase() string
 
-	// Create a new NextRollRevStrategy from the given name.
-	CreateNextRollStrategy(context.Context, string) (strategy.NextRollStrategy, error)
+	// Create a new NextRollRevStrategy from the given name and the maximum
+	// number of commits per roll for strategy.ROLL_STRATEGY_N_BATCH.
+	CreateNextRollStrategy(context.Context, string, int) (strategy.NextRollStrategy, error)
 
 	// Set the RepoManager's NextRollRevStrategy.
 	SetStrategy(strategy.NextRollStrategy)
@@ -93,6 +96,32 @@ type RepoManager interface {
 	ValidStrategies() []string
 }
 
+// RollPreview describes the CL which a RepoManager would upload for a roll.
+type RollPreview struct {
+	CommitMsg string `json:"commitMsg"`
+	Diff      string `json:"diff"`
+}
+
+// RollPreviewer is implemented by RepoManagers which can perform a roll
+// without uploading it. Not all RepoManagers implement it, eg. GitHub rolls are
+// pushed to a fork as part of the roll, and Android rolls are merges which are
+// resolved and uploaded in the Android checkout, so neither can be previewed.
+type RollPreviewer interface {
+	// PreviewRoll performs the roll from one revision to another, including
+	// any pre-upload steps, and returns the resulting commit message and
+	// diff. The checkout is cleaned up afterward and nothing is uploaded.
+	PreviewRoll(context.Context, string, string, string) (*RollPreview, error)
+}
+
+// NotRolledLister is implemented by RepoManagers which track the individual
+// child commits which have not yet been rolled.
+type NotRolledLister interface {
+	// NotRolledCommits returns the child commits which had not yet been
+	// rolled as of the last call to Update, in reverse chronological
+	// order.
+	NotRolledCommits() []*vcsinfo.LongCommit
+}
+
 // Start makes the RepoManager begin the periodic update process.
 func Start(ctx context.Context, r RepoManager, frequency time.Duration) {
 	sklog.Infof("Starting repo_manager")
@@ -161,6 +190,7 @@ type commonRepoManager struct {
 	infoMtx          sync.RWMutex
 	lastRollRev      string
 	nextRollRev      string
+	notRolled        []*vcsinfo.LongCommit
 	parentBranch     string
 	preUploadSteps   []PreUploadStep
 	repoMtx          sync.RWMutex
@@ -232,6 +262,14 @@ func (r *commonRepoManager) RolledPast(ctx context.Context, hash string) (bool,
 	return r.childRepo.IsAncestor(ctx, hash, r.lastRollRev)
 }
 
+// See documentation for NotRolledLister interface. Returns nil for
+// RepoManagers which do not roll individual child commits.
+func (r *commonRepoManager) NotRolledCommits() []*vcsinfo.LongCommit {
+	r.infoMtx.RLock()
+	defer r.infoMtx.RUnlock()
+	return r.notRolled
+}
+
 // See documentation for RepoManager interface.
 func (r *commonRepoManager) NextRollRev() string {
 	r.infoMtx.RLock()
@@ -265,8 +303,8 @@ func (r *commonRepoManager) CommitsNotRolled() int {
 }
 
 // See documentation for RepoManger interface.
-func (r *commonRepoManager) CreateNextRollStrategy(ctx context.Context, s string) (strategy.NextRollStrategy, error) {
-	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, r.childRepo, nil)
+func (r *commonRepoManager) CreateNextRollStrategy(ctx context.Context, s string, nBatchMaxCommits int) (strategy.NextRollStrategy, error) {
+	return strategy.GetNextRollStrategy(ctx, s, r.childBranch, DEFAULT_REMOTE, nBatchMaxCommits, r.childRepo, nil)
 }
 
 // See documentation for RepoManager interface.
@@ -276,13 +314,15 @@ func (r *commonRepoManager) SetStrategy(s strategy.NextRollStrategy) {
 	r.strategy = s
 }
 
-// Set the given strategy on the RepoManager.
-func SetStrategy(ctx context.Context, r RepoManager, s string) error {
+// Set the given strategy on the RepoManager. nBatchMaxCommits is the maximum
+// number of commits per roll for strategy.ROLL_STRATEGY_N_BATCH; the default
+// is used if it is zero.
+func SetStrategy(ctx context.Context, r RepoManager, s string, nBatchMaxCommits int) error {
 	valid := r.ValidStrategies()
 	if !util.In(s, valid) {
 		return fmt.Errorf("Invalid strategy %q; valid: %v", s, valid)
 	}
-	strat, err := r.CreateNextRollStrategy(ctx, s)
+	strat, err := r.CreateNextRollStrategy(ctx, s, nBatchMaxCommits)
 	if err != nil {
 		return err
 	}
@@ -312,6 +352,7 @@ func (r *commonRepoManager) DefaultStrategy() string {
 func (r *commonRepoManager) ValidStrategies() []string {
 	return []string{
 		strategy.ROLL_STRATEGY_BATCH,
+		strategy.ROLL_STRATEGY_N_BATCH,
 		strategy.ROLL_STRATEGY_SINGLE,
 	}
 }
@@ -401,6 +442,96 @@ func (r *depotToolsRepoManager) cleanParentWithRemote(ctx context.Context, remot
 	return nil
 }
 
+// checkoutRollBranch cleans the parent checkout and creates a fresh roll
+// branch.
+func (r *depotToolsRepoManager) checkoutRollBranch(ctx context.Context) error {
+	if err := r.cleanParent(ctx); err != nil {
+		return err
+	}
+	_, err := exec.RunCwd(ctx, r.parentDir, "git", "checkout", "-b", ROLL_BRANCH, "-t", fmt.Sprintf("origin/%s", r.parentBranch), "-f")
+	return err
+}
+
+// diffParent returns the diff of all changes in the parent checkout, committed
+// or not, relative to the parent branch.
+func (r *depotToolsRepoManager) diffParent(ctx context.Context) (string, error) {
+	if _, err := exec.RunCwd(ctx, r.parentDir, "git", "add", "-A"); err != nil {
+		return "", err
+	}
+	return exec.RunCwd(ctx, r.parentDir, "git", "diff", "--cached", fmt.Sprintf("origin/%s", r.parentBranch))
+}
+
+// commitAndUpload commits the changes in the parent checkout, which must be on
+// a roll branch, with the given commit message and uploads the roll CL.
+// Returns the issue number of the uploaded CL.
+func (r *depotToolsRepoManager) commitAndUpload(ctx context.Context, commitMsg string, emails []string, dryRun bool) (int64, error) {
+	if _, err := exec.RunCwd(ctx, r.parentDir, "git", "config", "user.name", getLocalPartOfEmailAddress(r.user)); err != nil {
+		return 0, err
+	}
+	if _, err := exec.RunCwd(ctx, r.parentDir, "git", "config", "user.email", r.user); err != nil {
+		return 0, err
+	}
+
+	// Commit.
+	if _, err := exec.RunCwd(ctx, r.parentDir, "git", "commit", "-a", "-m", commitMsg); err != nil {
+		return 0, err
+	}
+
+	// Upload the CL.
+	uploadCmd := &exec.Command{
+		Dir:     r.parentDir,
+		Env:     depot_tools.Env(r.depotTools),
+		Name:    "git",
+		Args:    []string{"cl", "upload", "--bypass-hooks", "-f", "-v", "-v"},
+		Timeout: 2 * time.Minute,
+	}
+	if dryRun {
+		uploadCmd.Args = append(uploadCmd.Args, "--cq-dry-run")
+	} else {
+		uploadCmd.Args = append(uploadCmd.Args, "--use-commit-queue")
+	}
+	uploadCmd.Args = append(uploadCmd.Args, "--gerrit")
+	tbr := "\nTBR="
+	if emails != nil && len(emails) > 0 {
+		emailStr := strings.Join(emails, ",")
+		tbr += emailStr
+		uploadCmd.Args = append(uploadCmd.Args, "--send-mail", "--cc", emailStr)
+	}
+	commitMsg += tbr
+	uploadCmd.Args = append(uploadCmd.Args, "-m", commitMsg)
+
+	// Upload the CL.
+	sklog.Infof("Running command: git %s", strings.Join(uploadCmd.Args, " "))
+	if _, err := exec.RunCommand(ctx, uploadCmd); err != nil {
+		return 0, err
+	}
+
+	// Obtain the issue number.
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		return 0, err
+	}
+	defer util.RemoveAll(tmp)
+	jsonFile := path.Join(tmp, "issue.json")
+	if _, err := exec.RunCommand(ctx, &exec.Command{
+		Dir:  r.parentDir,
+		Env:  depot_tools.Env(r.depotTools),
+		Name: "git",
+		Args: []string{"cl", "issue", fmt.Sprintf("--json=%s", jsonFile)},
+	}); err != nil {
+		return 0, err
+	}
+	f, err := os.Open(jsonFile)
+	if err != nil {
+		return 0, err
+	}
+	var issue issueJson
+	if err := json.NewDecoder(f).Decode(&issue); err != nil {
+		return 0, err
+	}
+	return issue.Issue, nil
+}
+
 func (r *depotToolsRepoManager) createAndSyncParent(ctx context.Context) error {
 	return r.createAndSyncParentWithRemote(ctx, "origin")
 }
@@ -452,20 +583,26 @@ func (r *depotToolsRepoManager) createAndSyncParentWithRemote(ctx context.Contex
 }
 
 func (r *depotToolsRepoManager) getCommitsNotRolled(ctx context.Context, lastRollRev string) ([]*vcsinfo.LongCommit, error) {
-	head, err := r.childRepo.FullHash(ctx, fmt.Sprintf("origin/%s", r.childBranch))
+	return getCommitsNotRolled(ctx, r.childRepo, r.childBranch, lastRollRev)
+}
+
+// getCommitsNotRolled returns the commits on the given branch of the given
+// child repo which have not been rolled, in reverse chronological order.
+func getCommitsNotRolled(ctx context.Context, childRepo *git.Checkout, childBranch, lastRollRev string) ([]*vcsinfo.LongCommit, error) {
+	head, err := childRepo.FullHash(ctx, fmt.Sprintf("origin/%s", childBranch))
 	if err != nil {
 		return nil, err
 	}
 	if head == lastRollRev {
 		return []*vcsinfo.LongCommit{}, nil
 	}
-	commits, err := r.childRepo.RevList(ctx, fmt.Sprintf("%s..%s", lastRollRev, head))
+	commits, err := childRepo.RevList(ctx, fmt.Sprintf("%s..%s", lastRollRev, head))
 	if err != nil {
 		return nil, err
 	}
 	notRolled := make([]*vcsinfo.LongCommit, 0, len(commits))
 	for _, c := range commits {
-		detail, err := r.childRepo.Details(ctx, c)
+		detail, err := childRepo.Details(ctx, c)
 		if err != nil {
 			return nil, err
 		}
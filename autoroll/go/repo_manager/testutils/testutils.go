@@ -279,7 +279,7 @@ func (r *MockRepoManager) ValidStrategies() []string {
 	}
 }
 
-func (r *MockRepoManager) CreateNextRollStrategy(ctx context.Context, s string) (strategy.NextRollStrategy, error) {
+func (r *MockRepoManager) CreateNextRollStrategy(ctx context.Context, s string, nBatchMaxCommits int) (strategy.NextRollStrategy, error) {
 	return nil, fmt.Errorf("Not implemented")
 }
 
@@ -0,0 +1,169 @@
+package main
+
+/*
+	Preview the roll which an AutoRoll config would produce, without
+	uploading anything to Gerrit. Supports DEPS, multi-DEPS, no-checkout DEPS,
+	copy and manifest rollers.
+*/
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/flynn/json5"
+	"go.skia.org/infra/autoroll/go/repo_manager"
+	"go.skia.org/infra/autoroll/go/roller"
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/gerrit"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+var (
+	childRepo      = flag.String("child_repo", "", "If set, override the child repo URL of a copy roller, eg. to use a local repo.")
+	config         = flag.String("config", "", "Config file of the roller to preview.")
+	parentRepo     = flag.String("parent_repo", "", "If set, override the parent repo URL, eg. to use a local repo.")
+	recipesCfgFile = flag.String("recipes_cfg", "", "Path to the recipes.cfg file.")
+	serverURL      = flag.String("server_url", "https://autoroll.skia.org", "URL of the roller, used in commit messages.")
+	rollStrategy   = flag.String("strategy", "", "Strategy used to choose the revision to roll. If not set, the roller's default strategy is used.")
+	workdir        = flag.String("workdir", "", "Directory to use for scratch work. If not set, a temporary directory is used and deleted afterward.")
+)
+
+// newRepoManager creates a RepoManager for the given config which does not
+// communicate with a code review server.
+func newRepoManager(ctx context.Context, cfg *roller.AutoRollerConfig, wd string) (repo_manager.RepoManager, error) {
+	if cfg.DEPSRepoManager != nil {
+		if *parentRepo != "" {
+			cfg.DEPSRepoManager.ParentRepo = *parentRepo
+		}
+		return repo_manager.NewDEPSRepoManager(ctx, cfg.DEPSRepoManager, wd, nil, *recipesCfgFile, *serverURL)
+	} else if cfg.CopyRepoManager != nil {
+		if *parentRepo != "" {
+			cfg.CopyRepoManager.ParentRepo = *parentRepo
+		}
+		if *childRepo != "" {
+			cfg.CopyRepoManager.ChildRepo = *childRepo
+		}
+		return repo_manager.NewCopyRepoManager(ctx, cfg.CopyRepoManager, wd, nil, *recipesCfgFile, *serverURL)
+	} else if cfg.ManifestRepoManager != nil {
+		if *parentRepo != "" {
+			cfg.ManifestRepoManager.ParentRepo = *parentRepo
+		}
+		return repo_manager.NewManifestRepoManager(ctx, cfg.ManifestRepoManager, wd, nil, *recipesCfgFile, *serverURL)
+	} else if cfg.MultiDEPSRepoManager != nil {
+		if *parentRepo != "" {
+			cfg.MultiDEPSRepoManager.ParentRepo = *parentRepo
+		}
+		return repo_manager.NewMultiDEPSRepoManager(ctx, cfg.MultiDEPSRepoManager, wd, nil, *recipesCfgFile, *serverURL)
+	} else if cfg.NoCheckoutDEPSRepoManager != nil {
+		if *parentRepo != "" {
+			cfg.NoCheckoutDEPSRepoManager.ParentRepo = *parentRepo
+		}
+		// The no-checkout RepoManager reads the child repo through
+		// Gitiles, so it can't use a local repo.
+		if *childRepo != "" {
+			return nil, fmt.Errorf("--child_repo is not supported for %q rollers.", cfg.RollerType())
+		}
+		// The no-checkout RepoManager only uses Gerrit to look up the user,
+		// which is skipped for an uninitialized Gerrit.
+		var g *gerrit.Gerrit
+		return repo_manager.NewNoCheckoutDEPSRepoManager(ctx, cfg.NoCheckoutDEPSRepoManager, wd, g, *recipesCfgFile, *serverURL, "", nil)
+	}
+	return nil, fmt.Errorf("Roll preview is not supported for %q rollers.", cfg.RollerType())
+}
+
+func main() {
+	common.Init()
+
+	if *config == "" {
+		sklog.Fatal("--config is required.")
+	}
+	if *recipesCfgFile == "" {
+		sklog.Fatal("--recipes_cfg is required.")
+	}
+	var cfg roller.AutoRollerConfig
+	if err := util.WithReadFile(*config, func(r io.Reader) error {
+		return json5.NewDecoder(r).Decode(&cfg)
+	}); err != nil {
+		sklog.Fatalf("Failed to read %s: %s", *config, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		sklog.Fatalf("%s failed validation: %s", *config, err)
+	}
+
+	// sklog.Fatal exits without running deferred functions, so the work is
+	// done in run, which cleans up the temporary directory before returning.
+	if err := run(context.Background(), &cfg); err != nil {
+		sklog.Fatal(err)
+	}
+}
+
+// run previews the next roll of the given config.
+func run(ctx context.Context, cfg *roller.AutoRollerConfig) error {
+	wd := *workdir
+	if wd == "" {
+		tmp, err := ioutil.TempDir("", "roll_preview")
+		if err != nil {
+			return err
+		}
+		defer util.RemoveAll(tmp)
+		wd = tmp
+	}
+	wd, err := filepath.Abs(wd)
+	if err != nil {
+		return err
+	}
+
+	rm, err := newRepoManager(ctx, cfg, wd)
+	if err != nil {
+		return err
+	}
+	previewer, ok := rm.(repo_manager.RollPreviewer)
+	if !ok {
+		return fmt.Errorf("Roll preview is not supported for %q rollers.", cfg.RollerType())
+	}
+
+	// Find the next roll revision under each strategy.
+	fmt.Printf("Roller: %s\n", cfg.RollerName())
+	for _, s := range rm.ValidStrategies() {
+		if err := repo_manager.SetStrategy(ctx, rm, s, cfg.NBatchMaxCommits); err != nil {
+			return err
+		}
+		if err := rm.Update(ctx); err != nil {
+			return fmt.Errorf("Failed to update repo manager: %s", err)
+		}
+		fmt.Printf("Next roll rev (%s): %s\n", s, rm.NextRollRev())
+	}
+	strat := *rollStrategy
+	if strat == "" {
+		strat = rm.DefaultStrategy()
+	}
+	if err := repo_manager.SetStrategy(ctx, rm, strat, cfg.NBatchMaxCommits); err != nil {
+		return err
+	}
+	if err := rm.Update(ctx); err != nil {
+		return fmt.Errorf("Failed to update repo manager: %s", err)
+	}
+	from, to := rm.LastRollRev(), rm.NextRollRev()
+	fmt.Printf("Last roll rev: %s\n", from)
+	fmt.Printf("Commits not rolled: %d\n", rm.CommitsNotRolled())
+	if from == to {
+		fmt.Printf("Nothing to roll using strategy %q.\n", strat)
+		return nil
+	}
+
+	// Perform the roll, including the pre-upload steps.
+	fmt.Printf("Rolling %s..%s using strategy %q.\n", from, to, strat)
+	preview, err := previewer.PreviewRoll(ctx, from, to, strings.Join(cfg.CqExtraTrybots, ";"))
+	if err != nil {
+		return fmt.Errorf("Failed to preview roll: %s", err)
+	}
+	fmt.Printf("\nCommit message:\n\n%s\n", preview.CommitMsg)
+	fmt.Printf("Diff:\n\n%s\n", preview.Diff)
+	return nil
+}
@@ -26,7 +26,9 @@ import (
 	"go.skia.org/infra/go/human"
 	"go.skia.org/infra/go/metrics2"
 	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/time_window"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
 )
 
 const (
@@ -37,30 +39,33 @@ const (
 // AutoRoller is a struct which automates the merging new revisions of one
 // project into another.
 type AutoRoller struct {
-	childName       string
-	cqExtraTrybots  []string
-	currentRoll     RollImpl
-	emails          []string
-	emailsMtx       sync.RWMutex
-	failureThrottle *state_machine.Throttler
-	gerrit          *gerrit.Gerrit
-	liveness        metrics2.Liveness
-	modeHistory     *modes.ModeHistory
-	notifier        *arb_notifier.AutoRollNotifier
-	parentName      string
-	recent          *recent_rolls.RecentRolls
-	retrieveRoll    func(context.Context, *AutoRoller, int64) (RollImpl, error)
-	rm              repo_manager.RepoManager
-	runningMtx      sync.Mutex
-	safetyThrottle  *state_machine.Throttler
-	serverURL       string
-	sheriff         []string
-	sm              *state_machine.AutoRollStateMachine
-	status          *AutoRollStatusCache
-	statusMtx       sync.RWMutex
-	strategyHistory *strategy.StrategyHistory
-	successThrottle *state_machine.Throttler
-	rollIntoAndroid bool
+	bisector         *state_machine.Bisector
+	childName        string
+	cqExtraTrybots   []string
+	currentRoll      RollImpl
+	emails           []string
+	emailsMtx        sync.RWMutex
+	failureThrottle  *state_machine.Throttler
+	gerrit           *gerrit.Gerrit
+	liveness         metrics2.Liveness
+	modeHistory      *modes.ModeHistory
+	nBatchMaxCommits int
+	notifier         *arb_notifier.AutoRollNotifier
+	parentName       string
+	recent           *recent_rolls.RecentRolls
+	retrieveRoll     func(context.Context, *AutoRoller, int64) (RollImpl, error)
+	rm               repo_manager.RepoManager
+	rollWindow       *time_window.TimeWindow
+	runningMtx       sync.Mutex
+	safetyThrottle   *state_machine.Throttler
+	serverURL        string
+	sheriff          []string
+	sm               *state_machine.AutoRollStateMachine
+	status           *AutoRollStatusCache
+	statusMtx        sync.RWMutex
+	strategyHistory  *strategy.StrategyHistory
+	successThrottle  *state_machine.Throttler
+	rollIntoAndroid  bool
 }
 
 // NewAutoRoller returns an AutoRoller instance.
@@ -98,6 +103,8 @@ func NewAutoRoller(ctx context.Context, c AutoRollerConfig, emailer *email.GMail
 		}
 	} else if c.ManifestRepoManager != nil {
 		rm, err = repo_manager.NewManifestRepoManager(ctx, c.ManifestRepoManager, workdir, g, recipesCfgFile, serverURL)
+	} else if c.MultiDEPSRepoManager != nil {
+		rm, err = repo_manager.NewMultiDEPSRepoManager(ctx, c.MultiDEPSRepoManager, workdir, g, recipesCfgFile, serverURL)
 	} else if c.NoCheckoutDEPSRepoManager != nil {
 		rm, err = repo_manager.NewNoCheckoutDEPSRepoManager(ctx, c.NoCheckoutDEPSRepoManager, workdir, g, recipesCfgFile, serverURL, gitcookiesPath, nil)
 	} else {
@@ -112,7 +119,7 @@ func NewAutoRoller(ctx context.Context, c AutoRollerConfig, emailer *email.GMail
 		return nil, err
 	}
 	initialStrategy := sh.CurrentStrategy().Strategy
-	if err := repo_manager.SetStrategy(ctx, rm, initialStrategy); err != nil {
+	if err := repo_manager.SetStrategy(ctx, rm, initialStrategy, c.NBatchMaxCommits); err != nil {
 		return nil, err
 	}
 	if err := rm.Update(ctx); err != nil {
@@ -143,6 +150,11 @@ func NewAutoRoller(ctx context.Context, c AutoRollerConfig, emailer *email.GMail
 		return nil, err
 	}
 
+	bisector, err := state_machine.NewBisector(path.Join(workdir, "bisect_state"), c.BisectAfterFailures)
+	if err != nil {
+		return nil, err
+	}
+
 	maxRollFreq, err := human.ParseDuration(c.MaxRollFrequency)
 	if err != nil {
 		return nil, err
@@ -152,6 +164,11 @@ func NewAutoRoller(ctx context.Context, c AutoRollerConfig, emailer *email.GMail
 		return nil, err
 	}
 
+	rollWindow, err := time_window.Parse(c.RollWindow)
+	if err != nil {
+		return nil, err
+	}
+
 	emails, err := getSheriff(c.ParentName, c.ChildName, c.Sheriff)
 	if err != nil {
 		return nil, err
@@ -163,24 +180,27 @@ func NewAutoRoller(ctx context.Context, c AutoRollerConfig, emailer *email.GMail
 	}
 
 	arb := &AutoRoller{
-		childName:       c.ChildName,
-		cqExtraTrybots:  c.CqExtraTrybots,
-		emails:          emails,
-		failureThrottle: failureThrottle,
-		gerrit:          g,
-		liveness:        metrics2.NewLiveness("last_autoroll_landed", map[string]string{"roller": c.RollerName()}),
-		modeHistory:     mh,
-		notifier:        n,
-		parentName:      c.ParentName,
-		recent:          recent,
-		retrieveRoll:    retrieveRoll,
-		rm:              rm,
-		safetyThrottle:  safetyThrottle,
-		serverURL:       serverURL,
-		sheriff:         c.Sheriff,
-		status:          &AutoRollStatusCache{},
-		strategyHistory: sh,
-		successThrottle: successThrottle,
+		bisector:         bisector,
+		childName:        c.ChildName,
+		cqExtraTrybots:   c.CqExtraTrybots,
+		emails:           emails,
+		failureThrottle:  failureThrottle,
+		gerrit:           g,
+		liveness:         metrics2.NewLiveness("last_autoroll_landed", map[string]string{"roller": c.RollerName()}),
+		modeHistory:      mh,
+		nBatchMaxCommits: c.NBatchMaxCommits,
+		notifier:         n,
+		parentName:       c.ParentName,
+		recent:           recent,
+		retrieveRoll:     retrieveRoll,
+		rm:               rm,
+		rollWindow:       rollWindow,
+		safetyThrottle:   safetyThrottle,
+		serverURL:        serverURL,
+		sheriff:          c.Sheriff,
+		status:           &AutoRollStatusCache{},
+		strategyHistory:  sh,
+		successThrottle:  successThrottle,
 	}
 	sm, err := state_machine.New(arb, workdir, n)
 	if err != nil {
@@ -297,7 +317,7 @@ func (r *AutoRoller) SetMode(ctx context.Context, mode, user, message string) er
 
 // SetStrategy sets the desired next-roll-revision strategy for the roller.
 func (r *AutoRoller) SetStrategy(ctx context.Context, strategy, user, message string) error {
-	if err := repo_manager.SetStrategy(ctx, r.rm, strategy); err != nil {
+	if err := repo_manager.SetStrategy(ctx, r.rm, strategy, r.nBatchMaxCommits); err != nil {
 		return err
 	}
 	if err := r.strategyHistory.Add(strategy, user, message); err != nil {
@@ -359,6 +379,11 @@ func (r *AutoRoller) UploadNewRoll(ctx context.Context, from, to string, dryRun
 	return nil
 }
 
+// See documentation for state_machine.AutoRollerImpl interface.
+func (r *AutoRoller) Bisector() *state_machine.Bisector {
+	return r.bisector
+}
+
 // Return a state_machine.Throttler indicating that we have failed to roll too many
 // times within a time period.
 func (r *AutoRoller) FailureThrottle() *state_machine.Throttler {
@@ -375,6 +400,31 @@ func (r *AutoRoller) GetNextRollRev() string {
 	return r.rm.NextRollRev()
 }
 
+// See documentation for state_machine.AutoRollerImpl interface.
+func (r *AutoRoller) InRollWindow(t time.Time) bool {
+	return r.rollWindow.Test(t)
+}
+
+// See documentation for state_machine.AutoRollerImpl interface.
+func (r *AutoRoller) RecordRollWindow(ctx context.Context, inWindow bool) error {
+	// Record the change in the mode history so that it's visible
+	// alongside mode changes on the UI. The mode itself does not change.
+	msg := fmt.Sprintf("Outside of the roll window (%s); not uploading rolls until the window opens.", r.rollWindow)
+	if inWindow {
+		msg = fmt.Sprintf("Entered the roll window (%s); resuming normal operation.", r.rollWindow)
+	}
+	sklog.Info(msg)
+	return r.modeHistory.Add(r.GetMode(), "AutoRoll Bot", msg)
+}
+
+// See documentation for state_machine.AutoRollerImpl interface.
+func (r *AutoRoller) NotRolledCommits() []*vcsinfo.LongCommit {
+	if l, ok := r.rm.(repo_manager.NotRolledLister); ok {
+		return l.NotRolledCommits()
+	}
+	return nil
+}
+
 // See documentation for state_machine.AutoRollerImpl interface.
 func (r *AutoRoller) RolledPast(ctx context.Context, rev string) (bool, error) {
 	return r.rm.RolledPast(ctx, rev)
@@ -426,13 +476,26 @@ func (r *AutoRoller) updateStatus(replaceLastError bool, lastError string) error
 		throttledUntil = successThrottledUntil
 	}
 
+	currentRoll := r.recent.CurrentRoll()
+	changelogRev := r.rm.NextRollRev()
+	if currentRoll != nil {
+		changelogRev = currentRoll.RollingTo
+	}
+
+	var children []*repo_manager.ChildStatus
+	if mcrm, ok := r.rm.(repo_manager.MultiChildRepoManager); ok {
+		children = mcrm.ChildStatuses()
+	}
+
 	sklog.Infof("Updating status (%d)", r.rm.CommitsNotRolled())
 	return r.status.Set(&AutoRollStatus{
 		AutoRollMiniStatus: AutoRollMiniStatus{
 			NumFailedRolls:      numFailures,
 			NumNotRolledCommits: r.rm.CommitsNotRolled(),
 		},
-		CurrentRoll:     r.recent.CurrentRoll(),
+		Changelog:       r.changelog(changelogRev),
+		Children:        children,
+		CurrentRoll:     currentRoll,
 		Error:           lastError,
 		FullHistoryUrl:  r.rm.GetFullHistoryUrl(),
 		IssueUrlBase:    r.rm.GetIssueUrlBase(),
@@ -440,6 +503,7 @@ func (r *AutoRoller) updateStatus(replaceLastError bool, lastError string) error
 		LastRollRev:     r.rm.LastRollRev(),
 		Mode:            r.modeHistory.CurrentMode(),
 		Recent:          recent,
+		RollWindow:      r.rollWindow.String(),
 		Status:          string(r.sm.Current()),
 		Strategy:        r.strategyHistory.CurrentStrategy(),
 		ThrottledUntil:  throttledUntil,
@@ -447,6 +511,18 @@ func (r *AutoRoller) updateStatus(replaceLastError bool, lastError string) error
 	})
 }
 
+// changelog returns the Changelog for a roll to the given revision, based on
+// the commits which have not yet been rolled, or nil if it is not available.
+func (r *AutoRoller) changelog(rollingTo string) *repo_manager.Changelog {
+	notRolled := r.NotRolledCommits()
+	for i, c := range notRolled {
+		if c.Hash == rollingTo {
+			return repo_manager.NewChangelog(r.rm.LastRollRev(), rollingTo, notRolled[i:])
+		}
+	}
+	return nil
+}
+
 // Run one iteration of the roller.
 func (r *AutoRoller) Tick(ctx context.Context) error {
 	r.runningMtx.Lock()
@@ -9,10 +9,12 @@ import (
 	"time"
 
 	"github.com/flynn/json5"
+	"go.skia.org/infra/autoroll/go/chat_commands"
 	arb_notifier "go.skia.org/infra/autoroll/go/notifier"
 	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/go/human"
 	"go.skia.org/infra/go/notifier"
+	"go.skia.org/infra/go/time_window"
 	"go.skia.org/infra/go/util"
 )
 
@@ -31,6 +33,7 @@ const (
 	ROLLER_TYPE_GOOGLE3          = "google3"
 	ROLLER_TYPE_INVALID          = "INVALID"
 	ROLLER_TYPE_MANIFEST         = "manifest"
+	ROLLER_TYPE_MULTI_DEPS       = "multiDEPS"
 )
 
 var (
@@ -113,17 +116,31 @@ type AutoRollerConfig struct {
 	GithubRepoManager         *repo_manager.GithubRepoManagerConfig         `json:"githubRepoManager"`
 	Google3RepoManager        *google3FakeRepoManagerConfig                 `json:"google3"`
 	ManifestRepoManager       *repo_manager.ManifestRepoManagerConfig       `json:"manifestRepoManager"`
+	MultiDEPSRepoManager      *repo_manager.MultiDEPSRepoManagerConfig      `json:"multiDEPSRepoManager"`
 	NoCheckoutDEPSRepoManager *repo_manager.NoCheckoutDEPSRepoManagerConfig `json:"noCheckoutDEPSRepoManager"`
 
 	// Optional Fields.
 
+	// If set, bisect failed rolls of multiple commits after this many
+	// consecutive failures, using dry runs, to find the culprit.
+	BisectAfterFailures int `json:"bisectAfterFailures"`
+	// If set, authorized users may control the roller by sending commands
+	// to its chat bot.
+	ChatCommands *chat_commands.Config `json:"chatCommands"`
 	// Comma-separated list of trybots to add to roll CLs, in addition to
 	// the default set of commit queue trybots.
 	CqExtraTrybots []string `json:"cqExtraTrybots"`
 	// Limit to one successful roll within this time period.
 	MaxRollFrequency string `json:"maxRollFrequency"`
+	// Maximum number of commits to include in a single roll when using
+	// the "n_batch" strategy. Defaults to
+	// strategy.DEFAULT_N_BATCH_MAX_COMMITS.
+	NBatchMaxCommits int `json:"nBatchMaxCommits"`
 	// Any extra notification systems to be used for this roller.
 	Notifiers []*notifier.Config `json:"notifiers"`
+	// If set, only upload rolls during this window of time, eg.
+	// "M-F 09:00-17:00". See the time_window package for details.
+	RollWindow string `json:"rollWindow"`
 	// Throttling configuration to prevent uploading too many CLs within
 	// too short a time period.
 	SafetyThrottle *ThrottleConfig `json:"safetyThrottle"`
@@ -175,6 +192,9 @@ func (c *AutoRollerConfig) Validate() error {
 	if c.ManifestRepoManager != nil {
 		rm = append(rm, c.ManifestRepoManager)
 	}
+	if c.MultiDEPSRepoManager != nil {
+		rm = append(rm, c.MultiDEPSRepoManager)
+	}
 	if c.NoCheckoutDEPSRepoManager != nil {
 		rm = append(rm, c.NoCheckoutDEPSRepoManager)
 	}
@@ -184,6 +204,20 @@ func (c *AutoRollerConfig) Validate() error {
 	if err := rm[0].Validate(); err != nil {
 		return err
 	}
+	if _, err := time_window.Parse(c.RollWindow); err != nil {
+		return err
+	}
+	if c.BisectAfterFailures < 0 {
+		return errors.New("BisectAfterFailures must not be negative.")
+	}
+	if c.NBatchMaxCommits < 0 {
+		return errors.New("NBatchMaxCommits must not be negative.")
+	}
+	if c.ChatCommands != nil {
+		if err := c.ChatCommands.Validate(); err != nil {
+			return err
+		}
+	}
 
 	// Verify that the notifier configs are valid.
 	a := arb_notifier.New("fake", "fake", nil)
@@ -214,6 +248,8 @@ func (c *AutoRollerConfig) RollerType() string {
 			c.rollerType = ROLLER_TYPE_GOOGLE3
 		} else if c.ManifestRepoManager != nil {
 			c.rollerType = ROLLER_TYPE_MANIFEST
+		} else if c.MultiDEPSRepoManager != nil {
+			c.rollerType = ROLLER_TYPE_MULTI_DEPS
 		} else if c.NoCheckoutDEPSRepoManager != nil {
 			c.rollerType = ROLLER_TYPE_DEPS_NO_CHECKOUT
 		} else {
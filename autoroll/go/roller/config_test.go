@@ -7,6 +7,7 @@ import (
 
 	"github.com/flynn/json5"
 	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/autoroll/go/chat_commands"
 	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/go/deepequal"
 	"go.skia.org/infra/go/notifier"
@@ -85,6 +86,22 @@ func TestConfigs(t *testing.T) {
 		}
 	}, "Exactly one notification config must be supplied, but got 0")
 
+	testErr(func(c *AutoRollerConfig) {
+		c.BisectAfterFailures = -1
+	}, "BisectAfterFailures must not be negative.")
+
+	testErr(func(c *AutoRollerConfig) {
+		c.NBatchMaxCommits = -1
+	}, "NBatchMaxCommits must not be negative.")
+
+	testErr(func(c *AutoRollerConfig) {
+		c.ChatCommands = &chat_commands.Config{}
+	}, "AuthorizedUsers is required.")
+
+	testErr(func(c *AutoRollerConfig) {
+		c.RollWindow = "M-F 17:00-09:00"
+	}, "Invalid time range \"17:00-09:00\"; end must be after start")
+
 	// Helper function: create a valid base config, allow the caller to
 	// mutate it, then assert that validation succeeds.
 	testNoErr := func(fn func(c *AutoRollerConfig)) {
@@ -95,6 +112,16 @@ func TestConfigs(t *testing.T) {
 
 	// Test cases.
 
+	testNoErr(func(c *AutoRollerConfig) {
+		c.BisectAfterFailures = 3
+	})
+
+	testNoErr(func(c *AutoRollerConfig) {
+		c.ChatCommands = &chat_commands.Config{
+			AuthorizedUsers: []string{"google.com"},
+		}
+	})
+
 	testNoErr(func(c *AutoRollerConfig) {
 		c.CqExtraTrybots = []string{"extra-bot"}
 	})
@@ -121,6 +148,14 @@ func TestConfigs(t *testing.T) {
 		}
 	})
 
+	testNoErr(func(c *AutoRollerConfig) {
+		c.NBatchMaxCommits = 5
+	})
+
+	testNoErr(func(c *AutoRollerConfig) {
+		c.RollWindow = "M-F 09:00-17:00; Sa 10:00-12:00"
+	})
+
 	testNoErr(func(c *AutoRollerConfig) {
 		c.SafetyThrottle = &ThrottleConfig{
 			AttemptCount: 5,
@@ -144,8 +179,13 @@ func TestConfigSerialization(t *testing.T) {
 
 	test()
 
+	a.BisectAfterFailures = 3
+	a.ChatCommands = &chat_commands.Config{
+		AuthorizedUsers: []string{"google.com", "me@example.com"},
+	}
 	a.CqExtraTrybots = []string{"extra-bot"}
 	a.MaxRollFrequency = "1h"
+	a.NBatchMaxCommits = 5
 	a.Notifiers = []*notifier.Config{
 		&notifier.Config{
 			Filter:  "debug",
@@ -161,6 +201,7 @@ func TestConfigSerialization(t *testing.T) {
 			},
 		},
 	}
+	a.RollWindow = "M-F 09:00-17:00"
 	a.SafetyThrottle = &ThrottleConfig{
 		AttemptCount: 5,
 		TimeWindow:   time.Hour,
@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"go.skia.org/infra/autoroll/go/modes"
+	"go.skia.org/infra/autoroll/go/repo_manager"
 	"go.skia.org/infra/autoroll/go/strategy"
 	"go.skia.org/infra/go/autoroll"
 	"go.skia.org/infra/go/util"
@@ -13,20 +14,26 @@ import (
 // the AutoRoll Bot.
 type AutoRollStatus struct {
 	AutoRollMiniStatus
-	ChildHead       string                    `json:"childHead"`
-	CurrentRoll     *autoroll.AutoRollIssue   `json:"currentRoll"`
-	Error           string                    `json:"error"`
-	FullHistoryUrl  string                    `json:"fullHistoryUrl"`
-	IssueUrlBase    string                    `json:"issueUrlBase"`
-	LastRoll        *autoroll.AutoRollIssue   `json:"lastRoll"`
-	LastRollRev     string                    `json:"lastRollRev"`
-	Mode            *modes.ModeChange         `json:"mode"`
-	Recent          []*autoroll.AutoRollIssue `json:"recent"`
-	Status          string                    `json:"status"`
-	Strategy        *strategy.StrategyChange  `json:"strategy"`
-	ThrottledUntil  int64                     `json:"throttledUntil"`
-	ValidModes      []string                  `json:"validModes"`
-	ValidStrategies []string                  `json:"validStrategies"`
+	// Changelog of the current roll, or of the next roll if there is no
+	// current roll.
+	Changelog *repo_manager.Changelog `json:"changelog"`
+	ChildHead string                  `json:"childHead"`
+	// Status of each child, for rollers which roll multiple children.
+	Children        []*repo_manager.ChildStatus `json:"children"`
+	CurrentRoll     *autoroll.AutoRollIssue     `json:"currentRoll"`
+	Error           string                      `json:"error"`
+	FullHistoryUrl  string                      `json:"fullHistoryUrl"`
+	IssueUrlBase    string                      `json:"issueUrlBase"`
+	LastRoll        *autoroll.AutoRollIssue     `json:"lastRoll"`
+	LastRollRev     string                      `json:"lastRollRev"`
+	Mode            *modes.ModeChange           `json:"mode"`
+	Recent          []*autoroll.AutoRollIssue   `json:"recent"`
+	RollWindow      string                      `json:"rollWindow"`
+	Status          string                      `json:"status"`
+	Strategy        *strategy.StrategyChange    `json:"strategy"`
+	ThrottledUntil  int64                       `json:"throttledUntil"`
+	ValidModes      []string                    `json:"validModes"`
+	ValidStrategies []string                    `json:"validStrategies"`
 }
 
 // AutoRollMiniStatus is a struct which provides a minimal amount of status
@@ -54,6 +61,8 @@ type AutoRollMiniStatus struct {
 // AutoRollStatusCache is a struct used for caching roll-up status
 // information about the AutoRoll Bot.
 type AutoRollStatusCache struct {
+	changelog       *repo_manager.Changelog
+	children        []*repo_manager.ChildStatus
 	currentRoll     *autoroll.AutoRollIssue
 	fullHistoryUrl  string
 	issueUrlBase    string
@@ -65,6 +74,7 @@ type AutoRollStatusCache struct {
 	mode            *modes.ModeChange
 	mtx             sync.RWMutex
 	recent          []*autoroll.AutoRollIssue
+	rollWindow      string
 	status          string
 	strategy        *strategy.StrategyChange
 	throttledUntil  int64
@@ -96,11 +106,14 @@ func (c *AutoRollStatusCache) Get(includeError bool, cleanIssue func(*autoroll.A
 			NumFailedRolls:      c.numFailed,
 			NumNotRolledCommits: c.numNotRolled,
 		},
+		Changelog:       c.changelog,
+		Children:        copyChildStatuses(c.children),
 		FullHistoryUrl:  c.fullHistoryUrl,
 		IssueUrlBase:    c.issueUrlBase,
 		LastRollRev:     c.lastRollRev,
 		Mode:            mode,
 		Recent:          recent,
+		RollWindow:      c.rollWindow,
 		Status:          c.status,
 		Strategy:        c.strategy,
 		ThrottledUntil:  c.throttledUntil,
@@ -126,6 +139,18 @@ func (c *AutoRollStatusCache) Get(includeError bool, cleanIssue func(*autoroll.A
 	return s
 }
 
+// copyChildStatuses returns a deep copy of the given ChildStatuses.
+func copyChildStatuses(children []*repo_manager.ChildStatus) []*repo_manager.ChildStatus {
+	if children == nil {
+		return nil
+	}
+	rv := make([]*repo_manager.ChildStatus, 0, len(children))
+	for _, c := range children {
+		rv = append(rv, c.Copy())
+	}
+	return rv
+}
+
 // Set sets the current status information.
 func (c *AutoRollStatusCache) Set(s *AutoRollStatus) error {
 	c.mtx.Lock()
@@ -142,6 +167,9 @@ func (c *AutoRollStatusCache) Set(s *AutoRollStatus) error {
 	if s.LastRoll != nil {
 		c.lastRoll = s.LastRoll.Copy()
 	}
+	// Changelogs are not modified after creation, so we don't need to copy.
+	c.changelog = s.Changelog
+	c.children = copyChildStatuses(s.Children)
 	c.fullHistoryUrl = s.FullHistoryUrl
 	c.issueUrlBase = s.IssueUrlBase
 	c.lastError = s.Error
@@ -150,6 +178,7 @@ func (c *AutoRollStatusCache) Set(s *AutoRollStatus) error {
 	c.numFailed = s.NumFailedRolls
 	c.numNotRolled = s.NumNotRolledCommits
 	c.recent = recent
+	c.rollWindow = s.RollWindow
 	c.status = s.Status
 	c.strategy = s.Strategy
 	c.throttledUntil = s.ThrottledUntil
@@ -0,0 +1,226 @@
+package state_machine
+
+import (
+	"fmt"
+	"sync"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+// Bisection describes the progress of a bisection of a failed roll, used to
+// find the child commit which caused the failure.
+type Bisection struct {
+	// Child commits which may be the culprit, in chronological order.
+	Commits []*vcsinfo.LongCommit
+
+	// Index into Commits of the most recent commit known to roll
+	// successfully, or -1 if only the last-rolled revision is known to be
+	// good.
+	Good int
+
+	// Index into Commits of the earliest commit known to cause the roll
+	// to fail.
+	Bad int
+
+	// URL of a failed roll to Commits[Bad].
+	BadIssueURL string
+}
+
+// Copy returns a deep copy of the Bisection.
+func (b *Bisection) Copy() *Bisection {
+	commits := make([]*vcsinfo.LongCommit, len(b.Commits))
+	copy(commits, b.Commits)
+	return &Bisection{
+		Commits:     commits,
+		Good:        b.Good,
+		Bad:         b.Bad,
+		BadIssueURL: b.BadIssueURL,
+	}
+}
+
+// Culprit returns the commit which caused the roll to fail, or nil if the
+// bisection is not yet finished.
+func (b *Bisection) Culprit() *vcsinfo.LongCommit {
+	if b.Bad-b.Good == 1 {
+		return b.Commits[b.Bad]
+	}
+	return nil
+}
+
+// Next returns the commit which should be tried next, or nil if the bisection
+// is finished.
+func (b *Bisection) Next() *vcsinfo.LongCommit {
+	if b.Culprit() != nil {
+		return nil
+	}
+	return b.Commits[b.Good+(b.Bad-b.Good)/2]
+}
+
+// WithResult returns a copy of the Bisection updated with the result of a
+// roll to the given revision.
+func (b *Bisection) WithResult(rev string, success bool, issueURL string) (*Bisection, error) {
+	idx := -1
+	for i, c := range b.Commits {
+		if c.Hash == rev {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("Revision %s is not one of the commits being bisected.", rev)
+	}
+	// Results which have already been recorded do not change the
+	// Bisection.
+	if (success && idx == b.Good) || (!success && idx == b.Bad) {
+		return b.Copy(), nil
+	}
+	if idx <= b.Good || idx >= b.Bad {
+		return nil, fmt.Errorf("Revision %s is not within the range being bisected.", rev)
+	}
+	rv := b.Copy()
+	if success {
+		rv.Good = idx
+	} else {
+		rv.Bad = idx
+		rv.BadIssueURL = issueURL
+	}
+	return rv, nil
+}
+
+// bisectorState is the persistent state of a Bisector.
+type bisectorState struct {
+	Bisection   *Bisection
+	Failures    int
+	LastCulprit string
+}
+
+// Bisector tracks consecutive roll failures and determines when to bisect
+// a failed roll. A Bisector with a threshold of zero never bisects.
+type Bisector struct {
+	file      string
+	mtx       sync.Mutex
+	state     bisectorState
+	threshold int
+}
+
+// NewBisector returns a Bisector instance which bisects failed rolls after the
+// given number of consecutive failures. If file is not empty, the Bisector's
+// state is persisted there.
+func NewBisector(file string, threshold int) (*Bisector, error) {
+	rv := &Bisector{
+		file:      file,
+		threshold: threshold,
+	}
+	if file != "" {
+		if err := util.MaybeReadGobFile(file, &rv.state); err != nil {
+			return nil, fmt.Errorf("Failed to read bisector state: %s", err)
+		}
+	}
+	return rv, nil
+}
+
+// write persists the state of the Bisector. Assumes that the caller holds a
+// lock.
+func (b *Bisector) write() error {
+	if b.file == "" {
+		return nil
+	}
+	return util.WriteGobFile(b.file, &b.state)
+}
+
+// Current returns the in-progress Bisection, or nil if there is none.
+func (b *Bisector) Current() *Bisection {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state.Bisection == nil {
+		return nil
+	}
+	return b.state.Bisection.Copy()
+}
+
+// IncFailures increments the count of consecutive roll failures.
+func (b *Bisector) IncFailures() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.state.Failures++
+	return b.write()
+}
+
+// ResetFailures resets the count of consecutive roll failures.
+func (b *Bisector) ResetFailures() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state.Failures == 0 {
+		return nil
+	}
+	b.state.Failures = 0
+	return b.write()
+}
+
+// ShouldBisect returns true iff we should bisect a failed roll of the given
+// commits, given in chronological order. We only bisect rolls of more than
+// one commit, after the configured number of consecutive failures, and not if
+// the culprit of a previous bisection is still among the commits.
+func (b *Bisector) ShouldBisect(commits []*vcsinfo.LongCommit) bool {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.threshold <= 0 || b.state.Bisection != nil || len(commits) < 2 || b.state.Failures < b.threshold {
+		return false
+	}
+	for _, c := range commits {
+		if c.Hash == b.state.LastCulprit {
+			return false
+		}
+	}
+	return true
+}
+
+// Start begins bisecting the given commits, given in chronological order,
+// the last of which was rolled in the failed roll with the given URL.
+func (b *Bisector) Start(commits []*vcsinfo.LongCommit, issueURL string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if len(commits) < 2 {
+		return fmt.Errorf("Need at least two commits to bisect, but got %d", len(commits))
+	}
+	b.state.Bisection = &Bisection{
+		Commits:     commits,
+		Good:        -1,
+		Bad:         len(commits) - 1,
+		BadIssueURL: issueURL,
+	}
+	return b.write()
+}
+
+// Record updates the in-progress Bisection with the result of a roll to the
+// given revision.
+func (b *Bisector) Record(rev string, success bool, issueURL string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state.Bisection == nil {
+		return fmt.Errorf("No bisection in progress.")
+	}
+	bisection, err := b.state.Bisection.WithResult(rev, success, issueURL)
+	if err != nil {
+		return err
+	}
+	b.state.Bisection = bisection
+	return b.write()
+}
+
+// Finish ends the in-progress Bisection, if any, and resets the count of
+// consecutive failures. If the Bisection found a culprit, rolls including
+// that commit will not be bisected again.
+func (b *Bisector) Finish() error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if b.state.Bisection != nil {
+		if culprit := b.state.Bisection.Culprit(); culprit != nil {
+			b.state.LastCulprit = culprit.Hash
+		}
+	}
+	b.state.Bisection = nil
+	b.state.Failures = 0
+	return b.write()
+}
@@ -0,0 +1,130 @@
+package state_machine
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+// makeCommits returns n commits in chronological order.
+func makeCommits(n int) []*vcsinfo.LongCommit {
+	rv := make([]*vcsinfo.LongCommit, 0, n)
+	for i := 0; i < n; i++ {
+		rv = append(rv, &vcsinfo.LongCommit{
+			ShortCommit: &vcsinfo.ShortCommit{
+				Hash: fmt.Sprintf("c%d", i),
+			},
+		})
+	}
+	return rv
+}
+
+func TestBisection(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Find the culprit for each possible position in the range.
+	commits := makeCommits(7)
+	for culprit := range commits {
+		b := &Bisection{
+			Commits: commits,
+			Good:    -1,
+			Bad:     len(commits) - 1,
+		}
+		steps := 0
+		for b.Culprit() == nil {
+			next := b.Next()
+			assert.NotNil(t, next)
+			var err error
+			b, err = b.WithResult(next.Hash, next.Hash < commits[culprit].Hash, "url-"+next.Hash)
+			assert.NoError(t, err)
+			steps++
+		}
+		assert.Nil(t, b.Next())
+		assert.Equal(t, commits[culprit].Hash, b.Culprit().Hash)
+		assert.True(t, steps <= 3)
+		if culprit < len(commits)-1 {
+			assert.Equal(t, "url-"+commits[culprit].Hash, b.BadIssueURL)
+		}
+	}
+
+	// Invalid results.
+	b := &Bisection{
+		Commits: commits,
+		Good:    1,
+		Bad:     4,
+	}
+	_, err := b.WithResult("bogus", true, "")
+	assert.EqualError(t, err, "Revision bogus is not one of the commits being bisected.")
+	_, err = b.WithResult("c0", false, "")
+	assert.EqualError(t, err, "Revision c0 is not within the range being bisected.")
+	_, err = b.WithResult("c5", true, "")
+	assert.EqualError(t, err, "Revision c5 is not within the range being bisected.")
+
+	// Recording the same result again has no effect.
+	b2, err := b.WithResult("c1", true, "")
+	assert.NoError(t, err)
+	assert.Equal(t, b, b2)
+	b2, err = b.WithResult("c4", false, "")
+	assert.NoError(t, err)
+	assert.Equal(t, b, b2)
+}
+
+func TestBisector(t *testing.T) {
+	testutils.MediumTest(t)
+
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	file := path.Join(tmp, "bisect_state")
+
+	commits := makeCommits(4)
+	b, err := NewBisector(file, 2)
+	assert.NoError(t, err)
+	assert.Nil(t, b.Current())
+
+	// Only bisect after enough consecutive failures, and only rolls of
+	// multiple commits.
+	assert.False(t, b.ShouldBisect(commits))
+	assert.NoError(t, b.IncFailures())
+	assert.False(t, b.ShouldBisect(commits))
+	assert.NoError(t, b.ResetFailures())
+	assert.NoError(t, b.IncFailures())
+	assert.False(t, b.ShouldBisect(commits))
+	assert.NoError(t, b.IncFailures())
+	assert.True(t, b.ShouldBisect(commits))
+	assert.False(t, b.ShouldBisect(commits[:1]))
+
+	// The state persists across instances.
+	assert.NoError(t, b.Start(commits, "url"))
+	assert.False(t, b.ShouldBisect(commits))
+	assert.NoError(t, b.Record("c1", false, "url-c1"))
+	b, err = NewBisector(file, 2)
+	assert.NoError(t, err)
+	bisection := b.Current()
+	assert.NotNil(t, bisection)
+	assert.Equal(t, "c0", bisection.Next().Hash)
+	assert.NoError(t, b.Record("c0", true, "url-c0"))
+	assert.Equal(t, "c1", b.Current().Culprit().Hash)
+	assert.Equal(t, "url-c1", b.Current().BadIssueURL)
+
+	// After finishing, we don't bisect rolls containing the culprit.
+	assert.NoError(t, b.Finish())
+	assert.Nil(t, b.Current())
+	assert.NoError(t, b.IncFailures())
+	assert.NoError(t, b.IncFailures())
+	assert.False(t, b.ShouldBisect(commits))
+	assert.True(t, b.ShouldBisect(commits[2:]))
+
+	// A Bisector with no threshold never bisects.
+	b, err = NewBisector("", 0)
+	assert.NoError(t, err)
+	assert.NoError(t, b.IncFailures())
+	assert.False(t, b.ShouldBisect(commits))
+	assert.EqualError(t, b.Start(commits[:1], ""), "Need at least two commits to bisect, but got 1")
+	assert.EqualError(t, b.Record("c0", true, ""), "No bisection in progress.")
+}
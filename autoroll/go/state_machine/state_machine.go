@@ -13,6 +13,7 @@ import (
 	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/state_machine"
 	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/go/vcsinfo"
 )
 
 /*
@@ -28,6 +29,7 @@ const (
 	S_NORMAL_FAILURE               = "failure"
 	S_NORMAL_FAILURE_THROTTLED     = "failure throttled"
 	S_NORMAL_SAFETY_THROTTLED      = "safety throttled"
+	S_NORMAL_WAIT_FOR_WINDOW       = "outside roll window"
 	S_DRY_RUN_IDLE                 = "dry run idle"
 	S_DRY_RUN_ACTIVE               = "dry run active"
 	S_DRY_RUN_SUCCESS              = "dry run success"
@@ -35,6 +37,8 @@ const (
 	S_DRY_RUN_FAILURE              = "dry run failure"
 	S_DRY_RUN_FAILURE_THROTTLED    = "dry run failure throttled"
 	S_DRY_RUN_SAFETY_THROTTLED     = "dry run safety throttled"
+	S_BISECT_ACTIVE                = "bisecting"
+	S_BISECT_DRY_RUN_FINISHED      = "bisect dry run finished"
 	S_STOPPED                      = "stopped"
 
 	// Transition function names.
@@ -54,6 +58,12 @@ const (
 	F_RETRY_FAILED_DRY_RUN    = "retry failed dry run"
 	F_NOTIFY_FAILURE_THROTTLE = "notify failure throttled"
 	F_NOTIFY_SAFETY_THROTTLE  = "notify safety throttled"
+	F_WAIT_FOR_WINDOW         = "wait for roll window"
+	F_ENTER_WINDOW            = "enter roll window"
+	F_START_BISECT            = "close roll (failed) and start bisecting"
+	F_BISECT_NEXT             = "upload next bisect dry run"
+	F_FINISH_BISECT           = "finish bisecting"
+	F_ABORT_BISECT            = "abort bisecting"
 
 	// Maximum number of no-op transitions to perform at once. This is an
 	// arbitrary limit just to keep us from performing an unbounded number
@@ -112,6 +122,10 @@ type AutoRollerImpl interface {
 	// Upload a new roll. AutoRollerImpl should track the created roll.
 	UploadNewRoll(ctx context.Context, from, to string, dryRun bool) error
 
+	// Return a Bisector used to find the child commits which cause rolls
+	// to fail.
+	Bisector() *Bisector
+
 	// Return a Throttler indicating that we have failed to roll too many
 	// times within a time period.
 	FailureThrottle() *Throttler
@@ -129,6 +143,18 @@ type AutoRollerImpl interface {
 	// Return the current mode of the AutoRoller.
 	GetMode() string
 
+	// Return true iff the given time is within the window during which
+	// rolls may be uploaded.
+	InRollWindow(time.Time) bool
+
+	// Record that the roller has left (inWindow == false) or entered
+	// (inWindow == true) the window during which rolls may be uploaded.
+	RecordRollWindow(ctx context.Context, inWindow bool) error
+
+	// Return the child commits which have not yet been rolled, in reverse
+	// chronological order. May be nil if the commits are not available.
+	NotRolledCommits() []*vcsinfo.LongCommit
+
 	// Return true if we have already rolled past the given revision.
 	RolledPast(context.Context, string) (bool, error)
 
@@ -258,7 +284,7 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	})
 	b.F(F_CLOSE_FAILED, func(ctx context.Context) error {
 		roll := s.a.GetActiveRoll()
-		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, fmt.Sprintf("Commit queue failed; closing this roll.")); err != nil {
+		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, "Commit queue failed; closing this roll."); err != nil {
 			return err
 		}
 		n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), "This CL was abandoned because the commit queue failed and there are new commits to try.")
@@ -266,7 +292,7 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	})
 	b.F(F_CLOSE_STOPPED, func(ctx context.Context) error {
 		roll := s.a.GetActiveRoll()
-		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, fmt.Sprintf("AutoRoller is stopped; closing the active roll.")); err != nil {
+		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, "AutoRoller is stopped; closing the active roll."); err != nil {
 			return err
 		}
 		n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), "This CL was abandoned because the AutoRoller was stopped.")
@@ -274,7 +300,7 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	})
 	b.F(F_CLOSE_DRY_RUN_FAILED, func(ctx context.Context) error {
 		roll := s.a.GetActiveRoll()
-		if err := roll.Close(ctx, autoroll.ROLL_RESULT_DRY_RUN_FAILURE, fmt.Sprintf("Commit queue failed; closing this roll.")); err != nil {
+		if err := roll.Close(ctx, autoroll.ROLL_RESULT_DRY_RUN_FAILURE, "Commit queue failed; closing this roll."); err != nil {
 			return err
 		}
 		n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), "This CL was abandoned because the commit queue dry run failed and there are new commits to try.")
@@ -367,6 +393,48 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 		n.SendSafetyThrottled(ctx, s.a.SafetyThrottle().ThrottledUntil())
 		return nil
 	})
+	b.F(F_WAIT_FOR_WINDOW, func(ctx context.Context) error {
+		return s.a.RecordRollWindow(ctx, false)
+	})
+	b.F(F_ENTER_WINDOW, func(ctx context.Context) error {
+		return s.a.RecordRollWindow(ctx, true)
+	})
+	b.F(F_START_BISECT, func(ctx context.Context) error {
+		roll := s.a.GetActiveRoll()
+		commits := s.bisectRange(roll.RollingTo())
+		if err := s.a.Bisector().Start(commits, roll.IssueURL()); err != nil {
+			return err
+		}
+		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, "Commit queue failed; closing this roll and bisecting to find the culprit."); err != nil {
+			return err
+		}
+		n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), fmt.Sprintf("This CL was abandoned because the commit queue failed repeatedly. The roller will bisect the %d commits in this roll to find the culprit.", len(commits)))
+		return s.uploadBisectDryRun(ctx, n)
+	})
+	b.F(F_BISECT_NEXT, func(ctx context.Context) error {
+		if err := s.recordBisectResult(ctx); err != nil {
+			return err
+		}
+		return s.uploadBisectDryRun(ctx, n)
+	})
+	b.F(F_FINISH_BISECT, func(ctx context.Context) error {
+		if err := s.recordBisectResult(ctx); err != nil {
+			return err
+		}
+		bisection := s.a.Bisector().Current()
+		if culprit := bisection.Culprit(); culprit != nil {
+			n.SendBisectCulprit(ctx, culprit.Hash, culprit.Author, culprit.Subject, bisection.BadIssueURL)
+		}
+		return s.a.Bisector().Finish()
+	})
+	b.F(F_ABORT_BISECT, func(ctx context.Context) error {
+		roll := s.a.GetActiveRoll()
+		if err := roll.Close(ctx, autoroll.ROLL_RESULT_FAILURE, "AutoRoller is stopped; abandoning bisection."); err != nil {
+			return err
+		}
+		n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), "This CL was abandoned because the AutoRoller was stopped while bisecting.")
+		return s.a.Bisector().Finish()
+	})
 
 	// States and transitions.
 
@@ -382,6 +450,7 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	b.T(S_NORMAL_IDLE, S_NORMAL_SAFETY_THROTTLED, F_NOTIFY_SAFETY_THROTTLE)
 	b.T(S_NORMAL_IDLE, S_NORMAL_SUCCESS_THROTTLED, F_NOOP)
 	b.T(S_NORMAL_IDLE, S_NORMAL_ACTIVE, F_UPLOAD_ROLL)
+	b.T(S_NORMAL_IDLE, S_NORMAL_WAIT_FOR_WINDOW, F_WAIT_FOR_WINDOW)
 	b.T(S_NORMAL_ACTIVE, S_NORMAL_ACTIVE, F_UPDATE_ROLL)
 	b.T(S_NORMAL_ACTIVE, S_DRY_RUN_ACTIVE, F_SWITCH_TO_DRY_RUN)
 	b.T(S_NORMAL_ACTIVE, S_NORMAL_SUCCESS, F_NOOP)
@@ -395,6 +464,7 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	b.T(S_NORMAL_SUCCESS_THROTTLED, S_STOPPED, F_NOOP)
 	b.T(S_NORMAL_FAILURE, S_NORMAL_IDLE, F_CLOSE_FAILED)
 	b.T(S_NORMAL_FAILURE, S_NORMAL_FAILURE_THROTTLED, F_NOTIFY_FAILURE_THROTTLE)
+	b.T(S_NORMAL_FAILURE, S_BISECT_ACTIVE, F_START_BISECT)
 	b.T(S_NORMAL_FAILURE_THROTTLED, S_NORMAL_FAILURE_THROTTLED, F_UPDATE_REPOS)
 	b.T(S_NORMAL_FAILURE_THROTTLED, S_NORMAL_ACTIVE, F_RETRY_FAILED_NORMAL)
 	b.T(S_NORMAL_FAILURE_THROTTLED, S_DRY_RUN_ACTIVE, F_SWITCH_TO_DRY_RUN)
@@ -402,6 +472,18 @@ func New(impl AutoRollerImpl, workdir string, n *notifier.AutoRollNotifier) (*Au
 	b.T(S_NORMAL_FAILURE_THROTTLED, S_STOPPED, F_CLOSE_STOPPED)
 	b.T(S_NORMAL_SAFETY_THROTTLED, S_NORMAL_IDLE, F_NOOP)
 	b.T(S_NORMAL_SAFETY_THROTTLED, S_NORMAL_SAFETY_THROTTLED, F_UPDATE_REPOS)
+	b.T(S_NORMAL_WAIT_FOR_WINDOW, S_NORMAL_WAIT_FOR_WINDOW, F_UPDATE_REPOS)
+	b.T(S_NORMAL_WAIT_FOR_WINDOW, S_NORMAL_IDLE, F_ENTER_WINDOW)
+	b.T(S_NORMAL_WAIT_FOR_WINDOW, S_DRY_RUN_IDLE, F_NOOP)
+	b.T(S_NORMAL_WAIT_FOR_WINDOW, S_STOPPED, F_NOOP)
+
+	// Bisect states.
+	b.T(S_BISECT_ACTIVE, S_BISECT_ACTIVE, F_UPDATE_ROLL)
+	b.T(S_BISECT_ACTIVE, S_BISECT_DRY_RUN_FINISHED, F_NOOP)
+	b.T(S_BISECT_ACTIVE, S_STOPPED, F_ABORT_BISECT)
+	b.T(S_BISECT_DRY_RUN_FINISHED, S_BISECT_ACTIVE, F_BISECT_NEXT)
+	b.T(S_BISECT_DRY_RUN_FINISHED, S_NORMAL_IDLE, F_FINISH_BISECT)
+	b.T(S_BISECT_DRY_RUN_FINISHED, S_STOPPED, F_ABORT_BISECT)
 
 	// Dry run states.
 	b.T(S_DRY_RUN_IDLE, S_STOPPED, F_NOOP)
@@ -467,6 +549,9 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 		default:
 			return "", fmt.Errorf("Invalid mode: %q", desiredMode)
 		}
+		if !s.a.InRollWindow(time.Now()) {
+			return S_NORMAL_WAIT_FOR_WINDOW, nil
+		}
 		current := s.a.GetCurrentRev()
 		next := s.a.GetNextRollRev()
 		if current == next {
@@ -502,6 +587,9 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 		if err := throttle.Inc(); err != nil {
 			return "", err
 		}
+		if err := s.a.Bisector().ResetFailures(); err != nil {
+			return "", err
+		}
 		if throttle.IsThrottled() {
 			return S_NORMAL_SUCCESS_THROTTLED, nil
 		}
@@ -520,6 +608,13 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 		if err := throttle.Inc(); err != nil {
 			return "", err
 		}
+		bisector := s.a.Bisector()
+		if err := bisector.IncFailures(); err != nil {
+			return "", err
+		}
+		if bisector.ShouldBisect(s.bisectRange(s.a.GetActiveRoll().RollingTo())) {
+			return S_BISECT_ACTIVE, nil
+		}
 		if s.a.GetNextRollRev() == s.a.GetActiveRoll().RollingTo() {
 			// Rather than upload the same CL again, we'll try
 			// running the CQ again after a period of throttling.
@@ -544,6 +639,45 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 			return S_NORMAL_SAFETY_THROTTLED, nil
 		}
 		return S_NORMAL_IDLE, nil
+	case S_NORMAL_WAIT_FOR_WINDOW:
+		switch desiredMode {
+		case modes.MODE_RUNNING:
+			break
+		case modes.MODE_DRY_RUN:
+			return S_DRY_RUN_IDLE, nil
+		case modes.MODE_STOPPED:
+			return S_STOPPED, nil
+		default:
+			return "", fmt.Errorf("Invalid mode: %q", desiredMode)
+		}
+		if s.a.InRollWindow(time.Now()) {
+			return S_NORMAL_IDLE, nil
+		}
+		return S_NORMAL_WAIT_FOR_WINDOW, nil
+	case S_BISECT_ACTIVE:
+		if desiredMode == modes.MODE_STOPPED {
+			return S_STOPPED, nil
+		} else if s.a.GetActiveRoll().IsDryRunFinished() {
+			return S_BISECT_DRY_RUN_FINISHED, nil
+		}
+		return S_BISECT_ACTIVE, nil
+	case S_BISECT_DRY_RUN_FINISHED:
+		if desiredMode == modes.MODE_STOPPED {
+			return S_STOPPED, nil
+		}
+		bisection := s.a.Bisector().Current()
+		if bisection == nil {
+			return "", fmt.Errorf("No bisection in progress in state %q", state)
+		}
+		roll := s.a.GetActiveRoll()
+		bisection, err := bisection.WithResult(roll.RollingTo(), roll.IsDryRunSuccess(), roll.IssueURL())
+		if err != nil {
+			return "", err
+		}
+		if bisection.Culprit() != nil {
+			return S_NORMAL_IDLE, nil
+		}
+		return S_BISECT_ACTIVE, nil
 	case S_DRY_RUN_IDLE:
 		if desiredMode == modes.MODE_RUNNING {
 			if s.a.SuccessThrottle().IsThrottled() {
@@ -575,6 +709,11 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 		} else {
 			desiredMode := s.a.GetMode()
 			if desiredMode == modes.MODE_RUNNING {
+				// Keep the dry run going until we're
+				// allowed to land the roll.
+				if !s.a.InRollWindow(time.Now()) {
+					return S_DRY_RUN_ACTIVE, nil
+				}
 				return S_NORMAL_ACTIVE, nil
 			} else if desiredMode == modes.MODE_STOPPED {
 				return S_STOPPED, nil
@@ -592,11 +731,11 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 		}
 		return S_DRY_RUN_IDLE, nil
 	case S_DRY_RUN_SUCCESS_LEAVING_OPEN:
-		if desiredMode == modes.MODE_RUNNING {
+		if desiredMode == modes.MODE_RUNNING && s.a.InRollWindow(time.Now()) {
 			return S_NORMAL_ACTIVE, nil
 		} else if desiredMode == modes.MODE_STOPPED {
 			return S_STOPPED, nil
-		} else if desiredMode != modes.MODE_DRY_RUN {
+		} else if desiredMode != modes.MODE_DRY_RUN && desiredMode != modes.MODE_RUNNING {
 			return "", fmt.Errorf("Invalid mode %q", desiredMode)
 		}
 
@@ -621,7 +760,7 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 			return S_STOPPED, nil
 		} else if s.a.GetNextRollRev() != s.a.GetActiveRoll().RollingTo() {
 			return S_DRY_RUN_IDLE, nil
-		} else if desiredMode == modes.MODE_RUNNING {
+		} else if desiredMode == modes.MODE_RUNNING && s.a.InRollWindow(time.Now()) {
 			return S_NORMAL_ACTIVE, nil
 		} else if s.a.FailureThrottle().IsThrottled() {
 			return S_DRY_RUN_FAILURE_THROTTLED, nil
@@ -637,6 +776,53 @@ func (s *AutoRollStateMachine) GetNext() (string, error) {
 	}
 }
 
+// bisectRange returns the not-yet-rolled child commits up to and including the
+// given revision, in chronological order, or nil if the revision is not among
+// them.
+func (s *AutoRollStateMachine) bisectRange(rollingTo string) []*vcsinfo.LongCommit {
+	notRolled := s.a.NotRolledCommits()
+	for i, c := range notRolled {
+		if c.Hash == rollingTo {
+			rv := make([]*vcsinfo.LongCommit, 0, len(notRolled)-i)
+			for j := len(notRolled) - 1; j >= i; j-- {
+				rv = append(rv, notRolled[j])
+			}
+			return rv
+		}
+	}
+	return nil
+}
+
+// uploadBisectDryRun uploads a dry run to the next revision to try in the
+// in-progress bisection.
+func (s *AutoRollStateMachine) uploadBisectDryRun(ctx context.Context, n *notifier.AutoRollNotifier) error {
+	next := s.a.Bisector().Current().Next()
+	if next == nil {
+		return fmt.Errorf("Bisection is already finished.")
+	}
+	if err := s.a.UploadNewRoll(ctx, s.a.GetCurrentRev(), next.Hash, true); err != nil {
+		return err
+	}
+	roll := s.a.GetActiveRoll()
+	n.SendIssueUpdate(ctx, roll.IssueID(), roll.IssueURL(), fmt.Sprintf("The roller has uploaded a dry run to bisect a failed roll: %s", roll.IssueURL()))
+	return nil
+}
+
+// recordBisectResult records the result of the active bisect dry run and
+// closes it.
+func (s *AutoRollStateMachine) recordBisectResult(ctx context.Context) error {
+	roll := s.a.GetActiveRoll()
+	success := roll.IsDryRunSuccess()
+	if err := s.a.Bisector().Record(roll.RollingTo(), success, roll.IssueURL()); err != nil {
+		return err
+	}
+	result := autoroll.ROLL_RESULT_DRY_RUN_FAILURE
+	if success {
+		result = autoroll.ROLL_RESULT_DRY_RUN_SUCCESS
+	}
+	return roll.Close(ctx, result, "Bisect dry run finished; closing this roll.")
+}
+
 // Attempt to perform the given state transition.
 func (s *AutoRollStateMachine) Transition(ctx context.Context, dest string) error {
 	fName, err := s.s.GetTransitionName(dest)
@@ -13,6 +13,7 @@ import (
 	"go.skia.org/infra/autoroll/go/notifier"
 	"go.skia.org/infra/go/autoroll"
 	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
 
 	assert "github.com/stretchr/testify/require"
 )
@@ -155,6 +156,8 @@ func (r *TestRollCLImpl) Update(ctx context.Context) error {
 type TestAutoRollerImpl struct {
 	t *testing.T
 
+	bisector *Bisector
+
 	createNewRollResult *TestRollCLImpl
 	createNewRollError  error
 
@@ -169,6 +172,9 @@ type TestAutoRollerImpl struct {
 	getNextRollRevError  error
 
 	getModeResult   string
+	inRollWindow    bool
+	notRolled       []*vcsinfo.LongCommit
+	rollWindowLog   []bool
 	rolledPast      map[string]bool
 	safetyThrottle  *Throttler
 	successThrottle *Throttler
@@ -183,10 +189,14 @@ func NewTestAutoRollerImpl(t *testing.T) *TestAutoRollerImpl {
 	assert.NoError(t, err)
 	successThrottle, err := NewThrottler("", time.Duration(0), 0)
 	assert.NoError(t, err)
+	bisector, err := NewBisector("", 0)
+	assert.NoError(t, err)
 	return &TestAutoRollerImpl{
 		t:               t,
+		bisector:        bisector,
 		failureThrottle: failureThrottle,
 		getModeResult:   modes.MODE_RUNNING,
+		inRollWindow:    true,
 		rolledPast:      map[string]bool{},
 		safetyThrottle:  safetyThrottle,
 		successThrottle: successThrottle,
@@ -255,6 +265,42 @@ func (r *TestAutoRollerImpl) SetMode(ctx context.Context, mode string) {
 	r.getModeResult = mode
 }
 
+// See documentation for AutoRollerImpl.
+func (r *TestAutoRollerImpl) InRollWindow(time.Time) bool {
+	return r.inRollWindow
+}
+
+// Set the result of InRollWindow.
+func (r *TestAutoRollerImpl) SetInRollWindow(inWindow bool) {
+	r.inRollWindow = inWindow
+}
+
+// See documentation for AutoRollerImpl.
+func (r *TestAutoRollerImpl) RecordRollWindow(ctx context.Context, inWindow bool) error {
+	r.rollWindowLog = append(r.rollWindowLog, inWindow)
+	return nil
+}
+
+// See documentation for AutoRollerImpl.
+func (r *TestAutoRollerImpl) NotRolledCommits() []*vcsinfo.LongCommit {
+	return r.notRolled
+}
+
+// Set the result of NotRolledCommits, given the hashes of the commits in
+// reverse chronological order.
+func (r *TestAutoRollerImpl) SetNotRolledCommits(hashes ...string) {
+	r.notRolled = make([]*vcsinfo.LongCommit, 0, len(hashes))
+	for _, h := range hashes {
+		r.notRolled = append(r.notRolled, &vcsinfo.LongCommit{
+			ShortCommit: &vcsinfo.ShortCommit{
+				Author:  "me@google.com",
+				Hash:    h,
+				Subject: "Commit " + h,
+			},
+		})
+	}
+}
+
 // See documentation for AutoRollerImpl.
 func (r *TestAutoRollerImpl) RolledPast(ctx context.Context, rev string) (bool, error) {
 	rv, ok := r.rolledPast[rev]
@@ -280,6 +326,11 @@ func (r *TestAutoRollerImpl) SetUpdateError(err error) {
 	r.updateError = err
 }
 
+// See documentation for AutoRollerImpl.
+func (r *TestAutoRollerImpl) Bisector() *Bisector {
+	return r.bisector
+}
+
 // Return a Throttler indicating that we have failed to roll too many
 // times within a time period.
 func (r *TestAutoRollerImpl) FailureThrottle() *Throttler {
@@ -737,3 +788,138 @@ func TestSuccessThrottle(t *testing.T) {
 	r.successThrottle = successThrottle
 	checkNextState(t, sm, S_NORMAL_IDLE)
 }
+
+func TestRollWindow(t *testing.T) {
+	sm, r, cleanup := setup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	checkState(t, sm, S_NORMAL_IDLE)
+
+	// Leave the roll window. We shouldn't upload a roll even though there
+	// is a new commit.
+	r.SetInRollWindow(false)
+	r.SetNextRollRev("HEAD+1")
+	checkNextState(t, sm, S_NORMAL_WAIT_FOR_WINDOW)
+	assert.Equal(t, []bool{false}, r.rollWindowLog)
+	checkNextState(t, sm, S_NORMAL_WAIT_FOR_WINDOW)
+	assert.Nil(t, r.GetActiveRoll())
+
+	// We still have to respect mode changes.
+	r.SetMode(ctx, modes.MODE_STOPPED)
+	checkNextState(t, sm, S_STOPPED)
+	r.SetMode(ctx, modes.MODE_RUNNING)
+	checkNextState(t, sm, S_NORMAL_IDLE)
+	checkNextState(t, sm, S_NORMAL_WAIT_FOR_WINDOW)
+	assert.Equal(t, []bool{false, false}, r.rollWindowLog)
+
+	// Dry runs are not restricted to the roll window.
+	r.SetMode(ctx, modes.MODE_DRY_RUN)
+	checkNextState(t, sm, S_DRY_RUN_IDLE)
+	checkNextState(t, sm, S_DRY_RUN_ACTIVE)
+	roll := r.GetActiveRoll().(*TestRollCLImpl)
+	roll.AssertDryRun()
+	roll.SetDryRunSucceeded()
+	checkNextState(t, sm, S_DRY_RUN_SUCCESS)
+	checkNextState(t, sm, S_DRY_RUN_SUCCESS_LEAVING_OPEN)
+
+	// Switching back to normal mode leaves the dry run open until we're
+	// within the roll window.
+	r.SetMode(ctx, modes.MODE_RUNNING)
+	checkNextState(t, sm, S_DRY_RUN_SUCCESS_LEAVING_OPEN)
+	roll.AssertDryRun()
+	r.SetInRollWindow(true)
+	checkNextState(t, sm, S_NORMAL_ACTIVE)
+	roll.AssertNotDryRun()
+	assert.Equal(t, []bool{false, false}, r.rollWindowLog)
+
+	// Once the roll lands, leave the roll window again, then enter it.
+	roll.SetSucceeded()
+	r.SetCurrentRev(r.GetNextRollRev())
+	checkNextState(t, sm, S_NORMAL_SUCCESS)
+	r.SetRolledPast("HEAD+1", true)
+	checkNextState(t, sm, S_NORMAL_IDLE)
+	r.SetInRollWindow(false)
+	checkNextState(t, sm, S_NORMAL_WAIT_FOR_WINDOW)
+	r.SetNextRollRev("HEAD+2")
+	checkNextState(t, sm, S_NORMAL_WAIT_FOR_WINDOW)
+	r.SetInRollWindow(true)
+	checkNextState(t, sm, S_NORMAL_IDLE)
+	assert.Equal(t, []bool{false, false, false, true}, r.rollWindowLog)
+	checkNextState(t, sm, S_NORMAL_ACTIVE)
+	r.GetActiveRoll().(*TestRollCLImpl).AssertNotDryRun()
+}
+
+func TestBisect(t *testing.T) {
+	sm, r, cleanup := setup(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	bisector, err := NewBisector("", 2)
+	assert.NoError(t, err)
+	r.bisector = bisector
+	r.SetCurrentRev("c0")
+	r.SetNotRolledCommits("c4", "c3", "c2", "c1")
+	r.SetNextRollRev("c4")
+
+	// The first failure doesn't trigger a bisection.
+	checkNextState(t, sm, S_NORMAL_ACTIVE)
+	roll := r.GetActiveRoll().(*TestRollCLImpl)
+	roll.SetFailed()
+	checkNextState(t, sm, S_NORMAL_FAILURE)
+	checkNextState(t, sm, S_NORMAL_IDLE)
+	roll.AssertClosed(autoroll.ROLL_RESULT_FAILURE)
+	assert.Nil(t, bisector.Current())
+
+	// The second consecutive failure does.
+	checkNextState(t, sm, S_NORMAL_ACTIVE)
+	roll = r.GetActiveRoll().(*TestRollCLImpl)
+	roll.SetFailed()
+	checkNextState(t, sm, S_NORMAL_FAILURE)
+	checkNextState(t, sm, S_BISECT_ACTIVE)
+	roll.AssertClosed(autoroll.ROLL_RESULT_FAILURE)
+	assert.NotNil(t, bisector.Current())
+
+	// Bisect dry runs are uploaded until we find the culprit.
+	roll = r.GetActiveRoll().(*TestRollCLImpl)
+	roll.AssertDryRun()
+	assert.Equal(t, "c2", roll.RollingTo())
+	checkNextState(t, sm, S_BISECT_ACTIVE)
+	roll.SetDryRunSucceeded()
+	checkNextState(t, sm, S_BISECT_DRY_RUN_FINISHED)
+	checkNextState(t, sm, S_BISECT_ACTIVE)
+	roll.AssertClosed(autoroll.ROLL_RESULT_DRY_RUN_SUCCESS)
+	roll = r.GetActiveRoll().(*TestRollCLImpl)
+	roll.AssertDryRun()
+	assert.Equal(t, "c3", roll.RollingTo())
+	roll.SetDryRunFailed()
+	checkNextState(t, sm, S_BISECT_DRY_RUN_FINISHED)
+	checkNextState(t, sm, S_NORMAL_IDLE)
+	roll.AssertClosed(autoroll.ROLL_RESULT_DRY_RUN_FAILURE)
+	assert.Nil(t, bisector.Current())
+
+	// We don't bisect again while the culprit is still in the roll.
+	for i := 0; i < 2; i++ {
+		checkNextState(t, sm, S_NORMAL_ACTIVE)
+		roll = r.GetActiveRoll().(*TestRollCLImpl)
+		assert.Equal(t, "c4", roll.RollingTo())
+		roll.AssertNotDryRun()
+		roll.SetFailed()
+		checkNextState(t, sm, S_NORMAL_FAILURE)
+		checkNextState(t, sm, S_NORMAL_IDLE)
+	}
+
+	// Bisection is abandoned when the roller is stopped.
+	bisector, err = NewBisector("", 1)
+	assert.NoError(t, err)
+	r.bisector = bisector
+	checkNextState(t, sm, S_NORMAL_ACTIVE)
+	r.GetActiveRoll().(*TestRollCLImpl).SetFailed()
+	checkNextState(t, sm, S_NORMAL_FAILURE)
+	checkNextState(t, sm, S_BISECT_ACTIVE)
+	roll = r.GetActiveRoll().(*TestRollCLImpl)
+	r.SetMode(ctx, modes.MODE_STOPPED)
+	checkNextState(t, sm, S_STOPPED)
+	roll.AssertClosed(autoroll.ROLL_RESULT_FAILURE)
+	assert.Nil(t, bisector.Current())
+}
@@ -17,8 +17,13 @@ const (
 	ROLL_STRATEGY_AFDO         = "afdo"
 	ROLL_STRATEGY_BATCH        = "batch"
 	ROLL_STRATEGY_FUCHSIA_SDK  = "fuchsiaSDK"
+	ROLL_STRATEGY_N_BATCH      = "n_batch"
 	ROLL_STRATEGY_REMOTE_BATCH = "remote batch"
 	ROLL_STRATEGY_SINGLE       = "single"
+
+	// Default maximum number of commits to include in a single roll when
+	// using ROLL_STRATEGY_N_BATCH.
+	DEFAULT_N_BATCH_MAX_COMMITS = 20
 )
 
 // NextRollStrategy is an interface for modules which determine what the next roll
@@ -30,8 +35,10 @@ type NextRollStrategy interface {
 	GetNextRollRev(context.Context, []*vcsinfo.LongCommit) (string, error)
 }
 
-// Return the NextRollStrategy indicated by the given string.
-func GetNextRollStrategy(ctx context.Context, strategy, branch, upstreamRemote string, repo *git.Checkout, authClient *http.Client) (NextRollStrategy, error) {
+// Return the NextRollStrategy indicated by the given string. nBatchMaxCommits
+// is the maximum number of commits per roll for ROLL_STRATEGY_N_BATCH;
+// DEFAULT_N_BATCH_MAX_COMMITS is used if it is zero.
+func GetNextRollStrategy(ctx context.Context, strategy, branch, upstreamRemote string, nBatchMaxCommits int, repo *git.Checkout, authClient *http.Client) (NextRollStrategy, error) {
 	switch strategy {
 	case ROLL_STRATEGY_AFDO:
 		storageClient, err := storage.NewClient(ctx, option.WithHTTPClient(authClient))
@@ -45,6 +52,14 @@ func GetNextRollStrategy(ctx context.Context, strategy, branch, upstreamRemote s
 		return StrategyHead(branch), nil
 	case ROLL_STRATEGY_FUCHSIA_SDK:
 		return nil, nil // Handled by FuchsiaSDKRepoManager.
+	case ROLL_STRATEGY_N_BATCH:
+		if nBatchMaxCommits == 0 {
+			nBatchMaxCommits = DEFAULT_N_BATCH_MAX_COMMITS
+		}
+		if nBatchMaxCommits < 0 {
+			return nil, fmt.Errorf("Invalid maximum number of commits for roll strategy %q: %d", strategy, nBatchMaxCommits)
+		}
+		return StrategyNBatch(branch, nBatchMaxCommits), nil
 	case ROLL_STRATEGY_REMOTE_BATCH:
 		return StrategyRemoteHead(branch, upstreamRemote, repo), nil
 	case ROLL_STRATEGY_SINGLE:
@@ -122,3 +137,30 @@ func (s *singleStrategy) GetNextRollRev(ctx context.Context, notRolled []*vcsinf
 func StrategySingle(branch string) NextRollStrategy {
 	return &singleStrategy{StrategyHead(branch).(*headStrategy)}
 }
+
+// nBatchStrategy is a NextRollStrategy which rolls toward HEAD of a given
+// branch, at most N commits at a time.
+type nBatchStrategy struct {
+	*headStrategy
+	n int
+}
+
+// See documentation for NextRollStrategy interface.
+func (s *nBatchStrategy) GetNextRollRev(ctx context.Context, notRolled []*vcsinfo.LongCommit) (string, error) {
+	if len(notRolled) > s.n {
+		// Commits are listed in reverse chronological order.
+		return notRolled[len(notRolled)-s.n].Hash, nil
+	} else if len(notRolled) > 0 {
+		return notRolled[0].Hash, nil
+	}
+	return "", nil
+}
+
+// StrategyNBatch returns a NextRollStrategy which rolls toward HEAD of a given
+// branch, at most n commits at a time.
+func StrategyNBatch(branch string, n int) NextRollStrategy {
+	return &nBatchStrategy{
+		headStrategy: StrategyHead(branch).(*headStrategy),
+		n:            n,
+	}
+}
@@ -0,0 +1,52 @@
+package strategy
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/vcsinfo"
+)
+
+func TestNBatchStrategy(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ctx := context.Background()
+	s := StrategyNBatch("master", 3)
+
+	// Commits are listed in reverse chronological order.
+	notRolled := func(n int) []*vcsinfo.LongCommit {
+		rv := make([]*vcsinfo.LongCommit, 0, n)
+		for i := n; i > 0; i-- {
+			rv = append(rv, &vcsinfo.LongCommit{
+				ShortCommit: &vcsinfo.ShortCommit{
+					Hash: fmt.Sprintf("commit%d", i),
+				},
+			})
+		}
+		return rv
+	}
+	check := func(n int, expect string) {
+		next, err := s.GetNextRollRev(ctx, notRolled(n))
+		assert.NoError(t, err)
+		assert.Equal(t, expect, next)
+	}
+	check(0, "")
+	check(1, "commit1")
+	check(3, "commit3")
+	check(4, "commit3")
+	check(10, "commit3")
+
+	// GetNextRollStrategy uses the given maximum number of commits or the
+	// default if none is given.
+	s, err := GetNextRollStrategy(ctx, ROLL_STRATEGY_N_BATCH, "master", "origin", 5, nil, nil)
+	assert.NoError(t, err)
+	check(10, "commit5")
+	s, err = GetNextRollStrategy(ctx, ROLL_STRATEGY_N_BATCH, "master", "origin", 0, nil, nil)
+	assert.NoError(t, err)
+	check(DEFAULT_N_BATCH_MAX_COMMITS+10, fmt.Sprintf("commit%d", DEFAULT_N_BATCH_MAX_COMMITS))
+	_, err = GetNextRollStrategy(ctx, ROLL_STRATEGY_N_BATCH, "master", "origin", -1, nil, nil)
+	assert.Error(t, err)
+}
@@ -3,6 +3,7 @@ package ingestion
 import (
 	"bytes"
 	"context"
+	"crypto/md5"
 	"encoding/hex"
 	"fmt"
 	"io"
@@ -404,3 +405,50 @@ func (f *fsResultFileLocation) TimeStamp() int64 {
 func (f *fsResultFileLocation) Content() []byte {
 	return f.buf
 }
+
+// memResultFileLocation implements the ResultFileLocation interface for
+// files that are held in memory, e.g. because they were uploaded directly.
+type memResultFileLocation struct {
+	name        string
+	buf         []byte
+	md5         string
+	lastUpdated int64
+}
+
+// MemResultFileLocation returns a ResultFileLocation for the given content.
+// name is the path of the file and timeStamp the time it was created in
+// seconds since the epoch.
+func MemResultFileLocation(name string, content []byte, timeStamp int64) ResultFileLocation {
+	hash := md5.Sum(content)
+	return &memResultFileLocation{
+		name:        name,
+		buf:         content,
+		md5:         hex.EncodeToString(hash[:]),
+		lastUpdated: timeStamp,
+	}
+}
+
+// see ResultFileLocation interface.
+func (m *memResultFileLocation) Open() (io.ReadCloser, error) {
+	return ioutil.NopCloser(bytes.NewBuffer(m.buf)), nil
+}
+
+// see ResultFileLocation interface.
+func (m *memResultFileLocation) Name() string {
+	return m.name
+}
+
+// see ResultFileLocation interface.
+func (m *memResultFileLocation) MD5() string {
+	return m.md5
+}
+
+// see ResultFileLocation interface.
+func (m *memResultFileLocation) TimeStamp() int64 {
+	return m.lastUpdated
+}
+
+// see ResultFileLocation interface.
+func (m *memResultFileLocation) Content() []byte {
+	return m.buf
+}
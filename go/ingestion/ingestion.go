@@ -29,6 +29,10 @@ const (
 	TAG_INGESTER_SOURCE   = "source"
 
 	POLL_CHUNK_SIZE = 50
+
+	// PUSH_QUEUE_SIZE is the number of pushed batches of result files that
+	// are buffered while the ingester is busy.
+	PUSH_QUEUE_SIZE = 100
 )
 
 var (
@@ -93,6 +97,7 @@ type Ingester struct {
 	sources        []Source
 	processor      Processor
 	doneCh         chan bool
+	eventChan      chan []ResultFileLocation
 	statusDB       *bolt.DB
 	resultFilesDir string
 
@@ -164,6 +169,28 @@ func (i *Ingester) Start(ctx context.Context) {
 	}(i.doneCh)
 }
 
+// ID returns the id of the ingester, which is also the id of its Processor.
+func (i *Ingester) ID() string {
+	return i.id
+}
+
+// Push sends result files to the ingester that were not delivered by its
+// sources, e.g. because they were uploaded directly. They are processed like
+// polled files, but tracked by separate metrics. Push does not block; it
+// returns an error if the ingester has not been started or if
+// PUSH_QUEUE_SIZE batches are already waiting to be processed.
+func (i *Ingester) Push(resultFiles []ResultFileLocation) error {
+	if i.eventChan == nil {
+		return fmt.Errorf("Ingester %s has not been started.", i.id)
+	}
+	select {
+	case i.eventChan <- resultFiles:
+		return nil
+	default:
+		return fmt.Errorf("Ingester %s is busy. Too many pushed result files are waiting to be processed.", i.id)
+	}
+}
+
 // stop stops the ingestion process. Currently only used for testing.
 func (i *Ingester) stop() {
 	close(i.doneCh)
@@ -184,7 +211,7 @@ func (q *rflQueue) clear() {
 
 func (i *Ingester) getInputChannels(ctx context.Context) (<-chan []ResultFileLocation, <-chan []ResultFileLocation) {
 	pollChan := make(chan []ResultFileLocation)
-	eventChan := make(chan []ResultFileLocation)
+	i.eventChan = make(chan []ResultFileLocation, PUSH_QUEUE_SIZE)
 	i.doneCh = make(chan bool)
 
 	for idx, source := range i.sources {
@@ -221,7 +248,7 @@ func (i *Ingester) getInputChannels(ctx context.Context) (<-chan []ResultFileLoc
 			})
 		}(source, i.srcMetrics[idx], i.doneCh)
 	}
-	return pollChan, eventChan
+	return pollChan, i.eventChan
 }
 
 // inProcessedFiles returns true if the given md5 hash is in the list of
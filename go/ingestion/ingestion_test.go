@@ -223,3 +223,28 @@ func TestIngesterNilVcs(t *testing.T) {
 	delta := -time.Duration(conf.MinDays) * time.Hour * 24
 	assert.Equal(t, time.Unix(end, 0).Add(delta).Unix(), start)
 }
+
+func TestIngesterPush(t *testing.T) {
+	testutils.SmallTest(t)
+	statusDir := LOCAL_STATUS_DIR + "-push"
+	defer util.RemoveAll(statusDir)
+
+	conf := &sharedconfig.IngesterConfig{
+		MinDays:   3,
+		StatusDir: statusDir,
+	}
+	ingester, err := NewIngester("test-ingester", conf, nil, nil, nil)
+	assert.NoError(t, err)
+	rf := MemResultFileLocation("push/result.json", []byte("{}"), time.Now().Unix())
+
+	// Pushing to an ingester which was not started fails instead of blocking.
+	assert.Error(t, ingester.Push([]ResultFileLocation{rf}))
+
+	// Pushes are queued while the ingester is busy, up to PUSH_QUEUE_SIZE.
+	ingester.getInputChannels(context.Background())
+	defer ingester.stop()
+	for i := 0; i < PUSH_QUEUE_SIZE; i++ {
+		assert.NoError(t, ingester.Push([]ResultFileLocation{rf}))
+	}
+	assert.Error(t, ingester.Push([]ResultFileLocation{rf}))
+}
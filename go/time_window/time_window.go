@@ -0,0 +1,164 @@
+// Package time_window provides a way to describe recurring windows of time,
+// eg. "weekdays between 9am and 5pm".
+package time_window
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+/*
+	A TimeWindow is specified as a semicolon-separated list of day ranges, each
+	optionally followed by a range of times of day, eg:
+
+		M-F 09:00-17:00; Sa 10:00-12:00
+
+	Days are given as M, Tu, W, Th, F, Sa, Su. Times of day are given in
+	24-hour HH:MM format and are interpreted as UTC. The end of each range of
+	times is exclusive. A day range with no times covers the whole day.
+*/
+
+var (
+	DAYS = map[string]time.Weekday{
+		"Su": time.Sunday,
+		"M":  time.Monday,
+		"Tu": time.Tuesday,
+		"W":  time.Wednesday,
+		"Th": time.Thursday,
+		"F":  time.Friday,
+		"Sa": time.Saturday,
+	}
+)
+
+// dayWindow represents a window of time on a single day of the week. Start
+// and end are offsets from midnight.
+type dayWindow struct {
+	day   time.Weekday
+	start time.Duration
+	end   time.Duration
+}
+
+// TimeWindow represents a set of recurring windows of time within a week.
+type TimeWindow struct {
+	spec    string
+	windows []*dayWindow
+}
+
+// parseDay parses a single day of the week.
+func parseDay(s string) (time.Weekday, error) {
+	d, ok := DAYS[s]
+	if !ok {
+		return time.Sunday, fmt.Errorf("Invalid day %q; expected one of M, Tu, W, Th, F, Sa, Su", s)
+	}
+	return d, nil
+}
+
+// parseTime parses a time of day in HH:MM format and returns its offset from
+// midnight.
+func parseTime(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time of day %q; expected HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseDayRange parses a day range, eg. "M-F", into the list of days it
+// covers.
+func parseDayRange(s string) ([]time.Weekday, error) {
+	split := strings.Split(s, "-")
+	if len(split) > 2 {
+		return nil, fmt.Errorf("Invalid day range %q", s)
+	}
+	first, err := parseDay(split[0])
+	if err != nil {
+		return nil, err
+	}
+	last := first
+	if len(split) == 2 {
+		last, err = parseDay(split[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	rv := []time.Weekday{first}
+	// Allow ranges which wrap around the end of the week, eg. "Sa-M".
+	for d := first; d != last; {
+		d = (d + 1) % 7
+		rv = append(rv, d)
+	}
+	return rv, nil
+}
+
+// Parse returns a TimeWindow based on the given specification. An empty
+// specification results in a nil TimeWindow, which includes all times.
+func Parse(spec string) (*TimeWindow, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+	rv := &TimeWindow{
+		spec: spec,
+	}
+	for _, w := range strings.Split(spec, ";") {
+		fields := strings.Fields(w)
+		if len(fields) < 1 || len(fields) > 2 {
+			return nil, fmt.Errorf("Invalid time window %q; expected a day range and an optional time range, eg. \"M-F 09:00-17:00\"", w)
+		}
+		days, err := parseDayRange(fields[0])
+		if err != nil {
+			return nil, err
+		}
+		start := time.Duration(0)
+		end := 24 * time.Hour
+		if len(fields) == 2 {
+			times := strings.Split(fields[1], "-")
+			if len(times) != 2 {
+				return nil, fmt.Errorf("Invalid time range %q; expected HH:MM-HH:MM", fields[1])
+			}
+			start, err = parseTime(times[0])
+			if err != nil {
+				return nil, err
+			}
+			end, err = parseTime(times[1])
+			if err != nil {
+				return nil, err
+			}
+			if end <= start {
+				return nil, fmt.Errorf("Invalid time range %q; end must be after start", fields[1])
+			}
+		}
+		for _, d := range days {
+			rv.windows = append(rv.windows, &dayWindow{
+				day:   d,
+				start: start,
+				end:   end,
+			})
+		}
+	}
+	return rv, nil
+}
+
+// Test returns true iff the given time falls within the TimeWindow. A nil
+// TimeWindow includes all times.
+func (w *TimeWindow) Test(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.UTC()
+	offset := t.Sub(time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC))
+	for _, dw := range w.windows {
+		if t.Weekday() == dw.day && offset >= dw.start && offset < dw.end {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the specification from which the TimeWindow was created.
+func (w *TimeWindow) String() string {
+	if w == nil {
+		return ""
+	}
+	return w.spec
+}
@@ -0,0 +1,69 @@
+package time_window
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestParseErrors(t *testing.T) {
+	testutils.SmallTest(t)
+
+	check := func(spec, expectErr string) {
+		_, err := Parse(spec)
+		assert.EqualError(t, err, expectErr)
+	}
+	check("Mo", "Invalid day \"Mo\"; expected one of M, Tu, W, Th, F, Sa, Su")
+	check("M-W-F", "Invalid day range \"M-W-F\"")
+	check("M 09:00", "Invalid time range \"09:00\"; expected HH:MM-HH:MM")
+	check("M 9am-5pm", "Invalid time of day \"9am\"; expected HH:MM")
+	check("M 17:00-09:00", "Invalid time range \"17:00-09:00\"; end must be after start")
+	check("M 09:00-17:00 extra", "Invalid time window \"M 09:00-17:00 extra\"; expected a day range and an optional time range, eg. \"M-F 09:00-17:00\"")
+	check("M;", "Invalid time window \"\"; expected a day range and an optional time range, eg. \"M-F 09:00-17:00\"")
+}
+
+func TestTimeWindow(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// A nil window includes all times.
+	w, err := Parse("")
+	assert.NoError(t, err)
+	assert.Nil(t, w)
+	assert.True(t, w.Test(time.Now()))
+
+	w, err = Parse("M-F 09:00-17:00; Sa 10:00-12:00; Su")
+	assert.NoError(t, err)
+	assert.Equal(t, "M-F 09:00-17:00; Sa 10:00-12:00; Su", w.String())
+
+	// Monday, January 1, 2018.
+	mon := time.Date(2018, time.January, 1, 0, 0, 0, 0, time.UTC)
+	check := func(expect bool, day int, hour, minute int) {
+		ts := mon.Add(time.Duration(day)*24*time.Hour + time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute)
+		assert.Equal(t, expect, w.Test(ts), ts.String())
+	}
+	check(false, 0, 8, 59)
+	check(true, 0, 9, 0)
+	check(true, 2, 12, 0)
+	check(true, 4, 16, 59)
+	check(false, 4, 17, 0)
+	check(false, 5, 9, 30)
+	check(true, 5, 11, 59)
+	check(false, 5, 12, 0)
+	check(true, 6, 0, 0)
+	check(true, 6, 23, 59)
+
+	// Times are converted to UTC.
+	loc := time.FixedZone("UTC-8", -8*60*60)
+	assert.True(t, w.Test(time.Date(2018, time.January, 1, 1, 0, 0, 0, loc)))
+
+	// Day ranges may wrap around the end of the week.
+	w, err = Parse("Sa-M")
+	assert.NoError(t, err)
+	check(true, 0, 12, 0)
+	check(false, 1, 12, 0)
+	check(false, 4, 12, 0)
+	check(true, 5, 12, 0)
+	check(true, 6, 12, 0)
+}
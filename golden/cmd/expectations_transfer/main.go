@@ -0,0 +1,159 @@
+package main
+
+// Exports the expectations and the triage log of a Gold instance to a file or
+// imports such a file into the expectations store of another instance, e.g.
+// to seed a staging instance. The expectations can either be stored in MySQL
+// or in Cloud Datastore.
+//
+// Running instances cache the expectations. To import into a running instance
+// use its /json/expectations/import endpoint instead.
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/database"
+	"go.skia.org/infra/go/ds"
+	"go.skia.org/infra/go/skiaversion"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/db"
+	"go.skia.org/infra/golden/go/expstorage"
+)
+
+const (
+	// Values for the 'action' flag.
+	ACTION_EXPORT = "export"
+	ACTION_IMPORT = "import"
+
+	// Values for the 'store' flag.
+	STORE_SQL   = "sql"
+	STORE_CLOUD = "cloud"
+)
+
+// Command line flags
+var (
+	action         = flag.String("action", "", "Either 'export' or 'import'.")
+	dryRun         = flag.Bool("dry_run", false, "Only report what would be imported without changing the expectations.")
+	dsNamespace    = flag.String("ds_namespace", "", "Cloud datastore namespace to be used if 'store' is 'cloud'.")
+	file           = flag.String("file", "", "File to export to or import from.")
+	includeLog     = flag.Bool("include_log", true, "Export the triage log in addition to the expectations.")
+	overwrite      = flag.Bool("overwrite", false, "Overwrite labels that are different in the imported expectations.")
+	projectID      = flag.String("project_id", common.PROJECT_ID, "GCP project ID.")
+	promptPassword = flag.Bool("password", false, "Prompt for the MySQL password.")
+	replayLog      = flag.Bool("replay_log", false, "Replay the imported triage log before the expectations are imported. Requires that there are no expectations yet.")
+	source         = flag.String("source", "", "Name of the instance that is exported, e.g. 'gold.skia.org'.")
+	storeType      = flag.String("store", STORE_SQL, "Where the expectations are stored. Either 'sql' or 'cloud'.")
+	user           = flag.String("user", "", "User that imported changes are attributed to.")
+)
+
+func main() {
+	defer common.LogPanic()
+	// Don't specify a default host or database to avoid accidental writes.
+	dbConf := database.ConfigFromFlags("", db.PROD_DB_PORT, database.USER_RW, "", db.MigrationSteps())
+
+	// Global init to initialize logging and parse arguments.
+	common.Init()
+	skiaversion.MustLogVersion()
+
+	if *file == "" {
+		sklog.Fatalf("The 'file' flag is required.")
+	}
+
+	store := newExpectationsStore(dbConf)
+	switch *action {
+	case ACTION_EXPORT:
+		exportExpectations(store)
+	case ACTION_IMPORT:
+		importExpectations(store)
+	default:
+		sklog.Fatalf("Unknown action %q. Must be '%s' or '%s'.", *action, ACTION_EXPORT, ACTION_IMPORT)
+	}
+}
+
+// newExpectationsStore returns the expectations store selected by the 'store' flag.
+func newExpectationsStore(dbConf *database.DatabaseConfig) expstorage.ExpectationsStore {
+	switch *storeType {
+	case STORE_SQL:
+		if *promptPassword {
+			if err := dbConf.PromptForPassword(); err != nil {
+				sklog.Fatal(err)
+			}
+		}
+		vdb, err := dbConf.NewVersionedDB()
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		return expstorage.NewSQLExpectationStore(vdb)
+	case STORE_CLOUD:
+		if err := ds.InitWithOpt(*projectID, *dsNamespace); err != nil {
+			sklog.Fatalf("Unable to configure cloud datastore: %s", err)
+		}
+		store, _, err := expstorage.NewCloudExpectationsStore(ds.DS, nil)
+		if err != nil {
+			sklog.Fatalf("Unable to create cloud expectations store: %s", err)
+		}
+		return store
+	}
+	sklog.Fatalf("Unknown store %q. Must be '%s' or '%s'.", *storeType, STORE_SQL, STORE_CLOUD)
+	return nil
+}
+
+// exportExpectations writes the expectations of the given store to the file.
+func exportExpectations(store expstorage.ExpectationsStore) {
+	export, err := expstorage.Export(store, *source, *includeLog)
+	if err != nil {
+		sklog.Fatalf("Unable to export expectations: %s", err)
+	}
+
+	f, err := os.Create(*file)
+	if err != nil {
+		sklog.Fatalf("Unable to create file %s: %s", *file, err)
+	}
+	if err := expstorage.WriteExport(f, export); err != nil {
+		util.Close(f)
+		sklog.Fatalf("Unable to write %s: %s", *file, err)
+	}
+	if err := f.Close(); err != nil {
+		sklog.Fatalf("Unable to close %s: %s", *file, err)
+	}
+	sklog.Infof("Exported expectations for %d tests and %d triage log entries to %s", len(export.Expectations), len(export.TriageLog), *file)
+}
+
+// importExpectations imports the file into the given store and prints the
+// result, including all conflicts, to stdout.
+func importExpectations(store expstorage.ExpectationsStore) {
+	if *user == "" && !*dryRun {
+		sklog.Fatalf("The 'user' flag is required to import expectations.")
+	}
+
+	f, err := os.Open(*file)
+	if err != nil {
+		sklog.Fatalf("Unable to open file %s: %s", *file, err)
+	}
+	defer util.Close(f)
+
+	export, err := expstorage.ReadExport(f)
+	if err != nil {
+		sklog.Fatalf("Unable to read %s: %s", *file, err)
+	}
+
+	opts := expstorage.ImportOptions{
+		Overwrite: *overwrite,
+		ReplayLog: *replayLog,
+		DryRun:    *dryRun,
+	}
+	result, err := expstorage.ImportExpectations(store, export, *user, opts)
+	if err != nil {
+		sklog.Fatalf("Unable to import expectations: %s", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		sklog.Fatalf("Unable to write result: %s", err)
+	}
+	sklog.Infof("Imported expectations from %s: %d added, %d unchanged, %d conflicts, %d labels changed.", export.Source, result.Added, result.Unchanged, len(result.Conflicts), result.Applied)
+}
@@ -1,4 +1,5 @@
-// Simple command line app the applies our image diff library to two PNGs.
+// Simple command line app the applies our image diff library to two images.
+// See diff.DecodeImage for the supported formats.
 package main
 
 import (
@@ -22,11 +23,11 @@ func main() {
 	if flag.NArg() != 2 {
 		log.Fatal("Usage: imagediff [--out filename] imagepath1.png imagepath2.png\n")
 	}
-	a, err := diff.OpenNRGBAFromFile(flag.Arg(0))
+	a, err := diff.OpenImageFromFile(flag.Arg(0))
 	if err != nil {
 		log.Fatal(err)
 	}
-	b, err := diff.OpenNRGBAFromFile(flag.Arg(1))
+	b, err := diff.OpenImageFromFile(flag.Arg(1))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -26,6 +26,7 @@ var (
 	gsBaseDir          = flag.String("gs_basedir", diffstore.DEFAULT_GCS_IMG_DIR_NAME, "String that represents the google storage directory/directories following the GS bucket")
 	imageDir           = flag.String("image_dir", "/tmp/imagedir", "What directory to store test and diff images in.")
 	imagePort          = flag.String("image_port", ":9001", "Address that serves image files via HTTP.")
+	migrateMetrics     = flag.Bool("migrate_metrics", false, "Adds diff metrics that were introduced after diffs were cached to the cached diffs in the background.")
 	noCloudLog         = flag.Bool("no_cloud_log", false, "Disables cloud logging. Primarily for running locally.")
 	grpcPort           = flag.String("grpc_port", ":9000", "gRPC service address (e.g., ':9000')")
 	promPort           = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
@@ -77,6 +78,10 @@ func main() {
 		memDiffStore.(*diffstore.MemDiffStore).ConvertLegacy()
 	}
 
+	if *migrateMetrics {
+		memDiffStore.(*diffstore.MemDiffStore).MigrateDiffMetrics()
+	}
+
 	// Create the server side instance of the DiffService.
 	codec := diffstore.MetricMapCodec{}
 	serverImpl := diffstore.NewDiffServiceServer(memDiffStore, codec)
@@ -38,6 +38,7 @@ import (
 	"go.skia.org/infra/go/metadata"
 	"go.skia.org/infra/go/skiaversion"
 	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/tiling"
 	"go.skia.org/infra/go/timer"
 	tracedb "go.skia.org/infra/go/trace/db"
 	"go.skia.org/infra/go/util"
@@ -46,8 +47,10 @@ import (
 	"go.skia.org/infra/golden/go/diffstore"
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/fuzzy"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/savedsearch"
 	"go.skia.org/infra/golden/go/search"
 	"go.skia.org/infra/golden/go/status"
 	"go.skia.org/infra/golden/go/storage"
@@ -64,6 +67,9 @@ const (
 
 	// WHITELIST_ALL can be provided as the value for the whitelist file to whitelist all configurations
 	WHITELIST_ALL = "all"
+
+	// MIRROR_USER_ID is the user that mirrored expectation changes are attributed to.
+	MIRROR_USER_ID = "expectations-mirror"
 )
 
 // Command line flags.
@@ -79,6 +85,7 @@ var (
 	dsNamespace         = flag.String("ds_namespace", "", "Cloud datastore namespace to be used by this instance.")
 	eventTopic          = flag.String("event_topic", "", "The pubsub topic to use for distributed events.")
 	forceLogin          = flag.Bool("force_login", true, "Force the user to be authenticated for all requests.")
+	fuzzyRules          = flag.String("fuzzy_rules", "", "File name of a JSON5 file that contains rules to automatically label untriaged digests positive if they are nearly identical to a positive digest. Only used if 'authoritative' is true. If empty no digests are auto-triaged.")
 	gsBucketNames       = flag.String("gs_buckets", "skia-infra-gm,chromium-skia-gm", "Comma-separated list of google storage bucket that hold uploaded images.")
 	hashesGSPath        = flag.String("hashes_gs_path", "", "GS path, where the known hashes file should be stored. If empty no file will be written. Format: <bucket>/<path>.")
 	baselineGSPath      = flag.String("baseline_gs_path", "", "GS path, where the baseline file should be stored. If empty no file will be written. Format: <bucket>/<path>.")
@@ -88,6 +95,8 @@ var (
 	issueTrackerKey     = flag.String("issue_tracker_key", "", "API Key for accessing the project hosting API.")
 	local               = flag.Bool("local", false, "Running locally if true. As opposed to in production.")
 	memProfile          = flag.Duration("memprofile", 0, "Duration for which to profile memory. After this duration the program writes the memory profile and exits.")
+	mirrorExpectations  = flag.String("mirror_expectations_from", "", "URL of the expectations export endpoint of a primary instance, e.g. 'http://gold-primary:8001/json/expectations/export' on its internal port. If set the expectations of this instance are periodically replaced with the ones of the primary instance, i.e. triage changes made on this instance are overwritten.")
+	mirrorInterval      = flag.Duration("mirror_interval", 5*time.Minute, "Interval at which expectations are mirrored from 'mirror_expectations_from'.")
 	nCommits            = flag.Int("n_commits", 50, "Number of recent commits to include in the analysis.")
 	noCloudLog          = flag.Bool("no_cloud_log", false, "Disables cloud logging. Primarily for running locally.")
 	port                = flag.String("port", ":9000", "HTTP service address (e.g., ':9000')")
@@ -318,6 +327,11 @@ func main() {
 		sklog.Fatalf("Unable to instantiate tryjob store: %s", err)
 	}
 
+	savedSearchStore, err := savedsearch.NewCloudSavedSearchStore(ds.DS)
+	if err != nil {
+		sklog.Fatalf("Unable to instantiate saved search store: %s", err)
+	}
+
 	// Extract the site URL
 	siteURL, err := httputils.GetBaseURL(*redirectURL)
 	if err != nil {
@@ -336,6 +350,7 @@ func main() {
 		GerritAPI:         gerritAPI,
 		GStorageClient:    gsClient,
 		Git:               git,
+		SavedSearchStore:  savedSearchStore,
 	}
 
 	// Load the whitelist if there is one and disable querying for issues.
@@ -345,6 +360,28 @@ func main() {
 		}
 	}
 
+	// Mirror the expectations of a primary instance. Since this overwrites all
+	// local changes, the mirror can not change expectations automatically.
+	if *mirrorExpectations != "" {
+		if *authoritative {
+			sklog.Fatalf("An instance that mirrors expectations can not be authoritative.")
+		}
+		mirrorClient := httputils.NewConfiguredTimeoutClient(httputils.DIAL_TIMEOUT, 5*time.Minute)
+		if err := expstorage.StartMirror(mirrorClient, *mirrorExpectations, storages.ExpectationsStore, MIRROR_USER_ID, *mirrorInterval); err != nil {
+			sklog.Fatalf("Unable to mirror expectations: %s", err)
+		}
+	}
+
+	// Load the fuzzy matching rules. Only the authoritative instance changes
+	// expectations automatically.
+	if *fuzzyRules != "" && *authoritative {
+		rules, err := fuzzy.LoadRules(*fuzzyRules)
+		if err != nil {
+			sklog.Fatalf("Unable to load fuzzy matching rules: %s", err)
+		}
+		storages.FuzzyMatcher = fuzzy.New(rules, storages.DiffStore, storages.ExpectationsStore)
+	}
+
 	// Check if this is public instance. If so make sure there is a white list.
 	if !*forceLogin && (*pubWhiteList == "") {
 		sklog.Fatalf("Empty whitelist file. A non-empty white list must be provided if force_login=false.")
@@ -365,6 +402,11 @@ func main() {
 		sklog.Fatalf("Failed to create indexer: %s", err)
 	}
 
+	// Monitor ignore rules that don't hide anything useful anymore.
+	ignore.InitStaleRulesMonitoring(storages.IgnoreStore, storages.ExpectationsStore, func() *tiling.Tile {
+		return ixr.GetIndex().GetTile(true)
+	})
+
 	searchAPI, err := search.NewSearchAPI(storages, ixr)
 	if err != nil {
 		sklog.Fatalf("Failed to create instance of search API: %s", err)
@@ -418,20 +460,27 @@ func main() {
 	router.HandleFunc("/json/details", handlers.JsonDetailsHandler).Methods("GET")
 	router.HandleFunc("/json/triage", handlers.JsonTriageHandler).Methods("POST")
 	router.HandleFunc("/json/clusterdiff", handlers.JsonClusterDiffHandler).Methods("GET")
+	router.HandleFunc("/json/clusteruntriaged", handlers.JsonClusterUntriagedHandler).Methods("GET")
 	router.HandleFunc("/json/cmp", handlers.JsonCompareTestHandler).Methods("POST")
 	router.HandleFunc("/json/triagelog", handlers.JsonTriageLogHandler).Methods("GET")
 	router.HandleFunc("/json/triagelog/undo", handlers.JsonTriageUndoHandler).Methods("POST")
+	router.HandleFunc("/json/triagelog/digest", handlers.JsonDigestHistoryHandler).Methods("GET")
+	router.HandleFunc("/json/expectations/at", handlers.JsonExpectationsAtHandler).Methods("GET")
+	router.HandleFunc("/json/expectations/export", handlers.JsonExpectationsExportHandler).Methods("GET")
+	router.HandleFunc("/json/expectations/import", handlers.JsonExpectationsImportHandler).Methods("POST")
 	router.HandleFunc("/json/failure", handlers.JsonListFailureHandler).Methods("GET")
 	router.HandleFunc("/json/failure/clear", handlers.JsonClearFailureHandler).Methods("POST")
 	router.HandleFunc("/json/cleardigests", handlers.JsonClearDigests).Methods("POST")
 	router.HandleFunc("/json/search", handlers.JsonSearchHandler).Methods("GET")
 	router.HandleFunc("/json/export", handlers.JsonExportHandler).Methods("GET")
+	router.HandleFunc("/json/savedsearches", handlers.JsonSavedSearchesHandler).Methods("GET")
 	router.HandleFunc("/json/tryjob", handlers.JsonTryjobListHandler).Methods("GET")
 	router.HandleFunc("/json/tryjob/{id}", handlers.JsonTryjobSummaryHandler).Methods("GET")
 
 	// Retrieving that baseline for master and an Gerrit issue are handled the same way
 	router.HandleFunc(web.BASELINE_ROUTE, handlers.JsonBaselineHandler).Methods("GET")
 	router.HandleFunc(web.BASELINE_ISSUE_ROUTE, handlers.JsonBaselineHandler).Methods("GET")
+	router.HandleFunc(web.BASELINE_COMMIT_ROUTE, handlers.JsonBaselineCommitHandler).Methods("GET")
 	router.HandleFunc("/json/refresh/{id}", handlers.JsonRefreshIssue).Methods("GET")
 
 	// Only expose these endpoints if login is enforced across the app or this an open site.
@@ -440,6 +489,10 @@ func main() {
 		router.HandleFunc("/json/ignores/add/", handlers.JsonIgnoresAddHandler).Methods("POST")
 		router.HandleFunc("/json/ignores/del/{id}", handlers.JsonIgnoresDeleteHandler).Methods("POST")
 		router.HandleFunc("/json/ignores/save/{id}", handlers.JsonIgnoresUpdateHandler).Methods("POST")
+		router.HandleFunc("/json/ignores/impact", handlers.JsonIgnoresImpactHandler).Methods("GET")
+		router.HandleFunc("/json/ignores/stale", handlers.JsonIgnoresStaleHandler).Methods("GET")
+		router.HandleFunc("/json/savedsearches/save", handlers.JsonSavedSearchSaveHandler).Methods("POST")
+		router.HandleFunc("/json/savedsearches/del/{name}", handlers.JsonSavedSearchDeleteHandler).Methods("POST")
 	}
 
 	// For everything else serve the same markup.
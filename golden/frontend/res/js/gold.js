@@ -21,13 +21,19 @@ var gold = gold || {};
   gold.REF_TRACE = 'trace';
 
   // Metric values.
+	gold.METRIC_COLOR    = 'color';
 	gold.METRIC_COMBINED = 'combined';
+	gold.METRIC_EDGE     = 'edge';
 	gold.METRIC_PERCENT  = 'percent';
 	gold.METRIC_PIXEL    = 'pixel';
+	gold.METRIC_SSIM     = 'ssim';
   gold.allMetrics = [
     gold.METRIC_COMBINED,
     gold.METRIC_PERCENT,
     gold.METRIC_PIXEL,
+    gold.METRIC_SSIM,
+    gold.METRIC_COLOR,
+    gold.METRIC_EDGE,
   ];
 
   // Default values for match selection.
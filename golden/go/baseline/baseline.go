@@ -7,6 +7,7 @@ import (
 
 	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/tally"
 	"go.skia.org/infra/golden/go/tryjobstore"
@@ -79,6 +80,14 @@ type CommitableBaseLine struct {
 // expectations and the given tile. The commit of the baseline is last commit
 // in tile.
 func GetBaselineForMaster(exps *expstorage.Expectations, tile *tiling.Tile) *CommitableBaseLine {
+	return GetBaselineForCommit(exps, tile, len(tile.Commits)-1)
+}
+
+// GetBaselineForCommit calculates the master baseline as it was at the commit
+// with the given index in the tile, i.e. it only considers digests produced
+// at or before that commit. To get the baseline of an old commit exps should
+// be the expectations at the time of that commit, see expstorage.History.
+func GetBaselineForCommit(exps *expstorage.Expectations, tile *tiling.Tile, commitIdx int) *CommitableBaseLine {
 	commits := tile.Commits
 	var startCommit *tiling.Commit = nil
 	var endCommit *tiling.Commit = nil
@@ -87,7 +96,7 @@ func GetBaselineForMaster(exps *expstorage.Expectations, tile *tiling.Tile) *Com
 	for _, trace := range tile.Traces {
 		gTrace := trace.(*types.GoldenTrace)
 		testName := gTrace.Params_[types.PRIMARY_KEY_FIELD]
-		if idx := gTrace.LastIndex(); idx >= 0 {
+		if idx := lastIndexAt(gTrace, commitIdx); idx >= 0 {
 			digest := gTrace.Values[idx]
 			if exps.Classification(testName, digest) == types.POSITIVE {
 				masterBaseline.add(testName, digest)
@@ -162,6 +171,17 @@ func CommitIssueBaseline(issueID int64, user string, tryjobStore tryjobstore.Try
 	return tryjobStore.CommitIssueExp(issueID, commitFn)
 }
 
+// lastIndexAt returns the index of the last non-empty value in the trace at
+// or before endIdx and -1 if there is none.
+func lastIndexAt(trace *types.GoldenTrace, endIdx int) int {
+	for i := util.MinInt(endIdx, len(trace.Values)-1); i >= 0; i-- {
+		if trace.Values[i] != types.MISSING_DIGEST {
+			return i
+		}
+	}
+	return -1
+}
+
 // minCommit returns newCommit if it appears before current (or current is nil).
 func minCommit(current *tiling.Commit, newCommit *tiling.Commit) *tiling.Commit {
 	if current == nil || newCommit == nil || newCommit.CommitTime < current.CommitTime {
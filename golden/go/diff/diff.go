@@ -1,6 +1,9 @@
 package diff
 
 import (
+	"bufio"
+	"bytes"
+	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
@@ -13,6 +16,17 @@ import (
 
 	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/util"
+	"golang.org/x/image/webp"
+)
+
+const (
+	// PNG_MAGIC is the signature at the start of every PNG file.
+	PNG_MAGIC = "\x89PNG\r\n\x1a\n"
+
+	// WEBP_MAGIC_RIFF and WEBP_MAGIC_WEBP are found at offsets 0 and 8 of
+	// every WebP file.
+	WEBP_MAGIC_RIFF = "RIFF"
+	WEBP_MAGIC_WEBP = "WEBP"
 )
 
 var (
@@ -161,17 +175,76 @@ type DiffStore interface {
 	PurgeDigests(digests []string, purgeGCS bool) error
 }
 
+// DecodeImage reads an image from the given reader. The format is detected
+// from the content: PNG (8 and 16-bit), WebP and Radiance HDR images are
+// supported. 8-bit images are returned as *image.NRGBA, exactly like
+// OpenNRGBA does. Images with a higher precision are returned in their native
+// format, e.g. *image.NRGBA64 for 16-bit PNGs and *FloatImage for HDR images,
+// so they can be diffed at that precision by PixelDiff.
+func DecodeImage(reader io.Reader) (image.Image, error) {
+	// The first 12 bytes are enough to identify all supported formats.
+	r := bufio.NewReader(reader)
+	magic, err := r.Peek(12)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, []byte(PNG_MAGIC)):
+		im, err := png.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		if IsHighPrecision(im) {
+			return im, nil
+		}
+		return GetNRGBA(im), nil
+	case len(magic) >= 12 && string(magic[0:4]) == WEBP_MAGIC_RIFF && string(magic[8:12]) == WEBP_MAGIC_WEBP:
+		im, err := webp.Decode(r)
+		if err != nil {
+			return nil, err
+		}
+		return GetNRGBA(im), nil
+	case bytes.HasPrefix(magic, []byte(HDR_MAGIC)) || bytes.HasPrefix(magic, []byte(HDR_MAGIC_RGBE)):
+		return DecodeHDR(r)
+	}
+	return nil, fmt.Errorf("Unknown image format.")
+}
+
+// IsHighPrecision returns true if the image stores more than 8 bits per
+// channel.
+func IsHighPrecision(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16, *FloatImage:
+		return true
+	}
+	return false
+}
+
+// OpenImageFromFile opens the given image file and returns the image in its
+// native precision. See DecodeImage.
+func OpenImageFromFile(fileName string) (image.Image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer util.Close(f)
+
+	return DecodeImage(f)
+}
+
 // OpenNRGBA reads an NRGBA image from the given reader.
-// If the underlying image is not NRGBA it will be converted.
+// If the underlying image is not NRGBA it will be converted. Any format
+// supported by DecodeImage can be read.
 func OpenNRGBA(reader io.Reader) (*image.NRGBA, error) {
-	im, err := png.Decode(reader)
+	im, err := DecodeImage(reader)
 	if err != nil {
 		return nil, err
 	}
 	return GetNRGBA(im), nil
 }
 
-// OpenNRGBAFromFile opens the given file path to a PNG file and returns the image as image.NRGBA.
+// OpenNRGBAFromFile opens the given file path to an image file and returns the image as image.NRGBA.
 func OpenNRGBAFromFile(fileName string) (*image.NRGBA, error) {
 	f, err := os.Open(fileName)
 	if err != nil {
@@ -274,7 +347,13 @@ func GetNRGBA(img image.Image) *image.NRGBA {
 
 // PixelDiff is a utility function that calculates the DiffMetrics and the image of the
 // difference for the provided images.
+//
+// If one of the images has more than 8 bits per channel the images are
+// compared at that precision, see highPrecisionPixelDiff.
 func PixelDiff(img1, img2 image.Image) (*DiffMetrics, *image.NRGBA) {
+	if IsHighPrecision(img1) || IsHighPrecision(img2) {
+		return highPrecisionPixelDiff(img1, img2)
+	}
 
 	img1Bounds := img1.Bounds()
 	img2Bounds := img2.Bounds()
@@ -362,3 +441,79 @@ func PixelDiff(img1, img2 image.Image) (*DiffMetrics, *image.NRGBA) {
 		MaxRGBADiffs:     maxRGBADiffs,
 		DimDiffer:        (cmpWidth != resultWidth) || (cmpHeight != resultHeight)}, resultImg
 }
+
+// highPrecisionPixelDiff is the equivalent of PixelDiff for images with more
+// than 8 bits per channel. The channels are compared as floats, which
+// represent 16-bit and float values exactly, so pixels that differ by less
+// than one 8-bit step are counted as different. MaxRGBADiffs and the colors of
+// the diff image use the same 8-bit scale as PixelDiff, where any difference
+// is at least 1 and differences of HDR values are capped at 255.
+func highPrecisionPixelDiff(img1, img2 image.Image) (*DiffMetrics, *image.NRGBA) {
+	f1 := GetFloatImage(img1)
+	f2 := GetFloatImage(img2)
+	img1Bounds := f1.Bounds()
+	img2Bounds := f2.Bounds()
+
+	cmpWidth := util.MinInt(img1Bounds.Dx(), img2Bounds.Dx())
+	cmpHeight := util.MinInt(img1Bounds.Dy(), img2Bounds.Dy())
+	resultWidth := util.MaxInt(img1Bounds.Dx(), img2Bounds.Dx())
+	resultHeight := util.MaxInt(img1Bounds.Dy(), img2Bounds.Dy())
+	resultImg := image.NewNRGBA(image.Rect(0, 0, resultWidth, resultHeight))
+	totalPixels := resultWidth * resultHeight
+	dimDiffer := (cmpWidth != resultWidth) || (cmpHeight != resultHeight)
+
+	// As in PixelDiff, pixels outside of the compared area are different.
+	numDiffPixels := totalPixels
+	if dimDiffer {
+		maxDiffColor := uint8ToColor(PixelDiffColor[deltaOffset(1024)])
+		draw.Draw(resultImg, resultImg.Bounds(), &image.Uniform{maxDiffColor}, image.ZP, draw.Src)
+	}
+
+	maxRGBADiffs := make([]int, 4)
+	deltas := make([]int, 4)
+	for y := 0; y < cmpHeight; y++ {
+		for x := 0; x < cmpWidth; x++ {
+			p1 := f1.Pix[f1.PixOffset(img1Bounds.Min.X+x, img1Bounds.Min.Y+y):]
+			p2 := f2.Pix[f2.PixOffset(img2Bounds.Min.X+x, img2Bounds.Min.Y+y):]
+			resultOffset := resultImg.PixOffset(x, y)
+
+			differ := false
+			for c := 0; c < 4; c++ {
+				deltas[c] = 0
+				if p1[c] != p2[c] {
+					differ = true
+					deltas[c] = floatDelta(p1[c], p2[c])
+					maxRGBADiffs[c] = util.MaxInt(deltas[c], maxRGBADiffs[c])
+				}
+			}
+
+			if !differ {
+				numDiffPixels--
+				copy(resultImg.Pix[resultOffset:resultOffset+4], []uint8{0, 0, 0, 0})
+			} else if deltas[0]+deltas[1]+deltas[2] > 0 {
+				copy(resultImg.Pix[resultOffset:], PixelDiffColor[deltaOffset(deltas[0]+deltas[1]+deltas[2]+deltas[3])])
+			} else {
+				copy(resultImg.Pix[resultOffset:], PixelAlphaDiffColor[deltaOffset(deltas[3])])
+			}
+		}
+	}
+
+	return &DiffMetrics{
+		NumDiffPixels:    numDiffPixels,
+		PixelDiffPercent: GetPixelDiffPercent(numDiffPixels, totalPixels),
+		MaxRGBADiffs:     maxRGBADiffs,
+		DimDiffer:        dimDiffer}, resultImg
+}
+
+// floatDelta returns the difference of two different channel values on the
+// 8-bit scale, i.e. a value in [1, 255].
+func floatDelta(v1, v2 float32) int {
+	// The epsilon compensates the rounding errors of values that were
+	// converted from 16 bits, so that a difference of exactly one 8-bit step
+	// is 1.
+	d := int(math.Ceil(math.Abs(float64(v1)-float64(v2))*255 - 1e-4))
+	if d < 1 {
+		return 1
+	}
+	return util.MinInt(d, 255)
+}
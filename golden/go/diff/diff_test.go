@@ -3,6 +3,9 @@ package diff
 import (
 	"bytes"
 	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
 	"path/filepath"
 	"reflect"
 	"strings"
@@ -275,6 +278,100 @@ func assertDiffs(t *testing.T, d1, d2 string, expectedDiffMetrics *DiffMetrics)
 	}
 }
 
+// encodePNG returns the given image encoded as PNG.
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, img))
+	return buf.Bytes()
+}
+
+func TestDecodeImage(t *testing.T) {
+	testutils.MediumTest(t)
+
+	// 8-bit PNGs are decoded exactly as before.
+	for _, digest := range []string{"4029959456464745507", "b716a12d5b98d04b15db1d9dd82c82ea"} {
+		content, err := ioutil.ReadFile(filepath.Join(TESTDATA_DIR, digest+".png"))
+		assert.NoError(t, err)
+		decoded, err := DecodeImage(bytes.NewReader(content))
+		assert.NoError(t, err)
+		original, err := png.Decode(bytes.NewReader(content))
+		assert.NoError(t, err)
+		assert.Equal(t, GetNRGBA(original), decoded)
+	}
+
+	// 16-bit PNGs keep their precision.
+	img := image.NewNRGBA64(image.Rect(0, 0, 2, 2))
+	img.SetNRGBA64(1, 1, color.NRGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff})
+	decoded, err := DecodeImage(bytes.NewReader(encodePNG(t, img)))
+	assert.NoError(t, err)
+	assert.True(t, IsHighPrecision(decoded))
+	assert.Equal(t, color.NRGBA64{R: 0x1234, G: 0x5678, B: 0x9abc, A: 0xffff}, color.NRGBA64Model.Convert(decoded.At(1, 1)))
+
+	// OpenNRGBA converts them to 8 bits.
+	nrgba, err := OpenNRGBA(bytes.NewReader(encodePNG(t, img)))
+	assert.NoError(t, err)
+	assert.Equal(t, color.NRGBA{R: 0x12, G: 0x56, B: 0x9a, A: 0xff}, nrgba.NRGBAAt(1, 1))
+
+	_, err = DecodeImage(bytes.NewReader([]byte("not an image")))
+	assert.Error(t, err)
+	_, err = DecodeImage(bytes.NewReader([]byte{}))
+	assert.Error(t, err)
+}
+
+func TestHighPrecisionPixelDiff(t *testing.T) {
+	testutils.SmallTest(t)
+
+	img1 := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	img2 := image.NewNRGBA64(image.Rect(0, 0, 4, 4))
+	for y := 0; y < 4; y++ {
+		for x := 0; x < 4; x++ {
+			c := color.NRGBA64{R: 0x8000, G: 0x8000, B: 0x8000, A: 0xffff}
+			img1.SetNRGBA64(x, y, c)
+			img2.SetNRGBA64(x, y, c)
+		}
+	}
+
+	// A difference that is lost when the images are converted to 8 bits.
+	img2.SetNRGBA64(0, 0, color.NRGBA64{R: 0x8001, G: 0x8000, B: 0x8000, A: 0xffff})
+	// A difference of one 8-bit step in alpha.
+	img2.SetNRGBA64(1, 0, color.NRGBA64{R: 0x8000, G: 0x8000, B: 0x8000, A: 0xffff - 0x101})
+	// A large difference.
+	img2.SetNRGBA64(2, 0, color.NRGBA64{R: 0, G: 0x8000, B: 0xffff, A: 0xffff})
+
+	dm, diffImg := PixelDiff(img1, img2)
+	assert.Equal(t, &DiffMetrics{
+		NumDiffPixels:    3,
+		PixelDiffPercent: 3.0 / 16.0 * 100,
+		MaxRGBADiffs:     []int{128, 0, 128, 1},
+		DimDiffer:        false,
+	}, dm)
+	assert.Equal(t, uint8ToColor(PixelDiffColor[0]), diffImg.At(0, 0))
+	assert.Equal(t, uint8ToColor(PixelAlphaDiffColor[0]), diffImg.At(1, 0))
+	assert.Equal(t, uint8ToColor(PixelDiffColor[deltaOffset(256)]), diffImg.At(2, 0))
+	assert.Equal(t, color.NRGBA{}, diffImg.At(3, 0))
+
+	// The 8-bit versions only differ in two pixels.
+	dm, _ = PixelDiff(GetNRGBA(img1), GetNRGBA(img2))
+	assert.Equal(t, 2, dm.NumDiffPixels)
+
+	// Identical images and images of different sizes.
+	dm, _ = PixelDiff(img1, img1)
+	assert.Equal(t, 0, dm.NumDiffPixels)
+	dm, diffImg = PixelDiff(img1, image.NewNRGBA64(image.Rect(0, 0, 4, 5)))
+	assert.True(t, dm.DimDiffer)
+	assert.Equal(t, 20, dm.NumDiffPixels)
+	assert.Equal(t, uint8ToColor(PixelDiffColor[deltaOffset(1024)]), diffImg.At(0, 4))
+
+	// Differences of HDR values above 1.0 are found.
+	hdr1 := NewFloatImage(image.Rect(0, 0, 2, 1))
+	hdr2 := NewFloatImage(image.Rect(0, 0, 2, 1))
+	copy(hdr1.Pix, []float32{2, 2, 2, 1, 0.5, 0.5, 0.5, 1})
+	copy(hdr2.Pix, []float32{4, 2, 2, 1, 0.5, 0.5, 0.5, 1})
+	dm, _ = PixelDiff(hdr1, hdr2)
+	assert.Equal(t, 1, dm.NumDiffPixels)
+	assert.Equal(t, []int{255, 0, 0, 0}, dm.MaxRGBADiffs)
+}
+
 func TestDeltaOffset(t *testing.T) {
 	testutils.SmallTest(t)
 	testCases := []struct {
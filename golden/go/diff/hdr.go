@@ -0,0 +1,292 @@
+package diff
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"strings"
+)
+
+const (
+	// HDR_MAGIC and HDR_MAGIC_RGBE are the possible first lines of a Radiance
+	// HDR (RGBE) file.
+	HDR_MAGIC      = "#?RADIANCE"
+	HDR_MAGIC_RGBE = "#?RGBE"
+
+	// HDR_FORMAT is the only pixel format of Radiance HDR files we support.
+	// The XYZE format is not produced by our tests.
+	HDR_FORMAT = "32-bit_rle_rgbe"
+
+	// MAX_HDR_PIXELS is the maximum number of pixels of an HDR image we
+	// decode. The header determines the size of the image buffer, so this
+	// prevents a crafted header from allocating an arbitrary amount of memory.
+	// Decoded images use 16 bytes per pixel.
+	MAX_HDR_PIXELS = 16 * 1024 * 1024
+
+	// Width limits of scanlines that can be stored with the adaptive
+	// run-length encoding.
+	hdrMinRLEWidth = 8
+	hdrMaxRLEWidth = 0x7fff
+)
+
+// FloatImage is an in-memory image with non-premultiplied float32 RGBA
+// values. Values are linear and are not limited to the range [0, 1], which
+// allows to represent HDR images at their native precision.
+type FloatImage struct {
+	// Pix holds the image's pixels in R, G, B, A order. The pixel at (x, y)
+	// starts at Pix[(y-Rect.Min.Y)*Stride + (x-Rect.Min.X)*4].
+	Pix []float32
+
+	// Stride is the Pix stride between vertically adjacent pixels.
+	Stride int
+
+	// Rect is the image's bounds.
+	Rect image.Rectangle
+}
+
+// NewFloatImage returns a new FloatImage with the given bounds.
+func NewFloatImage(r image.Rectangle) *FloatImage {
+	return &FloatImage{
+		Pix:    make([]float32, 4*r.Dx()*r.Dy()),
+		Stride: 4 * r.Dx(),
+		Rect:   r,
+	}
+}
+
+// GetFloatImage converts the image to a *FloatImage. Values of images that
+// are not FloatImages are scaled to [0, 1] without any color space conversion.
+func GetFloatImage(img image.Image) *FloatImage {
+	if f, ok := img.(*FloatImage); ok {
+		return f
+	}
+
+	bounds := img.Bounds()
+	ret := NewFloatImage(bounds)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := color.NRGBA64Model.Convert(img.At(x, y)).(color.NRGBA64)
+			p := ret.Pix[ret.PixOffset(x, y):]
+			p[0] = float32(c.R) / 0xffff
+			p[1] = float32(c.G) / 0xffff
+			p[2] = float32(c.B) / 0xffff
+			p[3] = float32(c.A) / 0xffff
+		}
+	}
+	return ret
+}
+
+// ColorModel implements the image.Image interface.
+func (f *FloatImage) ColorModel() color.Model { return color.NRGBA64Model }
+
+// Bounds implements the image.Image interface.
+func (f *FloatImage) Bounds() image.Rectangle { return f.Rect }
+
+// At implements the image.Image interface. The linear values are clamped to
+// [0, 1] and converted to sRGB, which makes HDR images viewable and allows to
+// use them wherever 8-bit images are expected.
+func (f *FloatImage) At(x, y int) color.Color {
+	if !(image.Point{x, y}.In(f.Rect)) {
+		return color.NRGBA64{}
+	}
+	p := f.Pix[f.PixOffset(x, y):]
+	return color.NRGBA64{
+		R: toUint16(linearToSRGB(p[0])),
+		G: toUint16(linearToSRGB(p[1])),
+		B: toUint16(linearToSRGB(p[2])),
+		A: toUint16(p[3]),
+	}
+}
+
+// PixOffset returns the index of the first element of Pix that corresponds to
+// the pixel at (x, y).
+func (f *FloatImage) PixOffset(x, y int) int {
+	return (y-f.Rect.Min.Y)*f.Stride + (x-f.Rect.Min.X)*4
+}
+
+// linearToSRGB converts a linear color value to sRGB and clamps it to [0, 1].
+func linearToSRGB(v float32) float32 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 1
+	}
+	if v <= 0.0031308 {
+		return 12.92 * v
+	}
+	return float32(1.055*math.Pow(float64(v), 1/2.4) - 0.055)
+}
+
+// toUint16 converts a value in [0, 1] to a 16-bit color value.
+func toUint16(v float32) uint16 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 0xffff
+	}
+	return uint16(v*0xffff + 0.5)
+}
+
+// DecodeHDR decodes a Radiance HDR (RGBE) image into a FloatImage. Only the
+// standard orientation ("-Y <height> +X <width>") is supported.
+func DecodeHDR(reader io.Reader) (*FloatImage, error) {
+	r := bufio.NewReader(reader)
+	width, height, err := readHDRHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := NewFloatImage(image.Rect(0, 0, width, height))
+	scanline := make([]byte, 4*width)
+	for y := 0; y < height; y++ {
+		if err := readHDRScanline(r, scanline); err != nil {
+			return nil, fmt.Errorf("Unable to read scanline %d: %s", y, err)
+		}
+		pix := ret.Pix[y*ret.Stride:]
+		for x := 0; x < width; x++ {
+			rgbe := scanline[x*4 : x*4+4]
+			// An exponent of zero encodes black.
+			if rgbe[3] != 0 {
+				f := float32(math.Ldexp(1, int(rgbe[3])-(128+8)))
+				pix[x*4+0] = float32(rgbe[0]) * f
+				pix[x*4+1] = float32(rgbe[1]) * f
+				pix[x*4+2] = float32(rgbe[2]) * f
+			}
+			pix[x*4+3] = 1
+		}
+	}
+	return ret, nil
+}
+
+// readHDRHeader reads the header of a Radiance HDR file including the
+// resolution line and returns the width and height of the image.
+func readHDRHeader(r *bufio.Reader) (int, int, error) {
+	line, err := readHDRLine(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	if line != HDR_MAGIC && line != HDR_MAGIC_RGBE {
+		return 0, 0, fmt.Errorf("Not a Radiance HDR file.")
+	}
+
+	// The header consists of variables and comments terminated by an empty line.
+	for {
+		if line, err = readHDRLine(r); err != nil {
+			return 0, 0, err
+		}
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT="+HDR_FORMAT {
+			return 0, 0, fmt.Errorf("Unsupported HDR format: %s", line)
+		}
+	}
+
+	if line, err = readHDRLine(r); err != nil {
+		return 0, 0, err
+	}
+	var width, height int
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &height, &width); err != nil {
+		return 0, 0, fmt.Errorf("Unsupported HDR resolution %q: %s", line, err)
+	}
+	if width <= 0 || height <= 0 {
+		return 0, 0, fmt.Errorf("Invalid HDR dimensions %dx%d", width, height)
+	}
+	if width > MAX_HDR_PIXELS/height {
+		return 0, 0, fmt.Errorf("HDR image of size %dx%d exceeds the maximum of %d pixels", width, height, MAX_HDR_PIXELS)
+	}
+	return width, height, nil
+}
+
+// readHDRLine reads a line of the header of a Radiance HDR file.
+func readHDRLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("Unable to read HDR header: %s", err)
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// readHDRScanline reads one scanline of RGBE pixels into scanline. It handles
+// flat, old-style run-length encoded and adaptive run-length encoded scanlines.
+func readHDRScanline(r *bufio.Reader, scanline []byte) error {
+	width := len(scanline) / 4
+	rgbe := scanline[:4]
+	if _, err := io.ReadFull(r, rgbe); err != nil {
+		return err
+	}
+
+	// Adaptive run-length encoding stores the four components separately.
+	if width >= hdrMinRLEWidth && width <= hdrMaxRLEWidth && rgbe[0] == 2 && rgbe[1] == 2 && rgbe[2]&0x80 == 0 {
+		if encWidth := int(rgbe[2])<<8 | int(rgbe[3]); encWidth != width {
+			return fmt.Errorf("Scanline width %d does not match image width %d", encWidth, width)
+		}
+		for c := 0; c < 4; c++ {
+			for x := 0; x < width; {
+				count, err := r.ReadByte()
+				if err != nil {
+					return err
+				}
+				if count > 128 {
+					// A run of identical values.
+					n := int(count) - 128
+					if x+n > width {
+						return fmt.Errorf("Run exceeds scanline.")
+					}
+					val, err := r.ReadByte()
+					if err != nil {
+						return err
+					}
+					for ; n > 0; n-- {
+						scanline[x*4+c] = val
+						x++
+					}
+				} else {
+					// A sequence of literal values.
+					n := int(count)
+					if n == 0 || x+n > width {
+						return fmt.Errorf("Invalid literal count %d", n)
+					}
+					for ; n > 0; n-- {
+						val, err := r.ReadByte()
+						if err != nil {
+							return err
+						}
+						scanline[x*4+c] = val
+						x++
+					}
+				}
+			}
+		}
+		return nil
+	}
+
+	// Flat scanline, where the old run-length encoding repeats the previous
+	// pixel if a pixel is (1, 1, 1, n).
+	shift := uint(0)
+	for x := 1; x < width; {
+		pixel := scanline[x*4 : x*4+4]
+		if _, err := io.ReadFull(r, pixel); err != nil {
+			return err
+		}
+		if pixel[0] == 1 && pixel[1] == 1 && pixel[2] == 1 {
+			n := int(pixel[3]) << shift
+			if x+n > width {
+				return fmt.Errorf("Run exceeds scanline.")
+			}
+			for ; n > 0; n-- {
+				copy(scanline[x*4:x*4+4], scanline[(x-1)*4:x*4])
+				x++
+			}
+			shift += 8
+			continue
+		}
+		shift = 0
+		x++
+	}
+	return nil
+}
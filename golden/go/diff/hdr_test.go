@@ -0,0 +1,107 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+)
+
+// hdrFile returns a Radiance HDR file with the given header lines and
+// scanlines. Scanlines are written as given, i.e. they need to be encoded.
+func hdrFile(width, height int, header string, scanlines ...[]byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(HDR_MAGIC + "\n# Written by a test.\n" + header + "\n")
+	buf.WriteString(fmt.Sprintf("-Y %d +X %d\n", height, width))
+	for _, s := range scanlines {
+		buf.Write(s)
+	}
+	return buf.Bytes()
+}
+
+// rleScanline encodes the given RGBE pixels with the adaptive run-length
+// encoding. Every component is encoded as a single run if all its values are
+// equal and as literals otherwise.
+func rleScanline(pixels [][]byte) []byte {
+	width := len(pixels)
+	ret := []byte{2, 2, byte(width >> 8), byte(width & 0xff)}
+	for c := 0; c < 4; c++ {
+		run := true
+		for _, p := range pixels {
+			run = run && p[c] == pixels[0][c]
+		}
+		if run {
+			ret = append(ret, byte(128+width), pixels[0][c])
+			continue
+		}
+		ret = append(ret, byte(width))
+		for _, p := range pixels {
+			ret = append(ret, p[c])
+		}
+	}
+	return ret
+}
+
+func TestDecodeHDR(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Flat scanlines. 128 with an exponent of 129 is 1.0, with an exponent
+	// of 131 it is 4.0.
+	content := hdrFile(2, 2, "FORMAT="+HDR_FORMAT+"\n",
+		[]byte{128, 64, 0, 129, 128, 128, 128, 131},
+		[]byte{0, 0, 0, 0, 128, 0, 0, 128})
+	img, err := DecodeHDR(bytes.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, image.Rect(0, 0, 2, 2), img.Bounds())
+	assert.Equal(t, []float32{
+		1, 0.5, 0, 1, 4, 4, 4, 1,
+		0, 0, 0, 1, 0.5, 0, 0, 1,
+	}, img.Pix)
+
+	// Old run-length encoding repeats the previous pixel.
+	content = hdrFile(4, 1, "", []byte{128, 64, 0, 129, 1, 1, 1, 3})
+	img, err = DecodeHDR(bytes.NewReader(content))
+	assert.NoError(t, err)
+	for x := 0; x < 4; x++ {
+		assert.Equal(t, []float32{1, 0.5, 0, 1}, img.Pix[x*4:x*4+4])
+	}
+
+	// Adaptive run-length encoding.
+	pixels := make([][]byte, 10)
+	for x := range pixels {
+		pixels[x] = []byte{byte(x), 128, 0, 129}
+	}
+	content = hdrFile(10, 2, "", rleScanline(pixels), rleScanline(pixels))
+	img, err = DecodeHDR(bytes.NewReader(content))
+	assert.NoError(t, err)
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 10; x++ {
+			assert.Equal(t, []float32{float32(x) / 128, 1, 0, 1}, img.Pix[img.PixOffset(x, y):img.PixOffset(x, y)+4])
+		}
+	}
+
+	// DecodeImage detects the format.
+	decoded, err := DecodeImage(bytes.NewReader(content))
+	assert.NoError(t, err)
+	assert.Equal(t, img, decoded)
+
+	// Values are clamped and converted to sRGB for display.
+	assert.Equal(t, uint8(0xff), GetNRGBA(img).Pix[img.PixOffset(9, 0)+1])
+
+	// Invalid files.
+	for _, content := range [][]byte{
+		[]byte("#?SOMETHING\n\n-Y 1 +X 1\n\x80\x80\x80\x81"),
+		hdrFile(1, 1, "FORMAT=32-bit_rle_xyze\n", []byte{128, 128, 128, 129}),
+		[]byte(HDR_MAGIC + "\n\n+X 1 -Y 1\n\x80\x80\x80\x81"),
+		hdrFile(2, 1, "", []byte{128, 128, 128, 129}),
+		hdrFile(8, 1, "", []byte{2, 2, 0, 9}),
+		hdrFile(MAX_HDR_PIXELS, 2, ""),
+		[]byte(HDR_MAGIC + "\n\n-Y 2147483647 +X 2147483647\n"),
+	} {
+		_, err := DecodeHDR(bytes.NewReader(content))
+		assert.Error(t, err)
+	}
+}
@@ -9,6 +9,11 @@ const (
 	METRIC_COMBINED = "combined"
 	METRIC_PERCENT  = "percent"
 	METRIC_PIXEL    = "pixel"
+
+	// Perceptual metrics, see perceptual.go.
+	METRIC_COLOR = "color"
+	METRIC_EDGE  = "edge"
+	METRIC_SSIM  = "ssim"
 )
 
 // MetricsFn is the signature a custom diff metric has to implmente.
@@ -16,9 +21,12 @@ type MetricFn func(*DiffMetrics, *image.NRGBA, *image.NRGBA) float32
 
 // metrics contains the custom diff metrics.
 var metrics = map[string]MetricFn{
+	METRIC_COLOR:    colorDiffMetric,
 	METRIC_COMBINED: combinedDiffMetric,
+	METRIC_EDGE:     edgeDiffMetric,
 	METRIC_PERCENT:  percentDiffMetric,
 	METRIC_PIXEL:    pixelDiffMetric,
+	METRIC_SSIM:     ssimDiffMetric,
 }
 
 // diffMetricIds contains the ids of all diff metrics.
@@ -37,15 +45,66 @@ func GetDiffMetricIDs() []string {
 	return diffMetricIds
 }
 
+// HasAllDiffs returns true if the DiffMetrics contain a value for every
+// available diff metric. DiffMetrics that were calculated and cached before a
+// metric was added will not contain it.
+func (d *DiffMetrics) HasAllDiffs() bool {
+	for _, id := range diffMetricIds {
+		if _, ok := d.Diffs[id]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// MetricValue returns the value of the given diff metric. The combined metric
+// is derived from the basic diff metrics, so it is available for all
+// DiffMetrics. For other metrics that are missing, see HasAllDiffs,
+// math.MaxFloat32 is returned, so the DiffMetrics are never considered a close
+// match.
+func (d *DiffMetrics) MetricValue(metric string) float32 {
+	if metric == METRIC_COMBINED {
+		return combinedDiffMetric(d, nil, nil)
+	}
+	if val, ok := d.Diffs[metric]; ok {
+		return val
+	}
+	return math.MaxFloat32
+}
+
+// AddMissingDiffs calculates the diff metrics that are missing from the
+// DiffMetrics, see HasAllDiffs, for the two images they were calculated for.
+// The basic diff metrics are not changed. Returns true if any metric was added.
+func (d *DiffMetrics) AddMissingDiffs(leftImg image.Image, rightImg image.Image) bool {
+	var leftNRGBA, rightNRGBA *image.NRGBA
+	added := false
+	for _, id := range diffMetricIds {
+		if _, ok := d.Diffs[id]; ok {
+			continue
+		}
+		if !added {
+			leftNRGBA, rightNRGBA = GetNRGBA(leftImg), GetNRGBA(rightImg)
+			if d.Diffs == nil {
+				d.Diffs = make(map[string]float32, len(diffMetricIds))
+			}
+			added = true
+		}
+		d.Diffs[id] = metrics[id](d, leftNRGBA, rightNRGBA)
+	}
+	return added
+}
+
 // DefaultDiffFn implements the DiffFn function type. Calculates the basic
-// image difference along with custom diff metrics.
-func DefaultDiffFn(leftImg *image.NRGBA, rightImg *image.NRGBA) (interface{}, *image.NRGBA) {
+// image difference at the native precision of the images along with custom
+// diff metrics, which are calculated on the 8-bit version of the images.
+func DefaultDiffFn(leftImg image.Image, rightImg image.Image) (interface{}, *image.NRGBA) {
 	ret, diffImg := PixelDiff(leftImg, rightImg)
 
 	// Calculate the metrics.
+	leftNRGBA, rightNRGBA := GetNRGBA(leftImg), GetNRGBA(rightImg)
 	diffs := make(map[string]float32, len(diffMetricIds))
 	for _, id := range diffMetricIds {
-		diffs[id] = metrics[id](ret, leftImg, rightImg)
+		diffs[id] = metrics[id](ret, leftNRGBA, rightNRGBA)
 	}
 	ret.Diffs = diffs
 
@@ -0,0 +1,121 @@
+package diff
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.skia.org/infra/go/testutils"
+)
+
+// twoToneImage returns a 32x32 image which is black on the left half and white
+// on the right half.
+func twoToneImage() *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, 32, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 32; x++ {
+			c := color.NRGBA{0, 0, 0, 0xff}
+			if x >= 16 {
+				c = color.NRGBA{0xff, 0xff, 0xff, 0xff}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func TestGetDiffMetricIDs(t *testing.T) {
+	testutils.SmallTest(t)
+
+	ids := append([]string{}, GetDiffMetricIDs()...)
+	sort.Strings(ids)
+	assert.Equal(t, []string{METRIC_COLOR, METRIC_COMBINED, METRIC_EDGE, METRIC_PERCENT, METRIC_PIXEL, METRIC_SSIM}, ids)
+
+	dm, _ := DefaultDiffFn(twoToneImage(), twoToneImage())
+	assert.True(t, dm.(*DiffMetrics).HasAllDiffs())
+	assert.False(t, (&DiffMetrics{Diffs: map[string]float32{METRIC_COMBINED: 0}}).HasAllDiffs())
+}
+
+func TestMissingDiffs(t *testing.T) {
+	testutils.SmallTest(t)
+
+	one := twoToneImage()
+	two := twoToneImage()
+	two.SetNRGBA(0, 0, color.NRGBA{0xff, 0, 0, 0xff})
+	full, _ := DefaultDiffFn(one, two)
+	expected := full.(*DiffMetrics)
+
+	// DiffMetrics that were cached before the perceptual metrics were added.
+	cached := &DiffMetrics{
+		NumDiffPixels:    expected.NumDiffPixels,
+		PixelDiffPercent: expected.PixelDiffPercent,
+		MaxRGBADiffs:     expected.MaxRGBADiffs,
+		Diffs: map[string]float32{
+			METRIC_COMBINED: expected.Diffs[METRIC_COMBINED],
+			METRIC_PERCENT:  expected.Diffs[METRIC_PERCENT],
+			METRIC_PIXEL:    expected.Diffs[METRIC_PIXEL],
+		},
+	}
+	assert.Equal(t, expected.Diffs[METRIC_COMBINED], cached.MetricValue(METRIC_COMBINED))
+	assert.Equal(t, expected.Diffs[METRIC_PIXEL], cached.MetricValue(METRIC_PIXEL))
+	assert.Equal(t, float32(math.MaxFloat32), cached.MetricValue(METRIC_SSIM))
+	assert.Equal(t, expected.Diffs[METRIC_COMBINED], (&DiffMetrics{MaxRGBADiffs: expected.MaxRGBADiffs, PixelDiffPercent: expected.PixelDiffPercent}).MetricValue(METRIC_COMBINED))
+
+	assert.True(t, cached.AddMissingDiffs(one, two))
+	assert.True(t, cached.HasAllDiffs())
+	assert.Equal(t, expected, cached)
+	assert.Equal(t, expected.Diffs[METRIC_SSIM], cached.MetricValue(METRIC_SSIM))
+	assert.False(t, cached.AddMissingDiffs(one, two))
+}
+
+func TestPerceptualMetrics(t *testing.T) {
+	testutils.SmallTest(t)
+
+	diffs := func(one, two *image.NRGBA) map[string]float32 {
+		dm, _ := DefaultDiffFn(one, two)
+		return dm.(*DiffMetrics).Diffs
+	}
+
+	// Identical images.
+	same := diffs(twoToneImage(), twoToneImage())
+	assert.Equal(t, float32(0), same[METRIC_SSIM])
+	assert.Equal(t, float32(0), same[METRIC_COLOR])
+	assert.Equal(t, float32(0), same[METRIC_EDGE])
+
+	// Antialiasing differences along the edge.
+	aa := twoToneImage()
+	for y := 0; y < 32; y++ {
+		aa.SetNRGBA(15, y, color.NRGBA{0x40, 0x40, 0x40, 0xff})
+		aa.SetNRGBA(16, y, color.NRGBA{0xc0, 0xc0, 0xc0, 0xff})
+	}
+	aaDiffs := diffs(twoToneImage(), aa)
+	assert.True(t, aaDiffs[METRIC_SSIM] > 0)
+	assert.True(t, aaDiffs[METRIC_COLOR] > 0)
+	assert.Equal(t, float32(0), aaDiffs[METRIC_EDGE])
+
+	// A new shape away from the edge.
+	blob := twoToneImage()
+	for y := 4; y < 8; y++ {
+		for x := 4; x < 8; x++ {
+			blob.SetNRGBA(x, y, color.NRGBA{0xff, 0, 0, 0xff})
+		}
+	}
+	blobDiffs := diffs(twoToneImage(), blob)
+	assert.True(t, blobDiffs[METRIC_SSIM] > 0)
+	assert.True(t, blobDiffs[METRIC_COLOR] > 0)
+	assert.InDelta(t, 100*16.0/1024.0, blobDiffs[METRIC_EDGE], 0.0001)
+
+	// Metrics are symmetric.
+	assert.InDelta(t, blobDiffs[METRIC_SSIM], diffs(blob, twoToneImage())[METRIC_SSIM], 0.0001)
+	assert.InDelta(t, blobDiffs[METRIC_COLOR], diffs(blob, twoToneImage())[METRIC_COLOR], 0.0001)
+
+	// Images with different dimensions.
+	small := image.NewNRGBA(image.Rect(0, 0, 16, 16))
+	dimDiffs := diffs(twoToneImage(), small)
+	assert.Equal(t, float32(1), dimDiffs[METRIC_SSIM])
+	assert.Equal(t, float32(MAX_COLOR_DISTANCE), dimDiffs[METRIC_COLOR])
+	assert.Equal(t, float32(100), dimDiffs[METRIC_EDGE])
+}
@@ -0,0 +1,253 @@
+package diff
+
+import (
+	"image"
+	"math"
+
+	"go.skia.org/infra/go/util"
+)
+
+// Perceptual diff metrics. Like the other metrics, smaller values indicate
+// more similar images and identical images have a value of 0.
+
+const (
+	// SSIM_WINDOW is the width and height of the windows over which the
+	// structural similarity is calculated.
+	SSIM_WINDOW = 8
+
+	// SSIM_STEP is the distance between neighboring SSIM windows.
+	SSIM_STEP = 4
+
+	// SSIM_C1 and SSIM_C2 stabilize the SSIM division for windows with
+	// small means or variances. These are the standard values for K1=0.01,
+	// K2=0.03 and a dynamic range of 255.
+	SSIM_C1 = (0.01 * 255) * (0.01 * 255)
+	SSIM_C2 = (0.03 * 255) * (0.03 * 255)
+
+	// MAX_COLOR_DISTANCE is the value of the color metric for images with
+	// different dimensions. It is the CIELAB distance between black and
+	// white.
+	MAX_COLOR_DISTANCE = 100.0
+
+	// EDGE_THRESHOLD is the luma gradient above which a pixel is considered
+	// to be part of an edge.
+	EDGE_THRESHOLD = 24.0
+)
+
+// ssimDiffMetric returns the structural dissimilarity (1 - SSIM) / 2 of the
+// luma of the two images, which is a value in [0, 1]. Images with different
+// dimensions have a value of 1. Implements the MetricFn signature.
+func ssimDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.NumDiffPixels == 0 {
+		return 0
+	}
+	if !sameDimensions(one, two) {
+		return 1
+	}
+
+	w, h := one.Bounds().Dx(), one.Bounds().Dy()
+	luma1 := lumaPlane(one)
+	luma2 := lumaPlane(two)
+	winW := util.MinInt(SSIM_WINDOW, w)
+	winH := util.MinInt(SSIM_WINDOW, h)
+	sum := 0.0
+	n := 0
+	for _, y0 := range windowStarts(h, winH) {
+		for _, x0 := range windowStarts(w, winW) {
+			sum += ssimWindow(luma1, luma2, w, x0, y0, winW, winH)
+			n++
+		}
+	}
+	dssim := (1 - sum/float64(n)) / 2
+	return float32(math.Max(0, math.Min(1, dssim)))
+}
+
+// ssimWindow returns the structural similarity of the given window of the two
+// luma planes, which have the given width.
+func ssimWindow(luma1, luma2 []float64, width, x0, y0, winW, winH int) float64 {
+	n := float64(winW * winH)
+	mean1, mean2 := 0.0, 0.0
+	for y := y0; y < y0+winH; y++ {
+		for x := x0; x < x0+winW; x++ {
+			mean1 += luma1[y*width+x]
+			mean2 += luma2[y*width+x]
+		}
+	}
+	mean1 /= n
+	mean2 /= n
+
+	var1, var2, covar := 0.0, 0.0, 0.0
+	for y := y0; y < y0+winH; y++ {
+		for x := x0; x < x0+winW; x++ {
+			d1 := luma1[y*width+x] - mean1
+			d2 := luma2[y*width+x] - mean2
+			var1 += d1 * d1
+			var2 += d2 * d2
+			covar += d1 * d2
+		}
+	}
+	var1 /= n
+	var2 /= n
+	covar /= n
+
+	return ((2*mean1*mean2 + SSIM_C1) * (2*covar + SSIM_C2)) /
+		((mean1*mean1 + mean2*mean2 + SSIM_C1) * (var1 + var2 + SSIM_C2))
+}
+
+// windowStarts returns the offsets of the windows of the given size which
+// cover a dimension of the given length.
+func windowStarts(length, size int) []int {
+	ret := []int{}
+	for start := 0; start+size <= length; start += SSIM_STEP {
+		ret = append(ret, start)
+	}
+	// Make sure the end of the image is covered.
+	if last := length - size; ret[len(ret)-1] != last {
+		ret = append(ret, last)
+	}
+	return ret
+}
+
+// colorDiffMetric returns the average perceptual color distance of the pixels
+// of the two images, measured as the CIE76 delta E in CIELAB space. A distance
+// of about 2.3 is just noticeable. Images with different dimensions have a
+// value of MAX_COLOR_DISTANCE. Implements the MetricFn signature.
+func colorDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.NumDiffPixels == 0 {
+		return 0
+	}
+	if !sameDimensions(one, two) {
+		return MAX_COLOR_DISTANCE
+	}
+
+	w, h := one.Bounds().Dx(), one.Bounds().Dy()
+	sum := 0.0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p1 := pixelAt(one, x, y)
+			p2 := pixelAt(two, x, y)
+			if p1[0] == p2[0] && p1[1] == p2[1] && p1[2] == p2[2] && p1[3] == p2[3] {
+				continue
+			}
+			l1, a1, b1 := toLab(p1)
+			l2, a2, b2 := toLab(p2)
+			sum += math.Sqrt((l1-l2)*(l1-l2) + (a1-a2)*(a1-a2) + (b1-b2)*(b1-b2))
+		}
+	}
+	return float32(sum / float64(w*h))
+}
+
+// edgeDiffMetric returns the percentage of pixels that differ, excluding pixels
+// that are on or next to an edge in both images. Antialiasing differences
+// occur along edges, so they don't contribute to this metric. Images with different
+// dimensions have a value of 100. Implements the MetricFn signature.
+func edgeDiffMetric(basic *DiffMetrics, one *image.NRGBA, two *image.NRGBA) float32 {
+	if basic.NumDiffPixels == 0 {
+		return 0
+	}
+	if !sameDimensions(one, two) {
+		return 100
+	}
+
+	w, h := one.Bounds().Dx(), one.Bounds().Dy()
+	edges1 := edgeMask(lumaPlane(one), w, h)
+	edges2 := edgeMask(lumaPlane(two), w, h)
+	nearEdge := func(edges []bool, x, y int) bool {
+		for ny := util.MaxInt(0, y-1); ny <= util.MinInt(h-1, y+1); ny++ {
+			for nx := util.MaxInt(0, x-1); nx <= util.MinInt(w-1, x+1); nx++ {
+				if edges[ny*w+nx] {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	numDiffPixels := 0
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p1 := pixelAt(one, x, y)
+			p2 := pixelAt(two, x, y)
+			if p1[0] == p2[0] && p1[1] == p2[1] && p1[2] == p2[2] && p1[3] == p2[3] {
+				continue
+			}
+			// Only discount pixels near an edge in both images, so that
+			// new shapes, which add edges, still count.
+			if !nearEdge(edges1, x, y) || !nearEdge(edges2, x, y) {
+				numDiffPixels++
+			}
+		}
+	}
+	return GetPixelDiffPercent(numDiffPixels, w*h)
+}
+
+// edgeMask returns which pixels of the given luma plane are part of an edge,
+// based on the magnitude of the Sobel gradient.
+func edgeMask(luma []float64, w, h int) []bool {
+	at := func(x, y int) float64 {
+		return luma[util.MinInt(h-1, util.MaxInt(0, y))*w+util.MinInt(w-1, util.MaxInt(0, x))]
+	}
+	ret := make([]bool, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			gx := (at(x+1, y-1) + 2*at(x+1, y) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x-1, y) + at(x-1, y+1))
+			gy := (at(x-1, y+1) + 2*at(x, y+1) + at(x+1, y+1)) - (at(x-1, y-1) + 2*at(x, y-1) + at(x+1, y-1))
+			// The Sobel kernels weigh the gradient by a factor of 4.
+			ret[y*w+x] = math.Sqrt(gx*gx+gy*gy)/4 > EDGE_THRESHOLD
+		}
+	}
+	return ret
+}
+
+// lumaPlane returns the luma of every pixel of the image in row-major order,
+// with the image composited over black.
+func lumaPlane(img *image.NRGBA) []float64 {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	ret := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := pixelAt(img, x, y)
+			alpha := float64(p[3]) / 255
+			ret[y*w+x] = (0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])) * alpha
+		}
+	}
+	return ret
+}
+
+// toLab converts the given non-premultiplied sRGB pixel, composited over
+// black, to CIELAB using the D65 white point.
+func toLab(p []uint8) (float64, float64, float64) {
+	alpha := float64(p[3]) / 255
+	linear := func(c uint8) float64 {
+		v := float64(c) / 255 * alpha
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+	r, g, b := linear(p[0]), linear(p[1]), linear(p[2])
+	x := (0.4124*r + 0.3576*g + 0.1805*b) / 0.95047
+	y := 0.2126*r + 0.7152*g + 0.0722*b
+	z := (0.0193*r + 0.1192*g + 0.9505*b) / 1.08883
+
+	f := func(t float64) float64 {
+		if t > 216.0/24389.0 {
+			return math.Cbrt(t)
+		}
+		return (24389.0/27.0*t + 16) / 116
+	}
+	fx, fy, fz := f(x), f(y), f(z)
+	return 116*fy - 16, 500 * (fx - fy), 200 * (fy - fz)
+}
+
+// pixelAt returns the RGBA values of the pixel at the given position, relative
+// to the origin of the image.
+func pixelAt(img *image.NRGBA, x, y int) []uint8 {
+	i := y*img.Stride + x*4
+	return img.Pix[i : i+4]
+}
+
+// sameDimensions returns true if the two images have the same size.
+func sameDimensions(one *image.NRGBA, two *image.NRGBA) bool {
+	return one.Bounds().Dx() == two.Bounds().Dx() && one.Bounds().Dy() == two.Bounds().Dy()
+}
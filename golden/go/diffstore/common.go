@@ -38,7 +38,7 @@ func saveFile(targetPath string, r io.Reader) error {
 }
 
 // loadImg loads an image from disk.
-func loadImg(sourcePath string) (*image.NRGBA, error) {
+func loadImg(sourcePath string) (image.Image, error) {
 	f, err := os.Open(sourcePath)
 	if err != nil {
 		return nil, err
@@ -57,13 +57,12 @@ func encodeImg(w io.Writer, img *image.NRGBA) error {
 	return nil
 }
 
-// decodeImg decodes an image from the given reader and returns it as a NRGBA image.
-func decodeImg(reader io.Reader) (*image.NRGBA, error) {
-	im, err := png.Decode(reader)
-	if err != nil {
-		return nil, err
-	}
-	return diff.GetNRGBA(im), nil
+// decodeImg decodes an image from the given reader and returns it in its
+// native precision. Besides PNGs, WebP and Radiance HDR images are supported.
+// Since images are stored under their digest the files of all formats are
+// named <digest>.png, see diff.DecodeImage for how the format is detected.
+func decodeImg(reader io.Reader) (image.Image, error) {
+	return diff.DecodeImage(reader)
 }
 
 // getDigestImageFileName returns the image name based on the digest.
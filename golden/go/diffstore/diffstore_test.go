@@ -45,13 +45,41 @@ func TestMemDiffStore(t *testing.T) {
 	memDiffStore := diffStore.(*MemDiffStore)
 
 	testDiffStore(t, tile, baseDir, diffStore, memDiffStore)
+	testMigrateDiffMetrics(t, memDiffStore)
+}
+
+// testMigrateDiffMetrics verifies that diff metrics that were cached before a
+// metric was added are migrated without recalculating them.
+func testMigrateDiffMetrics(t *testing.T, memDiffStore *MemDiffStore) {
+	id := memDiffStore.diffMetricsCache.Keys()[0]
+	dm, err := memDiffStore.metricsStore.loadDiffMetrics(id)
+	assert.NoError(t, err)
+	expected := dm.(*diff.DiffMetrics)
+
+	// Cache the diff metrics without the perceptual metrics.
+	cached := *expected
+	cached.Diffs = map[string]float32{diff.METRIC_COMBINED: expected.Diffs[diff.METRIC_COMBINED]}
+	assert.NoError(t, memDiffStore.metricsStore.saveDiffMetrics(id, &cached))
+	ok, err := memDiffStore.migrateDiffMetrics(id)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.False(t, memDiffStore.diffMetricsCache.Contains(id))
+
+	dm, err = memDiffStore.metricsStore.loadDiffMetrics(id)
+	assert.NoError(t, err)
+	assert.Equal(t, expected, dm)
+
+	// Migrated diff metrics are left alone.
+	ok, err = memDiffStore.migrateDiffMetrics(id)
+	assert.NoError(t, err)
+	assert.False(t, ok)
 }
 
 type DummyDiffStoreMapper struct {
 	GoldDiffStoreMapper
 }
 
-func (d DummyDiffStoreMapper) DiffFn(leftImg *image.NRGBA, rightImg *image.NRGBA) (interface{}, *image.NRGBA) {
+func (d DummyDiffStoreMapper) DiffFn(leftImg image.Image, rightImg image.Image) (interface{}, *image.NRGBA) {
 	return 42, nil
 }
 
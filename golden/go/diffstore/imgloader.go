@@ -112,14 +112,15 @@ type errResult struct {
 	id  string
 }
 
-// Get returns the images identified by digests and returns them as NRGBA images.
+// Get returns the images identified by digests. 8-bit images are returned as
+// NRGBA images, images with a higher precision in their native format.
 // Priority determines the order in which multiple concurrent calls are processed.
 // The returned instance of WaitGroup can be used to wait until all images are
 // not just loaded but also written to disk. Calling the Wait() function of the
 // WaitGroup is optional and the client should not call any of its other functions.
-func (il *ImageLoader) Get(priority int64, images []string) ([]*image.NRGBA, *sync.WaitGroup, error) {
+func (il *ImageLoader) Get(priority int64, images []string) ([]image.Image, *sync.WaitGroup, error) {
 	// Parallel load the requested images.
-	result := make([]*image.NRGBA, len(images))
+	result := make([]image.Image, len(images))
 	imgWrappers := make(imgRetSlice, len(images))
 	errCh := make(chan errResult, len(images))
 	var wg sync.WaitGroup
@@ -343,7 +344,7 @@ func (il *ImageLoader) removeImg(bucket, gsRelPath string) {
 // was already on disk and/or RAM.
 type imgRet struct {
 	writtenCh <-chan bool // will be closed after the image has been written to disk
-	img       *image.NRGBA
+	img       image.Image
 	mutex     sync.Mutex
 }
 
@@ -35,7 +35,7 @@ func NewGoldDiffStoreMapper(diffInstance interface{}) DiffStoreMapper {
 }
 
 // DiffFn implements the DiffStoreMapper interface.
-func (g GoldDiffStoreMapper) DiffFn(leftImg *image.NRGBA, rightImg *image.NRGBA) (interface{}, *image.NRGBA) {
+func (g GoldDiffStoreMapper) DiffFn(leftImg image.Image, rightImg image.Image) (interface{}, *image.NRGBA) {
 	return diff.DefaultDiffFn(leftImg, rightImg)
 }
 
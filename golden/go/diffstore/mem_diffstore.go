@@ -52,8 +52,9 @@ type DiffStoreMapper interface {
 	// DiffFn calculates the different between two given images and returns a
 	// difference image. The type underlying interface{} is the input and output
 	// of the LRUCodec above. It is also what is returned by the Get(...) function
-	// of the DiffStore interface.
-	DiffFn(*image.NRGBA, *image.NRGBA) (interface{}, *image.NRGBA)
+	// of the DiffStore interface. The images are in their native precision,
+	// see diff.DecodeImage.
+	DiffFn(image.Image, image.Image) (interface{}, *image.NRGBA)
 
 	// Takes two image IDs and returns a unique diff ID.
 	// Note: DiffID(a,b) == DiffID(b, a) should hold.
@@ -147,6 +148,68 @@ func (d *MemDiffStore) ConvertLegacy() {
 	d.metricsStore.convertDatabaseFromLegacy()
 }
 
+// MigrateDiffMetrics adds the diff metrics that are missing from the cached
+// diff metrics, because they were cached before a metric was added, in the
+// background. Only the missing metrics are calculated; the cached diff images
+// are kept. Until a diff is migrated its missing metrics are unavailable, see
+// diff.DiffMetrics.MetricValue.
+func (d *MemDiffStore) MigrateDiffMetrics() {
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				sklog.Errorf("Recovered panic: %s", r)
+			}
+		}()
+
+		ids, err := d.metricsStore.listIDs()
+		if err != nil {
+			sklog.Errorf("Unable to get the database ids. Got error: %s", err)
+			return
+		}
+
+		sklog.Infof("Migrating %d diffmetric records.", len(ids))
+		migrated := 0
+		for _, id := range ids {
+			ok, err := d.migrateDiffMetrics(id)
+			if err != nil {
+				sklog.Errorf("Error migrating diff metrics %s: %s", id, err)
+			} else if ok {
+				migrated++
+			}
+		}
+		sklog.Infof("Diff metrics migration completed. Migrated %d of %d records.", migrated, len(ids))
+	}()
+}
+
+// migrateDiffMetrics adds the missing metrics to the cached diff metrics with
+// the given id. Returns true if the cached diff metrics were changed.
+func (d *MemDiffStore) migrateDiffMetrics(id string) (bool, error) {
+	dm, err := d.metricsStore.loadDiffMetrics(id)
+	if err != nil {
+		return false, err
+	}
+	diffMetrics, ok := dm.(*diff.DiffMetrics)
+	if !ok || diffMetrics.HasAllDiffs() {
+		return false, nil
+	}
+
+	leftDigest, rightDigest := d.mapper.SplitDiffID(id)
+	imgs, _, err := d.imgLoader.Get(diff.PRIORITY_IDLE, []string{leftDigest, rightDigest})
+	if err != nil {
+		return false, err
+	}
+	if !diffMetrics.AddMissingDiffs(imgs[0], imgs[1]) {
+		return false, nil
+	}
+	if err := d.metricsStore.saveDiffMetrics(id, diffMetrics); err != nil {
+		return false, err
+	}
+
+	// Drop the outdated copy from the in-memory cache.
+	d.diffMetricsCache.Remove([]string{id})
+	return true, nil
+}
+
 // WarmDigests fetches images based on the given list of digests. It does
 // not cache the images but makes sure they are downloaded from GCS.
 func (d *MemDiffStore) WarmDigests(priority int64, digests []string, sync bool) {
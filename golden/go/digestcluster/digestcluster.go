@@ -0,0 +1,154 @@
+// Package digestcluster groups visually similar digests of a test, so that
+// they can be triaged together.
+package digestcluster
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"go.skia.org/infra/golden/go/diff"
+)
+
+const (
+	// DEFAULT_THRESHOLD is the default maximum distance between two digests
+	// for them to be placed in the same cluster. It is suitable for the
+	// combined diff metric, which is in the range [0, 1].
+	DEFAULT_THRESHOLD = 0.1
+)
+
+// Cluster is a group of visually similar digests.
+type Cluster struct {
+	// Representative is the member of the cluster with the smallest total
+	// distance to all other members.
+	Representative string `json:"representative"`
+
+	// Digests are the members of the cluster, sorted.
+	Digests []string `json:"digests"`
+}
+
+// Distances contains the distance between pairs of digests. Distances are
+// symmetric, but each pair is only stored once. Use Get to look up a distance.
+type Distances map[string]map[string]float32
+
+// Get returns the distance between the two digests and whether it is known.
+func (d Distances) Get(a, b string) (float32, bool) {
+	if dist, ok := d[a][b]; ok {
+		return dist, true
+	}
+	dist, ok := d[b][a]
+	return dist, ok
+}
+
+// set records the distance between the two digests.
+func (d Distances) set(a, b string, dist float32) {
+	if _, ok := d[a]; !ok {
+		d[a] = map[string]float32{}
+	}
+	d[a][b] = dist
+}
+
+// PairwiseDistances returns the distances between all pairs of the given
+// digests according to the given diff metric, see diff.DiffMetrics.MetricValue.
+// Pairs for which the diff or the metric is not available have no distance.
+func PairwiseDistances(diffStore diff.DiffStore, digests []string, metric string) (Distances, error) {
+	ret := Distances{}
+	for i, d := range digests {
+		if i == len(digests)-1 {
+			break
+		}
+		diffs, err := diffStore.Get(diff.PRIORITY_NOW, d, digests[i+1:])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to calculate differences for %s: %s", d, err)
+		}
+		for other, dm := range diffs {
+			if val := dm.(*diff.DiffMetrics).MetricValue(metric); val != math.MaxFloat32 {
+				ret.set(d, other, val)
+			}
+		}
+	}
+	return ret, nil
+}
+
+// Compute groups the given digests into clusters. Two digests are in the same
+// cluster if there is a chain of digests between them in which the distance
+// between neighbors is at most threshold. Clusters are sorted by decreasing
+// size, then by representative.
+func Compute(digests []string, distances Distances, threshold float32) []*Cluster {
+	// Union-find over the digests.
+	parent := make(map[string]string, len(digests))
+	for _, d := range digests {
+		parent[d] = d
+	}
+	var find func(string) string
+	find = func(d string) string {
+		if parent[d] != d {
+			parent[d] = find(parent[d])
+		}
+		return parent[d]
+	}
+	for i, a := range digests {
+		for _, b := range digests[i+1:] {
+			if dist, ok := distances.Get(a, b); ok && dist <= threshold {
+				parent[find(a)] = find(b)
+			}
+		}
+	}
+
+	members := map[string][]string{}
+	for _, d := range digests {
+		root := find(d)
+		members[root] = append(members[root], d)
+	}
+	ret := make([]*Cluster, 0, len(members))
+	for _, group := range members {
+		sort.Strings(group)
+		ret = append(ret, &Cluster{
+			Representative: representative(group, distances),
+			Digests:        group,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if len(ret[i].Digests) != len(ret[j].Digests) {
+			return len(ret[i].Digests) > len(ret[j].Digests)
+		}
+		return ret[i].Representative < ret[j].Representative
+	})
+	return ret
+}
+
+// representative returns the digest with the smallest total distance to the
+// other given digests, which must be sorted. Unknown distances count as the
+// maximum distance.
+func representative(digests []string, distances Distances) string {
+	ret := ""
+	minTotal := math.Inf(1)
+	for _, a := range digests {
+		total := 0.0
+		for _, b := range digests {
+			if a == b {
+				continue
+			}
+			if dist, ok := distances.Get(a, b); ok {
+				total += float64(dist)
+			} else {
+				total += math.MaxFloat32
+			}
+		}
+		if total < minTotal {
+			minTotal = total
+			ret = a
+		}
+	}
+	return ret
+}
+
+// ClusterDigests calculates the distances between the given digests using the
+// given diff metric and groups them into clusters. See Compute.
+func ClusterDigests(diffStore diff.DiffStore, digests []string, metric string, threshold float32) ([]*Cluster, error) {
+	distances, err := PairwiseDistances(diffStore, digests, metric)
+	if err != nil {
+		return nil, err
+	}
+	return Compute(digests, distances, threshold), nil
+}
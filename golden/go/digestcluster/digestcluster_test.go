@@ -0,0 +1,78 @@
+package digestcluster
+
+import (
+	"net/http"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/diff"
+)
+
+// fakeDiffStore returns DiffMetrics whose combined diff metric is taken from a
+// fixed table of distances. Pairs which are not in the table are maximally
+// different. Like DiffMetrics cached before the custom metrics were added, the
+// DiffMetrics only contain the basic metrics.
+type fakeDiffStore struct {
+	distances Distances
+}
+
+func (f fakeDiffStore) Get(priority int64, mainDigest string, rightDigests []string) (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	for _, d := range rightDigests {
+		dist, ok := f.distances.Get(mainDigest, d)
+		if !ok {
+			dist = 1.0
+		}
+		ret[d] = &diff.DiffMetrics{
+			PixelDiffPercent: dist * dist,
+			MaxRGBADiffs:     []int{255, 255, 255, 255},
+		}
+	}
+	return ret, nil
+}
+
+func (f fakeDiffStore) ImageHandler(urlPrefix string) (http.Handler, error)                   { return nil, nil }
+func (f fakeDiffStore) WarmDigests(priority int64, digests []string, sync bool)               {}
+func (f fakeDiffStore) WarmDiffs(priority int64, leftDigests []string, rightDigests []string) {}
+func (f fakeDiffStore) UnavailableDigests() map[string]*diff.DigestFailure                    { return nil }
+func (f fakeDiffStore) PurgeDigests(digests []string, purgeGCS bool) error                    { return nil }
+
+func TestClusterDigests(t *testing.T) {
+	testutils.SmallTest(t)
+
+	diffStore := fakeDiffStore{
+		distances: Distances{
+			// "aaa", "bbb" and "ccc" form a chain with "bbb" in the middle.
+			"aaa": {"bbb": 0.05, "ccc": 0.15},
+			"bbb": {"ccc": 0.05},
+			// "ddd" and "eee" are similar.
+			"ddd": {"eee": 0.02},
+		},
+	}
+	digests := []string{"eee", "ddd", "ccc", "bbb", "aaa", "fff"}
+
+	clusters, err := ClusterDigests(diffStore, digests, diff.METRIC_COMBINED, DEFAULT_THRESHOLD)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Cluster{
+		{Representative: "bbb", Digests: []string{"aaa", "bbb", "ccc"}},
+		{Representative: "ddd", Digests: []string{"ddd", "eee"}},
+		{Representative: "fff", Digests: []string{"fff"}},
+	}, clusters)
+
+	// A smaller threshold breaks up the chain.
+	clusters, err = ClusterDigests(diffStore, digests, diff.METRIC_COMBINED, 0.03)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(clusters))
+	assert.Equal(t, []string{"ddd", "eee"}, clusters[0].Digests)
+
+	// Metrics which are not available don't link any digests.
+	clusters, err = ClusterDigests(diffStore, digests, diff.METRIC_SSIM, DEFAULT_THRESHOLD)
+	assert.NoError(t, err)
+	assert.Equal(t, 6, len(clusters))
+
+	// No digests.
+	clusters, err = ClusterDigests(diffStore, []string{}, diff.METRIC_COMBINED, DEFAULT_THRESHOLD)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(clusters))
+}
@@ -14,7 +14,7 @@ import (
 // Closest describes one digest that is the closest another digest.
 type Closest struct {
 	Digest     string  `json:"digest"`     // The closest digest, empty if there are no digests to compare to.
-	Diff       float32 `json:"diff"`       // A percent value.
+	Diff       float32 `json:"diff"`       // The value of the diff metric used to find the closest digest.
 	DiffPixels float32 `json:"diffPixels"` // A percent value.
 	MaxRGBA    []int   `json:"maxRGBA"`
 }
@@ -29,8 +29,12 @@ func newClosest() *Closest {
 
 // ClosestDigest returns the closest digest of type 'label' to 'digest', or "" if there aren't any positive digests.
 //
+// The given diff metric (see diff.GetDiffMetricIDs) determines the closest
+// digest and is stored in Closest.Diff. Digests for which the metric is not
+// available are never considered closest.
+//
 // If no digest of type 'label' is found then Closest.Digest is the empty string.
-func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tallies tally.Tally, diffStore diff.DiffStore, label types.Label) *Closest {
+func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tallies tally.Tally, diffStore diff.DiffStore, label types.Label, metric string) *Closest {
 	ret := newClosest()
 	unavailableDigests := diffStore.UnavailableDigests()
 
@@ -55,7 +59,7 @@ func ClosestDigest(test string, digest string, exp *expstorage.Expectations, tal
 	} else {
 		for digest, diffs := range diffMetrics {
 			dm := diffs.(*diff.DiffMetrics)
-			if delta := combinedDiffMetric(dm.PixelDiffPercent, dm.MaxRGBADiffs); delta < ret.Diff {
+			if delta := dm.MetricValue(metric); delta < ret.Diff {
 				ret.Digest = digest
 				ret.Diff = delta
 				ret.DiffPixels = dm.PixelDiffPercent
@@ -21,7 +21,8 @@ func (m MockDiffStore) WarmDiffs(priority int64, leftDigests []string, rightDige
 func (m MockDiffStore) UnavailableDigests() map[string]*diff.DigestFailure                    { return nil }
 func (m MockDiffStore) PurgeDigests(digests []string, purgeGCS bool) error                    { return nil }
 
-// Get always finds that digest "eee" is closest to dMain.
+// Get always finds that digest "eee" is closest to dMain, except by the SSIM
+// metric, by which "aaa" is closest. The edge metric is never available.
 func (m MockDiffStore) Get(priority int64, dMain string, dRest []string) (map[string]interface{}, error) {
 	result := map[string]interface{}{}
 	for i, d := range dRest {
@@ -29,9 +30,16 @@ func (m MockDiffStore) Get(priority int64, dMain string, dRest []string) (map[st
 		if d == "eee" {
 			diffPercent = 0.1
 		}
+		ssim := float32(0.5)
+		if d == "aaa" {
+			ssim = 0.01
+		}
 		result[d] = &diff.DiffMetrics{
 			PixelDiffPercent: diffPercent,
 			MaxRGBADiffs:     []int{5, 3, 4, 0},
+			Diffs: map[string]float32{
+				diff.METRIC_SSIM: ssim,
+			},
 		}
 	}
 	return result, nil
@@ -61,22 +69,32 @@ func TestClosestDigest(t *testing.T) {
 	}
 
 	// First test against a test that has positive digests.
-	c := ClosestDigest("foo", "fff", exp, tallies, diffStore, types.POSITIVE)
+	c := ClosestDigest("foo", "fff", exp, tallies, diffStore, types.POSITIVE, diff.METRIC_COMBINED)
 	assert.InDelta(t, 0.0372, float64(c.Diff), 0.01)
 	assert.Equal(t, "eee", c.Digest)
 	assert.Equal(t, []int{5, 3, 4, 0}, c.MaxRGBA)
 
 	// Now test against a test with no positive digests.
-	c = ClosestDigest("bar", "fff", exp, tallies, diffStore, types.POSITIVE)
+	c = ClosestDigest("bar", "fff", exp, tallies, diffStore, types.POSITIVE, diff.METRIC_COMBINED)
 	assert.Equal(t, float32(math.MaxFloat32), c.Diff)
 	assert.Equal(t, "", c.Digest)
 	assert.Equal(t, []int{}, c.MaxRGBA)
 
 	// Now test against negative digests.
-	c = ClosestDigest("foo", "fff", exp, tallies, diffStore, types.NEGATIVE)
+	c = ClosestDigest("foo", "fff", exp, tallies, diffStore, types.NEGATIVE, diff.METRIC_COMBINED)
 	assert.InDelta(t, 0.166, float64(c.Diff), 0.01)
 	assert.Equal(t, "bbb", c.Digest)
 	assert.Equal(t, []int{5, 3, 4, 0}, c.MaxRGBA)
+
+	// Use a different metric to find the closest digest.
+	c = ClosestDigest("foo", "fff", exp, tallies, diffStore, types.POSITIVE, diff.METRIC_SSIM)
+	assert.InDelta(t, 0.01, float64(c.Diff), 0.0001)
+	assert.Equal(t, "aaa", c.Digest)
+
+	// Metrics which are not available are ignored.
+	c = ClosestDigest("foo", "fff", exp, tallies, diffStore, types.POSITIVE, diff.METRIC_EDGE)
+	assert.Equal(t, float32(math.MaxFloat32), c.Diff)
+	assert.Equal(t, "", c.Digest)
 }
 
 func TestCombinedDiffMetric(t *testing.T) {
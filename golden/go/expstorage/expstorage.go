@@ -1,13 +1,13 @@
 package expstorage
 
 import (
+	"fmt"
 	"sync"
 
 	"go.skia.org/infra/go/jsonutils"
 
 	"go.skia.org/infra/go/eventbus"
 	"go.skia.org/infra/go/gevent"
-	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/types"
 )
@@ -270,12 +270,10 @@ func (m *MemExpectationsStore) removeChange(changedDigests map[string]types.Test
 
 // See ExpectationsStore interface.
 func (m *MemExpectationsStore) QueryLog(offset, size int, details bool) ([]*TriageLogEntry, int, error) {
-	sklog.Fatal("MemExpectation store does not support querying the logs.")
-	return nil, 0, nil
+	return nil, 0, fmt.Errorf("MemExpectation store does not support querying the logs.")
 }
 
 // See  ExpectationsStore interface.
 func (m *MemExpectationsStore) UndoChange(changeID int64, userID string) (map[string]types.TestClassification, error) {
-	sklog.Fatal("MemExpectation store does not support undo.")
-	return nil, nil
+	return nil, fmt.Errorf("MemExpectation store does not support undo.")
 }
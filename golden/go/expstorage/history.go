@@ -0,0 +1,181 @@
+package expstorage
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+const (
+	// HISTORY_PAGE_SIZE is the number of triage log entries HistoryCache
+	// retrieves with one query.
+	HISTORY_PAGE_SIZE = 100
+)
+
+// History contains the triage log of an ExpectationsStore in chronological
+// order. It allows to reconstruct the expectations as they were after any
+// change and to retrieve the history of individual digests.
+type History struct {
+	entries []*TriageLogEntry
+}
+
+// LabelChange captures one change of the label of a test/digest pair.
+type LabelChange struct {
+	ChangeID     int64  `json:"changeId"`
+	Name         string `json:"name"`
+	TS           int64  `json:"ts"`
+	Label        string `json:"label"`
+	UndoChangeID int64  `json:"undoChangeId"`
+}
+
+// HistoryCache keeps the History of an ExpectationsStore in memory and
+// extends it with the changes that were added to the store since the last
+// call to Get. It is safe for concurrent use.
+type HistoryCache struct {
+	store   ExpectationsStore
+	history *History
+	known   map[int64]bool
+	mutex   sync.Mutex
+}
+
+// NewHistoryCache creates a HistoryCache for the given store. The triage log
+// is retrieved on the first call to Get.
+func NewHistoryCache(store ExpectationsStore) *HistoryCache {
+	return &HistoryCache{
+		store:   store,
+		history: NewHistory(nil),
+		known:   map[int64]bool{},
+	}
+}
+
+// Get returns the history including all changes currently in the store. Only
+// the triage log entries that are not cached yet are retrieved. The returned
+// History is not modified by later calls.
+func (h *HistoryCache) Get() (*History, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	// The log is in reverse chronological order, so all entries after the
+	// first page that contains a known entry are known as well. Entries that
+	// are added while paging shift the log and can be returned twice.
+	added := map[int64]*TriageLogEntry{}
+	for offset := 0; ; offset += HISTORY_PAGE_SIZE {
+		entries, total, err := h.store.QueryLog(offset, HISTORY_PAGE_SIZE, true)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to retrieve triage log: %s", err)
+		}
+		foundKnown := false
+		for _, entry := range entries {
+			id := int64(entry.ID)
+			if h.known[id] {
+				foundKnown = true
+				continue
+			}
+			added[id] = entry
+		}
+		if foundKnown || len(entries) == 0 || offset+len(entries) >= total {
+			break
+		}
+	}
+
+	if len(added) > 0 {
+		entries := make([]*TriageLogEntry, 0, len(h.history.entries)+len(added))
+		entries = append(entries, h.history.entries...)
+		for id, entry := range added {
+			entries = append(entries, entry)
+			h.known[id] = true
+		}
+		h.history = NewHistory(entries)
+	}
+	return h.history, nil
+}
+
+// LoadHistory retrieves the complete triage log, including the details of
+// every change, from the given store. Use a HistoryCache to avoid retrieving
+// the complete log repeatedly.
+func LoadHistory(store ExpectationsStore) (*History, error) {
+	return NewHistoryCache(store).Get()
+}
+
+// queryFullLog returns all entries of the triage log of the given store,
+// including the details of every change, in reverse chronological order.
+func queryFullLog(store ExpectationsStore) ([]*TriageLogEntry, error) {
+	size := 1
+	for {
+		entries, total, err := store.QueryLog(0, size, true)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to retrieve triage log: %s", err)
+		}
+		// Retry if changes were added since the total was retrieved.
+		if len(entries) >= total {
+			return entries, nil
+		}
+		size = total
+	}
+}
+
+// NewHistory creates a History from the given triage log entries, which need
+// to include the details of the changes. The entries can be in any order.
+func NewHistory(entries []*TriageLogEntry) *History {
+	sorted := make([]*TriageLogEntry, len(entries))
+	copy(sorted, entries)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].TS != sorted[j].TS {
+			return sorted[i].TS < sorted[j].TS
+		}
+		return sorted[i].ID < sorted[j].ID
+	})
+	return &History{entries: sorted}
+}
+
+// AtChange returns the expectations as they were right after the change with
+// the given id was applied.
+func (h *History) AtChange(changeID int64) (*Expectations, error) {
+	for idx, entry := range h.entries {
+		if int64(entry.ID) == changeID {
+			return h.expectations(idx + 1), nil
+		}
+	}
+	return nil, fmt.Errorf("Change with id %d does not exist.", changeID)
+}
+
+// AtTime returns the expectations as they were at the given time in
+// milliseconds since the epoch, i.e. after all changes made up to and
+// including that time were applied.
+func (h *History) AtTime(ts int64) *Expectations {
+	n := sort.Search(len(h.entries), func(i int) bool {
+		return h.entries[i].TS > ts
+	})
+	return h.expectations(n)
+}
+
+// DigestHistory returns the changes of the label of the given test/digest
+// pair in chronological order.
+func (h *History) DigestHistory(testName, digest string) []*LabelChange {
+	ret := []*LabelChange{}
+	for _, entry := range h.entries {
+		for _, detail := range entry.Details {
+			if detail.TestName == testName && detail.Digest == digest {
+				ret = append(ret, &LabelChange{
+					ChangeID:     int64(entry.ID),
+					Name:         entry.Name,
+					TS:           entry.TS,
+					Label:        detail.Label,
+					UndoChangeID: entry.UndoChangeID,
+				})
+			}
+		}
+	}
+	return ret
+}
+
+// expectations returns the expectations after applying the first n changes.
+func (h *History) expectations(n int) *Expectations {
+	ret := NewExpectations()
+	for _, entry := range h.entries[:n] {
+		// Changes that set a digest to UNTRIAGED, e.g. undoing its first
+		// label, remove it from the expectations.
+		ret.AddDigests(entry.GetChanges())
+	}
+	return ret
+}
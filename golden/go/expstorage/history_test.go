@@ -0,0 +1,141 @@
+package expstorage
+
+import (
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/jsonutils"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// logStore is an ExpectationsStore that only serves a fixed triage log in
+// reverse chronological order, like the real stores.
+type logStore struct {
+	ExpectationsStore
+	entries []*TriageLogEntry
+
+	// offsets are the offsets of all calls to QueryLog.
+	offsets []int
+}
+
+// See ExpectationsStore interface.
+func (l *logStore) QueryLog(offset, size int, details bool) ([]*TriageLogEntry, int, error) {
+	l.offsets = append(l.offsets, offset)
+	start := offset
+	if start > len(l.entries) {
+		start = len(l.entries)
+	}
+	end := start + size
+	if end > len(l.entries) {
+		end = len(l.entries)
+	}
+	return l.entries[start:end], len(l.entries), nil
+}
+
+func TestHistory(t *testing.T) {
+	testutils.SmallTest(t)
+
+	store := &logStore{
+		entries: []*TriageLogEntry{
+			{ID: jsonutils.Number(4), Name: "user-2", TS: 4000, UndoChangeID: 2, Details: []*TriageDetail{
+				{"test1", "d11", "positive"},
+				{"test2", "d21", "untriaged"},
+			}},
+			{ID: jsonutils.Number(3), Name: "user-1", TS: 3000, Details: []*TriageDetail{}},
+			{ID: jsonutils.Number(2), Name: "user-1", TS: 2000, Details: []*TriageDetail{
+				{"test1", "d11", "negative"},
+				{"test2", "d21", "negative"},
+			}},
+			{ID: jsonutils.Number(1), Name: "user-0", TS: 1000, Details: []*TriageDetail{
+				{"test1", "d11", "positive"},
+				{"test1", "d12", "negative"},
+			}},
+		},
+	}
+
+	h, err := LoadHistory(store)
+	assert.NoError(t, err)
+
+	exp, err := h.AtChange(1)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, exp.Tests)
+
+	exp, err = h.AtChange(2)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.NEGATIVE, "d12": types.NEGATIVE},
+		"test2": {"d21": types.NEGATIVE},
+	}, exp.Tests)
+
+	// Undoing a change restores the previous labels.
+	exp, err = h.AtChange(4)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, exp.Tests)
+
+	_, err = h.AtChange(5)
+	assert.Error(t, err)
+
+	assert.Equal(t, 0, len(h.AtTime(999).Tests))
+	assert.Equal(t, types.POSITIVE, h.AtTime(1999).Classification("test1", "d11"))
+	assert.Equal(t, types.NEGATIVE, h.AtTime(2000).Classification("test1", "d11"))
+	assert.Equal(t, types.NEGATIVE, h.AtTime(3999).Classification("test2", "d21"))
+	assert.Equal(t, types.UNTRIAGED, h.AtTime(4000).Classification("test2", "d21"))
+
+	assert.Equal(t, []*LabelChange{
+		{ChangeID: 1, Name: "user-0", TS: 1000, Label: "positive"},
+		{ChangeID: 2, Name: "user-1", TS: 2000, Label: "negative"},
+		{ChangeID: 4, Name: "user-2", TS: 4000, Label: "positive", UndoChangeID: 2},
+	}, h.DigestHistory("test1", "d11"))
+	assert.Equal(t, []*LabelChange{}, h.DigestHistory("test3", "d31"))
+}
+
+func TestHistoryCache(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Fill the store with more than one page of changes.
+	store := &logStore{}
+	addEntry := func(id int64, label string) {
+		entry := &TriageLogEntry{ID: jsonutils.Number(id), Name: "user-0", TS: id * 1000, Details: []*TriageDetail{
+			{"test1", "d11", label},
+		}}
+		store.entries = append([]*TriageLogEntry{entry}, store.entries...)
+	}
+	for id := int64(1); id <= HISTORY_PAGE_SIZE+10; id++ {
+		addEntry(id, "positive")
+	}
+
+	cache := NewHistoryCache(store)
+	h, err := cache.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, HISTORY_PAGE_SIZE+10, len(h.DigestHistory("test1", "d11")))
+	assert.Equal(t, []int{0, HISTORY_PAGE_SIZE}, store.offsets)
+
+	// Only the first page is retrieved if nothing changed.
+	store.offsets = nil
+	cached, err := cache.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, h, cached)
+	assert.Equal(t, []int{0}, store.offsets)
+
+	// New changes are added to the cached history without changing the
+	// previously returned history.
+	addEntry(HISTORY_PAGE_SIZE+11, "negative")
+	addEntry(HISTORY_PAGE_SIZE+12, "untriaged")
+	store.offsets = nil
+	extended, err := cache.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0}, store.offsets)
+	assert.Equal(t, HISTORY_PAGE_SIZE+12, len(extended.DigestHistory("test1", "d11")))
+	assert.Equal(t, HISTORY_PAGE_SIZE+10, len(h.DigestHistory("test1", "d11")))
+	assert.Equal(t, types.NEGATIVE, extended.AtTime((HISTORY_PAGE_SIZE+11)*1000).Classification("test1", "d11"))
+	assert.Equal(t, types.UNTRIAGED, extended.AtTime((HISTORY_PAGE_SIZE+12)*1000).Classification("test1", "d11"))
+
+	// Errors of the store are returned.
+	_, err = NewHistoryCache(NewMemExpectationsStore(nil)).Get()
+	assert.Error(t, err)
+}
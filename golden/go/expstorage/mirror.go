@@ -0,0 +1,68 @@
+package expstorage
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+)
+
+// FetchExport retrieves an export in the format written by WriteExport from
+// the given URL, e.g. the expectations export endpoint of another Gold
+// instance.
+func FetchExport(client *http.Client, url string) (*ExpectationsExport, error) {
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations from %s: %s", url, err)
+	}
+	defer util.Close(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Unable to retrieve expectations from %s. Got status: %s", url, resp.Status)
+	}
+	return ReadExport(resp.Body)
+}
+
+// StartMirror starts a routine that mirrors the expectations exported at
+// primaryURL into the given store in the given interval. The changes are
+// attributed to userID. Mirroring is one-way, i.e. changes made directly to
+// the store are overwritten with the next sync. It returns an error if the
+// initial sync fails.
+func StartMirror(client *http.Client, primaryURL string, store ExpectationsStore, userID string, interval time.Duration) error {
+	liveness := metrics2.NewLiveness("gold_expectations_mirror")
+
+	syncOnce := func() error {
+		export, err := FetchExport(client, primaryURL)
+		if err != nil {
+			return err
+		}
+		exp, err := export.GetExpectations()
+		if err != nil {
+			return err
+		}
+		n, err := MirrorExpectations(store, exp, userID)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			sklog.Infof("Mirrored %d expectation changes from %s", n, primaryURL)
+		}
+		liveness.Reset()
+		return nil
+	}
+
+	if err := syncOnce(); err != nil {
+		return fmt.Errorf("Unable to start mirroring expectations: %s", err)
+	}
+	go func() {
+		for range time.Tick(interval) {
+			if err := syncOnce(); err != nil {
+				sklog.Errorf("Failed to mirror expectations: %s", err)
+			}
+		}
+	}()
+	return nil
+}
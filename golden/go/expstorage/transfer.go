@@ -0,0 +1,303 @@
+package expstorage
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// EXPORT_FORMAT_VERSION is the version of the format written by
+	// WriteExport. It has to be increased whenever the format changes in a way
+	// that older versions of ReadExport can not handle.
+	EXPORT_FORMAT_VERSION = 1
+)
+
+// ExpectationsExport is the format used to move expectations between Gold
+// instances. Labels are stored as strings, so the format does not depend on
+// the numeric values of types.Label.
+type ExpectationsExport struct {
+	// Version is the version of the format, see EXPORT_FORMAT_VERSION.
+	Version int `json:"version"`
+
+	// Source identifies the instance the expectations were exported from.
+	Source string `json:"source"`
+
+	// TS is the time of the export in milliseconds since the epoch.
+	TS int64 `json:"ts"`
+
+	// Expectations maps test names to digests and their labels. Untriaged
+	// digests are omitted.
+	Expectations map[string]map[string]string `json:"expectations"`
+
+	// TriageLog is the triage log including the details of every change in
+	// reverse chronological order. It is empty if the log was not exported.
+	TriageLog []*TriageLogEntry `json:"triageLog"`
+}
+
+// ImportOptions control how ImportExpectations treats the expectations of the
+// target store.
+type ImportOptions struct {
+	// Overwrite replaces the labels of conflicting digests with the imported
+	// labels. Otherwise the labels in the store are kept.
+	Overwrite bool
+
+	// ReplayLog adds the changes of the exported triage log to the store one
+	// by one with their original users before the expectations are imported.
+	// This preserves who triaged what, but not when. The store must not
+	// contain any expectations. Replaying is not atomic: if it fails the
+	// store contains the changes replayed so far and has to be cleared
+	// before the import is retried.
+	ReplayLog bool
+
+	// DryRun only reports what would be imported without changing the store.
+	DryRun bool
+}
+
+// ImportConflict is a digest that has different labels in the store and in
+// the imported expectations.
+type ImportConflict struct {
+	TestName string `json:"test_name"`
+	Digest   string `json:"digest"`
+	Current  string `json:"current"`
+	Imported string `json:"imported"`
+}
+
+// ImportResult summarizes the outcome of ImportExpectations.
+type ImportResult struct {
+	// Added is the number of imported digests that were untriaged in the store.
+	Added int `json:"added"`
+
+	// Unchanged is the number of imported digests that already had the
+	// imported label.
+	Unchanged int `json:"unchanged"`
+
+	// Conflicts are the digests that have a different label in the store.
+	Conflicts []*ImportConflict `json:"conflicts"`
+
+	// Applied is the number of labels that were, or in a dry run would be,
+	// changed in the store.
+	Applied int `json:"applied"`
+}
+
+// Export returns the expectations of the given store in the export format.
+// source identifies the instance in the export. If includeLog is true the
+// complete triage log is added, which requires a store that supports
+// QueryLog.
+func Export(store ExpectationsStore, source string, includeLog bool) (*ExpectationsExport, error) {
+	exp, err := store.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+
+	ret := &ExpectationsExport{
+		Version:      EXPORT_FORMAT_VERSION,
+		Source:       source,
+		TS:           util.TimeStampMs(),
+		Expectations: make(map[string]map[string]string, len(exp.Tests)),
+		TriageLog:    []*TriageLogEntry{},
+	}
+	for testName, digests := range exp.Tests {
+		for digest, label := range digests {
+			if label == types.UNTRIAGED {
+				continue
+			}
+			if _, ok := ret.Expectations[testName]; !ok {
+				ret.Expectations[testName] = map[string]string{}
+			}
+			ret.Expectations[testName][digest] = label.String()
+		}
+	}
+
+	if includeLog {
+		if ret.TriageLog, err = queryFullLog(store); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// WriteExport writes the given export to w.
+func WriteExport(w io.Writer, export *ExpectationsExport) error {
+	if err := json.NewEncoder(w).Encode(export); err != nil {
+		return fmt.Errorf("Unable to write expectations export: %s", err)
+	}
+	return nil
+}
+
+// ReadExport reads an export written by WriteExport from r and validates it.
+func ReadExport(r io.Reader) (*ExpectationsExport, error) {
+	ret := &ExpectationsExport{}
+	if err := json.NewDecoder(r).Decode(ret); err != nil {
+		return nil, fmt.Errorf("Unable to parse expectations export: %s", err)
+	}
+	if ret.Version < 1 || ret.Version > EXPORT_FORMAT_VERSION {
+		return nil, fmt.Errorf("Unsupported expectations export version %d. Supported versions are 1 to %d.", ret.Version, EXPORT_FORMAT_VERSION)
+	}
+	if _, err := ret.GetExpectations(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// GetExpectations returns the exported expectations. It fails if the export
+// contains an unknown label.
+func (e *ExpectationsExport) GetExpectations() (*Expectations, error) {
+	ret := NewExpectations()
+	for testName, digests := range e.Expectations {
+		changes := make(types.TestClassification, len(digests))
+		for digest, label := range digests {
+			if !types.ValidLabel(label) {
+				return nil, fmt.Errorf("Invalid label %q for digest %s of test %s.", label, digest, testName)
+			}
+			changes[digest] = types.LabelFromString(label)
+		}
+		ret.AddDigests(map[string]types.TestClassification{testName: changes})
+	}
+	return ret, nil
+}
+
+// ImportExpectations adds the expectations of the given export to the store
+// and attributes the change to userID. Digests that are labeled differently
+// in the store are reported as conflicts, see ImportOptions for how they are
+// resolved. Expectations in the store that are not part of the export are
+// left untouched.
+func ImportExpectations(store ExpectationsStore, export *ExpectationsExport, userID string, opts ImportOptions) (*ImportResult, error) {
+	imported, err := export.GetExpectations()
+	if err != nil {
+		return nil, err
+	}
+	current, err := store.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+	if opts.ReplayLog && numLabeled(current) > 0 {
+		return nil, fmt.Errorf("The triage log can only be replayed into a store without expectations.")
+	}
+
+	ret := &ImportResult{Conflicts: []*ImportConflict{}}
+	changes := map[string]types.TestClassification{}
+	for testName, digests := range imported.Tests {
+		for digest, label := range digests {
+			currentLabel := current.Classification(testName, digest)
+			switch {
+			case currentLabel == label:
+				ret.Unchanged++
+				continue
+			case currentLabel == types.UNTRIAGED:
+				ret.Added++
+			default:
+				ret.Conflicts = append(ret.Conflicts, &ImportConflict{
+					TestName: testName,
+					Digest:   digest,
+					Current:  currentLabel.String(),
+					Imported: label.String(),
+				})
+				if !opts.Overwrite {
+					continue
+				}
+			}
+			addLabel(changes, testName, digest, label)
+			ret.Applied++
+		}
+	}
+	sort.Slice(ret.Conflicts, func(i, j int) bool {
+		if ret.Conflicts[i].TestName != ret.Conflicts[j].TestName {
+			return ret.Conflicts[i].TestName < ret.Conflicts[j].TestName
+		}
+		return ret.Conflicts[i].Digest < ret.Conflicts[j].Digest
+	})
+
+	if opts.DryRun {
+		return ret, nil
+	}
+
+	if opts.ReplayLog {
+		replayed := 0
+		for _, entry := range NewHistory(export.TriageLog).entries {
+			if len(entry.Details) == 0 {
+				continue
+			}
+			if err := store.AddChange(entry.GetChanges(), entry.Name); err != nil {
+				return nil, fmt.Errorf("Unable to replay change %d after %d replayed changes. The store has to be cleared before retrying: %s", entry.ID, replayed, err)
+			}
+			replayed++
+		}
+		// The replayed log does not necessarily result in the exported
+		// expectations, e.g. if changes were made directly in the database.
+		if _, err := MirrorExpectations(store, imported, userID); err != nil {
+			return nil, err
+		}
+		return ret, nil
+	}
+
+	if len(changes) > 0 {
+		if err := store.AddChange(changes, userID); err != nil {
+			return nil, fmt.Errorf("Unable to add imported expectations: %s", err)
+		}
+	}
+	return ret, nil
+}
+
+// MirrorExpectations changes the expectations of the store to be identical to
+// the given expectations and attributes the change to userID. Digests that
+// are not labeled in exp are set to untriaged. It returns the number of
+// changed labels.
+func MirrorExpectations(store ExpectationsStore, exp *Expectations, userID string) (int, error) {
+	current, err := store.Get()
+	if err != nil {
+		return 0, fmt.Errorf("Unable to retrieve expectations: %s", err)
+	}
+
+	changes := map[string]types.TestClassification{}
+	n := 0
+	for testName, digests := range exp.Tests {
+		for digest, label := range digests {
+			if label != types.UNTRIAGED && current.Classification(testName, digest) != label {
+				addLabel(changes, testName, digest, label)
+				n++
+			}
+		}
+	}
+	for testName, digests := range current.Tests {
+		for digest, label := range digests {
+			if label != types.UNTRIAGED && exp.Classification(testName, digest) == types.UNTRIAGED {
+				addLabel(changes, testName, digest, types.UNTRIAGED)
+				n++
+			}
+		}
+	}
+
+	if n == 0 {
+		return 0, nil
+	}
+	if err := store.AddChange(changes, userID); err != nil {
+		return 0, fmt.Errorf("Unable to mirror expectations: %s", err)
+	}
+	return n, nil
+}
+
+// addLabel sets the label of the given test/digest pair in changes.
+func addLabel(changes map[string]types.TestClassification, testName, digest string, label types.Label) {
+	if _, ok := changes[testName]; !ok {
+		changes[testName] = types.TestClassification{}
+	}
+	changes[testName][digest] = label
+}
+
+// numLabeled returns the number of digests in exp that are not untriaged.
+func numLabeled(exp *Expectations) int {
+	ret := 0
+	for _, digests := range exp.Tests {
+		for _, label := range digests {
+			if label != types.UNTRIAGED {
+				ret++
+			}
+		}
+	}
+	return ret
+}
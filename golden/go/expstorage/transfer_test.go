@@ -0,0 +1,184 @@
+package expstorage
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/jsonutils"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// recordingStore is an ExpectationsStore that records the users of all
+// changes that are added.
+type recordingStore struct {
+	ExpectationsStore
+	users []string
+}
+
+// See ExpectationsStore interface.
+func (r *recordingStore) AddChange(changes map[string]types.TestClassification, userID string) error {
+	r.users = append(r.users, userID)
+	return r.ExpectationsStore.AddChange(changes, userID)
+}
+
+func TestExportImport(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// The log is in reverse chronological order, like the real stores return it.
+	triageLog := []*TriageLogEntry{
+		{ID: jsonutils.Number(2), Name: "user-1", TS: 2000, Details: []*TriageDetail{
+			{"test1", "d12", "negative"},
+		}},
+		{ID: jsonutils.Number(1), Name: "user-0", TS: 1000, Details: []*TriageDetail{
+			{"test1", "d11", "positive"},
+			{"test2", "d21", "positive"},
+		}},
+	}
+	primary := &logStore{ExpectationsStore: NewMemExpectationsStore(nil), entries: triageLog}
+	assert.NoError(t, primary.AddChange(map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE, "d13": types.UNTRIAGED},
+		"test2": {"d21": types.POSITIVE},
+	}, "user-0"))
+
+	export, err := Export(primary, "gold.example.com", true)
+	assert.NoError(t, err)
+	assert.Equal(t, EXPORT_FORMAT_VERSION, export.Version)
+	assert.Equal(t, "gold.example.com", export.Source)
+	assert.Equal(t, map[string]map[string]string{
+		"test1": {"d11": "positive", "d12": "negative"},
+		"test2": {"d21": "positive"},
+	}, export.Expectations)
+	assert.Equal(t, triageLog, export.TriageLog)
+
+	var buf bytes.Buffer
+	assert.NoError(t, WriteExport(&buf, export))
+	found, err := ReadExport(&buf)
+	assert.NoError(t, err)
+	assert.Equal(t, export, found)
+
+	// Import into a store with conflicting and additional expectations.
+	target := &recordingStore{ExpectationsStore: NewMemExpectationsStore(nil)}
+	assert.NoError(t, target.AddChange(map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.POSITIVE},
+		"test3": {"d31": types.NEGATIVE},
+	}, "user-2"))
+
+	expConflicts := []*ImportConflict{{TestName: "test1", Digest: "d12", Current: "positive", Imported: "negative"}}
+	result, err := ImportExpectations(target, export, "importer", ImportOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Added: 1, Unchanged: 1, Conflicts: expConflicts, Applied: 1}, result)
+	assert.Equal(t, []string{"user-2"}, target.users)
+
+	// Without overwrite the labels of the store are kept.
+	result, err = ImportExpectations(target, export, "importer", ImportOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Added: 1, Unchanged: 1, Conflicts: expConflicts, Applied: 1}, result)
+	assert.Equal(t, []string{"user-2", "importer"}, target.users)
+	exp, err := target.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.POSITIVE},
+		"test2": {"d21": types.POSITIVE},
+		"test3": {"d31": types.NEGATIVE},
+	}, exp.Tests)
+
+	result, err = ImportExpectations(target, export, "importer", ImportOptions{Overwrite: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Added: 0, Unchanged: 2, Conflicts: expConflicts, Applied: 1}, result)
+	exp, err = target.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test1", "d12"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test3", "d31"))
+
+	// The log can only be replayed into an empty store.
+	_, err = ImportExpectations(target, export, "importer", ImportOptions{ReplayLog: true})
+	assert.Error(t, err)
+
+	// Replaying the log attributes the changes to the original users.
+	// d11 was changed directly in the primary store and is missing from
+	// the log, so it is added by the importer.
+	export.Expectations["test1"]["d11"] = "negative"
+	empty := &recordingStore{ExpectationsStore: NewMemExpectationsStore(nil)}
+	result, err = ImportExpectations(empty, export, "importer", ImportOptions{ReplayLog: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Added: 3, Unchanged: 0, Conflicts: []*ImportConflict{}, Applied: 3}, result)
+	assert.Equal(t, []string{"user-0", "user-1", "importer"}, empty.users)
+	exp, err = empty.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"test1": {"d11": types.NEGATIVE, "d12": types.NEGATIVE},
+		"test2": {"d21": types.POSITIVE},
+	}, exp.Tests)
+}
+
+func TestReadExportErrors(t *testing.T) {
+	testutils.SmallTest(t)
+
+	for _, content := range []string{
+		`not json`,
+		`{"version": 0, "expectations": {}}`,
+		`{"version": 2, "expectations": {}}`,
+		`{"version": 1, "expectations": {"test1": {"d11": "maybe"}}}`,
+	} {
+		_, err := ReadExport(strings.NewReader(content))
+		assert.Error(t, err, content)
+	}
+
+	export, err := ReadExport(strings.NewReader(`{"version": 1, "expectations": {"test1": {"d11": "positive", "d12": "untriaged"}}}`))
+	assert.NoError(t, err)
+	exp, err := export.GetExpectations()
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{"test1": {"d11": types.POSITIVE}}, exp.Tests)
+}
+
+func TestMirrorExpectations(t *testing.T) {
+	testutils.SmallTest(t)
+
+	primary := NewMemExpectationsStore(nil)
+	assert.NoError(t, primary.AddChange(map[string]types.TestClassification{
+		"test1": {"d11": types.POSITIVE, "d12": types.NEGATIVE},
+	}, "user-0"))
+	export, err := Export(primary, "primary", false)
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/json/expectations/export" {
+			http.NotFound(w, r)
+			return
+		}
+		assert.NoError(t, WriteExport(w, export))
+	}))
+	defer server.Close()
+
+	_, err = FetchExport(http.DefaultClient, server.URL+"/unknown")
+	assert.Error(t, err)
+	fetched, err := FetchExport(http.DefaultClient, server.URL+"/json/expectations/export")
+	assert.NoError(t, err)
+	primaryExp, err := fetched.GetExpectations()
+	assert.NoError(t, err)
+
+	mirror := NewMemExpectationsStore(nil)
+	assert.NoError(t, mirror.AddChange(map[string]types.TestClassification{
+		"test1": {"d11": types.NEGATIVE},
+		"test2": {"d21": types.POSITIVE},
+	}, "user-1"))
+
+	n, err := MirrorExpectations(mirror, primaryExp, "mirror")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	exp, err := mirror.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, exp.Classification("test1", "d11"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("test1", "d12"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("test2", "d21"))
+
+	// Nothing changes if the expectations are already mirrored.
+	n, err = MirrorExpectations(mirror, primaryExp, "mirror")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
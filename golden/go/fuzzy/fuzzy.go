@@ -0,0 +1,332 @@
+// Package fuzzy automatically triages untriaged digests which are nearly
+// identical to a digest that has already been labeled positive, e.g. digests
+// which only differ from a positive digest by antialiasing noise.
+package fuzzy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/flynn/json5"
+
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+const (
+	// FUZZY_MATCHER_USER is the synthetic user under which the fuzzy matcher
+	// records its changes in the triage log. Changes can be audited and
+	// undone like any other triage operation.
+	FUZZY_MATCHER_USER = "fuzzy-matcher"
+
+	// TRIAGE_LOG_PAGE_SIZE is the number of triage log entries retrieved at
+	// once when the matcher determines which digests it labeled before.
+	TRIAGE_LOG_PAGE_SIZE = 1000
+)
+
+// ErrRunInProgress is returned by Matcher.Run if another run has not finished
+// yet.
+var ErrRunInProgress = errors.New("A fuzzy matcher run is already in progress.")
+
+// Rule defines the bounds within which an untriaged digest is considered
+// equivalent to a positive digest of the same test.
+type Rule struct {
+	// Query selects the digests the rule applies to. It has the same format
+	// as the query of an ignore rule, e.g. "name=mytest&config=gpu". A
+	// digest is only matched if all of the traces which produced it match.
+	Query string `json:"query"`
+
+	// MaxDiffPixels is the maximum number of pixels which may differ.
+	MaxDiffPixels int `json:"maxDiffPixels"`
+
+	// MaxRGBADiffs contains the maximum difference allowed in each of the
+	// R, G, B and A channels.
+	MaxRGBADiffs []int `json:"maxRGBADiffs"`
+
+	// Note describes why the rule exists.
+	Note string `json:"note"`
+
+	// query is the parsed version of Query.
+	query url.Values
+}
+
+// Validate verifies that the rule is well-formed and parses its query.
+func (r *Rule) Validate() error {
+	q, err := url.ParseQuery(r.Query)
+	if err != nil {
+		return fmt.Errorf("Invalid query %q: %s", r.Query, err)
+	}
+	if len(q) == 0 {
+		return fmt.Errorf("Query must not be empty.")
+	}
+	if r.MaxDiffPixels < 0 {
+		return fmt.Errorf("MaxDiffPixels must not be negative.")
+	}
+	if len(r.MaxRGBADiffs) != 4 {
+		return fmt.Errorf("MaxRGBADiffs must contain exactly 4 values, but got %d.", len(r.MaxRGBADiffs))
+	}
+	for _, d := range r.MaxRGBADiffs {
+		if d < 0 || d > 255 {
+			return fmt.Errorf("MaxRGBADiffs values must be in [0, 255], but got %d.", d)
+		}
+	}
+	r.query = q
+	return nil
+}
+
+// AppliesTo returns true if the rule applies to a digest which was produced by
+// traces with the given params. Every key in the rule's query must be present
+// in params and all of its values must be allowed by the query.
+func (r *Rule) AppliesTo(params paramtools.ParamSet) bool {
+	for key, allowed := range r.query {
+		vals, ok := params[key]
+		if !ok || len(vals) == 0 {
+			return false
+		}
+		for _, v := range vals {
+			if !util.In(v, allowed) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// IsMatch returns true if the given DiffMetrics are within the bounds of the
+// rule. Images with different dimensions never match.
+func (r *Rule) IsMatch(m *diff.DiffMetrics) bool {
+	if m.DimDiffer || m.NumDiffPixels > r.MaxDiffPixels || len(m.MaxRGBADiffs) != len(r.MaxRGBADiffs) {
+		return false
+	}
+	for i, d := range m.MaxRGBADiffs {
+		if d > r.MaxRGBADiffs[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// LoadRules reads a list of rules from the given JSON5 file and validates them.
+func LoadRules(fName string) ([]*Rule, error) {
+	f, err := os.Open(fName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable open file %s. Got error: %s", fName, err)
+	}
+	defer util.Close(f)
+
+	rules := []*Rule{}
+	if err := json5.NewDecoder(f).Decode(&rules); err != nil {
+		return nil, fmt.Errorf("Unable to decode fuzzy matching rules in %s: %s", fName, err)
+	}
+	for _, r := range rules {
+		if err := r.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	sklog.Infof("Loaded %d fuzzy matching rules from %s", len(rules), fName)
+	return rules, nil
+}
+
+// ParamsFn returns the params of the traces which produced the given digest.
+type ParamsFn func(test, digest string) paramtools.ParamSet
+
+// Matcher labels untriaged digests as positive if they match one of its rules
+// when compared against an existing positive digest.
+type Matcher struct {
+	rules     []*Rule
+	diffStore diff.DiffStore
+	expStore  expstorage.ExpectationsStore
+
+	// labeled records the test/digest pairs which the matcher has already
+	// labeled, so that it doesn't label them again if the change is undone.
+	// It is loaded from the triage log on the first run.
+	labeled map[string]util.StringSet
+
+	// checked contains the untriaged digests of each test which have been
+	// compared against the positive digests of the test without a match.
+	// They are only compared again if the positive digests of the test, as
+	// recorded in positives, change.
+	checked   map[string]util.StringSet
+	positives map[string]string
+
+	// running is 1 while Run is in progress. It is accessed atomically.
+	running int32
+}
+
+// New returns a new Matcher which applies the given rules. The rules must have
+// been validated.
+func New(rules []*Rule, diffStore diff.DiffStore, expStore expstorage.ExpectationsStore) *Matcher {
+	return &Matcher{
+		rules:     rules,
+		diffStore: diffStore,
+		expStore:  expStore,
+		checked:   map[string]util.StringSet{},
+		positives: map[string]string{},
+	}
+}
+
+// loadLabeled initializes m.labeled from the entries of the triage log which
+// were made by the matcher, so that digests whose labels were undone are not
+// labeled again after a restart. Only called from Run.
+func (m *Matcher) loadLabeled() error {
+	labeled := map[string]util.StringSet{}
+	for offset := 0; ; offset += TRIAGE_LOG_PAGE_SIZE {
+		entries, total, err := m.expStore.QueryLog(offset, TRIAGE_LOG_PAGE_SIZE, true)
+		if err != nil {
+			return fmt.Errorf("Unable to retrieve triage log: %s", err)
+		}
+		for _, e := range entries {
+			if e.Name != FUZZY_MATCHER_USER {
+				continue
+			}
+			for _, d := range e.Details {
+				if _, ok := labeled[d.TestName]; !ok {
+					labeled[d.TestName] = util.StringSet{}
+				}
+				labeled[d.TestName][d.Digest] = true
+			}
+		}
+		if len(entries) == 0 || offset+len(entries) >= total {
+			break
+		}
+	}
+	m.labeled = labeled
+	return nil
+}
+
+// Run compares the given untriaged digests, keyed by test name, to the
+// positive digests of the same test and labels those that are matched by a
+// rule as positive. Positive digests which were labeled by the matcher itself
+// are not compared against, so that matches can't drift away from the digests
+// labeled by humans. The changes are recorded in the expectations store under
+// FUZZY_MATCHER_USER. Digests which were compared in a previous run are only
+// compared again if the positive digests of their test changed. Returns the
+// changes that were made, or ErrRunInProgress if another run is in progress.
+func (m *Matcher) Run(untriaged map[string][]string, paramsFn ParamsFn) (map[string]types.TestClassification, error) {
+	if !atomic.CompareAndSwapInt32(&m.running, 0, 1) {
+		return nil, ErrRunInProgress
+	}
+	defer atomic.StoreInt32(&m.running, 0)
+
+	if m.labeled == nil {
+		if err := m.loadLabeled(); err != nil {
+			return nil, err
+		}
+	}
+
+	exp, err := m.expStore.Get()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to get expectations: %s", err)
+	}
+	unavailable := m.diffStore.UnavailableDigests()
+
+	changes := map[string]types.TestClassification{}
+	checked := map[string]util.StringSet{}
+	positivesByTest := map[string]string{}
+	for test, digests := range untriaged {
+		positives := []string{}
+		for d, label := range exp.Tests[test] {
+			if _, ok := unavailable[d]; !ok && label == types.POSITIVE && !m.labeled[test][d] {
+				positives = append(positives, d)
+			}
+		}
+		if len(positives) == 0 {
+			continue
+		}
+		sort.Strings(positives)
+		positivesByTest[test] = strings.Join(positives, ",")
+		prevChecked := m.checked[test]
+		if m.positives[test] != positivesByTest[test] {
+			prevChecked = nil
+		}
+		checked[test] = util.StringSet{}
+
+		for _, digest := range digests {
+			if prevChecked[digest] {
+				checked[test][digest] = true
+				continue
+			}
+			if m.labeled[test][digest] || exp.Classification(test, digest) != types.UNTRIAGED {
+				continue
+			}
+			if _, ok := unavailable[digest]; ok {
+				continue
+			}
+			rules := m.applicableRules(paramsFn(test, digest))
+			if len(rules) == 0 {
+				checked[test][digest] = true
+				continue
+			}
+			diffs, err := m.diffStore.Get(diff.PRIORITY_BACKGROUND, digest, positives)
+			if err != nil {
+				sklog.Errorf("Unable to diff %s against positive digests of %s: %s", digest, test, err)
+				continue
+			}
+			if match := findMatch(rules, positives, diffs); match != "" {
+				sklog.Infof("Fuzzy matcher: labeling %s/%s positive; it matches %s", test, digest, match)
+				if _, ok := changes[test]; !ok {
+					changes[test] = types.TestClassification{}
+				}
+				changes[test][digest] = types.POSITIVE
+			} else {
+				checked[test][digest] = true
+			}
+		}
+	}
+	m.checked = checked
+	m.positives = positivesByTest
+
+	if len(changes) == 0 {
+		return changes, nil
+	}
+	if err := m.expStore.AddChange(changes, FUZZY_MATCHER_USER); err != nil {
+		return nil, fmt.Errorf("Unable to store fuzzy matched expectations: %s", err)
+	}
+	for test, digests := range changes {
+		if _, ok := m.labeled[test]; !ok {
+			m.labeled[test] = util.StringSet{}
+		}
+		for d := range digests {
+			m.labeled[test][d] = true
+		}
+	}
+	return changes, nil
+}
+
+// applicableRules returns the rules which apply to a digest with the given
+// params.
+func (m *Matcher) applicableRules(params paramtools.ParamSet) []*Rule {
+	ret := []*Rule{}
+	for _, r := range m.rules {
+		if r.AppliesTo(params) {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// findMatch returns the first of the given positive digests whose diff
+// metrics are matched by one of the rules, or "" if there is none.
+func findMatch(rules []*Rule, positives []string, diffs map[string]interface{}) string {
+	for _, p := range positives {
+		dm, ok := diffs[p].(*diff.DiffMetrics)
+		if !ok {
+			continue
+		}
+		for _, r := range rules {
+			if r.IsMatch(dm) {
+				return p
+			}
+		}
+	}
+	return ""
+}
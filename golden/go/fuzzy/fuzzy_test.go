@@ -0,0 +1,272 @@
+package fuzzy
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/mocks"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// userExpStore wraps an ExpectationsStore and records the users which made
+// changes, as well as a triage log of the changes.
+type userExpStore struct {
+	expstorage.ExpectationsStore
+	users []string
+	log   []*expstorage.TriageLogEntry
+}
+
+// See ExpectationsStore interface.
+func (u *userExpStore) AddChange(changes map[string]types.TestClassification, userId string) error {
+	u.users = append(u.users, userId)
+	entry := &expstorage.TriageLogEntry{Name: userId}
+	for test, digests := range changes {
+		for d, label := range digests {
+			entry.Details = append(entry.Details, &expstorage.TriageDetail{TestName: test, Digest: d, Label: label.String()})
+		}
+	}
+	u.log = append([]*expstorage.TriageLogEntry{entry}, u.log...)
+	return u.ExpectationsStore.AddChange(changes, userId)
+}
+
+// See ExpectationsStore interface.
+func (u *userExpStore) QueryLog(offset, size int, details bool) ([]*expstorage.TriageLogEntry, int, error) {
+	if offset >= len(u.log) {
+		return []*expstorage.TriageLogEntry{}, len(u.log), nil
+	}
+	end := util.MinInt(offset+size, len(u.log))
+	return u.log[offset:end], len(u.log), nil
+}
+
+// countingDiffStore wraps a DiffStore and counts the digests which are
+// compared.
+type countingDiffStore struct {
+	diff.DiffStore
+	compared []string
+}
+
+// See DiffStore interface.
+func (c *countingDiffStore) Get(priority int64, mainDigest string, rightDigests []string) (map[string]interface{}, error) {
+	c.compared = append(c.compared, mainDigest)
+	return c.DiffStore.Get(priority, mainDigest, rightDigests)
+}
+
+func newRule(t *testing.T, query string, maxDiffPixels int, maxRGBADiffs []int) *Rule {
+	r := &Rule{
+		Query:         query,
+		MaxDiffPixels: maxDiffPixels,
+		MaxRGBADiffs:  maxRGBADiffs,
+	}
+	assert.NoError(t, r.Validate())
+	return r
+}
+
+func TestRule(t *testing.T) {
+	testutils.SmallTest(t)
+
+	r := newRule(t, "name=foo&config=8888&config=565", 10, []int{5, 5, 5, 0})
+
+	assert.True(t, r.AppliesTo(paramtools.ParamSet{"name": {"foo"}, "config": {"8888"}, "os": {"linux"}}))
+	assert.True(t, r.AppliesTo(paramtools.ParamSet{"name": {"foo"}, "config": {"8888", "565"}}))
+	// All traces which produced the digest need to match.
+	assert.False(t, r.AppliesTo(paramtools.ParamSet{"name": {"foo"}, "config": {"8888", "gpu"}}))
+	assert.False(t, r.AppliesTo(paramtools.ParamSet{"name": {"foo"}}))
+	assert.False(t, r.AppliesTo(nil))
+
+	assert.True(t, r.IsMatch(&diff.DiffMetrics{NumDiffPixels: 10, MaxRGBADiffs: []int{5, 3, 1, 0}}))
+	assert.False(t, r.IsMatch(&diff.DiffMetrics{NumDiffPixels: 11, MaxRGBADiffs: []int{5, 3, 1, 0}}))
+	assert.False(t, r.IsMatch(&diff.DiffMetrics{NumDiffPixels: 10, MaxRGBADiffs: []int{5, 3, 1, 1}}))
+	assert.False(t, r.IsMatch(&diff.DiffMetrics{NumDiffPixels: 0, MaxRGBADiffs: []int{0, 0, 0, 0}, DimDiffer: true}))
+
+	// Invalid rules.
+	assert.Error(t, (&Rule{Query: "", MaxRGBADiffs: []int{0, 0, 0, 0}}).Validate())
+	assert.Error(t, (&Rule{Query: "name=foo", MaxRGBADiffs: []int{0, 0, 0}}).Validate())
+	assert.Error(t, (&Rule{Query: "name=foo", MaxRGBADiffs: []int{0, 0, 0, 256}}).Validate())
+	assert.Error(t, (&Rule{Query: "name=foo", MaxDiffPixels: -1, MaxRGBADiffs: []int{0, 0, 0, 0}}).Validate())
+}
+
+func TestLoadRules(t *testing.T) {
+	testutils.SmallTest(t)
+
+	dir, err := ioutil.TempDir("", "fuzzy")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	fName := filepath.Join(dir, "rules.json5")
+	assert.NoError(t, ioutil.WriteFile(fName, []byte(`[
+	  {
+	    // Antialiasing differences.
+	    query: "name=foo",
+	    maxDiffPixels: 20,
+	    maxRGBADiffs: [2, 2, 2, 0],
+	    note: "Flaky AA",
+	  },
+	]`), os.ModePerm))
+	rules, err := LoadRules(fName)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(rules))
+	assert.Equal(t, 20, rules[0].MaxDiffPixels)
+	assert.Equal(t, []int{2, 2, 2, 0}, rules[0].MaxRGBADiffs)
+	assert.True(t, rules[0].AppliesTo(paramtools.ParamSet{"name": {"foo"}}))
+
+	assert.NoError(t, ioutil.WriteFile(fName, []byte(`[{query: "name=foo"}]`), os.ModePerm))
+	_, err = LoadRules(fName)
+	assert.Error(t, err)
+}
+
+func TestMatcherRun(t *testing.T) {
+	testutils.SmallTest(t)
+
+	expStore := &userExpStore{ExpectationsStore: expstorage.NewMemExpectationsStore(nil)}
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"foo": {"pos1": types.POSITIVE, "neg1": types.NEGATIVE},
+		"bar": {"pos2": types.POSITIVE},
+	}, "user@example.com"))
+
+	// The mock diffstore reports 10 different pixels and max RGBA diffs of
+	// [5, 3, 4, 0] for every pair of digests.
+	rules := []*Rule{
+		newRule(t, "name=foo", 10, []int{5, 5, 5, 0}),
+		newRule(t, "name=bar", 9, []int{5, 5, 5, 0}),
+	}
+	diffStore := &countingDiffStore{DiffStore: mocks.NewMockDiffStore()}
+	m := New(rules, diffStore, expStore)
+
+	params := map[string]paramtools.ParamSet{
+		"foo": {"name": {"foo"}},
+		"bar": {"name": {"bar"}},
+		"baz": {"name": {"baz"}},
+	}
+	paramsFn := func(test, digest string) paramtools.ParamSet {
+		return params[test]
+	}
+	untriaged := map[string][]string{
+		"foo": {"unt1", "unt2", "neg1"},
+		"bar": {"unt3"},
+		"baz": {"unt4"},
+	}
+	changes, err := m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"foo": {"unt1": types.POSITIVE, "unt2": types.POSITIVE},
+	}, changes)
+	assert.Equal(t, []string{"user@example.com", FUZZY_MATCHER_USER}, expStore.users)
+
+	exp, err := expStore.Get()
+	assert.NoError(t, err)
+	assert.Equal(t, types.POSITIVE, exp.Classification("foo", "unt1"))
+	assert.Equal(t, types.NEGATIVE, exp.Classification("foo", "neg1"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("bar", "unt3"))
+	assert.Equal(t, types.UNTRIAGED, exp.Classification("baz", "unt4"))
+
+	// Digests which were labeled before are not labeled again, e.g. after
+	// the change has been undone.
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"foo": {"unt1": types.UNTRIAGED},
+	}, "user@example.com"))
+	changes, err = m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, 3, len(expStore.users))
+
+	// The same holds for a new Matcher, e.g. after a restart, since the
+	// labeled digests are loaded from the triage log.
+	m = New(rules, diffStore, expStore)
+	changes, err = m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, 3, len(expStore.users))
+
+	// Only digests which are new since the last run are compared, unless
+	// the positive digests of the test change.
+	diffStore.compared = nil
+	untriaged["bar"] = []string{"unt3", "unt5"}
+	changes, err = m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, []string{"unt5"}, diffStore.compared)
+
+	diffStore.compared = nil
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"bar": {"pos3": types.POSITIVE},
+	}, "user@example.com"))
+	changes, err = m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, []string{"unt3", "unt5"}, diffStore.compared)
+
+	// Digests are not compared against positive digests which were labeled
+	// by the matcher itself, i.e. unt2 once pos1 is no longer positive.
+	diffStore.compared = nil
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"foo": {"pos1": types.NEGATIVE},
+	}, "user@example.com"))
+	untriaged["foo"] = []string{"unt6"}
+	changes, err = m.Run(untriaged, paramsFn)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(changes))
+	assert.Equal(t, 0, len(diffStore.compared))
+}
+
+// blockingDiffStore wraps a DiffStore and blocks in Get until release is
+// closed. started is closed when Get is first called.
+type blockingDiffStore struct {
+	diff.DiffStore
+	started chan bool
+	release chan bool
+}
+
+// See DiffStore interface.
+func (b *blockingDiffStore) Get(priority int64, mainDigest string, rightDigests []string) (map[string]interface{}, error) {
+	close(b.started)
+	<-b.release
+	return b.DiffStore.Get(priority, mainDigest, rightDigests)
+}
+
+func TestMatcherRunInProgress(t *testing.T) {
+	testutils.SmallTest(t)
+
+	expStore := &userExpStore{ExpectationsStore: expstorage.NewMemExpectationsStore(nil)}
+	assert.NoError(t, expStore.AddChange(map[string]types.TestClassification{
+		"foo": {"pos1": types.POSITIVE},
+	}, "user@example.com"))
+	rules := []*Rule{newRule(t, "name=foo", 10, []int{5, 5, 5, 0})}
+	diffStore := &blockingDiffStore{
+		DiffStore: mocks.NewMockDiffStore(),
+		started:   make(chan bool),
+		release:   make(chan bool),
+	}
+	m := New(rules, diffStore, expStore)
+	paramsFn := func(test, digest string) paramtools.ParamSet {
+		return paramtools.ParamSet{"name": {test}}
+	}
+	untriaged := map[string][]string{
+		"foo": {"unt1"},
+	}
+
+	// A second run while the first one is in progress is rejected.
+	var changes map[string]types.TestClassification
+	var err error
+	done := make(chan bool)
+	go func() {
+		changes, err = m.Run(untriaged, paramsFn)
+		close(done)
+	}()
+	<-diffStore.started
+	_, err2 := m.Run(untriaged, paramsFn)
+	assert.Equal(t, ErrRunInProgress, err2)
+	close(diffStore.release)
+	<-done
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]types.TestClassification{
+		"foo": {"unt1": types.POSITIVE},
+	}, changes)
+}
@@ -0,0 +1,277 @@
+package goldingestion
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+
+	"go.skia.org/infra/go/auth"
+	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/gcs"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/ingestion"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/validation"
+)
+
+const (
+	// PUSH_RESULTS_FIELD is the name of the multipart form field that contains
+	// the DM JSON results of an upload.
+	PUSH_RESULTS_FIELD = "results"
+
+	// PUSH_IMAGES_FIELD is the name of the multipart form field that contains
+	// the PNGs of an upload. The file name of each PNG is ignored, it is
+	// identified by the MD5 hash of its content.
+	PUSH_IMAGES_FIELD = "images"
+
+	// MAX_PUSH_MEMORY is the amount of an upload that is held in memory.
+	// The rest is buffered in temporary files.
+	MAX_PUSH_MEMORY = 32 * 1024 * 1024
+
+	// MAX_PUSH_SIZE is the maximum size of an upload, including all images.
+	MAX_PUSH_SIZE = 256 * 1024 * 1024
+)
+
+// ImageStore stores the images that are uploaded with results, so that they
+// are available to the diff server.
+type ImageStore interface {
+	// Put stores the given PNG under the given digest.
+	Put(digest string, content []byte) error
+}
+
+// gcsImageStore implements the ImageStore interface for Google storage.
+type gcsImageStore struct {
+	client *storage.Client
+	bucket string
+	dir    string
+}
+
+// NewGCSImageStore returns an ImageStore that writes images to the given
+// bucket and directory, e.g. the first bucket of the diff server and
+// diffstore.DEFAULT_GCS_IMG_DIR_NAME.
+func NewGCSImageStore(client *storage.Client, bucket, dir string) ImageStore {
+	return &gcsImageStore{
+		client: client,
+		bucket: bucket,
+		dir:    dir,
+	}
+}
+
+// See ImageStore interface.
+func (g *gcsImageStore) Put(digest string, content []byte) error {
+	objPath := filepath.Join(g.dir, digest+".png")
+	obj := g.client.Bucket(g.bucket).Object(objPath)
+	if err := gcs.WriteObj(obj, content); err != nil {
+		return fmt.Errorf("Unable to write image to gs://%s/%s: %s", g.bucket, objPath, err)
+	}
+	return nil
+}
+
+// fileImageStore implements the ImageStore interface for the local file
+// system. It uses the same layout as the local image cache of the diff
+// server, which can therefore serve the images directly.
+type fileImageStore struct {
+	dir string
+}
+
+// NewFileImageStore returns an ImageStore that writes images to the given
+// directory.
+func NewFileImageStore(dir string) (ImageStore, error) {
+	absDir, err := fileutil.EnsureDirExists(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &fileImageStore{dir: absDir}, nil
+}
+
+// See ImageStore interface.
+func (f *fileImageStore) Put(digest string, content []byte) error {
+	imgPath := filepath.Join(f.dir, fileutil.TwoLevelRadixPath(digest+".png"))
+	if err := os.MkdirAll(filepath.Dir(imgPath), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(imgPath, content, 0644)
+}
+
+// Pusher receives result files that are uploaded directly. It is implemented
+// by ingestion.Ingester.
+type Pusher interface {
+	// Push queues the given result files for ingestion. It returns an
+	// error if they can't be accepted, e.g. because the queue is full.
+	Push(resultFiles []ingestion.ResultFileLocation) error
+}
+
+// AuthFn returns the identity of the sender of the given request or an error
+// if the request is not authorized.
+type AuthFn func(r *http.Request) (string, error)
+
+// BearerTokenAuth returns an AuthFn that accepts requests with an OAuth 2.0
+// bearer token of one of the given accounts.
+func BearerTokenAuth(authorizedEmails []string) AuthFn {
+	return func(r *http.Request) (string, error) {
+		tok := r.Header.Get("Authorization")
+		if tok == "" {
+			return "", fmt.Errorf("Missing Authorization header.")
+		}
+		tokenInfo, err := auth.ValidateBearerToken(strings.TrimPrefix(tok, "Bearer "))
+		if err != nil {
+			return "", fmt.Errorf("Invalid bearer token: %s", err)
+		}
+		if !util.In(tokenInfo.Email, authorizedEmails) {
+			return "", fmt.Errorf("%s is not authorized to upload results.", tokenInfo.Email)
+		}
+		return tokenInfo.Email, nil
+	}
+}
+
+// PushResponse is the response to a successful upload.
+type PushResponse struct {
+	// Name is the name under which the results are ingested.
+	Name string `json:"name"`
+
+	// NImages is the number of images that were stored.
+	NImages int `json:"nImages"`
+}
+
+// NewPushHandler returns a handler that accepts uploads of DM JSON results
+// and the PNGs they reference as multipart forms. After the upload is
+// validated the images are written to imageStore and the results are sent to
+// pusher, which ingests them asynchronously. Images that were uploaded before
+// don't need to be uploaded again.
+func NewPushHandler(pusher Pusher, imageStore ImageStore, authFn AuthFn) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authFn(r)
+		if err != nil {
+			sklog.Warningf("Rejected upload: %s", err)
+			http.Error(w, "Not authorized to upload results.", http.StatusForbidden)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, MAX_PUSH_SIZE)
+		if err := r.ParseMultipartForm(MAX_PUSH_MEMORY); err != nil {
+			httputils.ReportError(w, r, err, "Failed to parse upload.")
+			return
+		}
+		defer func() {
+			if err := r.MultipartForm.RemoveAll(); err != nil {
+				sklog.Errorf("Failed to remove temporary upload files: %s", err)
+			}
+		}()
+
+		resultsJSON, images, err := readUpload(r)
+		if err != nil {
+			httputils.ReportError(w, r, err, "Invalid upload.")
+			return
+		}
+
+		hash := md5.Sum(resultsJSON)
+		name := fmt.Sprintf("push/%s/%s.json", time.Now().UTC().Format("2006/01/02/15"), hex.EncodeToString(hash[:]))
+		if err := validateUpload(resultsJSON, name, images); err != nil {
+			httputils.ReportError(w, r, err, "Invalid upload.")
+			return
+		}
+
+		for digest, content := range images {
+			if err := imageStore.Put(digest, content); err != nil {
+				httputils.ReportError(w, r, err, "Failed to store image.")
+				return
+			}
+		}
+
+		if err := pusher.Push([]ingestion.ResultFileLocation{ingestion.MemResultFileLocation(name, resultsJSON, time.Now().Unix())}); err != nil {
+			httputils.ReportError(w, r, err, "Failed to queue results for ingestion.")
+			return
+		}
+		sklog.Infof("%s uploaded %s with %d images.", user, name, len(images))
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		if err := json.NewEncoder(w).Encode(&PushResponse{Name: name, NImages: len(images)}); err != nil {
+			sklog.Errorf("Failed to write or encode result: %s", err)
+		}
+	}
+}
+
+// readUpload returns the DM JSON results and the images, keyed by digest, of
+// the parsed multipart form of the given request.
+func readUpload(r *http.Request) ([]byte, map[string][]byte, error) {
+	resultHeaders := r.MultipartForm.File[PUSH_RESULTS_FIELD]
+	if len(resultHeaders) != 1 {
+		return nil, nil, fmt.Errorf("Expected exactly one results file, got %d.", len(resultHeaders))
+	}
+	resultsJSON, err := readPart(resultHeaders[0])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	images := map[string][]byte{}
+	for _, header := range r.MultipartForm.File[PUSH_IMAGES_FIELD] {
+		content, err := readPart(header)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash := md5.Sum(content)
+		images[hex.EncodeToString(hash[:])] = content
+	}
+	return resultsJSON, images, nil
+}
+
+// readPart returns the content of the given file of a multipart form.
+func readPart(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to open %s: %s", header.Filename, err)
+	}
+	defer util.Close(f)
+	return ioutil.ReadAll(f)
+}
+
+// validateUpload makes sure that the given DM JSON results can be ingested and
+// that every image is a PNG that is referenced by the results.
+func validateUpload(resultsJSON []byte, name string, images map[string][]byte) error {
+	dmResults, err := ParseDMResultsFromReader(ioutil.NopCloser(bytes.NewReader(resultsJSON)), name)
+	if err != nil {
+		return err
+	}
+	if dmResults.GitHash == "" {
+		return fmt.Errorf("Missing gitHash in results.")
+	}
+
+	entries, err := dmResults.getTraceDBEntries()
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		digest := string(entry.Value)
+		if !validation.IsValidDigest(digest) {
+			return fmt.Errorf("Invalid digest %q in results.", digest)
+		}
+		referenced[strings.ToLower(digest)] = true
+	}
+
+	for digest, content := range images {
+		if !referenced[digest] {
+			return fmt.Errorf("Image %s is not referenced by the results.", digest)
+		}
+		// Decode the image with the same decoder as the diff store, so that
+		// all formats that can be diffed are accepted.
+		if _, err := diff.DecodeImage(bytes.NewReader(content)); err != nil {
+			return fmt.Errorf("Image %s is not a valid image: %s", digest, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package goldingestion
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/fileutil"
+	"go.skia.org/infra/go/ingestion"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/diff"
+)
+
+// mockPusher collects the result files that are pushed to it. If err is set
+// they are rejected.
+type mockPusher struct {
+	resultFiles []ingestion.ResultFileLocation
+	err         error
+}
+
+// See Pusher interface.
+func (m *mockPusher) Push(resultFiles []ingestion.ResultFileLocation) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.resultFiles = append(m.resultFiles, resultFiles...)
+	return nil
+}
+
+// testPNG returns a PNG of the given size and its digest.
+func testPNG(t *testing.T, size int) ([]byte, string) {
+	var buf bytes.Buffer
+	assert.NoError(t, png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, size, size))))
+	hash := md5.Sum(buf.Bytes())
+	return buf.Bytes(), hex.EncodeToString(hash[:])
+}
+
+// testResults returns DM JSON results that reference the given digests.
+func testResults(digests ...string) []byte {
+	results := ""
+	for idx, digest := range digests {
+		if idx > 0 {
+			results += ","
+		}
+		results += fmt.Sprintf(`{"key": {"name": "test%d", "config": "8888"}, "md5": %q, "options": {"ext": "png"}}`, idx, digest)
+	}
+	return []byte(fmt.Sprintf(`{"gitHash": "abcd", "key": {"arch": "x86"}, "results": [%s]}`, results))
+}
+
+// pushRequest returns an upload request with the given results and images.
+func pushRequest(t *testing.T, results []byte, images ...[]byte) *http.Request {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile(PUSH_RESULTS_FIELD, "dm.json")
+	assert.NoError(t, err)
+	_, err = fw.Write(results)
+	assert.NoError(t, err)
+	for idx, img := range images {
+		fw, err := mw.CreateFormFile(PUSH_IMAGES_FIELD, fmt.Sprintf("%d.png", idx))
+		assert.NoError(t, err)
+		_, err = fw.Write(img)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, mw.Close())
+
+	r := httptest.NewRequest("POST", "/push", &body)
+	r.Header.Set("Content-Type", mw.FormDataContentType())
+	return r
+}
+
+func TestPushHandler(t *testing.T) {
+	testutils.SmallTest(t)
+
+	dir, err := ioutil.TempDir("", "push")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, dir)
+
+	imageStore, err := NewFileImageStore(dir)
+	assert.NoError(t, err)
+	pusher := &mockPusher{}
+	authorized := true
+	authFn := func(r *http.Request) (string, error) {
+		if !authorized {
+			return "", fmt.Errorf("Not authorized")
+		}
+		return "bot@example.com", nil
+	}
+	handler := NewPushHandler(pusher, imageStore, authFn)
+
+	img1, digest1 := testPNG(t, 2)
+	img2, digest2 := testPNG(t, 3)
+	results := testResults(digest1, digest2)
+
+	// A valid upload. The second image was uploaded before.
+	w := httptest.NewRecorder()
+	handler(w, pushRequest(t, results, img1))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	resp := &PushResponse{}
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(resp))
+	assert.Equal(t, 1, resp.NImages)
+
+	assert.Equal(t, 1, len(pusher.resultFiles))
+	assert.Equal(t, resp.Name, pusher.resultFiles[0].Name())
+	assert.Equal(t, results, pusher.resultFiles[0].Content())
+	dmResults, err := processDMResults(pusher.resultFiles[0])
+	assert.NoError(t, err)
+	assert.Equal(t, "abcd", dmResults.GitHash)
+
+	stored, err := ioutil.ReadFile(filepath.Join(dir, fileutil.TwoLevelRadixPath(digest1+".png")))
+	assert.NoError(t, err)
+	assert.Equal(t, img1, stored)
+
+	// Images in other formats that can be diffed are accepted as well.
+	hdrImg := []byte(diff.HDR_MAGIC + "\n\n-Y 1 +X 1\n\x80\x80\x80\x81")
+	hdrDigest := fmt.Sprintf("%x", md5.Sum(hdrImg))
+	w = httptest.NewRecorder()
+	handler(w, pushRequest(t, testResults(hdrDigest), hdrImg))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Equal(t, 2, len(pusher.resultFiles))
+
+	// Invalid uploads.
+	for _, r := range []*http.Request{
+		// Image that is not referenced.
+		pushRequest(t, testResults(digest1), img2),
+		// Image that can not be decoded.
+		pushRequest(t, testResults(digest1, fmt.Sprintf("%x", md5.Sum([]byte("not a png")))), []byte("not a png")),
+		// Invalid digest.
+		pushRequest(t, testResults("abc")),
+		// Invalid JSON.
+		pushRequest(t, []byte("{")),
+	} {
+		w = httptest.NewRecorder()
+		handler(w, r)
+		assert.Equal(t, http.StatusInternalServerError, w.Code)
+	}
+
+	// The upload fails if the results can't be queued.
+	pusher.err = fmt.Errorf("Queue is full.")
+	w = httptest.NewRecorder()
+	handler(w, pushRequest(t, results, img1))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	pusher.err = nil
+
+	authorized = false
+	w = httptest.NewRecorder()
+	handler(w, pushRequest(t, results, img1))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+	assert.Equal(t, 2, len(pusher.resultFiles))
+}
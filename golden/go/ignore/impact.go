@@ -0,0 +1,170 @@
+package ignore
+
+import (
+	"net/url"
+	"sort"
+
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// Reasons why an ignore rule is considered stale.
+const (
+	// STALE_NO_MATCH indicates that the rule does not match any trace.
+	STALE_NO_MATCH = "no_match"
+
+	// STALE_POSITIVE_ONLY indicates that all traces matched by the rule only
+	// contain positive digests, i.e. there is nothing left to ignore.
+	STALE_POSITIVE_ONLY = "positive_only"
+)
+
+// Impact describes what an ignore rule would hide in a tile.
+type Impact struct {
+	// Query is the query of the ignore rule.
+	Query string `json:"query"`
+
+	// Traces are the ids of the traces that would be hidden, sorted.
+	Traces []string `json:"traces"`
+
+	// Tests are the names of the tests with hidden traces, sorted.
+	Tests []string `json:"tests"`
+
+	// Untriaged maps test names to the sorted untriaged digests that would be
+	// hidden, i.e. digests that only appear in hidden traces.
+	Untriaged map[string][]string `json:"untriaged"`
+}
+
+// CalcImpact calculates what an ignore rule with the given query would hide in
+// the given tile, which should contain all traces, including ignored ones.
+// Traces matched by ignoreMatcher are already ignored and are not counted.
+func CalcImpact(query url.Values, tile *tiling.Tile, exp *expstorage.Expectations, ignoreMatcher RuleMatcher) *Impact {
+	rule := NewQueryRule(query)
+	traceIDs := []string{}
+	tests := util.StringSet{}
+
+	// Untriaged digests in traces that would be hidden and in traces that
+	// remain visible, keyed by test name.
+	hidden := map[string]util.StringSet{}
+	visible := map[string]util.StringSet{}
+
+	for traceID, trace := range tile.Traces {
+		gTrace := trace.(*types.GoldenTrace)
+		if _, ok := ignoreMatcher(gTrace.Params_); ok {
+			continue
+		}
+
+		testName := gTrace.Params_[types.PRIMARY_KEY_FIELD]
+		target := visible
+		if rule.IsMatch(gTrace.Params_) {
+			traceIDs = append(traceIDs, traceID)
+			tests[testName] = true
+			target = hidden
+		}
+
+		for _, digest := range gTrace.Values {
+			if digest == types.MISSING_DIGEST || exp.Classification(testName, digest) != types.UNTRIAGED {
+				continue
+			}
+			if _, ok := target[testName]; !ok {
+				target[testName] = util.StringSet{}
+			}
+			target[testName][digest] = true
+		}
+	}
+
+	untriaged := make(map[string][]string, len(hidden))
+	for testName, digests := range hidden {
+		hiddenDigests := digests.Complement(visible[testName]).Keys()
+		if len(hiddenDigests) > 0 {
+			sort.Strings(hiddenDigests)
+			untriaged[testName] = hiddenDigests
+		}
+	}
+
+	sort.Strings(traceIDs)
+	sortedTests := tests.Keys()
+	sort.Strings(sortedTests)
+	return &Impact{
+		Query:     query.Encode(),
+		Traces:    traceIDs,
+		Tests:     sortedTests,
+		Untriaged: untriaged,
+	}
+}
+
+// StaleRule is an ignore rule that should probably be removed.
+type StaleRule struct {
+	Rule *IgnoreRule `json:"rule"`
+
+	// Reason is one of the STALE_* constants.
+	Reason string `json:"reason"`
+
+	// NTraces is the number of traces matched by the rule.
+	NTraces int `json:"nTraces"`
+}
+
+// FindStaleRules returns the rules which don't match any trace in the given
+// tile, or only match traces that contain nothing but positive digests. The
+// tile should contain all traces, including ignored ones.
+func FindStaleRules(rules []*IgnoreRule, tile *tiling.Tile, exp *expstorage.Expectations) ([]*StaleRule, error) {
+	queries, err := ToQuery(rules)
+	if err != nil {
+		return nil, err
+	}
+	queryRules := make([]QueryRule, len(queries))
+	for idx, q := range queries {
+		queryRules[idx] = NewQueryRule(q)
+	}
+
+	nTraces := make([]int, len(rules))
+	nPositiveOnly := make([]int, len(rules))
+	for _, trace := range tile.Traces {
+		gTrace := trace.(*types.GoldenTrace)
+		positiveOnly := isPositiveOnly(gTrace, exp)
+		for idx, q := range queryRules {
+			if q.IsMatch(gTrace.Params_) {
+				nTraces[idx]++
+				if positiveOnly {
+					nPositiveOnly[idx]++
+				}
+			}
+		}
+	}
+
+	ret := []*StaleRule{}
+	for idx, rule := range rules {
+		reason := ""
+		if nTraces[idx] == 0 {
+			reason = STALE_NO_MATCH
+		} else if nPositiveOnly[idx] == nTraces[idx] {
+			reason = STALE_POSITIVE_ONLY
+		}
+		if reason != "" {
+			ret = append(ret, &StaleRule{
+				Rule:    rule,
+				Reason:  reason,
+				NTraces: nTraces[idx],
+			})
+		}
+	}
+	return ret, nil
+}
+
+// isPositiveOnly returns true if the trace contains at least one digest and
+// all of its digests are positive.
+func isPositiveOnly(trace *types.GoldenTrace, exp *expstorage.Expectations) bool {
+	testName := trace.Params_[types.PRIMARY_KEY_FIELD]
+	found := false
+	for _, digest := range trace.Values {
+		if digest == types.MISSING_DIGEST {
+			continue
+		}
+		if exp.Classification(testName, digest) != types.POSITIVE {
+			return false
+		}
+		found = true
+	}
+	return found
+}
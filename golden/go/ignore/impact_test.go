@@ -0,0 +1,101 @@
+package ignore
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/types"
+)
+
+func newTrace(testName, config string, values ...string) *types.GoldenTrace {
+	return &types.GoldenTrace{
+		Values: values,
+		Params_: map[string]string{
+			types.PRIMARY_KEY_FIELD: testName,
+			"config":                config,
+		},
+	}
+}
+
+func testTile() *tiling.Tile {
+	return &tiling.Tile{
+		Traces: map[string]tiling.Trace{
+			",config=8888,name=foo,": newTrace("foo", "8888", "pos1", "unt1"),
+			",config=565,name=foo,":  newTrace("foo", "565", "pos1", "unt2"),
+			",config=gpu,name=foo,":  newTrace("foo", "gpu", "unt1", types.MISSING_DIGEST),
+			",config=565,name=bar,":  newTrace("bar", "565", "pos2", "pos2"),
+			",config=gpu,name=bar,":  newTrace("bar", "gpu", "unt3", "neg1"),
+		},
+	}
+}
+
+func testExpectations() *expstorage.Expectations {
+	exp := expstorage.NewExpectations()
+	exp.AddDigests(map[string]types.TestClassification{
+		"foo": {"pos1": types.POSITIVE},
+		"bar": {"pos2": types.POSITIVE, "neg1": types.NEGATIVE},
+	})
+	return exp
+}
+
+func TestCalcImpact(t *testing.T) {
+	testutils.SmallTest(t)
+
+	tile := testTile()
+	exp := testExpectations()
+
+	impact := CalcImpact(url.Values{"config": {"565"}}, tile, exp, noopRuleMatcher)
+	assert.Equal(t, &Impact{
+		Query:     "config=565",
+		Traces:    []string{",config=565,name=bar,", ",config=565,name=foo,"},
+		Tests:     []string{"bar", "foo"},
+		Untriaged: map[string][]string{"foo": {"unt2"}},
+	}, impact)
+
+	// "unt1" is hidden once both traces which contain it are hidden.
+	impact = CalcImpact(url.Values{"name": {"foo"}, "config": {"8888"}}, tile, exp, noopRuleMatcher)
+	assert.Equal(t, []string{",config=8888,name=foo,"}, impact.Traces)
+	assert.Equal(t, map[string][]string{}, impact.Untriaged)
+
+	// Traces that are already ignored are not counted and don't keep
+	// digests visible.
+	store := NewMemIgnoreStore()
+	assert.NoError(t, store.Create(NewIgnoreRule("user@example.com", time.Now().Add(time.Hour), "config=gpu", "")))
+	ignoreMatcher, err := store.BuildRuleMatcher()
+	assert.NoError(t, err)
+	impact = CalcImpact(url.Values{"name": {"foo"}, "config": {"8888", "gpu"}}, tile, exp, ignoreMatcher)
+	assert.Equal(t, []string{",config=8888,name=foo,"}, impact.Traces)
+	assert.Equal(t, map[string][]string{"foo": {"unt1"}}, impact.Untriaged)
+
+	// Nothing matches.
+	impact = CalcImpact(url.Values{"name": {"baz"}}, tile, exp, noopRuleMatcher)
+	assert.Equal(t, 0, len(impact.Traces))
+	assert.Equal(t, 0, len(impact.Tests))
+	assert.Equal(t, 0, len(impact.Untriaged))
+}
+
+func TestFindStaleRules(t *testing.T) {
+	testutils.SmallTest(t)
+
+	expires := time.Now().Add(time.Hour)
+	rules := []*IgnoreRule{
+		NewIgnoreRule("user@example.com", expires, "config=565", ""),
+		NewIgnoreRule("user@example.com", expires, "name=bar&config=565", ""),
+		NewIgnoreRule("user@example.com", expires, "name=baz", ""),
+		NewIgnoreRule("user@example.com", expires, "name=bar", ""),
+	}
+	staleRules, err := FindStaleRules(rules, testTile(), testExpectations())
+	assert.NoError(t, err)
+	assert.Equal(t, []*StaleRule{
+		{Rule: rules[1], Reason: STALE_POSITIVE_ONLY, NTraces: 1},
+		{Rule: rules[2], Reason: STALE_NO_MATCH, NTraces: 0},
+	}, staleRules)
+
+	_, err = FindStaleRules([]*IgnoreRule{NewIgnoreRule("user@example.com", expires, "name=%zz", "")}, testTile(), testExpectations())
+	assert.Error(t, err)
+}
@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/tiling"
+	"go.skia.org/infra/golden/go/expstorage"
 
 	"go.skia.org/infra/go/sklog"
 )
@@ -48,3 +50,49 @@ func Init(store IgnoreStore) error {
 
 	return nil
 }
+
+// InitStaleRulesMonitoring starts a routine that periodically counts the
+// ignore rules which don't match any trace in the tile returned by tileFn or
+// only hide traces with positive digests, and pushes those counts into
+// metrics. tileFn should return the tile including ignored traces, or nil if
+// no tile is available yet.
+func InitStaleRulesMonitoring(store IgnoreStore, expStore expstorage.ExpectationsStore, tileFn func() *tiling.Tile) {
+	numNoMatch := metrics2.GetInt64Metric("gold_num_stale_ignore_rules", map[string]string{"reason": STALE_NO_MATCH})
+	numPositiveOnly := metrics2.GetInt64Metric("gold_num_stale_ignore_rules", map[string]string{"reason": STALE_POSITIVE_ONLY})
+	liveness := metrics2.NewLiveness("gold_stale_ignore_rules_monitoring")
+
+	go func() {
+		for range time.Tick(5 * time.Minute) {
+			tile := tileFn()
+			if tile == nil {
+				continue
+			}
+			staleRules, err := staleRules(store, expStore, tile)
+			if err != nil {
+				sklog.Errorf("Failed one step of monitoring stale ignore rules: %s", err)
+				continue
+			}
+
+			counts := map[string]int64{}
+			for _, s := range staleRules {
+				counts[s.Reason]++
+			}
+			numNoMatch.Update(counts[STALE_NO_MATCH])
+			numPositiveOnly.Update(counts[STALE_POSITIVE_ONLY])
+			liveness.Reset()
+		}
+	}()
+}
+
+// staleRules returns the stale rules of the given store. See FindStaleRules.
+func staleRules(store IgnoreStore, expStore expstorage.ExpectationsStore, tile *tiling.Tile) ([]*StaleRule, error) {
+	rules, err := store.List(false)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := expStore.Get()
+	if err != nil {
+		return nil, err
+	}
+	return FindStaleRules(rules, tile, exp)
+}
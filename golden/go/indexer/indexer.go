@@ -19,6 +19,7 @@ import (
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/diff"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/fuzzy"
 	"go.skia.org/infra/golden/go/paramsets"
 	"go.skia.org/infra/golden/go/pdag"
 	"go.skia.org/infra/golden/go/storage"
@@ -197,6 +198,10 @@ func New(storages *storage.Storage, interval time.Duration) (*Indexer, error) {
 	// The warmer depends on summaries.
 	pdag.NewNode(runWarmer, summaryNode, summaryIgnoresNode)
 
+	// The fuzzy matcher needs the untriaged digests from the summaries and
+	// the params of each digest.
+	pdag.NewNode(runFuzzyMatcher, summaryNode, paramsNode)
+
 	// Set the result on the Indexer instance, once summaries, parameters and writing
 	// the hash files is done.
 	pdag.NewNode(ret.setIndex, summaryNode, summaryIgnoresNode, paramsNode)
@@ -464,3 +469,39 @@ func runWarmer(state interface{}) error {
 	go idx.warmer.Run(idx.tilePair.TileWithIgnores, idx.summariesWithIgnores, idx.talliesWithIgnores)
 	return nil
 }
+
+// runFuzzyMatcher is the pipeline function to auto-triage untriaged digests
+// that are nearly identical to positive digests. It runs asynchronously,
+// since any resulting expectation changes trigger their own re-indexing. It is
+// skipped if the previous run has not finished yet.
+func runFuzzyMatcher(state interface{}) error {
+	idx := state.(*SearchIndex)
+	if idx.storages.FuzzyMatcher == nil {
+		return nil
+	}
+
+	untriaged := map[string][]string{}
+	for test, sum := range idx.summaries.Get() {
+		if len(sum.UntHashes) > 0 {
+			untriaged[test] = sum.UntHashes
+		}
+	}
+	paramsFn := func(test, digest string) paramtools.ParamSet {
+		return idx.GetParamsetSummary(test, digest, false)
+	}
+
+	go func() {
+		changes, err := idx.storages.FuzzyMatcher.Run(untriaged, paramsFn)
+		if err == fuzzy.ErrRunInProgress {
+			sklog.Infof("Skipping fuzzy matcher; the previous run is still in progress.")
+			return
+		} else if err != nil {
+			sklog.Errorf("Error running fuzzy matcher: %s", err)
+			return
+		}
+		if len(changes) > 0 {
+			sklog.Infof("Fuzzy matcher labeled digests in %d tests.", len(changes))
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,71 @@
+package savedsearch
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+
+	"go.skia.org/infra/go/ds"
+	"go.skia.org/infra/go/sklog"
+)
+
+// cloudSavedSearchStore implements the SavedSearchStore interface on top of
+// Cloud Datastore. Searches are keyed by their name.
+type cloudSavedSearchStore struct {
+	client *datastore.Client
+}
+
+// NewCloudSavedSearchStore returns a SavedSearchStore that is backed by Cloud
+// Datastore.
+func NewCloudSavedSearchStore(client *datastore.Client) (SavedSearchStore, error) {
+	if client == nil {
+		return nil, sklog.FmtErrorf("Received nil for datastore client.")
+	}
+	return &cloudSavedSearchStore{client: client}, nil
+}
+
+// key returns the datastore key of the saved search with the given name.
+func (c *cloudSavedSearchStore) key(name string) *datastore.Key {
+	key := ds.NewKey(ds.SAVED_SEARCH)
+	key.Name = name
+	return key
+}
+
+// Get implements the SavedSearchStore interface.
+func (c *cloudSavedSearchStore) Get(name string) (*SavedSearch, error) {
+	ret := &SavedSearch{}
+	if err := c.client.Get(context.TODO(), c.key(name), ret); err != nil {
+		if err == datastore.ErrNoSuchEntity {
+			return nil, nil
+		}
+		return nil, sklog.FmtErrorf("Error retrieving saved search %s: %s", name, err)
+	}
+	return ret, nil
+}
+
+// List implements the SavedSearchStore interface.
+func (c *cloudSavedSearchStore) List() ([]*SavedSearch, error) {
+	ret := []*SavedSearch{}
+	if _, err := c.client.GetAll(context.TODO(), ds.NewQuery(ds.SAVED_SEARCH), &ret); err != nil {
+		return nil, sklog.FmtErrorf("Error retrieving saved searches: %s", err)
+	}
+	sortByName(ret)
+	return ret, nil
+}
+
+// Put implements the SavedSearchStore interface.
+func (c *cloudSavedSearchStore) Put(search *SavedSearch) error {
+	if err := validate(search); err != nil {
+		return err
+	}
+	_, err := c.client.Put(context.TODO(), c.key(search.Name), search)
+	return err
+}
+
+// Delete implements the SavedSearchStore interface.
+func (c *cloudSavedSearchStore) Delete(name string) error {
+	if err := c.client.Delete(context.TODO(), c.key(name)); err != nil && err != datastore.ErrNoSuchEntity {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// savedsearch stores named searches, so that triagers can bookmark and share
+// searches that are written in the search query language.
+package savedsearch
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// validName matches the names of saved searches. Names are used in URLs and
+// are therefore restricted to characters that don't need to be escaped.
+var validName = regexp.MustCompile(`^[a-zA-Z0-9_.-]{1,100}$`)
+
+// SavedSearch is a named search in the search query language.
+type SavedSearch struct {
+	// Name uniquely identifies the search, e.g. "untriaged-gpu-5pct".
+	Name string `json:"name"`
+
+	// Query is the query language expression, see search.CompileQueryLang.
+	Query string `json:"query"`
+
+	// Description is a human readable description of the search.
+	Description string `json:"description" datastore:",noindex"`
+
+	// UpdatedBy is the user that last saved the search.
+	UpdatedBy string `json:"updatedBy"`
+
+	// Updated is when the search was last saved.
+	Updated time.Time `json:"updated"`
+}
+
+// IsValidName returns true if the given string can be used as the name of a
+// saved search.
+func IsValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+// SavedSearchStore stores saved searches by name.
+type SavedSearchStore interface {
+	// Get returns the saved search with the given name or nil if it does not
+	// exist.
+	Get(name string) (*SavedSearch, error)
+
+	// List returns all saved searches sorted by name.
+	List() ([]*SavedSearch, error)
+
+	// Put adds the given search or replaces the search with the same name.
+	Put(search *SavedSearch) error
+
+	// Delete removes the saved search with the given name. It is not an error
+	// if the search does not exist.
+	Delete(name string) error
+}
+
+// validate returns an error if the given search cannot be stored.
+func validate(search *SavedSearch) error {
+	if !IsValidName(search.Name) {
+		return fmt.Errorf("Invalid name %q. Names can contain letters, digits, '_', '.' and '-'.", search.Name)
+	}
+	if search.Query == "" {
+		return fmt.Errorf("Saved search %s has an empty query.", search.Name)
+	}
+	return nil
+}
+
+// sortByName sorts the given searches by name.
+func sortByName(searches []*SavedSearch) {
+	sort.Slice(searches, func(i, j int) bool { return searches[i].Name < searches[j].Name })
+}
+
+// memSavedSearchStore is an in-memory implementation of SavedSearchStore.
+type memSavedSearchStore struct {
+	searches map[string]*SavedSearch
+	mutex    sync.Mutex
+}
+
+// NewMemSavedSearchStore returns an in-memory SavedSearchStore.
+func NewMemSavedSearchStore() SavedSearchStore {
+	return &memSavedSearchStore{
+		searches: map[string]*SavedSearch{},
+	}
+}
+
+// Get implements the SavedSearchStore interface.
+func (m *memSavedSearchStore) Get(name string) (*SavedSearch, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.searches[name], nil
+}
+
+// List implements the SavedSearchStore interface.
+func (m *memSavedSearchStore) List() ([]*SavedSearch, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	ret := make([]*SavedSearch, 0, len(m.searches))
+	for _, search := range m.searches {
+		ret = append(ret, search)
+	}
+	sortByName(ret)
+	return ret, nil
+}
+
+// Put implements the SavedSearchStore interface.
+func (m *memSavedSearchStore) Put(search *SavedSearch) error {
+	if err := validate(search); err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.searches[search.Name] = search
+	return nil
+}
+
+// Delete implements the SavedSearchStore interface.
+func (m *memSavedSearchStore) Delete(name string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.searches, name)
+	return nil
+}
@@ -0,0 +1,56 @@
+package savedsearch
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+)
+
+func TestMemSavedSearchStore(t *testing.T) {
+	testutils.SmallTest(t)
+
+	store := NewMemSavedSearchStore()
+	found, err := store.Get("gpu")
+	assert.NoError(t, err)
+	assert.Nil(t, found)
+
+	gpu := &SavedSearch{
+		Name:      "untriaged-gpu-5pct",
+		Query:     "cpu_or_gpu:GPU label:untriaged diff>5%",
+		UpdatedBy: "user@example.com",
+		Updated:   time.Now(),
+	}
+	cpu := &SavedSearch{
+		Name:  "cpu",
+		Query: "cpu_or_gpu:CPU",
+	}
+	assert.NoError(t, store.Put(gpu))
+	assert.NoError(t, store.Put(cpu))
+
+	found, err = store.Get(gpu.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, gpu, found)
+	list, err := store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*SavedSearch{cpu, gpu}, list)
+
+	// Putting a search with the same name replaces it.
+	updated := &SavedSearch{Name: cpu.Name, Query: "cpu_or_gpu:CPU label:untriaged"}
+	assert.NoError(t, store.Put(updated))
+	found, err = store.Get(cpu.Name)
+	assert.NoError(t, err)
+	assert.Equal(t, updated, found)
+
+	// Invalid searches.
+	assert.Error(t, store.Put(&SavedSearch{Name: "has space", Query: "label:untriaged"}))
+	assert.Error(t, store.Put(&SavedSearch{Name: "", Query: "label:untriaged"}))
+	assert.Error(t, store.Put(&SavedSearch{Name: "empty"}))
+
+	assert.NoError(t, store.Delete(cpu.Name))
+	assert.NoError(t, store.Delete("does-not-exist"))
+	list, err = store.List()
+	assert.NoError(t, err)
+	assert.Equal(t, []*SavedSearch{gpu}, list)
+}
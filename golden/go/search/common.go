@@ -66,10 +66,13 @@ func iterTile(query *Query, addFn AddFn, acceptFn AcceptFn, exp ExpSlice, idx *i
 				}
 
 				// Fix blamer to make this easier.
-				if query.BlameGroupID != "" {
+				if query.BlameGroupID != "" || query.BlameAuthor != "" {
 					if cl == types.UNTRIAGED {
 						b := idx.GetBlame(test, digest, tile.Commits)
-						if query.BlameGroupID != blameGroupID(b, tile.Commits) {
+						if query.BlameGroupID != "" && query.BlameGroupID != blameGroupID(b, tile.Commits) {
+							continue
+						}
+						if query.BlameAuthor != "" && !blamedOnAuthor(b, tile.Commits, query.BlameAuthor) {
 							continue
 						}
 					} else {
@@ -102,12 +102,6 @@ func (s *SearchAPI) Search(ctx context.Context, q *Query) (*NewSearchResponse, e
 	ctx, span := trace.StartSpan(ctx, "search/Search")
 	defer span.End()
 
-	// Keep track if we are including reference diffs. This is going to be true
-	// for the majority of queries.
-	getRefDiffs := !q.NoDiff
-
-	isTryjobSearch := q.Issue > 0
-
 	// Get the expectations and the current index, which we assume constant
 	// for the duration of this query.
 	exp, err := s.getExpectationsFromQuery(q)
@@ -116,8 +110,99 @@ func (s *SearchAPI) Search(ctx context.Context, q *Query) (*NewSearchResponse, e
 	}
 	idx := s.ixr.GetIndex()
 
+	ret, inter, issue, err := s.findDigests(ctx, q, exp, idx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sort the digests and fill the ones that are going to be displayed with
+	// additional data. Note we are returning all digests found, so we can do
+	// bulk triage, but only the digests that are going to be shown are padded
+	// with additional information.
+	displayRet, offset := s.sortAndLimitDigests(ctx, q, ret, int(q.Offset), int(q.Limit))
+	s.addParamsAndTraces(ctx, displayRet, inter, exp, idx)
+
+	// Return all digests with the selected offset within the result set.
+	searchRet := &NewSearchResponse{
+		Digests: ret,
+		Offset:  offset,
+		Size:    len(displayRet),
+		Commits: idx.GetTile(false).Commits,
+		Issue:   issue,
+	}
+	return searchRet, nil
+}
+
+// SearchAny returns the union of the results of the given queries, e.g. the
+// queries compiled from a query language expression by CompileQueryLang.
+// Sorting, pagination and the expectations are determined by the first
+// query, so the queries should only differ in their filters.
+func (s *SearchAPI) SearchAny(ctx context.Context, queries []*Query) (*NewSearchResponse, error) {
+	if len(queries) == 1 {
+		return s.Search(ctx, queries[0])
+	}
+
+	ctx, span := trace.StartSpan(ctx, "search/SearchAny")
+	defer span.End()
+
+	idx := s.ixr.GetIndex()
+	if len(queries) == 0 {
+		return &NewSearchResponse{
+			Digests: []*SRDigest{},
+			Commits: idx.GetTile(false).Commits,
+		}, nil
+	}
+
+	first := queries[0]
+	exp, err := s.getExpectationsFromQuery(first)
+	if err != nil {
+		return nil, err
+	}
+
+	// Digests found by multiple queries are only returned once.
+	ret := []*SRDigest{}
+	inter := srInterMap{}
+	found := map[string]bool{}
+	var issue *tryjobstore.Issue = nil
+	for _, q := range queries {
+		digests, qInter, qIssue, err := s.findDigests(ctx, q, exp, idx)
+		if err != nil {
+			return nil, err
+		}
+		inter.merge(qInter, digests)
+		issue = qIssue
+		for _, d := range digests {
+			if key := d.Test + ":" + d.Digest; !found[key] {
+				found[key] = true
+				ret = append(ret, d)
+			}
+		}
+	}
+
+	displayRet, offset := s.sortAndLimitDigests(ctx, first, ret, int(first.Offset), int(first.Limit))
+	s.addParamsAndTraces(ctx, displayRet, inter, exp, idx)
+	return &NewSearchResponse{
+		Digests: ret,
+		Offset:  offset,
+		Size:    len(displayRet),
+		Commits: idx.GetTile(false).Commits,
+		Issue:   issue,
+	}, nil
+}
+
+// findDigests returns the digests that match the given query along with the
+// intermediate representation of the search and the issue of tryjob searches.
+// The digests contain the reference diffs unless the query disables them.
+func (s *SearchAPI) findDigests(ctx context.Context, q *Query, exp ExpSlice, idx *indexer.SearchIndex) ([]*SRDigest, srInterMap, *tryjobstore.Issue, error) {
+	// Keep track if we are including reference diffs. This is going to be true
+	// for the majority of queries.
+	getRefDiffs := !q.NoDiff
+
+	isTryjobSearch := q.Issue > 0
+
 	var inter srInterMap = nil
 	var issue *tryjobstore.Issue = nil
+	var err error
 
 	// Find the digests (left hand side) we are interested in.
 	if isTryjobSearch {
@@ -130,7 +215,7 @@ func (s *SearchAPI) Search(ctx context.Context, q *Query) (*NewSearchResponse, e
 		inter, err = s.filterTile(ctx, q, exp, idx)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	// Convert the intermediate representation to the list of digests that we
@@ -142,31 +227,12 @@ func (s *SearchAPI) Search(ctx context.Context, q *Query) (*NewSearchResponse, e
 		// Diff stage: Compare all digests found in the previous stages and find
 		// reference points (positive, negative etc.) for each digest.
 		s.getReferenceDiffs(ctx, ret, q.Metric, q.Match, q.RQuery, q.IncludeIgnores, exp, idx)
-		if err != nil {
-			return nil, err
-		}
 
 		// Post-diff stage: Apply all filters that are relevant once we have
 		// diff values for the digests.
 		ret = s.afterDiffResultFilter(ctx, ret, q)
 	}
-
-	// Sort the digests and fill the ones that are going to be displayed with
-	// additional data. Note we are returning all digests found, so we can do
-	// bulk triage, but only the digests that are going to be shown are padded
-	// with additional information.
-	displayRet, offset := s.sortAndLimitDigests(ctx, q, ret, int(q.Offset), int(q.Limit))
-	s.addParamsAndTraces(ctx, displayRet, inter, exp, idx)
-
-	// Return all digests with the selected offset within the result set.
-	searchRet := &NewSearchResponse{
-		Digests: ret,
-		Offset:  offset,
-		Size:    len(displayRet),
-		Commits: idx.GetTile(false).Commits,
-		Issue:   issue,
-	}
-	return searchRet, nil
+	return ret, inter, issue, nil
 }
 
 // Summary returns a high level summary of a Gerrit issue and the tryjobs
@@ -484,6 +550,7 @@ func (s *SearchAPI) afterDiffResultFilter(ctx context.Context, digestInfo []*SRD
 	newDigestInfo := make([]*SRDigest, 0, len(digestInfo))
 	filterRGBADiff := (q.FRGBAMin > 0) || (q.FRGBAMax < 255)
 	filterDiffMax := (q.FDiffMax >= 0)
+	filterDiffMin := (q.FDiffMin > 0)
 	for _, digest := range digestInfo {
 		ref, ok := digest.RefDiffs[digest.ClosestRef]
 
@@ -501,7 +568,12 @@ func (s *SearchAPI) afterDiffResultFilter(ctx context.Context, digestInfo []*SRD
 		}
 
 		// Filter all digests where the diff is below the given threshold.
-		if filterDiffMax && (!ok || (ref.Diffs[q.Metric] > q.FDiffMax)) {
+		if filterDiffMax && (!ok || (ref.MetricValue(q.Metric) > q.FDiffMax)) {
+			continue
+		}
+
+		// Filter all digests where the diff is below the given minimum.
+		if filterDiffMin && (!ok || (ref.MetricValue(q.Metric) < q.FDiffMin)) {
 			continue
 		}
 
@@ -651,8 +723,8 @@ func newSRDigestSlice(metric string, slice []*SRDigest) *srDigestSlice {
 		if j.ClosestRef == "" {
 			return true
 		}
-		iDiff := i.RefDiffs[i.ClosestRef].Diffs[metric]
-		jDiff := j.RefDiffs[j.ClosestRef].Diffs[metric]
+		iDiff := i.RefDiffs[i.ClosestRef].MetricValue(metric)
+		jDiff := j.RefDiffs[j.ClosestRef].MetricValue(metric)
 
 		// If they are the same then sort by digest to make the result stable.
 		if iDiff == jDiff {
@@ -256,6 +256,7 @@ func ParseQuery(r *http.Request, query *Query) error {
 	query.FRGBAMin = int32(validate.Int64FormValue(r, "frgbamin", 0))
 	query.FRGBAMax = int32(validate.Int64FormValue(r, "frgbamax", 255))
 	query.FDiffMax = float32(validate.Float64FormValue(r, "fdiffmax", -1.0))
+	query.FDiffMin = float32(validate.Float64FormValue(r, "fdiffmin", 0))
 
 	// Parse out the issue and patchsets.
 	query.Patchsets = validate.Int64SliceFormValue(r, "patchsets", nil)
@@ -267,6 +268,7 @@ func ParseQuery(r *http.Request, query *Query) error {
 	}
 
 	query.BlameGroupID = r.FormValue("blame")
+	query.BlameAuthor = r.FormValue("blameauthor")
 	query.Pos = r.FormValue("pos") == "true"
 	query.Neg = r.FormValue("neg") == "true"
 	query.Unt = r.FormValue("unt") == "true"
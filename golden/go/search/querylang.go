@@ -0,0 +1,586 @@
+package search
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/types"
+)
+
+// The search query language is a textual alternative to the URL parameters
+// of a search. An expression consists of terms that can be combined with AND,
+// OR, NOT and parentheses. Terms next to each other are combined with AND.
+// For example:
+//
+//     source_type:gm (config:gpu OR cpu_or_gpu:GPU) label:untriaged diff>5%
+//     name:foo,bar NOT config:565 blame:"jane@example.com"
+//
+// A term is either a param match <key>:<value>[,<value>...] or one of the
+// special terms below. Values can be quoted if they contain spaces or
+// parentheses.
+//
+//     label:<label>[,<label>]   Digests with the given labels.
+//     diff<op><value>[%]        Bounds for the diff to the closest reference,
+//                               op is one of >, >=, <, <=. A '%' suffix selects
+//                               the percent metric. Bounds are inclusive.
+//     rgba<op><value>           Bounds for the max RGBA delta to the closest
+//                               reference.
+//     blame:<author>            Untriaged digests blamed on commits of the given
+//                               author, matched as case insensitive substring.
+//     commit:<begin>..<end>     Restrict the search to a commit range. Either
+//                               end can be omitted.
+//
+// The options metric:<metric>, head:<bool>, include:<bool> (include ignored
+// traces) and ref:<bool> (only digests with a reference) apply to the whole
+// query and can therefore not be used within OR or NOT. Labels that are not
+// restricted by the expression are taken from the base query, or all labels
+// are included if the base query selects none.
+//
+// Negated params match all other values of the param in the tile. Since a
+// Query can only express a conjunction of param matches, an expression is
+// compiled into one Query per alternative of its disjunctive normal form.
+// SearchAPI.SearchAny returns the union of their results.
+
+const (
+	// Special terms of the query language.
+	QL_LABEL  = "label"
+	QL_DIFF   = "diff"
+	QL_RGBA   = "rgba"
+	QL_BLAME  = "blame"
+	QL_COMMIT = "commit"
+
+	// Options of the query language.
+	QL_METRIC  = "metric"
+	QL_HEAD    = "head"
+	QL_INCLUDE = "include"
+	QL_REF     = "ref"
+
+	// Keywords of the query language.
+	QL_AND = "AND"
+	QL_OR  = "OR"
+	QL_NOT = "NOT"
+
+	// MAX_QUERY_ALTERNATIVES is the maximum number of queries a query
+	// language expression can be compiled to.
+	MAX_QUERY_ALTERNATIVES = 32
+)
+
+// qlOptions are the terms that apply to the whole query.
+var qlOptions = []string{QL_METRIC, QL_HEAD, QL_INCLUDE, QL_REF}
+
+// qlNode is a node of the syntax tree of a query language expression. It is
+// one of *qlTerm, qlAnd, qlOr or *qlNot.
+type qlNode interface{}
+
+// qlAnd is the conjunction of its children.
+type qlAnd []qlNode
+
+// qlOr is the disjunction of its children.
+type qlOr []qlNode
+
+// qlNot negates its child.
+type qlNot struct {
+	child qlNode
+}
+
+// qlTerm is a single term of a query language expression.
+type qlTerm struct {
+	key string
+
+	// op is one of ':', '>', '>=', '<', '<='.
+	op     string
+	values []string
+
+	// negated is set when the expression is converted into its disjunctive
+	// normal form.
+	negated bool
+}
+
+// qlToken is a token of a query language expression.
+type qlToken struct {
+	// text is the text of the token with quotes removed.
+	text string
+
+	// paren is true if the token is an unquoted parenthesis.
+	paren bool
+}
+
+// CompileQueryLang compiles the given query language expression into
+// queries. The filters of the expression are added to copies of base, which
+// provides the settings that are not part of the expression, e.g. pagination.
+// paramSet is used to negate param matches and should be the ParamSet of the
+// tile. The returned slice is empty if the expression can never match.
+func CompileQueryLang(expr string, base *Query, paramSet paramtools.ParamSet) ([]*Query, error) {
+	root, err := parseQueryLang(expr)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkQLOptions(root, false); err != nil {
+		return nil, err
+	}
+	alternatives, err := toDNF(root, false)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Query, 0, len(alternatives))
+	for _, terms := range alternatives {
+		q, ok, err := compileTerms(terms, base, paramSet)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, q)
+		}
+	}
+	return ret, nil
+}
+
+// parseQueryLang parses the given expression into its syntax tree.
+func parseQueryLang(expr string) (qlNode, error) {
+	tokens, err := tokenizeQueryLang(expr)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("Empty query.")
+	}
+
+	p := &qlParser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if !p.done() {
+		return nil, fmt.Errorf("Unexpected %q at position %d.", p.tokens[p.pos].text, p.pos)
+	}
+	return root, nil
+}
+
+// tokenizeQueryLang splits the given expression into tokens.
+func tokenizeQueryLang(expr string) ([]qlToken, error) {
+	ret := []qlToken{}
+	runes := []rune(expr)
+	for i := 0; i < len(runes); {
+		switch r := runes[i]; {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')':
+			ret = append(ret, qlToken{text: string(r), paren: true})
+			i++
+		default:
+			var buf bytes.Buffer
+			for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '(' && runes[i] != ')' {
+				if runes[i] == '"' {
+					end := strings.IndexRune(string(runes[i+1:]), '"')
+					if end < 0 {
+						return nil, fmt.Errorf("Unterminated quote in %q.", expr)
+					}
+					quoted := []rune(string(runes[i+1:])[:end])
+					buf.WriteString(string(quoted))
+					i += len(quoted) + 2
+					continue
+				}
+				buf.WriteRune(runes[i])
+				i++
+			}
+			ret = append(ret, qlToken{text: buf.String()})
+		}
+	}
+	return ret, nil
+}
+
+// qlParser is a recursive descent parser for query language expressions.
+type qlParser struct {
+	tokens []qlToken
+	pos    int
+}
+
+// done returns true if all tokens have been consumed.
+func (p *qlParser) done() bool {
+	return p.pos >= len(p.tokens)
+}
+
+// peekKeyword returns true if the next token is the given keyword.
+func (p *qlParser) peekKeyword(keyword string) bool {
+	return !p.done() && !p.tokens[p.pos].paren && p.tokens[p.pos].text == keyword
+}
+
+// peekParen returns true if the next token is the given parenthesis.
+func (p *qlParser) peekParen(paren string) bool {
+	return !p.done() && p.tokens[p.pos].paren && p.tokens[p.pos].text == paren
+}
+
+// parseOr parses: and (OR and)*
+func (p *qlParser) parseOr() (qlNode, error) {
+	children := qlOr{}
+	for {
+		child, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if !p.peekKeyword(QL_OR) {
+			break
+		}
+		p.pos++
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return children, nil
+}
+
+// parseAnd parses: unary ([AND] unary)*
+func (p *qlParser) parseAnd() (qlNode, error) {
+	children := qlAnd{}
+	for {
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		children = append(children, child)
+		if p.peekKeyword(QL_AND) {
+			p.pos++
+			continue
+		}
+		if p.done() || p.peekKeyword(QL_OR) || p.peekParen(")") {
+			break
+		}
+	}
+	if len(children) == 1 {
+		return children[0], nil
+	}
+	return children, nil
+}
+
+// parseUnary parses: NOT unary | '(' or ')' | term
+func (p *qlParser) parseUnary() (qlNode, error) {
+	if p.done() {
+		return nil, fmt.Errorf("Unexpected end of query.")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+	if tok.paren {
+		if tok.text != "(" {
+			return nil, fmt.Errorf("Unexpected ')' at position %d.", p.pos-1)
+		}
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peekParen(")") {
+			return nil, fmt.Errorf("Missing ')'.")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	switch tok.text {
+	case QL_NOT:
+		child, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &qlNot{child: child}, nil
+	case QL_AND, QL_OR:
+		return nil, fmt.Errorf("Unexpected %s at position %d.", tok.text, p.pos-1)
+	}
+	return parseQLTerm(tok.text)
+}
+
+// parseQLTerm parses a single term of the form <key><op><value>.
+func parseQLTerm(text string) (*qlTerm, error) {
+	idx := strings.IndexAny(text, ":=<>")
+	if idx <= 0 {
+		return nil, fmt.Errorf("Invalid term %q. Expected <key>:<value>.", text)
+	}
+	key, rest := text[:idx], text[idx:]
+	op := rest[:1]
+	if (op == "<" || op == ">") && strings.HasPrefix(rest[1:], "=") {
+		op = rest[:2]
+	}
+	value := rest[len(op):]
+	if op == "=" {
+		op = ":"
+	}
+	if value == "" {
+		return nil, fmt.Errorf("Missing value in term %q.", text)
+	}
+
+	ret := &qlTerm{key: key, op: op, values: []string{value}}
+	if op == ":" {
+		ret.values = strings.Split(value, ",")
+	}
+	return ret, nil
+}
+
+// checkQLOptions returns an error if an option is used within OR or NOT.
+func checkQLOptions(node qlNode, nested bool) error {
+	switch n := node.(type) {
+	case *qlTerm:
+		if nested && util.In(n.key, qlOptions) {
+			return fmt.Errorf("Option %q applies to the whole query and cannot be used with OR or NOT.", n.key)
+		}
+	case qlAnd:
+		for _, child := range n {
+			if err := checkQLOptions(child, nested); err != nil {
+				return err
+			}
+		}
+	case qlOr:
+		for _, child := range n {
+			if err := checkQLOptions(child, true); err != nil {
+				return err
+			}
+		}
+	case *qlNot:
+		return checkQLOptions(n.child, true)
+	}
+	return nil
+}
+
+// toDNF converts the given syntax tree into its disjunctive normal form, i.e.
+// a list of alternatives, each of which is a conjunction of terms.
+// NOT is pushed down to the terms by De Morgan's laws.
+func toDNF(node qlNode, negated bool) ([][]*qlTerm, error) {
+	var children []qlNode
+	conjunction := false
+	switch n := node.(type) {
+	case *qlTerm:
+		term := *n
+		term.negated = negated
+		return [][]*qlTerm{{&term}}, nil
+	case *qlNot:
+		return toDNF(n.child, !negated)
+	case qlAnd:
+		children, conjunction = n, !negated
+	case qlOr:
+		children, conjunction = n, negated
+	default:
+		return nil, fmt.Errorf("Unknown node type %T", node)
+	}
+
+	var ret [][]*qlTerm
+	if conjunction {
+		ret = [][]*qlTerm{{}}
+	}
+	for _, child := range children {
+		childDNF, err := toDNF(child, negated)
+		if err != nil {
+			return nil, err
+		}
+		if conjunction {
+			// Distribute the conjunction over the alternatives of the child.
+			product := make([][]*qlTerm, 0, len(ret)*len(childDNF))
+			for _, left := range ret {
+				for _, right := range childDNF {
+					terms := make([]*qlTerm, 0, len(left)+len(right))
+					product = append(product, append(append(terms, left...), right...))
+				}
+			}
+			ret = product
+		} else {
+			ret = append(ret, childDNF...)
+		}
+		if len(ret) > MAX_QUERY_ALTERNATIVES {
+			return nil, fmt.Errorf("Query is too complex. It has more than %d alternatives.", MAX_QUERY_ALTERNATIVES)
+		}
+	}
+	return ret, nil
+}
+
+// compileTerms returns a copy of base with the filters of the given
+// conjunction of terms. The second return value is false if the terms can
+// never match.
+func compileTerms(terms []*qlTerm, base *Query, paramSet paramtools.ParamSet) (*Query, bool, error) {
+	q := *base
+	q.Query = make(url.Values, len(base.Query))
+	for k, v := range base.Query {
+		q.Query[k] = append([]string{}, v...)
+	}
+
+	// The options are applied first, since the percent suffix of the diff
+	// term depends on the metric.
+	metricSet := false
+	for _, t := range terms {
+		if !util.In(t.key, qlOptions) {
+			continue
+		}
+		if t.op != ":" || len(t.values) != 1 {
+			return nil, false, fmt.Errorf("Option %q requires a single value.", t.key)
+		}
+		val := t.values[0]
+		if t.key == QL_METRIC {
+			if !util.In(val, diff.GetDiffMetricIDs()) {
+				return nil, false, fmt.Errorf("Unknown metric %q.", val)
+			}
+			q.Metric = val
+			metricSet = true
+			continue
+		}
+
+		b, err := strconv.ParseBool(val)
+		if err != nil {
+			return nil, false, fmt.Errorf("Option %q requires true or false: %s", t.key, err)
+		}
+		switch t.key {
+		case QL_HEAD:
+			q.Head = b
+		case QL_INCLUDE:
+			q.IncludeIgnores = b
+		case QL_REF:
+			q.FRef = b
+		}
+	}
+
+	var labels util.StringSet
+	for _, t := range terms {
+		if util.In(t.key, qlOptions) {
+			continue
+		}
+
+		switch t.key {
+		case QL_LABEL:
+			if t.op != ":" {
+				return nil, false, fmt.Errorf("Term %q only supports ':'.", t.key)
+			}
+			for _, l := range t.values {
+				if !types.ValidLabel(l) {
+					return nil, false, fmt.Errorf("Unknown label %q.", l)
+				}
+			}
+			termLabels := util.NewStringSet(t.values)
+			if t.negated {
+				termLabels = util.NewStringSet([]string{types.POSITIVE.String(), types.NEGATIVE.String(), types.UNTRIAGED.String()}).Complement(termLabels)
+			}
+			if labels == nil {
+				labels = termLabels
+			} else {
+				labels = labels.Intersect(termLabels)
+			}
+
+		case QL_DIFF:
+			op, err := qlComparison(t)
+			if err != nil {
+				return nil, false, err
+			}
+			valStr := t.values[0]
+			if strings.HasSuffix(valStr, "%") {
+				if metricSet && q.Metric != diff.METRIC_PERCENT {
+					return nil, false, fmt.Errorf("A diff in percent requires the metric %q.", diff.METRIC_PERCENT)
+				}
+				q.Metric = diff.METRIC_PERCENT
+				valStr = strings.TrimSuffix(valStr, "%")
+			}
+			val, err := strconv.ParseFloat(valStr, 32)
+			if err != nil || val < 0 {
+				return nil, false, fmt.Errorf("Invalid diff %q.", t.values[0])
+			}
+			if op == ">" {
+				if float32(val) > q.FDiffMin {
+					q.FDiffMin = float32(val)
+				}
+			} else if q.FDiffMax < 0 || float32(val) < q.FDiffMax {
+				q.FDiffMax = float32(val)
+			}
+
+		case QL_RGBA:
+			op, err := qlComparison(t)
+			if err != nil {
+				return nil, false, err
+			}
+			val, err := strconv.Atoi(t.values[0])
+			if err != nil || val < 0 || val > 255 {
+				return nil, false, fmt.Errorf("Invalid RGBA delta %q. Must be in [0, 255].", t.values[0])
+			}
+			if op == ">" {
+				q.FRGBAMin = util.MaxInt32(q.FRGBAMin, int32(val))
+			} else {
+				q.FRGBAMax = util.MinInt32(q.FRGBAMax, int32(val))
+			}
+
+		case QL_BLAME:
+			if t.op != ":" || len(t.values) != 1 || t.negated {
+				return nil, false, fmt.Errorf("Term %q requires a single author and cannot be negated.", t.key)
+			}
+			if q.BlameAuthor != "" && q.BlameAuthor != t.values[0] {
+				return nil, false, fmt.Errorf("Only one blame author can be searched at a time.")
+			}
+			q.BlameAuthor = t.values[0]
+
+		case QL_COMMIT:
+			if t.op != ":" || len(t.values) != 1 || t.negated {
+				return nil, false, fmt.Errorf("Term %q requires a single commit range and cannot be negated.", t.key)
+			}
+			parts := strings.Split(t.values[0], "..")
+			if len(parts) != 2 {
+				return nil, false, fmt.Errorf("Invalid commit range %q. Expected <begin>..<end>.", t.values[0])
+			}
+			if (q.FCommitBegin != "" || q.FCommitEnd != "") && (q.FCommitBegin != parts[0] || q.FCommitEnd != parts[1]) {
+				return nil, false, fmt.Errorf("Only one commit range can be searched at a time.")
+			}
+			q.FCommitBegin, q.FCommitEnd = parts[0], parts[1]
+
+		default:
+			if t.op != ":" {
+				return nil, false, fmt.Errorf("Param %q only supports ':'.", t.key)
+			}
+			values := util.NewStringSet(t.values)
+			if t.negated {
+				values = util.NewStringSet(paramSet[t.key]).Complement(values)
+			}
+			if existing, ok := q.Query[t.key]; ok {
+				values = values.Intersect(util.NewStringSet(existing))
+			}
+			if len(values) == 0 {
+				return nil, false, nil
+			}
+			q.Query[t.key] = values.Keys()
+			sort.Strings(q.Query[t.key])
+		}
+	}
+
+	if labels != nil {
+		if len(labels) == 0 {
+			return nil, false, nil
+		}
+		q.Pos = labels[types.POSITIVE.String()]
+		q.Neg = labels[types.NEGATIVE.String()]
+		q.Unt = labels[types.UNTRIAGED.String()]
+	} else if !q.Pos && !q.Neg && !q.Unt {
+		// If neither the expression nor base select labels, all are included.
+		q.Pos, q.Neg, q.Unt = true, true, true
+	}
+
+	// Check whether the bounds exclude each other.
+	if (q.FDiffMax >= 0 && q.FDiffMin > q.FDiffMax) || (q.FRGBAMin > q.FRGBAMax) {
+		return nil, false, nil
+	}
+	q.QueryStr = q.Query.Encode()
+	return &q, true, nil
+}
+
+// qlComparison returns ">" if the given comparison term is a lower bound and
+// "<" if it is an upper bound, taking negation into account.
+func qlComparison(t *qlTerm) (string, error) {
+	if t.op == ":" {
+		return "", fmt.Errorf("Term %q requires one of >, >=, <, <=.", t.key)
+	}
+	lower := strings.HasPrefix(t.op, ">")
+	if t.negated {
+		lower = !lower
+	}
+	if lower {
+		return ">", nil
+	}
+	return "<", nil
+}
@@ -0,0 +1,166 @@
+package search
+
+import (
+	"net/url"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/paramtools"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/golden/go/diff"
+)
+
+var qlParamSet = paramtools.ParamSet{
+	"source_type": []string{"gm", "image"},
+	"config":      []string{"565", "8888", "gpu"},
+	"cpu_or_gpu":  []string{"CPU", "GPU"},
+}
+
+// qlBase returns the query the query language expressions are compiled on
+// top of in the tests.
+func qlBase() *Query {
+	return &Query{
+		Query:    url.Values{},
+		Metric:   diff.METRIC_COMBINED,
+		FDiffMax: -1,
+		FRGBAMax: 255,
+		Limit:    50,
+	}
+}
+
+// mustCompile compiles the given expression on top of qlBase.
+func mustCompile(t *testing.T, expr string) []*Query {
+	queries, err := CompileQueryLang(expr, qlBase(), qlParamSet)
+	assert.NoError(t, err)
+	return queries
+}
+
+func TestParseQueryLang(t *testing.T) {
+	testutils.SmallTest(t)
+
+	term := func(key, op string, values ...string) *qlTerm {
+		return &qlTerm{key: key, op: op, values: values}
+	}
+
+	root, err := parseQueryLang(`source_type:gm (config:gpu OR cpu_or_gpu=GPU) AND NOT name:"a b",c diff>=5%`)
+	assert.NoError(t, err)
+	assert.Equal(t, qlAnd{
+		term("source_type", ":", "gm"),
+		qlOr{term("config", ":", "gpu"), term("cpu_or_gpu", ":", "GPU")},
+		&qlNot{child: term("name", ":", "a b", "c")},
+		term("diff", ">=", "5%"),
+	}, root)
+
+	// AND binds stronger than OR.
+	root, err = parseQueryLang("a:1 OR b:2 c:3")
+	assert.NoError(t, err)
+	assert.Equal(t, qlOr{term("a", ":", "1"), qlAnd{term("b", ":", "2"), term("c", ":", "3")}}, root)
+
+	for _, expr := range []string{
+		"",
+		"   ",
+		"config",
+		":gpu",
+		"config:",
+		"(config:gpu",
+		"config:gpu)",
+		"config:gpu OR",
+		"AND config:gpu",
+		"NOT",
+		`name:"unterminated`,
+	} {
+		_, err := parseQueryLang(expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestCompileQueryLang(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// A single conjunction results in a single query. Without a label term
+	// all labels are included.
+	queries := mustCompile(t, "source_type:gm config:gpu,8888")
+	assert.Len(t, queries, 1)
+	q := queries[0]
+	assert.Equal(t, url.Values{"source_type": {"gm"}, "config": {"8888", "gpu"}}, q.Query)
+	assert.Equal(t, q.Query.Encode(), q.QueryStr)
+	assert.True(t, q.Pos && q.Neg && q.Unt)
+	assert.Equal(t, 50, q.Limit)
+
+	// OR across different keys results in one query per alternative.
+	queries = mustCompile(t, "source_type:gm (config:gpu OR cpu_or_gpu:GPU) label:untriaged diff>5%")
+	assert.Len(t, queries, 2)
+	assert.Equal(t, url.Values{"source_type": {"gm"}, "config": {"gpu"}}, queries[0].Query)
+	assert.Equal(t, url.Values{"source_type": {"gm"}, "cpu_or_gpu": {"GPU"}}, queries[1].Query)
+	for _, q := range queries {
+		assert.True(t, q.Unt)
+		assert.False(t, q.Pos || q.Neg)
+		assert.Equal(t, diff.METRIC_PERCENT, q.Metric)
+		assert.Equal(t, float32(5), q.FDiffMin)
+		assert.Equal(t, float32(-1), q.FDiffMax)
+	}
+
+	// Negated params match the other values in the param set.
+	queries = mustCompile(t, "NOT config:565 NOT label:positive")
+	assert.Len(t, queries, 1)
+	assert.Equal(t, url.Values{"config": {"8888", "gpu"}}, queries[0].Query)
+	assert.True(t, queries[0].Neg && queries[0].Unt)
+	assert.False(t, queries[0].Pos)
+
+	// De Morgan: NOT (a AND b) is (NOT a) OR (NOT b).
+	queries = mustCompile(t, "NOT (config:565 cpu_or_gpu:CPU)")
+	assert.Len(t, queries, 2)
+	assert.Equal(t, url.Values{"config": {"8888", "gpu"}}, queries[0].Query)
+	assert.Equal(t, url.Values{"cpu_or_gpu": {"GPU"}}, queries[1].Query)
+
+	// Bounds, blame, commit range and options.
+	queries = mustCompile(t, "metric:pixel diff<=100 NOT diff<10 rgba>=20 rgba<200 blame:jane commit:abc.. head:false ref:true include:true")
+	assert.Len(t, queries, 1)
+	q = queries[0]
+	assert.Equal(t, diff.METRIC_PIXEL, q.Metric)
+	assert.Equal(t, float32(10), q.FDiffMin)
+	assert.Equal(t, float32(100), q.FDiffMax)
+	assert.Equal(t, int32(20), q.FRGBAMin)
+	assert.Equal(t, int32(200), q.FRGBAMax)
+	assert.Equal(t, "jane", q.BlameAuthor)
+	assert.Equal(t, "abc", q.FCommitBegin)
+	assert.Equal(t, "", q.FCommitEnd)
+	assert.False(t, q.Head)
+	assert.True(t, q.FRef && q.IncludeIgnores)
+
+	// Alternatives that can never match are dropped.
+	assert.Len(t, mustCompile(t, "config:565 config:gpu"), 0)
+	assert.Len(t, mustCompile(t, "(config:565 config:gpu) OR config:8888"), 1)
+	assert.Len(t, mustCompile(t, "label:positive label:negative"), 0)
+	assert.Len(t, mustCompile(t, "diff>10 diff<5"), 0)
+
+	// The base query is not modified.
+	base := qlBase()
+	base.Query.Set("source_type", "gm")
+	queries, err := CompileQueryLang("source_type:gm,image config:gpu", base, qlParamSet)
+	assert.NoError(t, err)
+	assert.Equal(t, url.Values{"source_type": {"gm"}, "config": {"gpu"}}, queries[0].Query)
+	assert.Equal(t, url.Values{"source_type": {"gm"}}, base.Query)
+
+	for _, expr := range []string{
+		"config>gpu",
+		"label:unknown",
+		"label>1",
+		"diff:5",
+		"diff>abc",
+		"rgba>256",
+		"metric:unknown",
+		"metric:pixel diff>5%",
+		"head:maybe",
+		"config:gpu OR head:true",
+		"NOT ref:true",
+		"NOT blame:jane",
+		"blame:jane blame:joe",
+		"commit:abc",
+		"a:1 OR b:1 OR c:1 OR d:1 OR e:1 OR f:1 OR g:1 OR h:1 OR i:1 OR j:1 OR k:1 OR l:1 OR m:1 OR n:1 OR o:1 OR p:1 OR " +
+			"q:1 OR r:1 OR s:1 OR t:1 OR u:1 OR v:1 OR w:1 OR x:1 OR y:1 OR z:1 OR aa:1 OR bb:1 OR cc:1 OR dd:1 OR ee:1 OR ff:1 OR gg:1",
+	} {
+		_, err := CompileQueryLang(expr, qlBase(), qlParamSet)
+		assert.Error(t, err, expr)
+	}
+}
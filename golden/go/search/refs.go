@@ -76,9 +76,9 @@ func (r *RefDiffer) GetRefDiffs(metric string, match []string, test, digest stri
 			val.N = tally[val.Digest]
 
 			// Find the minimum.
-			if val.DiffMetrics.Diffs[metric] < minDiff {
+			if val.DiffMetrics.MetricValue(metric) < minDiff {
 				minKey = key
-				minDiff = val.DiffMetrics.Diffs[metric]
+				minDiff = val.DiffMetrics.MetricValue(metric)
 			}
 		}
 	}
@@ -122,8 +122,8 @@ func (r *RefDiffer) getClosestDiff(metric, digest string, compDigests []string)
 	minDigest := ""
 	for resultDigest, diffInfo := range diffs {
 		diffMetrics := diffInfo.(*diff.DiffMetrics)
-		if diffMetrics.Diffs[metric] < minDiff {
-			minDiff = diffMetrics.Diffs[metric]
+		if diffMetrics.MetricValue(metric) < minDiff {
+			minDiff = diffMetrics.MetricValue(metric)
 			minDigest = resultDigest
 		}
 	}
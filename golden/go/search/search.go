@@ -114,6 +114,7 @@ type Query struct {
 
 	// Blaming
 	BlameGroupID string `json:"blame"`
+	BlameAuthor  string `json:"blameauthor"` // Substring of the author of a blamed commit.
 
 	// Image classification
 	Pos            bool `json:"pos"`
@@ -142,6 +143,7 @@ type Query struct {
 	FRGBAMin     int32   `json:"frgbamin"`   // Min RGBA delta
 	FRGBAMax     int32   `json:"frgbamax"`   // Max RGBA delta
 	FDiffMax     float32 `json:"fdiffmax"`   // Max diff according to metric
+	FDiffMin     float32 `json:"fdiffmin"`   // Min diff according to metric
 	FGroupTest   string  `json:"fgrouptest"` // Op within grouped by test.
 	FRef         bool    `json:"fref"`       // Only digests with reference.
 
@@ -204,6 +206,19 @@ func blameGroupID(b *blame.BlameDistribution, commits []*tiling.Commit) string {
 	return strings.Join(ret, ":")
 }
 
+// blamedOnAuthor returns true if one of the commits in the blame distribution
+// was authored by the given author, which is matched as a case insensitive
+// substring of the commit author.
+func blamedOnAuthor(b *blame.BlameDistribution, commits []*tiling.Commit, author string) bool {
+	author = strings.ToLower(author)
+	for _, index := range b.Freq {
+		if strings.Contains(strings.ToLower(commits[index].Author), author) {
+			return true
+		}
+	}
+	return false
+}
+
 // digestsFromTrace returns all the digests in the given trace, controlled by
 // 'head', and being robust to tallies not having been calculated for the
 // trace.
@@ -615,7 +630,7 @@ func sortAndLimitRows(rows *[]*CTRow, rowDigests map[string]paramtools.ParamSet,
 		lessFn = func(c *ctRowSlice, i, j int) bool { return c.data[i].N < c.data[j].N }
 	} else {
 		lessFn = func(c *ctRowSlice, i, j int) bool {
-			return (len(c.data[i].Values) > 0) && (len(c.data[j].Values) > 0) && (c.data[i].Values[0].Diffs[diffMetric] < c.data[j].Values[0].Diffs[diffMetric])
+			return (len(c.data[i].Values) > 0) && (len(c.data[j].Values) > 0) && (c.data[i].Values[0].MetricValue(diffMetric) < c.data[j].Values[0].MetricValue(diffMetric))
 		}
 	}
 
@@ -677,7 +692,7 @@ func getDiffs(diffStore diff.DiffStore, digest string, colDigests []string, sort
 	// TODO(stephana): Add the reference points for each row.
 
 	lessFn := func(c *ctDiffMetricsSlice, i, j int) bool {
-		return c.data[i].Diffs[diffMetric] < c.data[j].Diffs[diffMetric]
+		return c.data[i].MetricValue(diffMetric) < c.data[j].MetricValue(diffMetric)
 	}
 	sortSlice := sort.Interface(newCTDiffMetricsSlice(ret, lessFn))
 	if sortDir == SORT_DESC {
@@ -69,6 +69,21 @@ func (sm srInterMap) add(test, digest, traceID string, trace *types.GoldenTrace,
 	}
 }
 
+// merge adds the traces and params of the given digests in other to the
+// srInterMap instance.
+func (sm srInterMap) merge(other srInterMap, digests []*SRDigest) {
+	for _, d := range digests {
+		entry, ok := other[d.Test][d.Digest]
+		if !ok {
+			continue
+		}
+		for traceID, trace := range entry.traces {
+			sm.add(d.Test, d.Digest, traceID, trace, nil)
+		}
+		sm.add(d.Test, d.Digest, "", nil, entry.params)
+	}
+}
+
 // digests is mostly used for debugging and returns all digests contained in
 // a collection of intermediate search results.
 func (sm srInterMap) numDigests() int {
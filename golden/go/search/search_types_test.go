@@ -31,3 +31,22 @@ func TestIntermediate(t *testing.T) {
 		},
 	}}, srMap)
 }
+
+func TestIntermediateMerge(t *testing.T) {
+	testutils.SmallTest(t)
+
+	digest02 := "ijklmno"
+	params02 := map[string][]string{"param-01": []string{"val-03"}}
+
+	srMap := srInterMap{}
+	srMap.add(TEST_1, DIGEST_01, "", nil, PARAMS_01)
+	other := srInterMap{}
+	other.add(TEST_1, DIGEST_01, "", nil, params02)
+	other.add(TEST_1, digest02, "", nil, params02)
+
+	// Only the given digests are merged.
+	srMap.merge(other, []*SRDigest{{Test: TEST_1, Digest: DIGEST_01}})
+	assert.Equal(t, 1, srMap.numDigests())
+	assert.Equal(t, []string{"val-01", "val-03"}, []string(srMap[TEST_1][DIGEST_01].params["param-01"]))
+	assert.Equal(t, []string{"val-02"}, []string(srMap[TEST_1][DIGEST_01].params["param-02"]))
+}
@@ -23,7 +23,9 @@ import (
 	"go.skia.org/infra/golden/go/diff"
 	"go.skia.org/infra/golden/go/digeststore"
 	"go.skia.org/infra/golden/go/expstorage"
+	"go.skia.org/infra/golden/go/fuzzy"
 	"go.skia.org/infra/golden/go/ignore"
+	"go.skia.org/infra/golden/go/savedsearch"
 	"go.skia.org/infra/golden/go/tally"
 	"go.skia.org/infra/golden/go/tryjobs"
 	"go.skia.org/infra/golden/go/tryjobstore"
@@ -45,6 +47,11 @@ type Storage struct {
 	GStorageClient    *GStorageClient
 	Git               *gitinfo.GitInfo
 	WhiteListQuery    paramtools.ParamSet
+	SavedSearchStore  savedsearch.SavedSearchStore
+
+	// FuzzyMatcher automatically labels untriaged digests that are nearly
+	// identical to positive digests. If nil no digests are auto-triaged.
+	FuzzyMatcher *fuzzy.Matcher
 
 	// NCommits is the number of commits we should consider. If NCommits is
 	// 0 or smaller all commits in the last tile will be considered.
@@ -56,6 +63,10 @@ type Storage struct {
 	lastIgnoreRev          int64
 	lastIgnoreRules        paramtools.ParamMatcher
 	mutex                  sync.Mutex
+
+	// Internal variables used to cache the expectations history.
+	historyCache     *expstorage.HistoryCache
+	historyCacheOnce sync.Once
 }
 
 // CanWriteBaseline returns true if this instance was configured to write baseline files.
@@ -93,6 +104,34 @@ func (s *Storage) getMasterBaseline(tile *tiling.Tile) (*expstorage.Expectations
 	return exps, baseline.GetBaselineForMaster(exps, tile), nil
 }
 
+// GetBaselineForCommit returns the master baseline as it was at the given
+// commit in the tile, based on the expectations at the time of the commit.
+func (s *Storage) GetBaselineForCommit(tile *tiling.Tile, commitHash string) (*baseline.CommitableBaseLine, error) {
+	idx, commit := tiling.FindCommit(tile.Commits, commitHash)
+	if commit == nil {
+		return nil, fmt.Errorf("Commit %s is not in the current tile.", commitHash)
+	}
+
+	history, err := s.GetExpectationsHistory()
+	if err != nil {
+		return nil, sklog.FmtErrorf("Unable to retrieve expectations history: %s", err)
+	}
+
+	// Commit times are in seconds, triage log times in milliseconds.
+	exps := history.AtTime(commit.CommitTime * 1000)
+	return baseline.GetBaselineForCommit(exps, tile, idx), nil
+}
+
+// GetExpectationsHistory returns the history of the master expectations. The
+// history is cached and only the changes added since the last call are
+// retrieved from the expectations store.
+func (s *Storage) GetExpectationsHistory() (*expstorage.History, error) {
+	s.historyCacheOnce.Do(func() {
+		s.historyCache = expstorage.NewHistoryCache(s.ExpectationsStore)
+	})
+	return s.historyCache.Get()
+}
+
 // PushIssueBaseline writes the baseline for a Gerrit issue to GCS.
 func (s *Storage) PushIssueBaseline(issueID int64, tile *tiling.Tile, tallies *tally.Tallies) error {
 	if !s.CanWriteBaseline() {
@@ -26,9 +26,11 @@ import (
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/golden/go/blame"
 	"go.skia.org/infra/golden/go/diff"
+	"go.skia.org/infra/golden/go/digestcluster"
 	"go.skia.org/infra/golden/go/expstorage"
 	"go.skia.org/infra/golden/go/ignore"
 	"go.skia.org/infra/golden/go/indexer"
+	"go.skia.org/infra/golden/go/savedsearch"
 	"go.skia.org/infra/golden/go/search"
 	"go.skia.org/infra/golden/go/summary"
 	"go.skia.org/infra/golden/go/types"
@@ -42,6 +44,9 @@ const (
 
 	// BASELINE_ISSUE_ROUTE serves the baseline for the Gerrit CL identified by 'id'
 	BASELINE_ISSUE_ROUTE = "/json/baseline/{id}"
+
+	// BASELINE_COMMIT_ROUTE serves the master baseline at the commit identified by 'hash'
+	BASELINE_COMMIT_ROUTE = "/json/baseline/commit/{hash}"
 )
 
 const (
@@ -50,6 +55,16 @@ const (
 
 	// MAX_PAGE_SIZE is the maximum page size used for pagination.
 	MAX_PAGE_SIZE = 100
+
+	// MAX_CLUSTER_DIGESTS is the maximum number of untriaged digests of a
+	// test that are clustered. Clustering requires the diffs between all
+	// pairs of digests at PRIORITY_NOW, so only the most frequent digests
+	// are selected.
+	MAX_CLUSTER_DIGESTS = 50
+
+	// MAX_IMPORT_SIZE is the maximum size in bytes of an expectations export
+	// that is accepted by JsonExpectationsImportHandler.
+	MAX_IMPORT_SIZE = 256 * 1024 * 1024
 )
 
 // WebHandlers holds the environment needed by the various http hander functions
@@ -280,13 +295,15 @@ func (wh *WebHandlers) JsonTryjobSummaryHandler(w http.ResponseWriter, r *http.R
 }
 
 // JsonSearchHandler is the endpoint for all searches.
+// Besides the URL parameters parsed by search.ParseQuery it accepts a search
+// query language expression in 'q' or the name of a saved search in 'saved'.
 func (wh *WebHandlers) JsonSearchHandler(w http.ResponseWriter, r *http.Request) {
-	query, ok := parseSearchQuery(w, r)
+	queries, ok := wh.parseSearchQueries(w, r)
 	if !ok {
 		return
 	}
 
-	searchResponse, err := wh.SearchAPI.Search(r.Context(), query)
+	searchResponse, err := wh.SearchAPI.SearchAny(r.Context(), queries)
 	if err != nil {
 		httputils.ReportError(w, r, err, "Search for digests failed.")
 		return
@@ -299,22 +316,24 @@ func (wh *WebHandlers) JsonSearchHandler(w http.ResponseWriter, r *http.Request)
 func (wh *WebHandlers) JsonExportHandler(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	query, ok := parseSearchQuery(w, r)
+	queries, ok := wh.parseSearchQueries(w, r)
 	if !ok {
 		return
 	}
 
-	if (query.Issue > 0) || (query.BlameGroupID != "") {
-		msg := "Search query cannot contain blame or issue information."
-		httputils.ReportError(w, r, errors.New(msg), msg)
-		return
-	}
+	for _, query := range queries {
+		if (query.Issue > 0) || (query.BlameGroupID != "") || (query.BlameAuthor != "") {
+			msg := "Search query cannot contain blame or issue information."
+			httputils.ReportError(w, r, errors.New(msg), msg)
+			return
+		}
 
-	// Mark the query to avoid expensive diffs.
-	query.NoDiff = true
+		// Mark the query to avoid expensive diffs.
+		query.NoDiff = true
+	}
 
 	// Execute the search
-	searchResponse, err := wh.SearchAPI.Search(ctx, query)
+	searchResponse, err := wh.SearchAPI.SearchAny(ctx, queries)
 	if err != nil {
 		httputils.ReportError(w, r, err, "Search for digests failed.")
 		return
@@ -350,6 +369,110 @@ func parseSearchQuery(w http.ResponseWriter, r *http.Request) (*search.Query, bo
 	return &query, true
 }
 
+// parseSearchQueries parses the search parameters of the request. If the
+// request contains a query language expression in 'q' or the name of a saved
+// search in 'saved', the expression is compiled on top of the other
+// parameters and can result in multiple queries, see search.CompileQueryLang.
+func (wh *WebHandlers) parseSearchQueries(w http.ResponseWriter, r *http.Request) ([]*search.Query, bool) {
+	query, ok := parseSearchQuery(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	expr := r.FormValue("q")
+	if name := r.FormValue("saved"); name != "" {
+		saved, err := wh.Storages.SavedSearchStore.Get(name)
+		if err != nil {
+			httputils.ReportError(w, r, err, "Failed to retrieve saved search.")
+			return nil, false
+		}
+		if saved == nil {
+			httputils.ReportError(w, r, fmt.Errorf("Saved search %q does not exist.", name), "Unknown saved search.")
+			return nil, false
+		}
+		expr = saved.Query
+	}
+	if expr == "" {
+		return []*search.Query{query}, true
+	}
+
+	queries, err := search.CompileQueryLang(expr, query, wh.Indexer.GetIndex().GetTile(true).ParamSet)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid search query.")
+		return nil, false
+	}
+	return queries, true
+}
+
+// JsonSavedSearchesHandler returns all saved searches.
+func (wh *WebHandlers) JsonSavedSearchesHandler(w http.ResponseWriter, r *http.Request) {
+	searches, err := wh.Storages.SavedSearchStore.List()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve saved searches.")
+		return
+	}
+	sendJsonResponse(w, searches)
+}
+
+// SavedSearchRequest is the request to save a search.
+type SavedSearchRequest struct {
+	Name        string `json:"name"`
+	Query       string `json:"query"`
+	Description string `json:"description"`
+}
+
+// JsonSavedSearchSaveHandler adds a saved search or replaces the saved search
+// with the same name. It returns all saved searches.
+func (wh *WebHandlers) JsonSavedSearchSaveHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to save a search.")
+		return
+	}
+	req := &SavedSearchRequest{}
+	if err := parseJson(r, req); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse submitted data.")
+		return
+	}
+
+	// Make sure the query is valid before it is shared.
+	if _, err := search.CompileQueryLang(req.Query, &search.Query{}, wh.Indexer.GetIndex().GetTile(true).ParamSet); err != nil {
+		httputils.ReportError(w, r, err, "Invalid search query.")
+		return
+	}
+
+	savedSearch := &savedsearch.SavedSearch{
+		Name:        req.Name,
+		Query:       req.Query,
+		Description: req.Description,
+		UpdatedBy:   user,
+		Updated:     time.Now(),
+	}
+	if err := wh.Storages.SavedSearchStore.Put(savedSearch); err != nil {
+		httputils.ReportError(w, r, err, "Failed to save search.")
+		return
+	}
+	wh.JsonSavedSearchesHandler(w, r)
+}
+
+// JsonSavedSearchDeleteHandler deletes the saved search identified by 'name'.
+// It returns the remaining saved searches.
+func (wh *WebHandlers) JsonSavedSearchDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to delete a saved search.")
+		return
+	}
+
+	name := mux.Vars(r)["name"]
+	if err := wh.Storages.SavedSearchStore.Delete(name); err != nil {
+		httputils.ReportError(w, r, err, "Failed to delete saved search.")
+		return
+	}
+	sklog.Infof("%s deleted saved search %s", user, name)
+	wh.JsonSavedSearchesHandler(w, r)
+}
+
 // JsonDetailsHandler returns the details about a single digest.
 func (wh *WebHandlers) JsonDetailsHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract: test, digest.
@@ -521,6 +644,59 @@ func (wh *WebHandlers) JsonIgnoresAddHandler(w http.ResponseWriter, r *http.Requ
 	wh.JsonIgnoresHandler(w, r)
 }
 
+// JsonIgnoresImpactHandler previews what a new ignore rule would hide in the
+// current tile before it is saved. It accepts one query parameter 'query',
+// which is the query of the candidate rule. Traces that are already ignored
+// are not counted.
+func (wh *WebHandlers) JsonIgnoresImpactHandler(w http.ResponseWriter, r *http.Request) {
+	queryStr := r.FormValue("query")
+	if queryStr == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Invalid Filter: %q", queryStr), "Filters can't be empty.")
+		return
+	}
+	query, err := url.ParseQuery(queryStr)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse filter.")
+		return
+	}
+
+	exp, err := wh.Storages.ExpectationsStore.Get()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve expectations.")
+		return
+	}
+	ignoreMatcher, err := wh.Storages.IgnoreStore.BuildRuleMatcher()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve ignore rules.")
+		return
+	}
+
+	tile := wh.Indexer.GetIndex().GetTile(true)
+	sendJsonResponse(w, ignore.CalcImpact(query, tile, exp, ignoreMatcher))
+}
+
+// JsonIgnoresStaleHandler returns the ignore rules that don't match any trace
+// in the current tile or only hide traces with positive digests.
+func (wh *WebHandlers) JsonIgnoresStaleHandler(w http.ResponseWriter, r *http.Request) {
+	rules, err := wh.Storages.IgnoreStore.List(false)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve ignore rules.")
+		return
+	}
+	exp, err := wh.Storages.ExpectationsStore.Get()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to retrieve expectations.")
+		return
+	}
+
+	staleRules, err := ignore.FindStaleRules(rules, wh.Indexer.GetIndex().GetTile(true), exp)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to find stale ignore rules.")
+		return
+	}
+	sendJsonResponse(w, staleRules)
+}
+
 // TriageRequest is the form of the JSON posted to jsonTriageHandler.
 type TriageRequest struct {
 	// TestDigestStatus maps status to test name and digests as: map[testName][digest]status
@@ -528,6 +704,18 @@ type TriageRequest struct {
 
 	// Issue is the id of the code review issue for which we want to change the expectations.
 	Issue int64 `json:"issue"`
+
+	// Clusters are clusters of untriaged digests, as returned by
+	// JsonClusterUntriagedHandler, whose members should all be labeled.
+	Clusters []*TriageCluster `json:"clusters"`
+}
+
+// TriageCluster contains the members of a cluster of untriaged digests, as
+// displayed to the user, and the label to assign to all of them.
+type TriageCluster struct {
+	Test    string   `json:"test"`
+	Digests []string `json:"digests"`
+	Label   string   `json:"label"`
 }
 
 // JsonTriageHandler handles a request to change the triage status of one or more
@@ -565,6 +753,28 @@ func (wh *WebHandlers) JsonTriageHandler(w http.ResponseWriter, r *http.Request)
 		tc[test] = labeledDigests
 	}
 
+	// Add the members of the clusters. The clusters are not recomputed, so
+	// exactly the digests the user saw are labeled. Digests that were
+	// labeled explicitly keep their label.
+	for _, c := range req.Clusters {
+		if !types.ValidLabel(c.Label) {
+			httputils.ReportError(w, r, nil, "Receive invalid label in triage request.")
+			return
+		}
+		if c.Test == "" || len(c.Digests) == 0 {
+			httputils.ReportError(w, r, nil, "Received empty cluster in triage request.")
+			return
+		}
+		if _, ok := tc[c.Test]; !ok {
+			tc[c.Test] = types.TestClassification{}
+		}
+		for _, d := range c.Digests {
+			if _, ok := tc[c.Test][d]; !ok {
+				tc[c.Test][d] = types.LabelFromString(c.Label)
+			}
+		}
+	}
+
 	// If it's an issue set the expectations for the given issue.
 	if req.Issue > 0 {
 		if err := wh.Storages.TryjobStore.AddChange(req.Issue, tc, user); err != nil {
@@ -708,6 +918,90 @@ type ClusterDiffResult struct {
 	ParamsetsUnion   map[string][]string            `json:"paramsetsUnion"`
 }
 
+// ClusterUntriagedResult contains the clusters of the untriaged digests of a
+// test.
+type ClusterUntriagedResult struct {
+	Test      string                   `json:"test"`
+	Metric    string                   `json:"metric"`
+	Threshold float32                  `json:"threshold"`
+	Clusters  []*digestcluster.Cluster `json:"clusters"`
+}
+
+// JsonClusterUntriagedHandler groups the untriaged digests of a test into
+// clusters of visually similar digests, which can then be triaged together
+// via JsonTriageHandler.
+//
+// It takes these parameters:
+//  test      - The name of the test. Required.
+//  metric    - The diff metric used to compare digests. Defaults to 'combined'.
+//  threshold - The maximum distance between digests in the same cluster.
+//  include   - If true ignored digests are included. (true, false)
+func (wh *WebHandlers) JsonClusterUntriagedHandler(w http.ResponseWriter, r *http.Request) {
+	testName := r.FormValue("test")
+	if testName == "" {
+		httputils.ReportError(w, r, fmt.Errorf("test name parameter missing"), "No test name provided.")
+		return
+	}
+
+	validate := search.Validation{}
+	var metric string
+	validate.StrFormValue(r, "metric", &metric, diff.GetDiffMetricIDs(), diff.METRIC_COMBINED)
+	threshold := float32(validate.Float64FormValue(r, "threshold", digestcluster.DEFAULT_THRESHOLD))
+	if err := validate.Errors(); err != nil {
+		httputils.ReportError(w, r, err, "Invalid parameters.")
+		return
+	}
+	includeIgnores := r.FormValue("include") == "true"
+
+	clusters, err := wh.clusterUntriaged(testName, metric, threshold, includeIgnores)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Failed to cluster untriaged digests.")
+		return
+	}
+
+	sendJsonResponse(w, &ClusterUntriagedResult{
+		Test:      testName,
+		Metric:    metric,
+		Threshold: threshold,
+		Clusters:  clusters,
+	})
+}
+
+// clusterUntriaged clusters the untriaged digests of the given test in the
+// current index. At most MAX_CLUSTER_DIGESTS of the most frequent digests are
+// clustered.
+func (wh *WebHandlers) clusterUntriaged(testName, metric string, threshold float32, includeIgnores bool) ([]*digestcluster.Cluster, error) {
+	if metric == "" {
+		metric = diff.METRIC_COMBINED
+	}
+	if !util.In(metric, diff.GetDiffMetricIDs()) {
+		return nil, fmt.Errorf("Unknown diff metric: %s", metric)
+	}
+
+	exp, err := wh.Storages.ExpectationsStore.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	tally := wh.Indexer.GetIndex().TalliesByTest(includeIgnores)[testName]
+	digests := make([]string, 0, len(tally))
+	for d := range tally {
+		if exp.Classification(testName, d) == types.UNTRIAGED {
+			digests = append(digests, d)
+		}
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		if tally[digests[i]] != tally[digests[j]] {
+			return tally[digests[i]] > tally[digests[j]]
+		}
+		return digests[i] < digests[j]
+	})
+	if len(digests) > MAX_CLUSTER_DIGESTS {
+		digests = digests[:MAX_CLUSTER_DIGESTS]
+	}
+	return digestcluster.ClusterDigests(wh.Storages.DiffStore, digests, metric, threshold)
+}
+
 // JsonListTestsHandler returns a JSON list with high level information about
 // each test.
 //
@@ -933,6 +1227,147 @@ func (wh *WebHandlers) JsonTriageUndoHandler(w http.ResponseWriter, r *http.Requ
 	wh.JsonTriageLogHandler(w, r)
 }
 
+// JsonExpectationsAtHandler returns the expectations as they were at a point
+// in the past, reconstructed from the triage log. It accepts one of these
+// query parameters:
+//    change - The id of a triage log entry. The returned expectations include
+//             that change.
+//    ts     - A timestamp in milliseconds since the epoch.
+func (wh *WebHandlers) JsonExpectationsAtHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	validate := search.Validation{}
+	changeID := validate.Int64Value("change", q.Get("change"), 0)
+	ts := validate.Int64Value("ts", q.Get("ts"), 0)
+	if err := validate.Errors(); err != nil {
+		httputils.ReportError(w, r, err, "Invalid parameters.")
+		return
+	}
+	if (changeID > 0) == (ts > 0) {
+		httputils.ReportError(w, r, fmt.Errorf("Exactly one of change and ts must be provided."), "Invalid parameters.")
+		return
+	}
+
+	history, err := wh.Storages.GetExpectationsHistory()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve expectations history.")
+		return
+	}
+
+	var exp *expstorage.Expectations
+	if changeID > 0 {
+		if exp, err = history.AtChange(changeID); err != nil {
+			httputils.ReportError(w, r, err, "Unable to reconstruct expectations.")
+			return
+		}
+	} else {
+		exp = history.AtTime(ts)
+	}
+	sendJsonResponse(w, exp)
+}
+
+// JsonDigestHistoryHandler returns the history of the label of one digest,
+// i.e. who changed it when, in chronological order. It accepts these query
+// parameters:
+//    test   - The name of the test.
+//    digest - The digest.
+func (wh *WebHandlers) JsonDigestHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	testName := r.FormValue("test")
+	digest := r.FormValue("digest")
+	if testName == "" || digest == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Missing test or digest."), "Test and digest must be provided.")
+		return
+	}
+
+	history, err := wh.Storages.GetExpectationsHistory()
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to retrieve expectations history.")
+		return
+	}
+	sendJsonResponse(w, history.DigestHistory(testName, digest))
+}
+
+// JsonExpectationsExportHandler returns the expectations in the format of
+// expstorage.WriteExport so they can be imported into another instance. It
+// accepts these query parameters:
+//    log - If true the complete triage log is included. (true, false)
+func (wh *WebHandlers) JsonExpectationsExportHandler(w http.ResponseWriter, r *http.Request) {
+	includeLog := r.FormValue("log") == "true"
+	export, err := expstorage.Export(wh.Storages.ExpectationsStore, r.Host, includeLog)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to export expectations.")
+		return
+	}
+
+	// Set it up so that it triggers a save in the browser.
+	setJSONHeaders(w)
+	w.Header().Set("Content-Disposition", "attachment; filename=expectations.json")
+
+	if err := expstorage.WriteExport(w, export); err != nil {
+		httputils.ReportError(w, r, err, "Unable to serialize expectations.")
+	}
+}
+
+// JsonExpectationsImportHandler imports expectations that were exported by
+// JsonExpectationsExportHandler, which are sent as the body of the request.
+// It returns an expstorage.ImportResult that lists the conflicts with the
+// current expectations. Only admins can import expectations. It accepts these
+// query parameters:
+//    overwrite - If true conflicting labels are overwritten. (true, false)
+//    replay    - If true the triage log is replayed. Requires that there
+//                are no expectations yet. (true, false)
+//    dryrun    - If true only the result is calculated. (true, false)
+func (wh *WebHandlers) JsonExpectationsImportHandler(w http.ResponseWriter, r *http.Request) {
+	user := login.LoggedInAs(r)
+	if user == "" {
+		httputils.ReportError(w, r, fmt.Errorf("Not logged in."), "You must be logged in to import expectations.")
+		return
+	}
+	if !login.IsAdmin(r) {
+		httputils.ReportError(w, r, fmt.Errorf("%s is not an admin.", user), "You must be logged in as an admin to import expectations.")
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, MAX_IMPORT_SIZE)
+	export, err := expstorage.ReadExport(r.Body)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Invalid expectations export.")
+		return
+	}
+
+	opts := expstorage.ImportOptions{
+		Overwrite: r.FormValue("overwrite") == "true",
+		ReplayLog: r.FormValue("replay") == "true",
+		DryRun:    r.FormValue("dryrun") == "true",
+	}
+	result, err := expstorage.ImportExpectations(wh.Storages.ExpectationsStore, export, user, opts)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to import expectations.")
+		return
+	}
+	if !opts.DryRun {
+		sklog.Infof("%s imported expectations from %s: %d labels changed, %d conflicts", user, export.Source, result.Applied, len(result.Conflicts))
+	}
+	sendJsonResponse(w, result)
+}
+
+// JsonBaselineCommitHandler returns the master baseline as it was at the
+// commit identified by 'hash', which needs to be in the current tile.
+func (wh *WebHandlers) JsonBaselineCommitHandler(w http.ResponseWriter, r *http.Request) {
+	commitHash, ok := mux.Vars(r)["hash"]
+	if !ok {
+		httputils.ReportError(w, r, fmt.Errorf("Missing commit hash."), "Commit hash must be provided.")
+		return
+	}
+
+	tile := wh.Indexer.GetIndex().GetTile(false)
+	commitBaseline, err := wh.Storages.GetBaselineForCommit(tile, commitHash)
+	if err != nil {
+		httputils.ReportError(w, r, err, "Unable to calculate baseline.")
+		return
+	}
+	sendJsonResponse(w, commitBaseline)
+}
+
 // JsonParamsHandler returns the union of all parameters.
 func (wh *WebHandlers) JsonParamsHandler(w http.ResponseWriter, r *http.Request) {
 	tile := wh.Indexer.GetIndex().GetTile(true)
@@ -6,7 +6,9 @@ import (
 	"fmt"
 	"os"
 	"regexp"
+	"sort"
 	"sync"
+	"time"
 
 	"go.skia.org/infra/go/sklog"
 
@@ -16,6 +18,9 @@ import (
 
 const (
 	MAX_NAME_CHARS = 50
+
+	// Format of the start and end times of an ActiveWindow.
+	WINDOW_TIME_FORMAT = "15:04"
 )
 
 var (
@@ -32,26 +37,38 @@ type Blacklist struct {
 	mtx         sync.RWMutex
 }
 
-// Match determines whether the given taskSpec/commit pair matches one of the
+// Match determines whether the given repo/taskSpec/commit matches one of the
 // Rules in the Blacklist.
-func (b *Blacklist) Match(taskSpec, commit string) bool {
-	return b.MatchRule(taskSpec, commit) != ""
+func (b *Blacklist) Match(repo, taskSpec, commit string) bool {
+	return b.MatchRule(repo, taskSpec, commit) != ""
 }
 
-// MatchRule determines whether the given taskSpec/commit pair matches one of the
-// Rules in the Blacklist. Returns the name of the matched Rule or the empty
-// string if no Rules match.
-func (b *Blacklist) MatchRule(taskSpec, commit string) string {
+// MatchRule determines whether the given repo/taskSpec/commit matches one of
+// the Rules in the Blacklist. Returns the name of the matched Rule or the empty
+// string if no Rules match. Rules which are not currently active are ignored.
+func (b *Blacklist) MatchRule(repo, taskSpec, commit string) string {
 	b.mtx.RLock()
 	defer b.mtx.RUnlock()
+	now := time.Now()
 	for _, rule := range b.Rules {
-		if rule.Match(taskSpec, commit) {
+		if rule.Active(now) && rule.Match(repo, taskSpec, commit) {
 			return rule.Name
 		}
 	}
 	return ""
 }
 
+// GetRule returns a copy of the Rule with the given name, or ERR_NO_SUCH_RULE.
+func (b *Blacklist) GetRule(name string) (*Rule, error) {
+	b.mtx.RLock()
+	defer b.mtx.RUnlock()
+	r, ok := b.Rules[name]
+	if !ok {
+		return nil, ERR_NO_SUCH_RULE
+	}
+	return r.Copy(), nil
+}
+
 // ensureDefaults adds the necessary default blacklist rules if necessary.
 func (b *Blacklist) ensureDefaults() error {
 	for _, rule := range DEFAULT_RULES {
@@ -169,6 +186,34 @@ func (b *Blacklist) removeRule(name string) error {
 	return nil
 }
 
+// RemoveExpiredRules removes all Rules which have expired as of the given
+// time, except for those for which keep returns true. keep may be nil.
+// Returns the removed Rules.
+func (b *Blacklist) RemoveExpiredRules(now time.Time, keep func(*Rule) bool) ([]*Rule, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	removed := []*Rule{}
+	for name, r := range b.Rules {
+		if r.Expired(now) && (keep == nil || !keep(r)) {
+			removed = append(removed, r)
+			delete(b.Rules, name)
+		}
+	}
+	if len(removed) == 0 {
+		return removed, nil
+	}
+	if err := b.writeOut(); err != nil {
+		for _, r := range removed {
+			b.Rules[r.Name] = r
+		}
+		return nil, err
+	}
+	sort.Slice(removed, func(i, j int) bool {
+		return removed[i].Name < removed[j].Name
+	})
+	return removed, nil
+}
+
 // RemoveRule removes the Rule from the Blacklist.
 func (b *Blacklist) RemoveRule(name string) error {
 	for _, r := range DEFAULT_RULES {
@@ -189,12 +234,145 @@ func (b *Blacklist) RemoveRule(name string) error {
 // empty, the Rule applies for all commits.
 //
 // A Rule should specify TaskSpecPatterns or Commits or both.
+//
+// If Repo is set, the Rule only applies to tasks in that repo.
+//
+// If Starts is non-zero, the Rule does not apply before that time. If Expires
+// is non-zero, the Rule no longer applies after that time, and it is
+// eventually removed from the Blacklist, at which point the Owner is notified.
+// If Window is set, the Rule only applies during the given times of day.
 type Rule struct {
-	AddedBy          string   `json:"added_by"`
-	TaskSpecPatterns []string `json:"task_spec_patterns"`
-	Commits          []string `json:"commits"`
-	Description      string   `json:"description"`
-	Name             string   `json:"name"`
+	AddedBy          string        `json:"added_by"`
+	Bug              string        `json:"bug,omitempty"`
+	TaskSpecPatterns []string      `json:"task_spec_patterns"`
+	Commits          []string      `json:"commits"`
+	Description      string        `json:"description"`
+	Expires          time.Time     `json:"expires"`
+	Name             string        `json:"name"`
+	Owner            string        `json:"owner,omitempty"`
+	Repo             string        `json:"repo,omitempty"`
+	Starts           time.Time     `json:"starts"`
+	Window           *ActiveWindow `json:"window,omitempty"`
+}
+
+// Copy returns a copy of the Rule.
+func (r *Rule) Copy() *Rule {
+	return &Rule{
+		AddedBy:          r.AddedBy,
+		Bug:              r.Bug,
+		TaskSpecPatterns: util.CopyStringSlice(r.TaskSpecPatterns),
+		Commits:          util.CopyStringSlice(r.Commits),
+		Description:      r.Description,
+		Expires:          r.Expires,
+		Name:             r.Name,
+		Owner:            r.Owner,
+		Repo:             r.Repo,
+		Starts:           r.Starts,
+		Window:           r.Window.Copy(),
+	}
+}
+
+// Expired returns true iff the Rule has an expiration time which is not after
+// the given time.
+func (r *Rule) Expired(now time.Time) bool {
+	return !util.TimeIsZero(r.Expires) && !now.Before(r.Expires)
+}
+
+// Active returns true iff the Rule applies at the given time.
+func (r *Rule) Active(now time.Time) bool {
+	if r.Expired(now) {
+		return false
+	}
+	if !util.TimeIsZero(r.Starts) && now.Before(r.Starts) {
+		return false
+	}
+	return r.Window.Contains(now)
+}
+
+// ActiveWindow is a recurring period of time, eg. lab maintenance hours.
+// Start and End are times of day in UTC, in "15:04" format. If End is before
+// Start, the window wraps past midnight. If Weekdays is non-empty, the window
+// only opens on the given days.
+type ActiveWindow struct {
+	Start    string         `json:"start"`
+	End      string         `json:"end"`
+	Weekdays []time.Weekday `json:"weekdays,omitempty"`
+}
+
+// Copy returns a copy of the ActiveWindow.
+func (w *ActiveWindow) Copy() *ActiveWindow {
+	if w == nil {
+		return nil
+	}
+	var weekdays []time.Weekday
+	if w.Weekdays != nil {
+		weekdays = make([]time.Weekday, len(w.Weekdays))
+		copy(weekdays, w.Weekdays)
+	}
+	return &ActiveWindow{
+		Start:    w.Start,
+		End:      w.End,
+		Weekdays: weekdays,
+	}
+}
+
+// Validate returns an error if the ActiveWindow is not valid.
+func (w *ActiveWindow) Validate() error {
+	start, err := time.Parse(WINDOW_TIME_FORMAT, w.Start)
+	if err != nil {
+		return fmt.Errorf("Invalid window start time %q; expected format %q", w.Start, WINDOW_TIME_FORMAT)
+	}
+	end, err := time.Parse(WINDOW_TIME_FORMAT, w.End)
+	if err != nil {
+		return fmt.Errorf("Invalid window end time %q; expected format %q", w.End, WINDOW_TIME_FORMAT)
+	}
+	if start.Equal(end) {
+		return fmt.Errorf("Window start and end times must differ.")
+	}
+	for _, d := range w.Weekdays {
+		if d < time.Sunday || d > time.Saturday {
+			return fmt.Errorf("Invalid weekday %d", d)
+		}
+	}
+	return nil
+}
+
+// Contains returns true iff the given time falls within the ActiveWindow. A
+// nil ActiveWindow contains all times. Assumes that the ActiveWindow is valid.
+func (w *ActiveWindow) Contains(now time.Time) bool {
+	if w == nil {
+		return true
+	}
+	now = now.UTC()
+	start, _ := time.Parse(WINDOW_TIME_FORMAT, w.Start)
+	end, _ := time.Parse(WINDOW_TIME_FORMAT, w.End)
+	startMin := start.Hour()*60 + start.Minute()
+	endMin := end.Hour()*60 + end.Minute()
+	nowMin := now.Hour()*60 + now.Minute()
+	// The day on which the window opened, for windows which wrap past
+	// midnight.
+	day := now.Weekday()
+	if startMin < endMin {
+		if nowMin < startMin || nowMin >= endMin {
+			return false
+		}
+	} else {
+		if nowMin < startMin && nowMin >= endMin {
+			return false
+		}
+		if nowMin < endMin {
+			day = now.Add(-24 * time.Hour).Weekday()
+		}
+	}
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == day {
+			return true
+		}
+	}
+	return false
 }
 
 // ValidateRule returns an error if the given Rule is not valid.
@@ -211,6 +389,19 @@ func ValidateRule(r *Rule, repos repograph.Map) error {
 	if len(r.TaskSpecPatterns) == 0 && len(r.Commits) == 0 {
 		return fmt.Errorf("Rules must include a taskSpec pattern and/or a commit/range.")
 	}
+	if !util.TimeIsZero(r.Starts) && !util.TimeIsZero(r.Expires) && !r.Starts.Before(r.Expires) {
+		return fmt.Errorf("Rules must expire after they start.")
+	}
+	if r.Window != nil {
+		if err := r.Window.Validate(); err != nil {
+			return err
+		}
+	}
+	if r.Repo != "" {
+		if _, ok := repos[r.Repo]; !ok {
+			return fmt.Errorf("Unknown repo %q", r.Repo)
+		}
+	}
 	for _, c := range r.Commits {
 		if _, _, _, err := repos.FindCommit(c); err != nil {
 			return err
@@ -255,8 +446,11 @@ func (r *Rule) matchCommit(commit string) bool {
 	return false
 }
 
-// Match returns true iff the Rule matches the given taskSpec and commit.
-func (r *Rule) Match(taskSpec, commit string) bool {
+// Match returns true iff the Rule matches the given repo, taskSpec and commit.
+func (r *Rule) Match(repo, taskSpec, commit string) bool {
+	if r.Repo != "" && r.Repo != repo {
+		return false
+	}
 	return r.matchTaskSpec(taskSpec) && r.matchCommit(commit)
 }
 
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"path"
 	"testing"
+	"time"
 
 	"go.skia.org/infra/go/deepequal"
 	"go.skia.org/infra/go/git/repograph"
@@ -45,9 +46,182 @@ func TestAddRemove(t *testing.T) {
 	deepequal.AssertDeepEqual(t, b1, b2)
 }
 
+func TestExpiredRule(t *testing.T) {
+	testutils.SmallTest(t)
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	b, err := FromFile(path.Join(tmp, "blacklist.json"))
+	assert.NoError(t, err)
+
+	now := time.Now()
+	r := &Rule{
+		AddedBy:          "test@google.com",
+		TaskSpecPatterns: []string{"^My-TaskSpec$"},
+		Expires:          now.Add(time.Hour),
+		Name:             "Expiring Rule",
+	}
+	assert.False(t, r.Expired(now))
+	assert.True(t, r.Expired(r.Expires))
+	assert.False(t, (&Rule{}).Expired(now))
+	assert.NoError(t, b.addRule(r))
+	assert.Equal(t, r.Name, b.MatchRule("skia.git", "My-TaskSpec", "abc123"))
+	got, err := b.GetRule(r.Name)
+	assert.NoError(t, err)
+	deepequal.AssertCopy(t, r, got)
+	_, err = b.GetRule("bogus")
+	assert.Equal(t, ERR_NO_SUCH_RULE, err)
+
+	// Expired rules don't match.
+	b.Rules[r.Name].Expires = now.Add(-time.Minute)
+	assert.Equal(t, "", b.MatchRule("skia.git", "My-TaskSpec", "abc123"))
+	assert.False(t, b.Match("skia.git", "My-TaskSpec", "abc123"))
+}
+
+func TestRuleActive(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Thursday.
+	now := time.Date(2018, time.May, 3, 12, 30, 0, 0, time.UTC)
+	r := &Rule{}
+	assert.True(t, r.Active(now))
+
+	// Starts and Expires.
+	r.Starts = now.Add(time.Hour)
+	r.Expires = now.Add(2 * time.Hour)
+	assert.False(t, r.Active(now))
+	assert.True(t, r.Active(r.Starts))
+	assert.False(t, r.Active(r.Expires))
+
+	// Windows.
+	r = &Rule{
+		Window: &ActiveWindow{
+			Start: "12:00",
+			End:   "13:00",
+		},
+	}
+	assert.True(t, r.Active(now))
+	assert.False(t, r.Active(now.Add(30*time.Minute)))
+	assert.False(t, r.Active(now.Add(-31*time.Minute)))
+	r.Window.Weekdays = []time.Weekday{time.Monday, time.Wednesday}
+	assert.False(t, r.Active(now))
+	assert.True(t, r.Active(now.Add(-24*time.Hour)))
+
+	// Windows which wrap past midnight belong to the day they start.
+	r.Window = &ActiveWindow{
+		Start:    "22:00",
+		End:      "02:00",
+		Weekdays: []time.Weekday{time.Thursday},
+	}
+	assert.False(t, r.Active(now))
+	assert.True(t, r.Active(time.Date(2018, time.May, 3, 23, 0, 0, 0, time.UTC)))
+	assert.True(t, r.Active(time.Date(2018, time.May, 4, 1, 0, 0, 0, time.UTC)))
+	assert.False(t, r.Active(time.Date(2018, time.May, 4, 2, 0, 0, 0, time.UTC)))
+	assert.False(t, r.Active(time.Date(2018, time.May, 3, 1, 0, 0, 0, time.UTC)))
+
+	// Times are converted to UTC.
+	r.Window.Weekdays = nil
+	loc := time.FixedZone("UTC-5", -5*60*60)
+	assert.True(t, r.Active(time.Date(2018, time.May, 3, 18, 0, 0, 0, loc)))
+	assert.False(t, r.Active(time.Date(2018, time.May, 3, 22, 0, 0, 0, loc)))
+}
+
+func TestActiveWindowValidate(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.NoError(t, (&ActiveWindow{Start: "22:00", End: "02:00"}).Validate())
+	assert.EqualError(t, (&ActiveWindow{Start: "10pm", End: "02:00"}).Validate(), `Invalid window start time "10pm"; expected format "15:04"`)
+	assert.EqualError(t, (&ActiveWindow{Start: "22:00", End: "25:00"}).Validate(), `Invalid window end time "25:00"; expected format "15:04"`)
+	assert.EqualError(t, (&ActiveWindow{Start: "22:00", End: "22:00"}).Validate(), "Window start and end times must differ.")
+	assert.EqualError(t, (&ActiveWindow{Start: "22:00", End: "02:00", Weekdays: []time.Weekday{7}}).Validate(), "Invalid weekday 7")
+
+	now := time.Now()
+	r := &Rule{
+		AddedBy:          "test@google.com",
+		Name:             "My Rule",
+		TaskSpecPatterns: []string{".*"},
+		Starts:           now,
+		Expires:          now,
+	}
+	assert.EqualError(t, ValidateRule(r, nil), "Rules must expire after they start.")
+	r.Expires = now.Add(time.Hour)
+	assert.NoError(t, ValidateRule(r, nil))
+	r.Window = &ActiveWindow{Start: "22:00"}
+	assert.EqualError(t, ValidateRule(r, nil), `Invalid window end time ""; expected format "15:04"`)
+
+	r = &Rule{
+		AddedBy:          "me@google.com",
+		Bug:              "skia:1234",
+		TaskSpecPatterns: []string{".*"},
+		Commits:          []string{"abc123"},
+		Description:      "Lab maintenance",
+		Expires:          now.Add(time.Hour),
+		Name:             "Maintenance",
+		Owner:            "you@google.com",
+		Starts:           now,
+		Window: &ActiveWindow{
+			Start:    "22:00",
+			End:      "02:00",
+			Weekdays: []time.Weekday{time.Saturday},
+		},
+	}
+	deepequal.AssertCopy(t, r, r.Copy())
+}
+
+func TestRemoveExpiredRules(t *testing.T) {
+	testutils.SmallTest(t)
+	tmp, err := ioutil.TempDir("", "")
+	assert.NoError(t, err)
+	defer testutils.RemoveAll(t, tmp)
+	f := path.Join(tmp, "blacklist.json")
+	b, err := FromFile(f)
+	assert.NoError(t, err)
+
+	now := time.Now()
+	rule := func(name string, expires time.Time) *Rule {
+		r := &Rule{
+			AddedBy:          "test@google.com",
+			TaskSpecPatterns: []string{".*"},
+			Expires:          expires,
+			Name:             name,
+		}
+		assert.NoError(t, b.addRule(r))
+		return r
+	}
+	r1 := rule("r1", now.Add(-time.Hour))
+	rule("r2", now.Add(time.Hour))
+	rule("r3", time.Time{})
+	r4 := rule("r4", now.Add(-time.Minute))
+	rule("keep", now.Add(-time.Hour))
+
+	removed, err := b.RemoveExpiredRules(now, func(r *Rule) bool {
+		return r.Name == "keep"
+	})
+	assert.NoError(t, err)
+	deepequal.AssertDeepEqual(t, []*Rule{r1, r4}, removed)
+	assert.Equal(t, 3, len(b.Rules))
+	b2, err := FromFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(b2.Rules))
+	for _, name := range []string{"r2", "r3", "keep"} {
+		_, err := b2.GetRule(name)
+		assert.NoError(t, err)
+	}
+
+	// Nothing else to remove.
+	removed, err = b.RemoveExpiredRules(now, func(r *Rule) bool {
+		return r.Name == "keep"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(removed))
+	removed, err = b.RemoveExpiredRules(now, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(removed))
+}
+
 func TestRules(t *testing.T) {
 	testutils.SmallTest(t)
 	type testCase struct {
+		repo        string
 		taskSpec    string
 		commit      string
 		expectMatch bool
@@ -154,10 +328,36 @@ func TestRules(t *testing.T) {
 				},
 			},
 		},
+		{
+			rule: Rule{
+				AddedBy: "test@google.com",
+				Name:    "Match one repo",
+				TaskSpecPatterns: []string{
+					".*",
+				},
+				Repo: "skia.git",
+			},
+			cases: []testCase{
+				{
+					repo:        "skia.git",
+					taskSpec:    "My-TaskSpec",
+					commit:      "abc123",
+					expectMatch: true,
+					msg:         "Repo match",
+				},
+				{
+					repo:        "infra.git",
+					taskSpec:    "My-TaskSpec",
+					commit:      "abc123",
+					expectMatch: false,
+					msg:         "Repo does not match",
+				},
+			},
+		},
 	}
 	for _, test := range tests {
 		for _, c := range test.cases {
-			assert.Equal(t, c.expectMatch, test.rule.Match(c.taskSpec, c.commit), c.msg)
+			assert.Equal(t, c.expectMatch, test.rule.Match(c.repo, c.taskSpec, c.commit), c.msg)
 		}
 	}
 }
@@ -336,6 +536,26 @@ func TestValidation(t *testing.T) {
 			expect: nil,
 			msg:    "One commit",
 		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Repo:             gb.RepoUrl(),
+			},
+			expect: nil,
+			msg:    "Known repo",
+		},
+		{
+			rule: Rule{
+				AddedBy:          "test@google.com",
+				Name:             "My rule",
+				TaskSpecPatterns: []string{".*"},
+				Repo:             "bogus.git",
+			},
+			expect: fmt.Errorf("Unknown repo \"bogus.git\""),
+			msg:    "Unknown repo",
+		},
 		{
 			rule: Rule{
 				AddedBy:          "test@google.com",
@@ -432,6 +652,6 @@ func TestCommitRange(t *testing.T) {
 		},
 	}
 	for _, c := range tc {
-		assert.Equal(t, c.expect, b.Match("", c.commit))
+		assert.Equal(t, c.expect, b.Match(gb.RepoUrl(), "", c.commit))
 	}
 }
@@ -0,0 +1,263 @@
+// Package dag provides analysis of the TaskSpec dependency graphs of JobSpecs,
+// annotated with historical task durations.
+package dag
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+const (
+	// DEFAULT_HISTORY is the default time period over which task durations
+	// are computed.
+	DEFAULT_HISTORY = 7 * 24 * time.Hour
+
+	FORMAT_DOT  = "dot"
+	FORMAT_JSON = "json"
+)
+
+// DurationStats describes the historical durations of a TaskSpec.
+type DurationStats struct {
+	// Median is the median duration of the tasks.
+	Median time.Duration `json:"median"`
+
+	// Samples is the number of tasks used to compute the median.
+	Samples int `json:"samples"`
+}
+
+// GetDurationStats computes DurationStats for each TaskSpec from the given
+// tasks. Only successful, non-fake tasks are considered, since failed tasks
+// may have stopped early.
+func GetDurationStats(tasks []*db.Task) map[string]*DurationStats {
+	durations := map[string][]time.Duration{}
+	for _, t := range tasks {
+		if t.Fake() || t.Status != db.TASK_STATUS_SUCCESS || util.TimeIsZero(t.Started) || util.TimeIsZero(t.Finished) {
+			continue
+		}
+		durations[t.Name] = append(durations[t.Name], t.Finished.Sub(t.Started))
+	}
+	rv := make(map[string]*DurationStats, len(durations))
+	for name, d := range durations {
+		sort.Slice(d, func(i, j int) bool {
+			return d[i] < d[j]
+		})
+		rv[name] = &DurationStats{
+			Median:  d[len(d)/2],
+			Samples: len(d),
+		}
+	}
+	return rv
+}
+
+// Node is a TaskSpec in a JobDAG.
+type Node struct {
+	Name         string   `json:"name"`
+	Dependencies []string `json:"dependencies"`
+
+	// Duration is the median duration of the TaskSpec, or zero if there
+	// is no history. Samples is the number of tasks used to compute it.
+	Duration time.Duration `json:"duration"`
+	Samples  int           `json:"samples"`
+
+	// EarliestStart and EarliestFinish are the offsets from the start of
+	// the Job at which the TaskSpec could start and finish, assuming that
+	// each task takes its median duration and that bots are always
+	// available.
+	EarliestStart  time.Duration `json:"earliestStart"`
+	EarliestFinish time.Duration `json:"earliestFinish"`
+
+	// Slack is the amount of time by which the TaskSpec could be delayed
+	// without delaying the Job.
+	Slack time.Duration `json:"slack"`
+
+	// OnCriticalPath indicates whether the TaskSpec is on the critical
+	// path of the Job.
+	OnCriticalPath bool `json:"onCriticalPath"`
+}
+
+// JobDAG is the TaskSpec dependency graph for a JobSpec at a given RepoState.
+type JobDAG struct {
+	Job       string       `json:"job"`
+	RepoState db.RepoState `json:"repoState"`
+
+	// Nodes are in topological order, with dependencies before the
+	// TaskSpecs which depend on them. Ties are broken by name.
+	Nodes []*Node `json:"nodes"`
+
+	// CriticalPath is the longest chain of dependent TaskSpecs, ordered
+	// from the first TaskSpec to run to the last. Speeding up any other
+	// TaskSpec does not reduce the Job's latency.
+	CriticalPath []string `json:"criticalPath"`
+
+	// Duration is the expected latency of the Job; the sum of the
+	// durations of the TaskSpecs on the critical path.
+	Duration time.Duration `json:"duration"`
+}
+
+// New returns a JobDAG for the given JobSpec. durations may be nil or missing
+// entries, in which case those TaskSpecs are assumed to take no time.
+func New(cfg *specs.TasksCfg, rs db.RepoState, jobName string, durations map[string]*DurationStats) (*JobDAG, error) {
+	j, ok := cfg.Jobs[jobName]
+	if !ok {
+		return nil, fmt.Errorf("No such job: %s", jobName)
+	}
+	deps, err := j.GetTaskSpecDAG(cfg)
+	if err != nil {
+		return nil, err
+	}
+	order, err := topologicalSort(deps)
+	if err != nil {
+		return nil, err
+	}
+
+	// Forward pass: earliest start and finish times.
+	nodes := make(map[string]*Node, len(order))
+	rv := &JobDAG{
+		Job:          jobName,
+		RepoState:    rs,
+		Nodes:        make([]*Node, 0, len(order)),
+		CriticalPath: []string{},
+	}
+	for _, name := range order {
+		n := &Node{
+			Name:         name,
+			Dependencies: util.CopyStringSlice(deps[name]),
+		}
+		sort.Strings(n.Dependencies)
+		if d, ok := durations[name]; ok {
+			n.Duration = d.Median
+			n.Samples = d.Samples
+		}
+		for _, dep := range n.Dependencies {
+			if f := nodes[dep].EarliestFinish; f > n.EarliestStart {
+				n.EarliestStart = f
+			}
+		}
+		n.EarliestFinish = n.EarliestStart + n.Duration
+		if n.EarliestFinish > rv.Duration {
+			rv.Duration = n.EarliestFinish
+		}
+		nodes[name] = n
+		rv.Nodes = append(rv.Nodes, n)
+	}
+
+	// Backward pass: latest start times and slack.
+	latestStart := make(map[string]time.Duration, len(order))
+	for i := len(rv.Nodes) - 1; i >= 0; i-- {
+		n := rv.Nodes[i]
+		latestFinish := rv.Duration
+		for _, other := range rv.Nodes[i+1:] {
+			if util.In(n.Name, other.Dependencies) && latestStart[other.Name] < latestFinish {
+				latestFinish = latestStart[other.Name]
+			}
+		}
+		latestStart[n.Name] = latestFinish - n.Duration
+		n.Slack = latestStart[n.Name] - n.EarliestStart
+	}
+
+	// Trace the critical path backward from the last TaskSpec to finish.
+	var last *Node
+	for _, n := range rv.Nodes {
+		if last == nil || n.EarliestFinish >= last.EarliestFinish {
+			last = n
+		}
+	}
+	for n := last; n != nil; {
+		n.OnCriticalPath = true
+		rv.CriticalPath = append([]string{n.Name}, rv.CriticalPath...)
+		var next *Node
+		for _, dep := range n.Dependencies {
+			d := nodes[dep]
+			if d.EarliestFinish == n.EarliestStart && (next == nil || d.Name < next.Name) {
+				next = d
+			}
+		}
+		n = next
+	}
+	return rv, nil
+}
+
+// topologicalSort returns the names of the TaskSpecs in the given dependency
+// graph in an order where each TaskSpec follows its dependencies. Ties are
+// broken by name.
+func topologicalSort(deps map[string][]string) ([]string, error) {
+	remaining := make(map[string]int, len(deps))
+	dependents := make(map[string][]string, len(deps))
+	for name, d := range deps {
+		remaining[name] = len(d)
+		for _, dep := range d {
+			dependents[dep] = append(dependents[dep], name)
+		}
+	}
+	ready := []string{}
+	for name, n := range remaining {
+		if n == 0 {
+			ready = append(ready, name)
+		}
+	}
+	rv := make([]string, 0, len(deps))
+	for len(ready) > 0 {
+		sort.Strings(ready)
+		name := ready[0]
+		ready = ready[1:]
+		rv = append(rv, name)
+		for _, d := range dependents[name] {
+			remaining[d]--
+			if remaining[d] == 0 {
+				ready = append(ready, d)
+			}
+		}
+	}
+	if len(rv) != len(deps) {
+		return nil, fmt.Errorf("Dependency graph contains a cycle.")
+	}
+	return rv, nil
+}
+
+// WriteDOT writes the JobDAG to the given writer in Graphviz DOT format. Each
+// TaskSpec is labeled with its median duration, and the critical path is
+// highlighted.
+func (d *JobDAG) WriteDOT(w io.Writer) error {
+	if _, err := fmt.Fprintf(w, "digraph %q {\n\tlabel=%q;\n\trankdir=LR;\n\tnode [shape=box];\n", d.Job, fmt.Sprintf("%s (critical path: %s)", d.Job, d.Duration)); err != nil {
+		return err
+	}
+	for _, n := range d.Nodes {
+		label := fmt.Sprintf("%s\n%s (%d samples)", n.Name, n.Duration, n.Samples)
+		attrs := ""
+		if n.OnCriticalPath {
+			attrs = " style=filled fillcolor=\"#f4cccc\""
+		}
+		if _, err := fmt.Fprintf(w, "\t%q [label=%q%s];\n", n.Name, label, attrs); err != nil {
+			return err
+		}
+	}
+	for _, n := range d.Nodes {
+		for _, dep := range n.Dependencies {
+			attrs := ""
+			if d.onPath(dep, n.Name) {
+				attrs = " [color=red penwidth=2]"
+			}
+			if _, err := fmt.Fprintf(w, "\t%q -> %q%s;\n", dep, n.Name, attrs); err != nil {
+				return err
+			}
+		}
+	}
+	_, err := fmt.Fprintf(w, "}\n")
+	return err
+}
+
+// onPath returns true iff the critical path contains the edge from a to b.
+func (d *JobDAG) onPath(a, b string) bool {
+	for i := 1; i < len(d.CriticalPath); i++ {
+		if d.CriticalPath[i-1] == a && d.CriticalPath[i] == b {
+			return true
+		}
+	}
+	return false
+}
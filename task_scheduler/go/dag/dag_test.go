@@ -0,0 +1,179 @@
+package dag
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/deepequal"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+func TestGetDurationStats(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Now()
+	task := func(name string, d time.Duration, status db.TaskStatus) *db.Task {
+		t := db.MakeTestTask(now, []string{"abc123"})
+		t.Name = name
+		t.Started = now
+		t.Finished = now.Add(d)
+		t.Status = status
+		return t
+	}
+	running := task("a", 0, db.TASK_STATUS_RUNNING)
+	running.Finished = time.Time{}
+	deepequal.AssertDeepEqual(t, map[string]*DurationStats{
+		"a": {
+			Median:  3 * time.Minute,
+			Samples: 3,
+		},
+		"b": {
+			Median:  time.Minute,
+			Samples: 1,
+		},
+	}, GetDurationStats([]*db.Task{
+		task("a", 5*time.Minute, db.TASK_STATUS_SUCCESS),
+		task("a", time.Minute, db.TASK_STATUS_SUCCESS),
+		task("a", 3*time.Minute, db.TASK_STATUS_SUCCESS),
+		task("a", time.Hour, db.TASK_STATUS_FAILURE),
+		running,
+		task("b", time.Minute, db.TASK_STATUS_SUCCESS),
+	}))
+}
+
+func TestJobDAG(t *testing.T) {
+	testutils.SmallTest(t)
+
+	cfg := &specs.TasksCfg{
+		Tasks: map[string]*specs.TaskSpec{
+			"build":       {},
+			"housekeeper": {},
+			"perf":        {Dependencies: []string{"build"}},
+			"test":        {Dependencies: []string{"build"}},
+			"upload":      {Dependencies: []string{"test", "perf"}},
+		},
+		Jobs: map[string]*specs.JobSpec{
+			"j": {TaskSpecs: []string{"upload", "housekeeper"}},
+		},
+	}
+	durations := map[string]*DurationStats{
+		"build":       {Median: 10 * time.Minute, Samples: 1},
+		"housekeeper": {Median: 3 * time.Minute, Samples: 2},
+		"perf":        {Median: 5 * time.Minute, Samples: 3},
+		"test":        {Median: 20 * time.Minute, Samples: 4},
+		"upload":      {Median: time.Minute, Samples: 5},
+	}
+	rs := db.RepoState{
+		Repo:     "a.git",
+		Revision: "abc123",
+	}
+
+	_, err := New(cfg, rs, "bogus", durations)
+	assert.EqualError(t, err, "No such job: bogus")
+
+	d, err := New(cfg, rs, "j", durations)
+	assert.NoError(t, err)
+	deepequal.AssertDeepEqual(t, &JobDAG{
+		Job:       "j",
+		RepoState: rs,
+		Nodes: []*Node{
+			{
+				Name:           "build",
+				Dependencies:   []string{},
+				Duration:       10 * time.Minute,
+				Samples:        1,
+				EarliestStart:  0,
+				EarliestFinish: 10 * time.Minute,
+				Slack:          0,
+				OnCriticalPath: true,
+			},
+			{
+				Name:           "housekeeper",
+				Dependencies:   []string{},
+				Duration:       3 * time.Minute,
+				Samples:        2,
+				EarliestStart:  0,
+				EarliestFinish: 3 * time.Minute,
+				Slack:          28 * time.Minute,
+			},
+			{
+				Name:           "perf",
+				Dependencies:   []string{"build"},
+				Duration:       5 * time.Minute,
+				Samples:        3,
+				EarliestStart:  10 * time.Minute,
+				EarliestFinish: 15 * time.Minute,
+				Slack:          15 * time.Minute,
+			},
+			{
+				Name:           "test",
+				Dependencies:   []string{"build"},
+				Duration:       20 * time.Minute,
+				Samples:        4,
+				EarliestStart:  10 * time.Minute,
+				EarliestFinish: 30 * time.Minute,
+				Slack:          0,
+				OnCriticalPath: true,
+			},
+			{
+				Name:           "upload",
+				Dependencies:   []string{"perf", "test"},
+				Duration:       time.Minute,
+				Samples:        5,
+				EarliestStart:  30 * time.Minute,
+				EarliestFinish: 31 * time.Minute,
+				Slack:          0,
+				OnCriticalPath: true,
+			},
+		},
+		CriticalPath: []string{"build", "test", "upload"},
+		Duration:     31 * time.Minute,
+	}, d)
+
+	var buf bytes.Buffer
+	assert.NoError(t, d.WriteDOT(&buf))
+	assert.Equal(t, `digraph "j" {
+	label="j (critical path: 31m0s)";
+	rankdir=LR;
+	node [shape=box];
+	"build" [label="build\n10m0s (1 samples)" style=filled fillcolor="#f4cccc"];
+	"housekeeper" [label="housekeeper\n3m0s (2 samples)"];
+	"perf" [label="perf\n5m0s (3 samples)"];
+	"test" [label="test\n20m0s (4 samples)" style=filled fillcolor="#f4cccc"];
+	"upload" [label="upload\n1m0s (5 samples)" style=filled fillcolor="#f4cccc"];
+	"build" -> "perf";
+	"build" -> "test" [color=red penwidth=2];
+	"perf" -> "upload";
+	"test" -> "upload" [color=red penwidth=2];
+}
+`, buf.String())
+
+	// Without durations, everything is on the critical path.
+	d, err = New(cfg, rs, "j", nil)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), d.Duration)
+	deepequal.AssertDeepEqual(t, []string{"build", "perf", "upload"}, d.CriticalPath)
+}
+
+func TestTopologicalSort(t *testing.T) {
+	testutils.SmallTest(t)
+
+	order, err := topologicalSort(map[string][]string{
+		"c": {"a", "b"},
+		"b": {"a"},
+		"a": {},
+		"d": {},
+	})
+	assert.NoError(t, err)
+	deepequal.AssertDeepEqual(t, []string{"a", "b", "c", "d"}, order)
+
+	_, err = topologicalSort(map[string][]string{
+		"a": {"b"},
+		"b": {"a"},
+	})
+	assert.EqualError(t, err, "Dependency graph contains a cycle.")
+}
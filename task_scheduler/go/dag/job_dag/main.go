@@ -0,0 +1,102 @@
+package main
+
+/*
+	Render the TaskSpec dependency graph of a JobSpec.
+
+	Reads a tasks.json file and writes the DAG for the given job in DOT or
+	JSON format, annotated with median task durations from the Task
+	Scheduler DB and the job's critical path.
+
+	Example:
+	  job_dag --tasks_json infra/bots/tasks.json \
+	    --repo https://skia.googlesource.com/skia.git \
+	    --job Test-Debian9-Clang-GCE-CPU-AVX2-x86_64-Debug-All | dot -Tsvg > dag.svg
+*/
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/httputils"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/task_scheduler/go/dag"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/db/remote_db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+var (
+	format    = flag.String("format", dag.FORMAT_DOT, fmt.Sprintf("Output format; %q or %q.", dag.FORMAT_DOT, dag.FORMAT_JSON))
+	history   = flag.Duration("history", dag.DEFAULT_HISTORY, "Time period over which to compute median task durations.")
+	job       = flag.String("job", "", "Name of the job.")
+	repo      = flag.String("repo", common.REPO_SKIA, "Repo whose tasks are used to compute median task durations.")
+	revision  = flag.String("revision", "", "Optional revision at which the tasks.json file was read; only used to annotate the output.")
+	taskDbUrl = flag.String("task_db_url", "http://skia-task-scheduler:8008/db/", "Where the Skia task scheduler database is hosted. If empty, task durations are not reported.")
+	tasksJson = flag.String("tasks_json", specs.TASKS_CFG_FILE, "Path to the tasks.json file.")
+)
+
+func main() {
+	common.Init()
+	defer common.LogPanic()
+
+	if *job == "" {
+		sklog.Fatal("--job is required.")
+	}
+	if *format != dag.FORMAT_DOT && *format != dag.FORMAT_JSON {
+		sklog.Fatalf("Unknown --format %q", *format)
+	}
+
+	b, err := ioutil.ReadFile(*tasksJson)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	cfg, err := specs.ParseTasksCfg(string(b))
+	if err != nil {
+		sklog.Fatal(err)
+	}
+
+	var durations map[string]*dag.DurationStats
+	if *taskDbUrl != "" {
+		d, err := remote_db.NewClient(*taskDbUrl, httputils.NewTimeoutClient())
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		now := time.Now()
+		tasks, err := d.GetTasksFromDateRange(now.Add(-*history), now)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		repoTasks := make([]*db.Task, 0, len(tasks))
+		for _, t := range tasks {
+			if t.Repo == *repo {
+				repoTasks = append(repoTasks, t)
+			}
+		}
+		durations = dag.GetDurationStats(repoTasks)
+	}
+
+	rs := db.RepoState{
+		Repo:     *repo,
+		Revision: *revision,
+	}
+	d, err := dag.New(cfg, rs, *job, durations)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	if *format == dag.FORMAT_DOT {
+		err = d.WriteDOT(os.Stdout)
+	} else {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(d)
+	}
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	sklog.Infof("Critical path (%s): %v", d.Duration, d.CriticalPath)
+}
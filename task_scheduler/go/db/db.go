@@ -364,7 +364,8 @@ func matchTasks(tasks []*Task, p *TaskSearchParams) []*Task {
 			searchStringEqual(p.Revision, t.Revision) &&
 			searchStringEqual(p.Name, t.Name) &&
 			searchStringEqual(string(p.Status), string(t.Status)) &&
-			searchStringEqual(p.ForcedJobId, t.ForcedJobId) {
+			searchStringEqual(p.ForcedJobId, t.ForcedJobId) &&
+			searchBoolEqual(p.Flaky, t.Flaky) {
 			rv = append(rv, t)
 		}
 	}
@@ -376,6 +377,7 @@ func matchTasks(tasks []*Task, p *TaskSearchParams) []*Task {
 // any value for that field. If either of TimeStart or TimeEnd is not provided,
 // the search defaults to the last 24 hours.
 type TaskSearchParams struct {
+	Flaky  *bool      `json:"flaky,omitempty"`
 	Status TaskStatus `json:"status"`
 	TaskKey
 	TimeStart time.Time `json:"time_start"`
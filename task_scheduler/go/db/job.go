@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
 )
 
 const (
@@ -114,6 +115,16 @@ type Job struct {
 	// for this Job, or zero if the job is new.
 	DbModified time.Time `json:"dbModified"`
 
+	// Deadline is the time by which the Job is expected to finish, or
+	// zero if the Job has no deadline. This property should never change
+	// for a given Job instance.
+	Deadline time.Time `json:"deadline"`
+
+	// DeadlineExceeded indicates that the Job was still running or finished
+	// after its Deadline. The Job keeps running; this only records the
+	// missed deadline.
+	DeadlineExceeded bool `json:"deadlineExceeded"`
+
 	// Dependencies maps out the DAG of TaskSpec names upon which this Job
 	// depends. Keys are TaskSpec names and values are slices of TaskSpec
 	// names indicating which TaskSpecs that TaskSpec depends on. This
@@ -144,6 +155,12 @@ type Job struct {
 	// RepoState is the current state of the repository for this Job.
 	RepoState
 
+	// SkippedTaskSpecs are the names of TaskSpecs which were omitted from
+	// the Job because the RepoState did not change any files matching
+	// their path filters. This property should never change for a given
+	// Job instance.
+	SkippedTaskSpecs []string `json:"skippedTaskSpecs"`
+
 	// Status is the current Job status, default JOB_STATUS_IN_PROGRESS.
 	Status JobStatus `json:"status"`
 
@@ -180,6 +197,8 @@ func (j *Job) Copy() *Job {
 		BuildbucketLeaseKey: j.BuildbucketLeaseKey,
 		Created:             j.Created,
 		DbModified:          j.DbModified,
+		Deadline:            j.Deadline,
+		DeadlineExceeded:    j.DeadlineExceeded,
 		Dependencies:        deps,
 		Finished:            j.Finished,
 		Id:                  j.Id,
@@ -187,6 +206,7 @@ func (j *Job) Copy() *Job {
 		Name:                j.Name,
 		Priority:            j.Priority,
 		RepoState:           j.RepoState.Copy(),
+		SkippedTaskSpecs:    util.CopyStringSlice(j.SkippedTaskSpecs),
 		Status:              j.Status,
 		Tasks:               tasks,
 	}
@@ -196,6 +216,24 @@ func (j *Job) Done() bool {
 	return j.Status != JOB_STATUS_IN_PROGRESS
 }
 
+// MetDeadline returns true iff the Job has a Deadline and finished before it.
+func (j *Job) MetDeadline() bool {
+	return !util.TimeIsZero(j.Deadline) && j.Done() && !j.Finished.After(j.Deadline)
+}
+
+// PastDeadline returns true iff the Job has a Deadline which it did not meet
+// as of the given time, ie. it finished after the Deadline or is still
+// running after it.
+func (j *Job) PastDeadline(now time.Time) bool {
+	if util.TimeIsZero(j.Deadline) {
+		return false
+	}
+	if j.Done() {
+		return j.Finished.After(j.Deadline)
+	}
+	return now.After(j.Deadline)
+}
+
 // MakeTaskKey returns a TaskKey for the given Task name.
 func (j *Job) MakeTaskKey(taskName string) TaskKey {
 	rv := TaskKey{
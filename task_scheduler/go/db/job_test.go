@@ -20,6 +20,8 @@ func makeFullJob(now time.Time) *Job {
 		BuildbucketLeaseKey: 987,
 		Created:             now.Add(time.Nanosecond),
 		DbModified:          now.Add(time.Millisecond),
+		Deadline:            now.Add(time.Hour),
+		DeadlineExceeded:    true,
 		Dependencies:        map[string][]string{"A": {"B"}, "B": {}},
 		Finished:            now.Add(time.Second),
 		Id:                  "abc123",
@@ -29,7 +31,8 @@ func makeFullJob(now time.Time) *Job {
 		RepoState: RepoState{
 			Repo: DEFAULT_TEST_REPO,
 		},
-		Status: JOB_STATUS_SUCCESS,
+		SkippedTaskSpecs: []string{"D"},
+		Status:           JOB_STATUS_SUCCESS,
 		Tasks: map[string][]*TaskSummary{
 			"task-name": {&TaskSummary{
 				Id:             "12345",
@@ -189,3 +189,27 @@ func TestJobSearchParamsJson(t *testing.T) {
 	*p.IsForce = false
 	deepequal.AssertDeepEqual(t, p, decode(`{"is_force": false}`))
 }
+
+func TestSearchTasksFlaky(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Now()
+	t1 := MakeTestTask(now, []string{"a"})
+	t1.Id = "t1"
+	t1.Status = TASK_STATUS_FAILURE
+	t1.Flaky = true
+	t2 := MakeTestTask(now, []string{"b"})
+	t2.Id = "t2"
+	t2.Status = TASK_STATUS_FAILURE
+	tasks := []*Task{t1, t2}
+
+	p := &TaskSearchParams{
+		TimeStart: now.Add(-time.Hour),
+		TimeEnd:   now.Add(time.Hour),
+	}
+	deepequal.AssertDeepEqual(t, tasks, matchTasks(tasks, p))
+	p.Flaky = new(bool)
+	deepequal.AssertDeepEqual(t, []*Task{t2}, matchTasks(tasks, p))
+	*p.Flaky = true
+	deepequal.AssertDeepEqual(t, []*Task{t1}, matchTasks(tasks, p))
+}
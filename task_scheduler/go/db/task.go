@@ -107,6 +107,10 @@ type Task struct {
 	// Attempt is the attempt number of this task, starting with zero.
 	Attempt int `json:"attempt"`
 
+	// CachedFrom is the ID of the Task whose results were reused for this
+	// Task, or empty if this Task was not a result cache hit.
+	CachedFrom string `json:"cachedFrom"`
+
 	// Commits are the commits which were tested in this Task. The list may
 	// change due to backfilling/bisecting.
 	Commits []string `json:"commits"`
@@ -123,6 +127,10 @@ type Task struct {
 	// zero if the task is pending or running.
 	Finished time.Time `json:"finished"`
 
+	// Flaky indicates that this Task failed but a retry of it at the same
+	// RepoState succeeded.
+	Flaky bool `json:"flaky"`
+
 	// Id is a generated unique identifier for this Task instance. Must be
 	// URL-safe.
 	Id string `json:"id"`
@@ -146,6 +154,11 @@ type Task struct {
 	// base64 encoding for binary data.
 	Properties map[string]string `json:"properties"`
 
+	// ResultCacheKey identifies the inputs of this Task for the purpose of
+	// reusing its results. It is only set for Tasks whose TaskSpec has
+	// CacheResults set.
+	ResultCacheKey string `json:"resultCacheKey"`
+
 	// RetryOf is the ID of the task which this task is a retry of, if any.
 	RetryOf string `json:"retryOf"`
 
@@ -384,16 +397,19 @@ func (t *Task) Success() bool {
 func (t *Task) Copy() *Task {
 	return &Task{
 		Attempt:        t.Attempt,
+		CachedFrom:     t.CachedFrom,
 		Commits:        util.CopyStringSlice(t.Commits),
 		Created:        t.Created,
 		DbModified:     t.DbModified,
 		Finished:       t.Finished,
+		Flaky:          t.Flaky,
 		Id:             t.Id,
 		IsolatedOutput: t.IsolatedOutput,
 		Jobs:           util.CopyStringSlice(t.Jobs),
 		MaxAttempts:    t.MaxAttempts,
 		ParentTaskIds:  util.CopyStringSlice(t.ParentTaskIds),
 		Properties:     util.CopyStringMap(t.Properties),
+		ResultCacheKey: t.ResultCacheKey,
 		RetryOf:        t.RetryOf,
 		Started:        t.Started,
 		Status:         t.Status,
@@ -408,7 +424,9 @@ func (task *Task) Validate() error {
 	if !task.TaskKey.Valid() {
 		return fmt.Errorf("TaskKey is not valid.")
 	}
-	if task.Fake() && !(task.IsolatedOutput == "" && task.SwarmingBotId == "" && task.SwarmingTaskId == "") {
+	// Result cache hits are fake tasks which reuse the isolated output of
+	// a previous task.
+	if task.Fake() && !((task.IsolatedOutput == "" || task.CachedFrom != "") && task.SwarmingBotId == "" && task.SwarmingTaskId == "") {
 		return fmt.Errorf("Can not specify Swarming info for a fake task.")
 	}
 	for key, value := range task.Properties {
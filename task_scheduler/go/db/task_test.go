@@ -654,10 +654,12 @@ func TestCopyTask(t *testing.T) {
 	now := time.Now()
 	v := &Task{
 		Attempt:        3,
+		CachedFrom:     "37",
 		Commits:        []string{"a", "b"},
 		Created:        now.Add(time.Nanosecond),
 		DbModified:     now.Add(time.Millisecond),
 		Finished:       now.Add(time.Second),
+		Flaky:          true,
 		Id:             "42",
 		IsolatedOutput: "lonely-result",
 		Jobs:           []string{"123abc", "456def"},
@@ -667,6 +669,7 @@ func TestCopyTask(t *testing.T) {
 			"color":   "blue",
 			"awesome": "true",
 		},
+		ResultCacheKey: "abc123",
 		RetryOf:        "41",
 		Started:        now.Add(time.Minute),
 		Status:         TASK_STATUS_MISHAP,
@@ -732,6 +735,12 @@ func TestValidateTask(t *testing.T) {
 	err := tmpl.Validate()
 	assert.NoError(t, err)
 	assert.True(t, tmpl.Valid())
+
+	// Result cache hits may have isolated outputs.
+	cached := tmpl.Copy()
+	cached.CachedFrom = "abc"
+	cached.IsolatedOutput = "loneliness"
+	assert.NoError(t, cached.Validate())
 }
 
 // Test that sort.Sort(TaskSlice(...)) works correctly.
@@ -0,0 +1,150 @@
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/swarming"
+)
+
+// Backend is the interface through which the TaskScheduler runs tasks. The
+// Swarming API types are used throughout so that the results of any Backend
+// can be used to update db.Tasks via db.UpdateDBFromSwarmingTask.
+type Backend interface {
+	// GetFreeBots returns the bots in the given pools which are available
+	// to run tasks.
+	GetFreeBots(pools []string) ([]*swarming_api.SwarmingRpcsBotInfo, error)
+
+	// IsolateTasks uploads the inputs for the given tasks and returns the
+	// isolated hash for each, in the same order.
+	IsolateTasks(ctx context.Context, tasks []*isolate.Task) ([]string, error)
+
+	// IsolateServerURL returns the URL of the server which holds the
+	// inputs returned by IsolateTasks.
+	IsolateServerURL() string
+
+	// TriggerTask triggers a task with the given request.
+	TriggerTask(req *swarming_api.SwarmingRpcsNewTaskRequest) (*swarming_api.SwarmingRpcsTaskRequestMetadata, error)
+
+	// CancelTask cancels the task with the given ID.
+	CancelTask(id string) error
+
+	// GetTaskResult returns the current state of the task with the given
+	// ID.
+	GetTaskResult(id string) (*swarming_api.SwarmingRpcsTaskResult, error)
+}
+
+// swarmingBackend is a Backend which runs tasks on Swarming, with inputs
+// uploaded to Isolate.
+type swarmingBackend struct {
+	busyBots *busyBots
+	isolate  *isolate.Client
+	swarming swarming.ApiClient
+}
+
+// NewSwarmingBackend returns a Backend which runs tasks on Swarming.
+func NewSwarmingBackend(isolateClient *isolate.Client, swarmingClient swarming.ApiClient) Backend {
+	return &swarmingBackend{
+		busyBots: newBusyBots(),
+		isolate:  isolateClient,
+		swarming: swarmingClient,
+	}
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) GetFreeBots(pools []string) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
+	return getFreeSwarmingBots(b.swarming, b.busyBots, pools)
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) IsolateTasks(ctx context.Context, tasks []*isolate.Task) ([]string, error) {
+	return b.isolate.IsolateTasks(ctx, tasks)
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) IsolateServerURL() string {
+	return b.isolate.ServerURL()
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) TriggerTask(req *swarming_api.SwarmingRpcsNewTaskRequest) (*swarming_api.SwarmingRpcsTaskRequestMetadata, error) {
+	return b.swarming.TriggerTask(req)
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) CancelTask(id string) error {
+	return b.swarming.CancelTask(id)
+}
+
+// See documentation for Backend interface.
+func (b *swarmingBackend) GetTaskResult(id string) (*swarming_api.SwarmingRpcsTaskResult, error) {
+	return b.swarming.GetTask(id, false)
+}
+
+// getFreeSwarmingBots returns a slice of free swarming bots.
+func getFreeSwarmingBots(s swarming.ApiClient, busy *busyBots, pools []string) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
+	defer metrics2.FuncTimer().Stop()
+
+	// Query for free Swarming bots and pending Swarming tasks in all pools.
+	var wg sync.WaitGroup
+	bots := []*swarming_api.SwarmingRpcsBotInfo{}
+	pending := []*swarming_api.SwarmingRpcsTaskResult{}
+	errs := []error{}
+	var mtx sync.Mutex
+	t := time.Time{}
+	for _, pool := range pools {
+		// Free bots.
+		wg.Add(1)
+		go func(pool string) {
+			defer wg.Done()
+			b, err := s.ListFreeBots(pool)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				bots = append(bots, b...)
+			}
+		}(pool)
+
+		// Pending tasks.
+		wg.Add(1)
+		go func(pool string) {
+			defer wg.Done()
+			t, err := s.ListTaskResults(t, t, []string{fmt.Sprintf("pool:%s", pool)}, "PENDING", false)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+			} else {
+				pending = append(pending, t...)
+			}
+		}(pool)
+	}
+
+	wg.Wait()
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("Got errors loading bots and tasks from Swarming: %v", errs)
+	}
+
+	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
+	for _, bot := range bots {
+		if bot.IsDead {
+			continue
+		}
+		if bot.Quarantined {
+			continue
+		}
+		if bot.TaskId != "" {
+			continue
+		}
+		rv = append(rv, bot)
+	}
+	busy.RefreshTasks(pending)
+	return busy.Filter(rv), nil
+}
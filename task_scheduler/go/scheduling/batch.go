@@ -0,0 +1,224 @@
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Actions which may be performed by BatchJobs.
+	BATCH_ACTION_CANCEL    = "cancel"
+	BATCH_ACTION_RETRIGGER = "retrigger"
+)
+
+// BatchJobRequest describes a set of Jobs and an action to perform on all of
+// them.
+type BatchJobRequest struct {
+	// Action is one of BATCH_ACTION_CANCEL or BATCH_ACTION_RETRIGGER.
+	Action string `json:"action"`
+	// Search selects the Jobs to act on. At least one of Search and
+	// Commits must select something. Unless Commits or a Revision are
+	// given, the time range of the search is required; otherwise it
+	// defaults to the time of the earliest selected commit until now.
+	Search db.JobSearchParams `json:"search"`
+	// Commits, if provided, must contain exactly two commits in the same
+	// repo. Only Jobs at commits in the range are selected. As with
+	// blacklist rules, the range includes the first commit and excludes
+	// the second.
+	Commits []string `json:"commits,omitempty"`
+	// If DryRun is true, no changes are made; the result indicates which
+	// Jobs would be affected.
+	DryRun bool `json:"dry_run"`
+	// User and Message are recorded in a CommitComment on each affected
+	// commit.
+	User    string `json:"user"`
+	Message string `json:"message"`
+}
+
+// BatchJobResult describes the outcome of a BatchJobRequest.
+type BatchJobResult struct {
+	// Jobs which were (or, for a dry run, would be) canceled or
+	// re-triggered.
+	Jobs []*db.Job `json:"jobs"`
+	// Triggered contains the IDs of the new Jobs created when
+	// re-triggering.
+	Triggered []string `json:"triggered,omitempty"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+// commitsInRange returns the repo containing the given commits, the set of
+// commits in the range, including start and excluding end, and the earliest
+// timestamp of any commit in the range.
+func (s *TaskScheduler) commitsInRange(ctx context.Context, start, end string) (string, map[string]bool, time.Time, error) {
+	startCommit, repoName, repo, err := s.repos.FindCommit(start)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	_, repo2, _, err := s.repos.FindCommit(end)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	if repo2 != repoName {
+		return "", nil, time.Time{}, fmt.Errorf("Commit %s is in a different repo (%s) from %s (%s)", end, repo2, start, repoName)
+	}
+	commits, err := repo.Repo().RevList(ctx, fmt.Sprintf("%s..%s", start, end))
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+	rv := make(map[string]bool, len(commits)+1)
+	earliest := startCommit.Timestamp
+	for _, c := range commits {
+		rv[c] = true
+		if commit := repo.Get(c); commit != nil && commit.Timestamp.Before(earliest) {
+			earliest = commit.Timestamp
+		}
+	}
+	rv[start] = true
+	delete(rv, end)
+	return repoName, rv, earliest, nil
+}
+
+// findBatchJobs returns the Jobs matched by the given BatchJobRequest which
+// its action applies to, sorted by creation time.
+func (s *TaskScheduler) findBatchJobs(ctx context.Context, req *BatchJobRequest) ([]*db.Job, error) {
+	var commits map[string]bool
+	var earliest time.Time
+	params := req.Search
+	if len(req.Commits) > 0 {
+		if len(req.Commits) != 2 {
+			return nil, fmt.Errorf("Commit range must contain exactly two commits, not %d", len(req.Commits))
+		}
+		repoName, c, ts, err := s.commitsInRange(ctx, req.Commits[0], req.Commits[1])
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve commit range: %s", err)
+		}
+		if params.Repo != "" && params.Repo != repoName {
+			return nil, fmt.Errorf("Commit range is in %s, not %s", repoName, params.Repo)
+		}
+		params.Repo = repoName
+		commits = c
+		earliest = ts
+	} else if params.RepoState == (db.RepoState{}) && params.BuildbucketBuildId == 0 && params.IsForce == nil && params.Name == "" && params.Status == "" {
+		return nil, fmt.Errorf("Request must select jobs by commits or search parameters.")
+	} else if params.Revision != "" {
+		c, _, _, err := s.repos.FindCommit(params.Revision)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to resolve revision: %s", err)
+		}
+		earliest = c.Timestamp
+	}
+
+	// Don't rely on the default time range of db.SearchJobs, which only
+	// covers the last 24 hours.
+	if util.TimeIsZero(params.TimeStart) {
+		if util.TimeIsZero(earliest) {
+			return nil, fmt.Errorf("Search must specify time_start unless jobs are selected by commits or revision.")
+		}
+		params.TimeStart = earliest
+	}
+	if util.TimeIsZero(params.TimeEnd) {
+		params.TimeEnd = time.Now()
+	}
+	jobs, err := db.SearchJobs(s.db, &params)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]*db.Job, 0, len(jobs))
+	for _, j := range jobs {
+		if commits != nil && !commits[j.Revision] {
+			continue
+		}
+		// Only unfinished Jobs can be canceled, and only Jobs which
+		// are not try jobs can be re-triggered.
+		if req.Action == BATCH_ACTION_CANCEL && j.Done() {
+			continue
+		}
+		if req.Action == BATCH_ACTION_RETRIGGER && j.IsTryJob() {
+			continue
+		}
+		rv = append(rv, j)
+	}
+	sort.Sort(db.JobSlice(rv))
+	return rv, nil
+}
+
+// BatchJobs cancels or re-triggers all Jobs matching the given request. If
+// the request is not a dry run, a CommitComment recording the action is
+// added to each affected commit.
+func (s *TaskScheduler) BatchJobs(ctx context.Context, req *BatchJobRequest) (*BatchJobResult, error) {
+	defer metrics2.FuncTimer().Stop()
+	if req.Action != BATCH_ACTION_CANCEL && req.Action != BATCH_ACTION_RETRIGGER {
+		return nil, fmt.Errorf("Invalid action %q", req.Action)
+	}
+	if !req.DryRun && (req.User == "" || req.Message == "") {
+		return nil, fmt.Errorf("User and Message are required.")
+	}
+	jobs, err := s.findBatchJobs(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	rv := &BatchJobResult{
+		Jobs:   jobs,
+		DryRun: req.DryRun,
+	}
+	if req.DryRun || len(jobs) == 0 {
+		return rv, nil
+	}
+
+	var verb string
+	if req.Action == BATCH_ACTION_CANCEL {
+		verb = "Canceled"
+		if err := s.cancelJobs(jobs); err != nil {
+			return nil, fmt.Errorf("Failed to cancel jobs: %s", err)
+		}
+	} else {
+		verb = "Re-triggered"
+		seen := make(map[db.TaskKey]bool, len(jobs))
+		for _, j := range jobs {
+			k := db.TaskKey{
+				RepoState: db.RepoState{
+					Repo:     j.Repo,
+					Revision: j.Revision,
+				},
+				Name: j.Name,
+			}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+			id, err := s.TriggerJob(ctx, j.Repo, j.Revision, j.Name)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to re-trigger %s at %s: %s", j.Name, j.Revision, err)
+			}
+			rv.Triggered = append(rv.Triggered, id)
+		}
+	}
+	sklog.Infof("%s %d jobs on behalf of %s: %s", verb, len(jobs), req.User, req.Message)
+
+	// Record the action on each affected commit.
+	byCommit := map[db.RepoState]int{}
+	for _, j := range jobs {
+		byCommit[db.RepoState{Repo: j.Repo, Revision: j.Revision}]++
+	}
+	now := time.Now()
+	for rs, count := range byCommit {
+		c := &db.CommitComment{
+			Repo:      rs.Repo,
+			Revision:  rs.Revision,
+			Timestamp: now,
+			User:      req.User,
+			Message:   fmt.Sprintf("%s %d job(s): %s", verb, count, req.Message),
+		}
+		if err := s.db.PutCommitComment(c); err != nil {
+			return nil, fmt.Errorf("Failed to add audit comment: %s", err)
+		}
+	}
+	return rv, nil
+}
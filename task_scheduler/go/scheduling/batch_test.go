@@ -0,0 +1,121 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestBatchJobs(t *testing.T) {
+	ctx, gb, d, swarmingClient, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	rs2 := getRS2(t, ctx, gb)
+	assert.NoError(t, s.gatherNewJobs(ctx))
+	jobs, err := s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(jobs)) // c1 has 2 jobs, c2 has 3 jobs.
+
+	// Invalid requests.
+	_, err = s.BatchJobs(ctx, &BatchJobRequest{Action: "bogus", DryRun: true})
+	assert.EqualError(t, err, "Invalid action \"bogus\"")
+	_, err = s.BatchJobs(ctx, &BatchJobRequest{Action: BATCH_ACTION_CANCEL})
+	assert.EqualError(t, err, "User and Message are required.")
+	_, err = s.BatchJobs(ctx, &BatchJobRequest{Action: BATCH_ACTION_CANCEL, DryRun: true, Commits: []string{rs1.Revision}})
+	assert.EqualError(t, err, "Commit range must contain exactly two commits, not 1")
+	_, err = s.BatchJobs(ctx, &BatchJobRequest{Action: BATCH_ACTION_CANCEL, DryRun: true})
+	assert.EqualError(t, err, "Request must select jobs by commits or search parameters.")
+	_, err = s.BatchJobs(ctx, &BatchJobRequest{Action: BATCH_ACTION_CANCEL, DryRun: true, Search: db.JobSearchParams{Name: "bogus"}})
+	assert.EqualError(t, err, "Search must specify time_start unless jobs are selected by commits or revision.")
+
+	// Dry run of a cancellation covering the first commit.
+	req := &BatchJobRequest{
+		Action:  BATCH_ACTION_CANCEL,
+		Commits: []string{rs1.Revision, rs2.Revision},
+		DryRun:  true,
+		User:    "me@google.com",
+		Message: "Bad commit",
+	}
+	res, err := s.BatchJobs(ctx, req)
+	assert.NoError(t, err)
+	assert.True(t, res.DryRun)
+	assert.Equal(t, 2, len(res.Jobs))
+	for _, j := range res.Jobs {
+		assert.Equal(t, rs1.Revision, j.Revision)
+	}
+	jobs, err = s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 5, len(jobs))
+	comments, err := d.GetCommentsForRepos([]string{rs1.Repo}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(comments[0].CommitComments))
+
+	// Add a running task for the Jobs at the first commit.
+	task := makeTask(specs_testutils.BuildTask, rs1.Repo, rs1.Revision)
+	task.Status = db.TASK_STATUS_RUNNING
+	task.SwarmingTaskId = "swarming-task"
+	assert.NoError(t, d.PutTask(task))
+	assert.NoError(t, s.tCache.Update())
+	assert.NoError(t, s.updateUnfinishedJobs())
+	assert.NoError(t, s.tCache.Update())
+	assert.NoError(t, s.jCache.Update())
+
+	// Actually cancel the Jobs.
+	req.DryRun = false
+	res, err = s.BatchJobs(ctx, req)
+	assert.NoError(t, err)
+	assert.False(t, res.DryRun)
+	assert.Equal(t, 2, len(res.Jobs))
+	for _, j := range res.Jobs {
+		got, err := d.GetJobById(j.Id)
+		assert.NoError(t, err)
+		assert.Equal(t, db.JOB_STATUS_CANCELED, got.Status)
+		assert.False(t, got.Finished.IsZero())
+	}
+	jobs, err = s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 3, len(jobs))
+	// The task is no longer needed by any Job, so it was canceled.
+	assert.Equal(t, []string{task.SwarmingTaskId}, swarmingClient.CanceledTasks())
+	comments, err = d.GetCommentsForRepos([]string{rs1.Repo}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(comments[0].CommitComments[rs1.Revision]))
+	c := comments[0].CommitComments[rs1.Revision][0]
+	assert.Equal(t, "me@google.com", c.User)
+	assert.Equal(t, "Canceled 2 job(s): Bad commit", c.Message)
+
+	// The canceled Jobs are no longer matched for cancellation.
+	req.DryRun = true
+	res, err = s.BatchJobs(ctx, req)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(res.Jobs))
+
+	// Re-trigger the canceled Jobs.
+	res, err = s.BatchJobs(ctx, &BatchJobRequest{
+		Action: BATCH_ACTION_RETRIGGER,
+		Search: db.JobSearchParams{
+			RepoState: rs1,
+			Status:    db.JOB_STATUS_CANCELED,
+		},
+		User:    "me@google.com",
+		Message: "Fixed",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(res.Jobs))
+	assert.Equal(t, 2, len(res.Triggered))
+	for _, id := range res.Triggered {
+		j, err := d.GetJobById(id)
+		assert.NoError(t, err)
+		assert.True(t, j.IsForce)
+		assert.Equal(t, rs1.Revision, j.Revision)
+		assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, j.Status)
+	}
+	comments, err = d.GetCommentsForRepos([]string{rs1.Repo}, time.Time{})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(comments[0].CommitComments[rs1.Revision]))
+	assert.Equal(t, "Re-triggered 2 job(s): Fixed", comments[0].CommitComments[rs1.Revision][1].Message)
+}
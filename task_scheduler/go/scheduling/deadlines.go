@@ -0,0 +1,140 @@
+package scheduling
+
+import (
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Measurement name for the fraction of finished Jobs with deadlines
+	// which met them, by repo and Job name.
+	MEASUREMENT_JOB_SLO_MET = "job_slo_met"
+
+	// Task candidates for Jobs which have used at least this fraction of
+	// the time before their deadlines are prioritized.
+	CANDIDATE_DEADLINE_BOOST_START = 0.5
+
+	// Scores of task candidates are multiplied by up to this much as their
+	// Jobs reach their deadlines.
+	CANDIDATE_DEADLINE_BOOST_MAX = 10.0
+)
+
+// deadlineMultiplier returns the factor by which to multiply the score of a
+// task candidate whose most urgent Job has the given deadline and was given
+// the given amount of time to finish. The factor increases linearly from one,
+// once CANDIDATE_DEADLINE_BOOST_START of the time has elapsed, to
+// CANDIDATE_DEADLINE_BOOST_MAX at the deadline.
+func deadlineMultiplier(now, deadline time.Time, allowed time.Duration) float64 {
+	if util.TimeIsZero(deadline) || allowed <= 0 {
+		return 1.0
+	}
+	elapsed := 1.0 - float64(deadline.Sub(now))/float64(allowed)
+	if elapsed <= CANDIDATE_DEADLINE_BOOST_START {
+		return 1.0
+	}
+	if elapsed > 1.0 {
+		elapsed = 1.0
+	}
+	return 1.0 + (CANDIDATE_DEADLINE_BOOST_MAX-1.0)*(elapsed-CANDIDATE_DEADLINE_BOOST_START)/(1.0-CANDIDATE_DEADLINE_BOOST_START)
+}
+
+// JobSLO describes how often Jobs with a given name in a given repo finished
+// before their deadlines.
+type JobSLO struct {
+	Repo     string `json:"repo"`
+	Name     string `json:"name"`
+	Finished int    `json:"finished"`
+	Met      int    `json:"met"`
+}
+
+// Rate returns the fraction of finished Jobs which met their deadlines.
+func (s *JobSLO) Rate() float64 {
+	if s.Finished == 0 {
+		return 0.0
+	}
+	return float64(s.Met) / float64(s.Finished)
+}
+
+// jobSLOKey is used for grouping Jobs when computing SLOs.
+type jobSLOKey struct {
+	repo string
+	name string
+}
+
+// computeJobSLOs returns the JobSLO for each repo and Job name in the given
+// Jobs, sorted by repo and name. Only finished Jobs with deadlines are
+// counted; canceled Jobs are ignored.
+func computeJobSLOs(jobs []*db.Job) []*JobSLO {
+	slos := map[jobSLOKey]*JobSLO{}
+	for _, j := range jobs {
+		if util.TimeIsZero(j.Deadline) || !j.Done() || j.Status == db.JOB_STATUS_CANCELED {
+			continue
+		}
+		k := jobSLOKey{repo: j.Repo, name: j.Name}
+		slo, ok := slos[k]
+		if !ok {
+			slo = &JobSLO{
+				Repo: j.Repo,
+				Name: j.Name,
+			}
+			slos[k] = slo
+		}
+		slo.Finished++
+		if j.MetDeadline() {
+			slo.Met++
+		}
+	}
+	rv := make([]*JobSLO, 0, len(slos))
+	for _, slo := range slos {
+		rv = append(rv, slo)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Repo != rv[j].Repo {
+			return rv[i].Repo < rv[j].Repo
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	return rv
+}
+
+// updateJobSLOs computes the fraction of Jobs which met their deadlines over
+// the scheduling window and reports them as metrics.
+func (s *TaskScheduler) updateJobSLOs(now time.Time) error {
+	defer metrics2.FuncTimer().Stop()
+	jobs, err := s.db.GetJobsFromDateRange(s.window.EarliestStart(), now)
+	if err != nil {
+		return err
+	}
+	slos := computeJobSLOs(jobs)
+
+	s.sloMtx.Lock()
+	defer s.sloMtx.Unlock()
+	seen := make(map[jobSLOKey]bool, len(slos))
+	for _, slo := range slos {
+		k := jobSLOKey{repo: slo.Repo, name: slo.Name}
+		seen[k] = true
+		metric, ok := s.sloMetrics[k]
+		if !ok {
+			metric = metrics2.GetFloat64Metric(MEASUREMENT_JOB_SLO_MET, map[string]string{
+				"repo":     slo.Repo,
+				"job_name": slo.Name,
+			})
+			s.sloMetrics[k] = metric
+		}
+		metric.Update(slo.Rate())
+	}
+	for k, metric := range s.sloMetrics {
+		if !seen[k] {
+			if err := metric.Delete(); err != nil {
+				sklog.Errorf("Failed to delete job SLO metric: %s", err)
+			}
+			delete(s.sloMetrics, k)
+		}
+	}
+	return nil
+}
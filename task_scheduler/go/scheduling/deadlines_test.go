@@ -0,0 +1,150 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/deepequal"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestDeadlineMultiplier(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Now()
+	check := func(expect float64, remaining, allowed time.Duration) {
+		assert.InDelta(t, expect, deadlineMultiplier(now, now.Add(remaining), allowed), 0.0001)
+	}
+
+	// No deadline.
+	assert.Equal(t, 1.0, deadlineMultiplier(now, time.Time{}, time.Hour))
+	check(1.0, time.Hour, 0)
+
+	// Not yet boosted.
+	check(1.0, 4*time.Hour, 4*time.Hour)
+	check(1.0, 2*time.Hour, 4*time.Hour)
+
+	// Boost increases linearly up to the deadline.
+	check(1.0+(CANDIDATE_DEADLINE_BOOST_MAX-1.0)/2.0, time.Hour, 4*time.Hour)
+	check(CANDIDATE_DEADLINE_BOOST_MAX, 0, 4*time.Hour)
+	check(CANDIDATE_DEADLINE_BOOST_MAX, -time.Hour, 4*time.Hour)
+}
+
+func TestComputeJobSLOs(t *testing.T) {
+	testutils.SmallTest(t)
+
+	now := time.Now()
+	job := func(repo, name string, status db.JobStatus, deadline, finished time.Duration) *db.Job {
+		j := &db.Job{
+			Created: now,
+			Name:    name,
+			RepoState: db.RepoState{
+				Repo:     repo,
+				Revision: "abc123",
+			},
+			Status: status,
+		}
+		if deadline != 0 {
+			j.Deadline = now.Add(deadline)
+		}
+		if status != db.JOB_STATUS_IN_PROGRESS {
+			j.Finished = now.Add(finished)
+		}
+		return j
+	}
+	met := job("a.git", "a", db.JOB_STATUS_SUCCESS, time.Hour, 30*time.Minute)
+	assert.True(t, met.MetDeadline())
+	late := job("a.git", "a", db.JOB_STATUS_FAILURE, time.Hour, 2*time.Hour)
+	assert.False(t, late.MetDeadline())
+	lateSuccess := job("a.git", "a", db.JOB_STATUS_SUCCESS, time.Hour, 2*time.Hour)
+	assert.False(t, lateSuccess.MetDeadline())
+	assert.True(t, lateSuccess.PastDeadline(now))
+	running := job("a.git", "a", db.JOB_STATUS_IN_PROGRESS, time.Hour, 0)
+	assert.False(t, running.MetDeadline())
+	assert.False(t, running.PastDeadline(now))
+	assert.True(t, running.PastDeadline(now.Add(2*time.Hour)))
+	slos := computeJobSLOs([]*db.Job{
+		job("b.git", "a", db.JOB_STATUS_MISHAP, time.Hour, time.Minute),
+		met,
+		late,
+		lateSuccess,
+		job("a.git", "a", db.JOB_STATUS_SUCCESS, time.Hour, time.Hour),
+		running,
+		job("a.git", "a", db.JOB_STATUS_CANCELED, time.Hour, time.Minute),
+		job("a.git", "b", db.JOB_STATUS_SUCCESS, 0, time.Minute),
+	})
+	deepequal.AssertDeepEqual(t, []*JobSLO{
+		{Repo: "a.git", Name: "a", Finished: 4, Met: 2},
+		{Repo: "b.git", Name: "a", Finished: 1, Met: 1},
+	}, slos)
+	assert.Equal(t, 0.5, slos[0].Rate())
+	assert.Equal(t, 1.0, slos[1].Rate())
+	assert.Equal(t, 0.0, (&JobSLO{}).Rate())
+}
+
+func TestJobDeadlineExceeded(t *testing.T) {
+	ctx, gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	j1, err := s.taskCfgCache.MakeJob(ctx, rs1, specs_testutils.BuildTask)
+	assert.NoError(t, err)
+	j1.Deadline = time.Now().Add(time.Hour)
+	j2, err := s.taskCfgCache.MakeJob(ctx, rs1, specs_testutils.TestTask)
+	assert.NoError(t, err)
+	j2.Deadline = time.Now().Add(-time.Minute)
+	assert.NoError(t, d.PutJobs([]*db.Job{j1, j2}))
+	assert.NoError(t, s.jCache.Update())
+
+	// The Job which missed its deadline keeps running.
+	assert.NoError(t, s.updateUnfinishedJobs())
+	j1, err = d.GetJobById(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, j1.Status)
+	assert.False(t, j1.DeadlineExceeded)
+	j2, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_IN_PROGRESS, j2.Status)
+	assert.True(t, j2.DeadlineExceeded)
+	assert.True(t, j2.Finished.IsZero())
+	jobs, err := s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(jobs))
+
+	// Candidates carry the most urgent deadline of their Jobs.
+	candidates, err := s.findTaskCandidatesForJobs(ctx, jobs)
+	assert.NoError(t, err)
+	c, ok := candidates[j1.MakeTaskKey(specs_testutils.BuildTask)]
+	assert.True(t, ok)
+	assert.True(t, j2.Deadline.Equal(c.JobDeadline))
+	assert.Equal(t, j2.Deadline.Sub(j2.Created), c.JobDeadlineAllowed)
+
+	// Both Jobs succeed; only the late one misses its SLO.
+	for _, name := range []string{specs_testutils.BuildTask, specs_testutils.TestTask} {
+		task := makeTask(name, rs1.Repo, rs1.Revision)
+		task.Status = db.TASK_STATUS_SUCCESS
+		assert.NoError(t, d.PutTask(task))
+	}
+	assert.NoError(t, s.tCache.Update())
+	assert.NoError(t, s.updateUnfinishedJobs())
+	j1, err = d.GetJobById(j1.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j1.Status)
+	assert.False(t, j1.DeadlineExceeded)
+	assert.True(t, j1.MetDeadline())
+	j2, err = d.GetJobById(j2.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j2.Status)
+	assert.True(t, j2.DeadlineExceeded)
+	assert.False(t, j2.Finished.IsZero())
+	assert.False(t, j2.MetDeadline())
+	deepequal.AssertDeepEqual(t, []*JobSLO{
+		{Repo: rs1.Repo, Name: specs_testutils.BuildTask, Finished: 1, Met: 1},
+		{Repo: rs1.Repo, Name: specs_testutils.TestTask, Finished: 1, Met: 0},
+	}, computeJobSLOs([]*db.Job{j1, j2}))
+
+	assert.NoError(t, s.updateJobSLOs(time.Now()))
+}
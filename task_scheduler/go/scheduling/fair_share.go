@@ -0,0 +1,373 @@
+package scheduling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+/*
+	Fair-share scheduling.
+
+	Candidates are scored on a single global scale, so a large number of
+	high-scoring candidates of one kind (eg. try jobs or forced jobs) can
+	starve everything else. A FairShareConfig divides candidates into groups
+	by repo, job category and TaskSpec name prefix, and assigns each group a
+	weight. When matching free bots to candidates, the next bot goes to the
+	group whose usage (tasks already running plus tasks triggered so far in
+	this round), divided by its weight, is lowest. Within a group, candidates
+	are still considered in order of decreasing score.
+*/
+
+const (
+	// Job categories used for fair-share scheduling.
+	FAIR_SHARE_CATEGORY_DEFAULT  = "default"
+	FAIR_SHARE_CATEGORY_FORCED   = "forced"
+	FAIR_SHARE_CATEGORY_PERIODIC = "periodic"
+	FAIR_SHARE_CATEGORY_TRY      = "try"
+
+	// Weight given to repos, categories and TaskSpec prefixes which are
+	// not listed in a FairShareConfig.
+	FAIR_SHARE_DEFAULT_WEIGHT = 1.0
+)
+
+var (
+	FAIR_SHARE_CATEGORIES = []string{
+		FAIR_SHARE_CATEGORY_DEFAULT,
+		FAIR_SHARE_CATEGORY_FORCED,
+		FAIR_SHARE_CATEGORY_PERIODIC,
+		FAIR_SHARE_CATEGORY_TRY,
+	}
+)
+
+// FairShareConfig assigns weights to repos, job categories and TaskSpec name
+// prefixes. Each of the maps is optional; tasks are only divided along the
+// dimensions which are configured. The weight of a group is the product of
+// its weights in each dimension. A TaskSpec belongs to the longest matching
+// prefix.
+type FairShareConfig struct {
+	Categories       map[string]float64 `json:"categories,omitempty"`
+	Repos            map[string]float64 `json:"repos,omitempty"`
+	TaskSpecPrefixes map[string]float64 `json:"task_spec_prefixes,omitempty"`
+}
+
+// ReadFairShareConfig reads and validates a FairShareConfig from the given
+// JSON file.
+func ReadFairShareConfig(file string) (*FairShareConfig, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read fair share config: %s", err)
+	}
+	defer util.Close(f)
+	var rv FairShareConfig
+	if err := json.NewDecoder(f).Decode(&rv); err != nil {
+		return nil, fmt.Errorf("Failed to decode fair share config: %s", err)
+	}
+	if err := rv.Validate(); err != nil {
+		return nil, err
+	}
+	return &rv, nil
+}
+
+// Validate returns an error if the FairShareConfig is not valid.
+func (c *FairShareConfig) Validate() error {
+	for cat, w := range c.Categories {
+		if !util.In(cat, FAIR_SHARE_CATEGORIES) {
+			return fmt.Errorf("Unknown fair share category %q; expected one of %v", cat, FAIR_SHARE_CATEGORIES)
+		}
+		if w <= 0.0 {
+			return fmt.Errorf("Fair share weight for category %q must be positive, not %f", cat, w)
+		}
+	}
+	for repo, w := range c.Repos {
+		if w <= 0.0 {
+			return fmt.Errorf("Fair share weight for repo %q must be positive, not %f", repo, w)
+		}
+	}
+	for prefix, w := range c.TaskSpecPrefixes {
+		if prefix == "" {
+			return fmt.Errorf("Fair share TaskSpec prefixes must not be empty.")
+		}
+		if w <= 0.0 {
+			return fmt.Errorf("Fair share weight for TaskSpec prefix %q must be positive, not %f", prefix, w)
+		}
+	}
+	return nil
+}
+
+// fairShareGroup identifies a set of tasks which share bots with other groups.
+// Fields which correspond to unconfigured dimensions are left empty.
+type fairShareGroup struct {
+	Repo           string
+	Category       string
+	TaskSpecPrefix string
+}
+
+// group returns the fairShareGroup for a task with the given properties.
+func (c *FairShareConfig) group(repo, category, taskName string) fairShareGroup {
+	var rv fairShareGroup
+	if len(c.Repos) > 0 {
+		rv.Repo = repo
+	}
+	if len(c.Categories) > 0 {
+		rv.Category = category
+	}
+	for prefix := range c.TaskSpecPrefixes {
+		if strings.HasPrefix(taskName, prefix) && len(prefix) > len(rv.TaskSpecPrefix) {
+			rv.TaskSpecPrefix = prefix
+		}
+	}
+	return rv
+}
+
+// weight returns the weight of the given fairShareGroup.
+func (c *FairShareConfig) weight(g fairShareGroup) float64 {
+	lookup := func(weights map[string]float64, key string) float64 {
+		if w, ok := weights[key]; ok {
+			return w
+		}
+		return FAIR_SHARE_DEFAULT_WEIGHT
+	}
+	return lookup(c.Repos, g.Repo) * lookup(c.Categories, g.Category) * lookup(c.TaskSpecPrefixes, g.TaskSpecPrefix)
+}
+
+// FairShareGroupStatus provides information about a fair-share group as of
+// the most recent scheduling round.
+type FairShareGroupStatus struct {
+	Repo           string  `json:"repo"`
+	Category       string  `json:"category"`
+	TaskSpecPrefix string  `json:"taskSpecPrefix"`
+	Weight         float64 `json:"weight"`
+	// Number of tasks which were pending or running before the round.
+	Running int `json:"running"`
+	// Number of candidates in the queue before the round.
+	Queued int `json:"queued"`
+	// Number of candidates chosen to run during the round.
+	Triggered int `json:"triggered"`
+}
+
+// fairShare tracks the usage of each fair-share group during a scheduling
+// round. A nil *fairShare disables fair-share scheduling.
+type fairShare struct {
+	cfg    *FairShareConfig
+	groups map[fairShareGroup]*FairShareGroupStatus
+}
+
+// newFairShare returns a fairShare instance with the given running tasks.
+// categories contains the job category for each running task.
+func newFairShare(cfg *FairShareConfig, running []*db.Task, categories []string) *fairShare {
+	fs := &fairShare{
+		cfg:    cfg,
+		groups: map[fairShareGroup]*FairShareGroupStatus{},
+	}
+	for i, t := range running {
+		fs.get(t.Repo, categories[i], t.Name).Running++
+	}
+	return fs
+}
+
+// get returns the FairShareGroupStatus for a task with the given properties,
+// creating it if necessary.
+func (fs *fairShare) get(repo, category, taskName string) *FairShareGroupStatus {
+	g := fs.cfg.group(repo, category, taskName)
+	st, ok := fs.groups[g]
+	if !ok {
+		st = &FairShareGroupStatus{
+			Repo:           g.Repo,
+			Category:       g.Category,
+			TaskSpecPrefix: g.TaskSpecPrefix,
+			Weight:         fs.cfg.weight(g),
+		}
+		fs.groups[g] = st
+	}
+	return st
+}
+
+// Status returns the status of each fair-share group, sorted by group.
+func (fs *fairShare) Status() []*FairShareGroupStatus {
+	if fs == nil {
+		return nil
+	}
+	rv := make([]*FairShareGroupStatus, 0, len(fs.groups))
+	for _, st := range fs.groups {
+		cpy := *st
+		rv = append(rv, &cpy)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Repo != rv[j].Repo {
+			return rv[i].Repo < rv[j].Repo
+		}
+		if rv[i].Category != rv[j].Category {
+			return rv[i].Category < rv[j].Category
+		}
+		return rv[i].TaskSpecPrefix < rv[j].TaskSpecPrefix
+	})
+	return rv
+}
+
+// fairShareQueue yields task candidates in the order in which they should be
+// offered free bots.
+type fairShareQueue struct {
+	fs    *fairShare
+	tasks []*taskCandidate
+	// Indexes into tasks of the remaining candidates for each group.
+	byGroup map[*FairShareGroupStatus][]int
+	next    int
+}
+
+// queue returns a fairShareQueue for the given candidates, which are assumed
+// to be sorted in decreasing order by score. If fs is nil, the candidates are
+// yielded in their original order.
+func (fs *fairShare) queue(tasks []*taskCandidate) *fairShareQueue {
+	q := &fairShareQueue{
+		fs:    fs,
+		tasks: tasks,
+	}
+	if fs != nil {
+		q.byGroup = map[*FairShareGroupStatus][]int{}
+		for i, c := range tasks {
+			st := fs.get(c.Repo, c.Category, c.Name)
+			st.Queued++
+			q.byGroup[st] = append(q.byGroup[st], i)
+		}
+	}
+	return q
+}
+
+// Pop returns the next candidate, or nil if there are none left.
+func (q *fairShareQueue) Pop() *taskCandidate {
+	if q.fs == nil {
+		if q.next >= len(q.tasks) {
+			return nil
+		}
+		q.next++
+		return q.tasks[q.next-1]
+	}
+	// Choose the group with the lowest weighted usage. Break ties using
+	// the order of the input, ie. by score.
+	var best *FairShareGroupStatus
+	var bestUsage float64
+	for st, idxs := range q.byGroup {
+		usage := float64(st.Running+st.Triggered) / st.Weight
+		if best == nil || usage < bestUsage || (usage == bestUsage && idxs[0] < q.byGroup[best][0]) {
+			best = st
+			bestUsage = usage
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	idxs := q.byGroup[best]
+	if len(idxs) == 1 {
+		delete(q.byGroup, best)
+	} else {
+		q.byGroup[best] = idxs[1:]
+	}
+	return q.tasks[idxs[0]]
+}
+
+// Triggered records that the given candidate was chosen to run.
+func (q *fairShareQueue) Triggered(c *taskCandidate) {
+	if q.fs != nil {
+		q.fs.get(c.Repo, c.Category, c.Name).Triggered++
+	}
+}
+
+// SetFairShareConfig sets the FairShareConfig used to divide free bots among
+// task candidates. A nil config disables fair-share scheduling.
+func (s *TaskScheduler) SetFairShareConfig(cfg *FairShareConfig) {
+	s.fairShareMtx.Lock()
+	defer s.fairShareMtx.Unlock()
+	s.fairShareCfg = cfg
+}
+
+// newFairShare returns a fairShare instance which accounts for the currently
+// pending and running tasks, or nil if fair-share scheduling is disabled.
+func (s *TaskScheduler) newFairShare(ctx context.Context) (*fairShare, error) {
+	s.fairShareMtx.RLock()
+	cfg := s.fairShareCfg
+	s.fairShareMtx.RUnlock()
+	if cfg == nil {
+		return nil, nil
+	}
+	running, err := s.tCache.UnfinishedTasks()
+	if err != nil {
+		return nil, err
+	}
+	categories := make([]string, len(running))
+	if len(cfg.Categories) > 0 {
+		for i, t := range running {
+			categories[i] = s.taskCategory(ctx, t)
+		}
+	}
+	return newFairShare(cfg, running, categories), nil
+}
+
+// jobCategory returns the fair-share category of the given Job.
+func (s *TaskScheduler) jobCategory(ctx context.Context, j *db.Job) (string, error) {
+	if j.IsTryJob() {
+		return FAIR_SHARE_CATEGORY_TRY, nil
+	}
+	if j.IsForce {
+		return FAIR_SHARE_CATEGORY_FORCED, nil
+	}
+	spec, err := s.taskCfgCache.GetJobSpec(ctx, j.RepoState, j.Name)
+	if err != nil {
+		return "", err
+	}
+	if util.In(spec.Trigger, specs.PERIODIC_TRIGGERS) {
+		return FAIR_SHARE_CATEGORY_PERIODIC, nil
+	}
+	return FAIR_SHARE_CATEGORY_DEFAULT, nil
+}
+
+// mergeCategories returns the fair-share category of a task which is shared
+// by Jobs of the two given categories. Only the default and periodic
+// categories may be shared; a task is periodic only if all of its Jobs are.
+func mergeCategories(a, b string) string {
+	if a == FAIR_SHARE_CATEGORY_PERIODIC {
+		return b
+	}
+	return a
+}
+
+// taskCategory returns the fair-share category of the given Task. Errors are
+// logged, and the Task is assumed to be in the default category.
+func (s *TaskScheduler) taskCategory(ctx context.Context, t *db.Task) string {
+	if t.IsTryJob() {
+		return FAIR_SHARE_CATEGORY_TRY
+	}
+	if t.IsForceRun() {
+		return FAIR_SHARE_CATEGORY_FORCED
+	}
+	rv := ""
+	for _, id := range t.Jobs {
+		j, err := s.jCache.GetJobMaybeExpired(id)
+		if err != nil {
+			sklog.Errorf("Failed to retrieve job %s for task %s: %s", id, t.Id, err)
+			return FAIR_SHARE_CATEGORY_DEFAULT
+		}
+		cat, err := s.jobCategory(ctx, j)
+		if err != nil {
+			sklog.Errorf("Failed to find category for job %s: %s", id, err)
+			return FAIR_SHARE_CATEGORY_DEFAULT
+		}
+		if rv == "" {
+			rv = cat
+		} else {
+			rv = mergeCategories(rv, cat)
+		}
+	}
+	if rv == "" {
+		return FAIR_SHARE_CATEGORY_DEFAULT
+	}
+	return rv
+}
@@ -0,0 +1,197 @@
+package scheduling
+
+import (
+	"io/ioutil"
+	"path"
+	"testing"
+
+	assert "github.com/stretchr/testify/require"
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/deepequal"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestFairShareConfig(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// Validation.
+	assert.NoError(t, (&FairShareConfig{}).Validate())
+	assert.EqualError(t, (&FairShareConfig{
+		Categories: map[string]float64{"bogus": 1.0},
+	}).Validate(), `Unknown fair share category "bogus"; expected one of [default forced periodic try]`)
+	assert.EqualError(t, (&FairShareConfig{
+		Repos: map[string]float64{"a.git": 0.0},
+	}).Validate(), `Fair share weight for repo "a.git" must be positive, not 0.000000`)
+	assert.EqualError(t, (&FairShareConfig{
+		TaskSpecPrefixes: map[string]float64{"": 2.0},
+	}).Validate(), "Fair share TaskSpec prefixes must not be empty.")
+
+	// Groups and weights. Unconfigured dimensions are not used to divide
+	// tasks, and the longest matching prefix wins.
+	cfg := &FairShareConfig{
+		Categories: map[string]float64{
+			FAIR_SHARE_CATEGORY_DEFAULT: 3.0,
+		},
+		TaskSpecPrefixes: map[string]float64{
+			"Test-":         2.0,
+			"Test-Android-": 0.5,
+		},
+	}
+	g := cfg.group("a.git", FAIR_SHARE_CATEGORY_DEFAULT, "Test-Android-Nexus")
+	assert.Equal(t, fairShareGroup{
+		Category:       FAIR_SHARE_CATEGORY_DEFAULT,
+		TaskSpecPrefix: "Test-Android-",
+	}, g)
+	assert.Equal(t, 1.5, cfg.weight(g))
+	g = cfg.group("a.git", FAIR_SHARE_CATEGORY_TRY, "Build-Linux")
+	assert.Equal(t, fairShareGroup{
+		Category: FAIR_SHARE_CATEGORY_TRY,
+	}, g)
+	assert.Equal(t, 1.0, cfg.weight(g))
+
+	// Read from a file.
+	wd, cleanup := testutils.TempDir(t)
+	defer cleanup()
+	f := path.Join(wd, "fair_share.json")
+	assert.NoError(t, ioutil.WriteFile(f, []byte(`{"categories": {"try": 2}, "repos": {"a.git": 5}}`), 0644))
+	cfg, err := ReadFairShareConfig(f)
+	assert.NoError(t, err)
+	deepequal.AssertDeepEqual(t, &FairShareConfig{
+		Categories: map[string]float64{
+			FAIR_SHARE_CATEGORY_TRY: 2.0,
+		},
+		Repos: map[string]float64{
+			"a.git": 5.0,
+		},
+	}, cfg)
+	assert.NoError(t, ioutil.WriteFile(f, []byte(`{"categories": {"try": -1}}`), 0644))
+	_, err = ReadFairShareConfig(f)
+	assert.EqualError(t, err, `Fair share weight for category "try" must be positive, not -1.000000`)
+}
+
+func TestGetCandidatesToScheduleFairShare(t *testing.T) {
+	testutils.SmallTest(t)
+
+	dims := []string{"k:v"}
+	bots := []*swarming_api.SwarmingRpcsBotInfo{
+		makeSwarmingBot("bot1", dims),
+		makeSwarmingBot("bot2", dims),
+		makeSwarmingBot("bot3", dims),
+		makeSwarmingBot("bot4", dims),
+	}
+	candidate := func(name, category string, score float64) *taskCandidate {
+		c := makeTaskCandidate(name, dims)
+		c.Category = category
+		c.Score = score
+		return c
+	}
+	try1 := candidate("try1", FAIR_SHARE_CATEGORY_TRY, 10.0)
+	try2 := candidate("try2", FAIR_SHARE_CATEGORY_TRY, 9.0)
+	try3 := candidate("try3", FAIR_SHARE_CATEGORY_TRY, 8.0)
+	try4 := candidate("try4", FAIR_SHARE_CATEGORY_TRY, 7.0)
+	d1 := candidate("d1", FAIR_SHARE_CATEGORY_DEFAULT, 3.0)
+	d2 := candidate("d2", FAIR_SHARE_CATEGORY_DEFAULT, 2.0)
+	d3 := candidate("d3", FAIR_SHARE_CATEGORY_DEFAULT, 1.0)
+	queue := []*taskCandidate{try1, try2, try3, try4, d1, d2, d3}
+
+	// Without fair share, the try jobs take all of the bots.
+	rv := getCandidatesToSchedule(bots, queue, nil)
+	deepequal.AssertDeepEqual(t, []*taskCandidate{try1, try2, try3, try4}, rv)
+
+	// With equal weights, the bots are split evenly.
+	cfg := &FairShareConfig{
+		Categories: map[string]float64{
+			FAIR_SHARE_CATEGORY_DEFAULT: 1.0,
+			FAIR_SHARE_CATEGORY_TRY:     1.0,
+		},
+	}
+	rv = getCandidatesToSchedule(bots, queue, newFairShare(cfg, nil, nil))
+	deepequal.AssertDeepEqual(t, []*taskCandidate{try1, try2, d1, d2}, rv)
+
+	// Running tasks count against their group's share.
+	running := []*db.Task{
+		{TaskKey: db.TaskKey{Name: "d0"}},
+		{TaskKey: db.TaskKey{Name: "d0"}},
+		{TaskKey: db.TaskKey{Name: "d0"}},
+	}
+	categories := []string{FAIR_SHARE_CATEGORY_DEFAULT, FAIR_SHARE_CATEGORY_DEFAULT, FAIR_SHARE_CATEGORY_DEFAULT}
+	rv = getCandidatesToSchedule(bots, queue, newFairShare(cfg, running, categories))
+	deepequal.AssertDeepEqual(t, []*taskCandidate{try1, try2, try3, try4}, rv)
+
+	// Weights.
+	cfg.Categories[FAIR_SHARE_CATEGORY_DEFAULT] = 3.0
+	fs := newFairShare(cfg, nil, nil)
+	rv = getCandidatesToSchedule(bots, queue, fs)
+	deepequal.AssertDeepEqual(t, []*taskCandidate{try1, d1, d2, d3}, rv)
+	deepequal.AssertDeepEqual(t, []*FairShareGroupStatus{
+		{
+			Category:  FAIR_SHARE_CATEGORY_DEFAULT,
+			Weight:    3.0,
+			Queued:    3,
+			Triggered: 3,
+		},
+		{
+			Category:  FAIR_SHARE_CATEGORY_TRY,
+			Weight:    1.0,
+			Queued:    4,
+			Triggered: 1,
+		},
+	}, fs.Status())
+}
+
+func TestFairShareCategories(t *testing.T) {
+	ctx, gb, _, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	j1, err := s.taskCfgCache.MakeJob(ctx, rs1, specs_testutils.BuildTask)
+	assert.NoError(t, err)
+	j1.Id = "j1"
+	j2, err := s.taskCfgCache.MakeJob(ctx, rs1, specs_testutils.BuildTask)
+	assert.NoError(t, err)
+	j2.Id = "j2"
+	j2.IsForce = true
+	jobs := []*db.Job{j1, j2}
+	k1 := j1.MakeTaskKey(specs_testutils.BuildTask)
+	k2 := j2.MakeTaskKey(specs_testutils.BuildTask)
+
+	// Categories are not computed unless they are weighted.
+	candidates, err := s.findTaskCandidatesForJobs(ctx, jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, "", candidates[k1].Category)
+	assert.Equal(t, "", candidates[k2].Category)
+
+	s.SetFairShareConfig(&FairShareConfig{
+		Categories: map[string]float64{
+			FAIR_SHARE_CATEGORY_FORCED: 0.5,
+		},
+	})
+	candidates, err = s.findTaskCandidatesForJobs(ctx, jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, FAIR_SHARE_CATEGORY_DEFAULT, candidates[k1].Category)
+	assert.Equal(t, FAIR_SHARE_CATEGORY_FORCED, candidates[k2].Category)
+
+	// Try jobs don't require a JobSpec lookup.
+	j3 := &db.Job{
+		Name: "bogus",
+		RepoState: db.RepoState{
+			Patch: db.Patch{
+				Issue:    "1",
+				Patchset: "2",
+				Server:   "https://codereview",
+			},
+			Repo:     rs1.Repo,
+			Revision: rs1.Revision,
+		},
+	}
+	cat, err := s.jobCategory(ctx, j3)
+	assert.NoError(t, err)
+	assert.Equal(t, FAIR_SHARE_CATEGORY_TRY, cat)
+
+	// Periodic jobs only remain periodic if not shared with other jobs.
+	assert.Equal(t, FAIR_SHARE_CATEGORY_PERIODIC, mergeCategories(FAIR_SHARE_CATEGORY_PERIODIC, FAIR_SHARE_CATEGORY_PERIODIC))
+	assert.Equal(t, FAIR_SHARE_CATEGORY_DEFAULT, mergeCategories(FAIR_SHARE_CATEGORY_PERIODIC, FAIR_SHARE_CATEGORY_DEFAULT))
+	assert.Equal(t, FAIR_SHARE_CATEGORY_DEFAULT, mergeCategories(FAIR_SHARE_CATEGORY_DEFAULT, FAIR_SHARE_CATEGORY_PERIODIC))
+}
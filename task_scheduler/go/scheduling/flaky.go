@@ -0,0 +1,268 @@
+package scheduling
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+const (
+	// Measurement name for the fraction of finished tasks which were flaky,
+	// by repo and TaskSpec name.
+	MEASUREMENT_TASK_FLAKE_RATE = "task_flake_rate"
+
+	// Author of automatically-created quarantine blacklist Rules.
+	FLAKE_QUARANTINE_USER = "TaskScheduler"
+
+	// Prefix for the names of automatically-created quarantine blacklist
+	// Rules.
+	FLAKE_QUARANTINE_RULE_PREFIX = "Flaky: "
+)
+
+// FlakeQuarantineConfig describes when TaskSpecs should be automatically
+// blacklisted due to flakiness.
+type FlakeQuarantineConfig struct {
+	// Threshold is the flake rate, between zero and one, at or above which
+	// a TaskSpec is quarantined.
+	Threshold float64
+
+	// MinTasks is the minimum number of finished tasks required before a
+	// TaskSpec may be quarantined.
+	MinTasks int
+
+	// Duration is the amount of time for which a TaskSpec is quarantined.
+	Duration time.Duration
+}
+
+// Validate returns an error if the FlakeQuarantineConfig is not valid.
+func (c *FlakeQuarantineConfig) Validate() error {
+	if c.Threshold <= 0.0 || c.Threshold > 1.0 {
+		return fmt.Errorf("Flake quarantine threshold must be in (0, 1], not %f", c.Threshold)
+	}
+	if c.MinTasks < 1 {
+		return fmt.Errorf("Flake quarantine minimum task count must be positive, not %d", c.MinTasks)
+	}
+	if c.Duration <= 0 {
+		return fmt.Errorf("Flake quarantine duration must be positive, not %s", c.Duration)
+	}
+	return nil
+}
+
+// FlakeRate describes how often tasks for a given TaskSpec in a given repo
+// were flaky.
+type FlakeRate struct {
+	Repo     string `json:"repo"`
+	Name     string `json:"name"`
+	Finished int    `json:"finished"`
+	Flaky    int    `json:"flaky"`
+}
+
+// Rate returns the fraction of finished tasks which were flaky.
+func (r *FlakeRate) Rate() float64 {
+	if r.Finished == 0 {
+		return 0.0
+	}
+	return float64(r.Flaky) / float64(r.Finished)
+}
+
+// classifyFlakyTasks marks as flaky any failed task in the given slice which
+// was eventually retried successfully, ie. every failed task in the RetryOf
+// chain of a successful task. All of the tasks must share the same TaskKey.
+// Returns the tasks which were modified.
+func classifyFlakyTasks(tasks []*db.Task) []*db.Task {
+	byId := make(map[string]*db.Task, len(tasks))
+	for _, t := range tasks {
+		byId[t.Id] = t
+	}
+	rv := []*db.Task{}
+	for _, t := range tasks {
+		if t.Status != db.TASK_STATUS_SUCCESS {
+			continue
+		}
+		// Guard against cycles in the chain.
+		visited := map[string]bool{t.Id: true}
+		for id := t.RetryOf; id != "" && !visited[id]; {
+			visited[id] = true
+			orig, ok := byId[id]
+			if !ok {
+				break
+			}
+			if orig.Status == db.TASK_STATUS_FAILURE && !orig.Flaky {
+				orig.Flaky = true
+				rv = append(rv, orig)
+			}
+			id = orig.RetryOf
+		}
+	}
+	return rv
+}
+
+// flakeRateKey is used for grouping tasks when computing flake rates.
+type flakeRateKey struct {
+	repo string
+	name string
+}
+
+// computeFlakeRates returns the FlakeRate for each repo and TaskSpec name in
+// the given tasks, sorted by repo and name. Only tasks which succeeded or
+// failed are counted.
+func computeFlakeRates(tasks []*db.Task) []*FlakeRate {
+	rates := map[flakeRateKey]*FlakeRate{}
+	for _, t := range tasks {
+		if t.Status != db.TASK_STATUS_SUCCESS && t.Status != db.TASK_STATUS_FAILURE {
+			continue
+		}
+		k := flakeRateKey{repo: t.Repo, name: t.Name}
+		r, ok := rates[k]
+		if !ok {
+			r = &FlakeRate{
+				Repo: t.Repo,
+				Name: t.Name,
+			}
+			rates[k] = r
+		}
+		r.Finished++
+		if t.Flaky {
+			r.Flaky++
+		}
+	}
+	rv := make([]*FlakeRate, 0, len(rates))
+	for _, r := range rates {
+		rv = append(rv, r)
+	}
+	sort.Slice(rv, func(i, j int) bool {
+		if rv[i].Repo != rv[j].Repo {
+			return rv[i].Repo < rv[j].Repo
+		}
+		return rv[i].Name < rv[j].Name
+	})
+	return rv
+}
+
+// quarantineRuleName returns the name of the quarantine blacklist Rule for the
+// given TaskSpec in the given repo. The name ends with a hash of the repo and
+// TaskSpec so that it stays unique when the TaskSpec name is truncated.
+func quarantineRuleName(repo, taskSpec string) string {
+	hash := fmt.Sprintf("%x", sha1.Sum([]byte(repo+"\n"+taskSpec)))
+	suffix := fmt.Sprintf(" (%s)", hash[:8])
+	rv := FLAKE_QUARANTINE_RULE_PREFIX + taskSpec
+	if limit := blacklist.MAX_NAME_CHARS - len(suffix); len(rv) > limit {
+		rv = rv[:limit]
+	}
+	return rv + suffix
+}
+
+// SetFlakeQuarantineConfig sets the FlakeQuarantineConfig used to decide when
+// to automatically blacklist flaky TaskSpecs. A nil config disables automatic
+// quarantine; flake rates are still reported.
+func (s *TaskScheduler) SetFlakeQuarantineConfig(cfg *FlakeQuarantineConfig) {
+	s.flakeMtx.Lock()
+	defer s.flakeMtx.Unlock()
+	s.flakeQuarantineCfg = cfg
+}
+
+// updateFlakeRates computes the flake rate for each TaskSpec over the
+// scheduling window, reports them as metrics, and quarantines TaskSpecs which
+// are too flaky, if configured to do so.
+func (s *TaskScheduler) updateFlakeRates(now time.Time) error {
+	defer metrics2.FuncTimer().Stop()
+	tasks, err := s.tCache.GetTasksFromDateRange(s.window.EarliestStart(), now)
+	if err != nil {
+		return err
+	}
+	rates := computeFlakeRates(tasks)
+
+	s.flakeMtx.Lock()
+	defer s.flakeMtx.Unlock()
+
+	// Report the data.
+	seen := make(map[flakeRateKey]bool, len(rates))
+	for _, r := range rates {
+		k := flakeRateKey{repo: r.Repo, name: r.Name}
+		seen[k] = true
+		metric, ok := s.flakeMetrics[k]
+		if !ok {
+			metric = metrics2.GetFloat64Metric(MEASUREMENT_TASK_FLAKE_RATE, map[string]string{
+				"repo":      r.Repo,
+				"task_name": r.Name,
+			})
+			s.flakeMetrics[k] = metric
+		}
+		metric.Update(r.Rate())
+	}
+	for k, metric := range s.flakeMetrics {
+		if !seen[k] {
+			if err := metric.Delete(); err != nil {
+				sklog.Errorf("Failed to delete flake rate metric: %s", err)
+			}
+			delete(s.flakeMetrics, k)
+		}
+	}
+
+	// Quarantine flaky TaskSpecs.
+	cfg := s.flakeQuarantineCfg
+	if cfg == nil {
+		return nil
+	}
+	for _, r := range rates {
+		if r.Finished < cfg.MinTasks || r.Rate() < cfg.Threshold {
+			continue
+		}
+		if err := s.quarantine(r, tasks, cfg, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// quarantine adds a blacklist Rule which prevents the TaskSpec described by
+// the given FlakeRate from being scheduled in its repo for the configured
+// duration. If a quarantine Rule for the repo and TaskSpec already exists,
+// nothing is changed. If the existing Rule has expired, the TaskSpec is only
+// quarantined again if it is still too flaky when considering only the tasks
+// created since the Rule expired.
+func (s *TaskScheduler) quarantine(r *FlakeRate, tasks []*db.Task, cfg *FlakeQuarantineConfig, now time.Time) error {
+	name := quarantineRuleName(r.Repo, r.Name)
+	existing, err := s.bl.GetRule(name)
+	if err == nil {
+		if !existing.Expired(now) {
+			return nil
+		}
+		recent := make([]*db.Task, 0, len(tasks))
+		for _, t := range tasks {
+			if t.Repo == r.Repo && t.Name == r.Name && t.Created.After(existing.Expires) {
+				recent = append(recent, t)
+			}
+		}
+		rates := computeFlakeRates(recent)
+		if len(rates) == 0 || rates[0].Finished < cfg.MinTasks || rates[0].Rate() < cfg.Threshold {
+			return nil
+		}
+		r = rates[0]
+		if err := s.bl.RemoveRule(name); err != nil {
+			return fmt.Errorf("Failed to remove expired quarantine rule %q: %s", name, err)
+		}
+	} else if err != blacklist.ERR_NO_SUCH_RULE {
+		return err
+	}
+	rule := &blacklist.Rule{
+		AddedBy:          FLAKE_QUARANTINE_USER,
+		TaskSpecPatterns: []string{fmt.Sprintf("^%s$", regexp.QuoteMeta(r.Name))},
+		Description:      fmt.Sprintf("Automatically quarantined: %d of %d recent tasks in %s were flaky (%.1f%%).", r.Flaky, r.Finished, r.Repo, 100.0*r.Rate()),
+		Expires:          now.Add(cfg.Duration),
+		Name:             name,
+		Repo:             r.Repo,
+	}
+	if err := s.bl.AddRule(rule, s.repos); err != nil {
+		return fmt.Errorf("Failed to add quarantine rule for %s in %s: %s", r.Name, r.Repo, err)
+	}
+	sklog.Warningf("Quarantined flaky TaskSpec %s in %s until %s: %s", r.Name, r.Repo, rule.Expires, rule.Description)
+	return nil
+}
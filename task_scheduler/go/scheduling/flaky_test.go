@@ -0,0 +1,226 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/deepequal"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestClassifyFlakyTasks(t *testing.T) {
+	testutils.SmallTest(t)
+
+	t1 := makeTask("a", "a.git", "abc123")
+	t1.Id = "t1"
+	t1.Status = db.TASK_STATUS_FAILURE
+	t2 := makeTask("a", "a.git", "abc123")
+	t2.Id = "t2"
+	t2.RetryOf = t1.Id
+	t2.Status = db.TASK_STATUS_RUNNING
+
+	// The retry hasn't finished yet.
+	deepequal.AssertDeepEqual(t, []*db.Task{}, classifyFlakyTasks([]*db.Task{t1, t2}))
+	assert.False(t, t1.Flaky)
+
+	// The retry failed too.
+	t2.Status = db.TASK_STATUS_FAILURE
+	deepequal.AssertDeepEqual(t, []*db.Task{}, classifyFlakyTasks([]*db.Task{t1, t2}))
+	assert.False(t, t1.Flaky)
+
+	// The retry succeeded.
+	t2.Status = db.TASK_STATUS_SUCCESS
+	deepequal.AssertDeepEqual(t, []*db.Task{t1}, classifyFlakyTasks([]*db.Task{t1, t2}))
+	assert.True(t, t1.Flaky)
+	assert.False(t, t2.Flaky)
+
+	// Already-classified tasks are not returned again.
+	deepequal.AssertDeepEqual(t, []*db.Task{}, classifyFlakyTasks([]*db.Task{t1, t2}))
+
+	// Mishaps are not flakes.
+	t1.Flaky = false
+	t1.Status = db.TASK_STATUS_MISHAP
+	deepequal.AssertDeepEqual(t, []*db.Task{}, classifyFlakyTasks([]*db.Task{t1, t2}))
+
+	// All failed tasks in the RetryOf chain are flaky, even if the chain
+	// contains a mishap.
+	t1.Status = db.TASK_STATUS_FAILURE
+	t2.Status = db.TASK_STATUS_MISHAP
+	t3 := makeTask("a", "a.git", "abc123")
+	t3.Id = "t3"
+	t3.RetryOf = t2.Id
+	t3.Status = db.TASK_STATUS_FAILURE
+	t4 := makeTask("a", "a.git", "abc123")
+	t4.Id = "t4"
+	t4.RetryOf = t3.Id
+	t4.Status = db.TASK_STATUS_SUCCESS
+	deepequal.AssertDeepEqual(t, []*db.Task{t3, t1}, classifyFlakyTasks([]*db.Task{t1, t2, t3, t4}))
+	assert.True(t, t1.Flaky)
+	assert.False(t, t2.Flaky)
+	assert.True(t, t3.Flaky)
+	assert.False(t, t4.Flaky)
+
+	// Cycles don't cause an infinite loop.
+	t1.Flaky = false
+	t3.Flaky = false
+	t1.RetryOf = t4.Id
+	deepequal.AssertDeepEqual(t, []*db.Task{t3, t1}, classifyFlakyTasks([]*db.Task{t1, t2, t3, t4}))
+}
+
+func TestComputeFlakeRates(t *testing.T) {
+	testutils.SmallTest(t)
+
+	task := func(repo, name string, status db.TaskStatus, flaky bool) *db.Task {
+		t := makeTask(name, repo, "abc123")
+		t.Status = status
+		t.Flaky = flaky
+		return t
+	}
+	rates := computeFlakeRates([]*db.Task{
+		task("b.git", "a", db.TASK_STATUS_FAILURE, true),
+		task("a.git", "b", db.TASK_STATUS_SUCCESS, false),
+		task("a.git", "a", db.TASK_STATUS_FAILURE, true),
+		task("a.git", "a", db.TASK_STATUS_SUCCESS, false),
+		task("a.git", "a", db.TASK_STATUS_FAILURE, false),
+		task("a.git", "a", db.TASK_STATUS_SUCCESS, false),
+		task("a.git", "a", db.TASK_STATUS_MISHAP, false),
+		task("a.git", "a", db.TASK_STATUS_RUNNING, false),
+	})
+	deepequal.AssertDeepEqual(t, []*FlakeRate{
+		{Repo: "a.git", Name: "a", Finished: 4, Flaky: 1},
+		{Repo: "a.git", Name: "b", Finished: 1, Flaky: 0},
+		{Repo: "b.git", Name: "a", Finished: 1, Flaky: 1},
+	}, rates)
+	assert.Equal(t, 0.25, rates[0].Rate())
+	assert.Equal(t, 0.0, rates[1].Rate())
+	assert.Equal(t, 1.0, rates[2].Rate())
+	assert.Equal(t, 0.0, (&FlakeRate{}).Rate())
+}
+
+func TestFlakeQuarantineConfig(t *testing.T) {
+	testutils.SmallTest(t)
+
+	cfg := &FlakeQuarantineConfig{
+		Threshold: 0.2,
+		MinTasks:  5,
+		Duration:  time.Hour,
+	}
+	assert.NoError(t, cfg.Validate())
+	cfg.Threshold = 1.5
+	assert.EqualError(t, cfg.Validate(), "Flake quarantine threshold must be in (0, 1], not 1.500000")
+	cfg.Threshold = 0.2
+	cfg.MinTasks = 0
+	assert.EqualError(t, cfg.Validate(), "Flake quarantine minimum task count must be positive, not 0")
+	cfg.MinTasks = 5
+	cfg.Duration = 0
+	assert.EqualError(t, cfg.Validate(), "Flake quarantine duration must be positive, not 0s")
+
+	assert.Equal(t, "Flaky: Build (55cc59a4)", quarantineRuleName("a.git", "Build"))
+	assert.NotEqual(t, quarantineRuleName("a.git", "Build"), quarantineRuleName("b.git", "Build"))
+	long := quarantineRuleName("a.git", specs_testutils.PerfTask)
+	assert.Equal(t, blacklist.MAX_NAME_CHARS, len(long))
+	assert.NotEqual(t, long, quarantineRuleName("a.git", specs_testutils.PerfTask+"-Other"))
+}
+
+func TestUpdateUnfinishedJobsFlakyTasks(t *testing.T) {
+	ctx, gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	j, err := s.taskCfgCache.MakeJob(ctx, rs1, specs_testutils.BuildTask)
+	assert.NoError(t, err)
+	assert.NoError(t, d.PutJob(j))
+
+	t1 := makeTask(specs_testutils.BuildTask, rs1.Repo, rs1.Revision)
+	t1.Status = db.TASK_STATUS_FAILURE
+	assert.NoError(t, d.PutTask(t1))
+	t2 := makeTask(specs_testutils.BuildTask, rs1.Repo, rs1.Revision)
+	t2.Attempt = 1
+	t2.RetryOf = t1.Id
+	t2.Status = db.TASK_STATUS_SUCCESS
+	assert.NoError(t, d.PutTask(t2))
+	assert.NoError(t, s.tCache.Update())
+	assert.NoError(t, s.jCache.Update())
+
+	assert.NoError(t, s.updateUnfinishedJobs())
+	t1, err = d.GetTaskById(t1.Id)
+	assert.NoError(t, err)
+	assert.True(t, t1.Flaky)
+	t2, err = d.GetTaskById(t2.Id)
+	assert.NoError(t, err)
+	assert.False(t, t2.Flaky)
+	j, err = d.GetJobById(j.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j.Status)
+}
+
+func TestFlakeQuarantine(t *testing.T) {
+	ctx, gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	addTasks := func(n int, flaky bool) {
+		for i := 0; i < n; i++ {
+			task := makeTask(specs_testutils.BuildTask, rs1.Repo, rs1.Revision)
+			task.Status = db.TASK_STATUS_SUCCESS
+			if flaky {
+				task.Status = db.TASK_STATUS_FAILURE
+				task.Flaky = true
+			}
+			assert.NoError(t, d.PutTask(task))
+		}
+		assert.NoError(t, s.tCache.Update())
+	}
+	addTasks(3, false)
+	addTasks(1, true)
+	ruleName := quarantineRuleName(rs1.Repo, specs_testutils.BuildTask)
+
+	// Without a config, nothing is quarantined.
+	now := time.Now()
+	assert.NoError(t, s.updateFlakeRates(now))
+	_, err := s.bl.GetRule(ruleName)
+	assert.Equal(t, blacklist.ERR_NO_SUCH_RULE, err)
+
+	// Not enough tasks.
+	s.SetFlakeQuarantineConfig(&FlakeQuarantineConfig{
+		Threshold: 0.25,
+		MinTasks:  5,
+		Duration:  time.Hour,
+	})
+	assert.NoError(t, s.updateFlakeRates(now))
+	_, err = s.bl.GetRule(ruleName)
+	assert.Equal(t, blacklist.ERR_NO_SUCH_RULE, err)
+
+	// Not flaky enough.
+	addTasks(1, false)
+	assert.NoError(t, s.updateFlakeRates(now))
+	_, err = s.bl.GetRule(ruleName)
+	assert.Equal(t, blacklist.ERR_NO_SUCH_RULE, err)
+
+	// Quarantined.
+	addTasks(1, true)
+	assert.NoError(t, s.updateFlakeRates(now))
+	rule, err := s.bl.GetRule(ruleName)
+	assert.NoError(t, err)
+	assert.Equal(t, FLAKE_QUARANTINE_USER, rule.AddedBy)
+	assert.True(t, now.Add(time.Hour).Equal(rule.Expires))
+	assert.Equal(t, "Automatically quarantined: 2 of 6 recent tasks in "+rs1.Repo+" were flaky (33.3%).", rule.Description)
+	assert.Equal(t, rs1.Repo, rule.Repo)
+	assert.Equal(t, ruleName, s.bl.MatchRule(rs1.Repo, specs_testutils.BuildTask, rs1.Revision))
+	assert.Equal(t, "", s.bl.MatchRule(rs1.Repo, specs_testutils.TestTask, rs1.Revision))
+	// The same TaskSpec in other repos is not quarantined.
+	assert.Equal(t, "", s.bl.MatchRule("other.git", specs_testutils.BuildTask, rs1.Revision))
+
+	// After the rule expires, old flakes don't cause the TaskSpec to be
+	// quarantined again.
+	later := now.Add(2 * time.Hour)
+	assert.NoError(t, s.updateFlakeRates(later))
+	rule, err = s.bl.GetRule(ruleName)
+	assert.NoError(t, err)
+	assert.True(t, rule.Expired(later))
+	assert.Equal(t, "", s.bl.MatchRule(rs1.Repo, specs_testutils.BuildTask, rs1.Revision))
+}
@@ -0,0 +1,503 @@
+package scheduling
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	osexec "os/exec"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pborman/uuid"
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/exec"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+const (
+	// LOCAL_ISOLATE_SERVER is reported as the isolate server for inputs
+	// and outputs of tasks run by a LocalBackend.
+	LOCAL_ISOLATE_SERVER = "local"
+
+	// LOCAL_BOT_ID_TMPL is the template for the IDs of LocalBackend bots.
+	LOCAL_BOT_ID_TMPL = "local-%d"
+
+	// Retry interval for results which the PubSubHandler could not yet
+	// process, eg. because the task has not yet been inserted into the DB.
+	LOCAL_RESULT_RETRY_INTERVAL = 5 * time.Second
+
+	// Maximum number of attempts to deliver a result to the PubSubHandler.
+	// Undelivered results are picked up by periodic polling.
+	LOCAL_RESULT_MAX_ATTEMPTS = 30
+
+	LOCAL_RESULT_FILE = "result.json"
+	LOCAL_LOG_FILE    = "log.txt"
+)
+
+// localTask is a task triggered on a LocalBackend.
+type localTask struct {
+	req    *swarming_api.SwarmingRpcsNewTaskRequest
+	result *swarming_api.SwarmingRpcsTaskResult
+	cancel context.CancelFunc
+}
+
+// LocalBackend is a Backend which runs tasks as subprocesses on the local
+// machine, for small deployments and integration tests which don't have access
+// to Swarming. Each bot is a slot which runs at most one task at a time; all
+// bots share the same dimensions. Isolated inputs and outputs are directories
+// within the workdir, and their "hashes" are the names of those directories.
+// Caches, CIPD packages and service accounts are not supported; tasks which
+// request them are run anyway, without them. Only unfinished tasks are kept in
+// memory; results of finished tasks are read from disk.
+type LocalBackend struct {
+	bots    []*swarming_api.SwarmingRpcsBotInfo
+	ctx     context.Context
+	handler swarming.PubSubHandler
+	mtx     sync.Mutex
+	pending []string              // IDs of PENDING tasks, in the order triggered.
+	running map[string]string     // IDs of tasks which occupy a bot, by bot ID.
+	tasks   map[string]*localTask // Unfinished tasks, by ID.
+	workdir string
+}
+
+// NewLocalBackend returns a LocalBackend which runs tasks on numBots bots with
+// the given dimensions, in "key:value" form. Tasks are killed when ctx is
+// canceled.
+func NewLocalBackend(ctx context.Context, workdir string, numBots int, dimensions []string) (*LocalBackend, error) {
+	if numBots < 1 {
+		return nil, fmt.Errorf("LocalBackend requires at least one bot, not %d", numBots)
+	}
+	dims, err := swarming.ParseDimensions(dimensions)
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{"isolated", "tasks"} {
+		if err := os.MkdirAll(path.Join(workdir, dir), os.ModePerm); err != nil {
+			return nil, err
+		}
+	}
+	bots := make([]*swarming_api.SwarmingRpcsBotInfo, 0, numBots)
+	for i := 0; i < numBots; i++ {
+		id := fmt.Sprintf(LOCAL_BOT_ID_TMPL, i)
+		botDims := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(dims)+1)
+		botDims = append(botDims, &swarming_api.SwarmingRpcsStringListPair{
+			Key:   "id",
+			Value: []string{id},
+		})
+		for k, v := range dims {
+			botDims = append(botDims, &swarming_api.SwarmingRpcsStringListPair{
+				Key:   k,
+				Value: util.CopyStringSlice(v),
+			})
+		}
+		sort.Slice(botDims, func(i, j int) bool {
+			return botDims[i].Key < botDims[j].Key
+		})
+		bots = append(bots, &swarming_api.SwarmingRpcsBotInfo{
+			BotId:      id,
+			Dimensions: botDims,
+		})
+	}
+	return &LocalBackend{
+		bots:    bots,
+		ctx:     ctx,
+		running: map[string]string{},
+		tasks:   map[string]*localTask{},
+		workdir: workdir,
+	}, nil
+}
+
+// SetPubSubHandler sets a handler which is notified when tasks finish, in the
+// same way as for Swarming pub/sub messages.
+func (b *LocalBackend) SetPubSubHandler(h swarming.PubSubHandler) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	b.handler = h
+}
+
+// isolatedDir returns the directory holding the given isolated inputs or
+// outputs.
+func (b *LocalBackend) isolatedDir(hash string) string {
+	return path.Join(b.workdir, "isolated", hash)
+}
+
+// taskDir returns the directory in which the given task runs.
+func (b *LocalBackend) taskDir(id string) string {
+	return path.Join(b.workdir, "tasks", id)
+}
+
+// botBusy returns true iff the given bot is running a task, including one
+// which has been canceled but has not yet exited. Assumes the caller holds
+// b.mtx.
+func (b *LocalBackend) botBusy(botId string) bool {
+	_, ok := b.running[botId]
+	return ok
+}
+
+// See documentation for Backend interface.
+func (b *LocalBackend) GetFreeBots(pools []string) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(b.bots))
+	for _, bot := range b.bots {
+		if b.botBusy(bot.BotId) {
+			continue
+		}
+		dims := swarming.BotDimensionsToStringMap(bot.Dimensions)
+		for _, pool := range pools {
+			if util.In(pool, dims["pool"]) {
+				rv = append(rv, bot)
+				break
+			}
+		}
+	}
+	return rv, nil
+}
+
+// See documentation for Backend interface. The inputs for each task are a copy
+// of its BaseDir, overlaid on copies of its Deps. The isolate file is not used
+// to filter the files.
+func (b *LocalBackend) IsolateTasks(ctx context.Context, tasks []*isolate.Task) ([]string, error) {
+	rv := make([]string, 0, len(tasks))
+	for _, t := range tasks {
+		if err := t.Validate(); err != nil {
+			return nil, err
+		}
+		hash := uuid.New()
+		dest := b.isolatedDir(hash)
+		if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+			return nil, err
+		}
+		srcs := make([]string, 0, len(t.Deps)+1)
+		for _, dep := range t.Deps {
+			srcs = append(srcs, b.isolatedDir(dep))
+		}
+		srcs = append(srcs, t.BaseDir)
+		for _, src := range srcs {
+			if _, err := exec.RunCwd(ctx, ".", "cp", "-a", src+"/.", dest); err != nil {
+				return nil, fmt.Errorf("Failed to copy isolated inputs: %s", err)
+			}
+		}
+		rv = append(rv, hash)
+	}
+	return rv, nil
+}
+
+// See documentation for Backend interface.
+func (b *LocalBackend) IsolateServerURL() string {
+	return LOCAL_ISOLATE_SERVER
+}
+
+// localBotMatches returns true iff the given bot has all of the requested dimensions.
+func localBotMatches(bot *swarming_api.SwarmingRpcsBotInfo, dims []*swarming_api.SwarmingRpcsStringPair) bool {
+	botDims := swarming.BotDimensionsToStringMap(bot.Dimensions)
+	for _, d := range dims {
+		if !util.In(d.Value, botDims[d.Key]) {
+			return false
+		}
+	}
+	return true
+}
+
+// See documentation for Backend interface. The task runs as soon as a matching
+// bot is free.
+func (b *LocalBackend) TriggerTask(req *swarming_api.SwarmingRpcsNewTaskRequest) (*swarming_api.SwarmingRpcsTaskRequestMetadata, error) {
+	if req.Properties == nil || len(req.Properties.Command) == 0 {
+		return nil, fmt.Errorf("Task %q has no command.", req.Name)
+	}
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	found := false
+	for _, bot := range b.bots {
+		if localBotMatches(bot, req.Properties.Dimensions) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("No local bot has dimensions matching task %q", req.Name)
+	}
+	now := time.Now().UTC().Format(swarming.TIMESTAMP_FORMAT)
+	id := uuid.New()
+	t := &localTask{
+		req: req,
+		result: &swarming_api.SwarmingRpcsTaskResult{
+			CreatedTs:  now,
+			ModifiedTs: now,
+			Name:       req.Name,
+			State:      swarming.TASK_STATE_PENDING,
+			Tags:       util.CopyStringSlice(req.Tags),
+			TaskId:     id,
+		},
+	}
+	if err := os.MkdirAll(b.taskDir(id), os.ModePerm); err != nil {
+		return nil, err
+	}
+	b.tasks[id] = t
+	b.pending = append(b.pending, id)
+	if err := b.writeResult(t); err != nil {
+		return nil, err
+	}
+	b.dispatch()
+	return &swarming_api.SwarmingRpcsTaskRequestMetadata{
+		Request: &swarming_api.SwarmingRpcsTaskRequest{
+			CreatedTs:  now,
+			Name:       req.Name,
+			Properties: req.Properties,
+			Tags:       util.CopyStringSlice(req.Tags),
+		},
+		TaskId:     id,
+		TaskResult: copyResult(t.result),
+	}, nil
+}
+
+// dispatch starts pending tasks on free bots. Assumes the caller holds b.mtx.
+func (b *LocalBackend) dispatch() {
+	stillPending := make([]string, 0, len(b.pending))
+	for _, id := range b.pending {
+		t := b.tasks[id]
+		var bot *swarming_api.SwarmingRpcsBotInfo
+		for _, candidate := range b.bots {
+			if !b.botBusy(candidate.BotId) && localBotMatches(candidate, t.req.Properties.Dimensions) {
+				bot = candidate
+				break
+			}
+		}
+		if bot == nil {
+			stillPending = append(stillPending, id)
+			continue
+		}
+		ctx, cancel := context.WithCancel(b.ctx)
+		t.cancel = cancel
+		b.running[bot.BotId] = id
+		t.result.BotId = bot.BotId
+		t.result.BotDimensions = bot.Dimensions
+		t.result.State = swarming.TASK_STATE_RUNNING
+		t.result.StartedTs = time.Now().UTC().Format(swarming.TIMESTAMP_FORMAT)
+		t.result.ModifiedTs = t.result.StartedTs
+		if err := b.writeResult(t); err != nil {
+			sklog.Errorf("Failed to write result for local task %s: %s", id, err)
+		}
+		go b.run(ctx, id, t.req)
+	}
+	b.pending = stillPending
+}
+
+// run runs the given task and records its result.
+func (b *LocalBackend) run(ctx context.Context, id string, req *swarming_api.SwarmingRpcsNewTaskRequest) {
+	outputs := uuid.New()
+	state, exitCode, err := b.runCommand(ctx, id, outputs, req)
+	if err != nil {
+		sklog.Errorf("Local task %s (%s) failed: %s", id, req.Name, err)
+	}
+
+	b.mtx.Lock()
+	t := b.tasks[id]
+	now := time.Now().UTC().Format(swarming.TIMESTAMP_FORMAT)
+	t.cancel = nil
+	t.result.ModifiedTs = now
+	if t.result.State == swarming.TASK_STATE_RUNNING {
+		t.result.State = state
+	}
+	switch t.result.State {
+	case swarming.TASK_STATE_COMPLETED:
+		t.result.CompletedTs = now
+		t.result.ExitCode = exitCode
+		t.result.Failure = exitCode != 0
+		t.result.OutputsRef = &swarming_api.SwarmingRpcsFilesRef{
+			Isolated:       outputs,
+			Isolatedserver: LOCAL_ISOLATE_SERVER,
+			Namespace:      isolate.DEFAULT_NAMESPACE,
+		}
+	case swarming.TASK_STATE_TIMED_OUT:
+		t.result.CompletedTs = now
+		t.result.Failure = true
+	default:
+		t.result.AbandonedTs = now
+		t.result.InternalFailure = true
+	}
+	if err := b.writeResult(t); err != nil {
+		sklog.Errorf("Failed to write result for local task %s: %s", id, err)
+	}
+	// The task is finished; its result is read from disk from now on.
+	delete(b.tasks, id)
+	delete(b.running, t.result.BotId)
+	msg := &swarming.PubSubTaskMessage{
+		SwarmingTaskId: id,
+		UserData:       req.PubsubUserdata,
+	}
+	h := b.handler
+	b.dispatch()
+	b.mtx.Unlock()
+
+	if h != nil {
+		for i := 0; i < LOCAL_RESULT_MAX_ATTEMPTS && !h.HandleSwarmingPubSub(msg); i++ {
+			time.Sleep(LOCAL_RESULT_RETRY_INTERVAL)
+		}
+	}
+}
+
+// runCommand runs the command for the given task in a copy of its isolated
+// inputs, writing its outputs to the given isolated hash. Returns the final
+// Swarming task state and the exit code of the command.
+func (b *LocalBackend) runCommand(ctx context.Context, id, outputs string, req *swarming_api.SwarmingRpcsNewTaskRequest) (string, int64, error) {
+	props := req.Properties
+	if props.CipdInput != nil || len(props.Caches) > 0 {
+		sklog.Warningf("Local task %s (%s) requested CIPD packages or caches, which are not supported.", id, req.Name)
+	}
+	dir := b.taskDir(id)
+	runDir := path.Join(dir, "run")
+	outDir := b.isolatedDir(outputs)
+	for _, d := range []string{runDir, outDir} {
+		if err := os.MkdirAll(d, os.ModePerm); err != nil {
+			return swarming.TASK_STATE_BOT_DIED, 0, err
+		}
+	}
+	if props.InputsRef != nil && props.InputsRef.Isolated != "" {
+		if _, err := exec.RunCwd(ctx, ".", "cp", "-a", b.isolatedDir(props.InputsRef.Isolated)+"/.", runDir); err != nil {
+			return swarming.TASK_STATE_BOT_DIED, 0, fmt.Errorf("Failed to copy isolated inputs: %s", err)
+		}
+	}
+
+	args := append(util.CopyStringSlice(props.Command), props.ExtraArgs...)
+	for i, arg := range args {
+		args[i] = strings.Replace(arg, specs.PLACEHOLDER_ISOLATED_OUTDIR, outDir, -1)
+	}
+	env := os.Environ()
+	for _, e := range props.Env {
+		env = append(env, fmt.Sprintf("%s=%s", e.Key, e.Value))
+	}
+	for _, e := range props.EnvPrefixes {
+		paths := make([]string, 0, len(e.Value)+1)
+		for _, p := range e.Value {
+			paths = append(paths, path.Join(runDir, p))
+		}
+		if existing := os.Getenv(e.Key); existing != "" {
+			paths = append(paths, existing)
+		}
+		env = append(env, fmt.Sprintf("%s=%s", e.Key, strings.Join(paths, string(os.PathListSeparator))))
+	}
+
+	timeout := time.Duration(props.ExecutionTimeoutSecs) * time.Second
+	if timeout == 0 {
+		timeout = swarming.RECOMMENDED_HARD_TIMEOUT
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var exitCode int64
+	err := util.WithWriteFile(path.Join(dir, LOCAL_LOG_FILE), func(w io.Writer) error {
+		cmd := osexec.CommandContext(ctx, args[0], args[1:]...)
+		cmd.Dir = runDir
+		cmd.Env = env
+		cmd.Stdout = w
+		cmd.Stderr = w
+		err := cmd.Run()
+		if exitErr, ok := err.(*osexec.ExitError); ok {
+			exitCode = 1
+			if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+				exitCode = int64(status.ExitStatus())
+			}
+			return nil
+		}
+		return err
+	})
+	if ctx.Err() == context.DeadlineExceeded {
+		return swarming.TASK_STATE_TIMED_OUT, exitCode, nil
+	} else if ctx.Err() != nil {
+		return swarming.TASK_STATE_KILLED, exitCode, nil
+	} else if err != nil {
+		return swarming.TASK_STATE_BOT_DIED, exitCode, err
+	}
+	return swarming.TASK_STATE_COMPLETED, exitCode, nil
+}
+
+// See documentation for Backend interface.
+func (b *LocalBackend) CancelTask(id string) error {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	t, ok := b.tasks[id]
+	if !ok {
+		// Canceling a finished task has no effect.
+		if _, err := os.Stat(path.Join(b.taskDir(id), LOCAL_RESULT_FILE)); err == nil {
+			return nil
+		}
+		return fmt.Errorf("No such task: %s", id)
+	}
+	switch t.result.State {
+	case swarming.TASK_STATE_PENDING:
+		now := time.Now().UTC().Format(swarming.TIMESTAMP_FORMAT)
+		t.result.State = swarming.TASK_STATE_CANCELED
+		t.result.AbandonedTs = now
+		t.result.ModifiedTs = now
+		pending := make([]string, 0, len(b.pending))
+		for _, p := range b.pending {
+			if p != id {
+				pending = append(pending, p)
+			}
+		}
+		b.pending = pending
+		delete(b.tasks, id)
+		return b.writeResult(t)
+	case swarming.TASK_STATE_RUNNING:
+		// run() records the result once the process exits.
+		t.result.State = swarming.TASK_STATE_KILLED
+		t.cancel()
+		return nil
+	}
+	return nil
+}
+
+// See documentation for Backend interface. Results of tasks which were started
+// by an earlier instance of the LocalBackend are read from disk; if they were
+// unfinished, the task is reported as BOT_DIED.
+func (b *LocalBackend) GetTaskResult(id string) (*swarming_api.SwarmingRpcsTaskResult, error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	if t, ok := b.tasks[id]; ok {
+		return copyResult(t.result), nil
+	}
+	var rv swarming_api.SwarmingRpcsTaskResult
+	if err := util.WithReadFile(path.Join(b.taskDir(id), LOCAL_RESULT_FILE), func(r io.Reader) error {
+		return json.NewDecoder(r).Decode(&rv)
+	}); err != nil {
+		return nil, fmt.Errorf("Failed to read result of local task %s: %s", id, err)
+	}
+	if rv.State == swarming.TASK_STATE_PENDING || rv.State == swarming.TASK_STATE_RUNNING {
+		rv.State = swarming.TASK_STATE_BOT_DIED
+		rv.AbandonedTs = rv.ModifiedTs
+		rv.InternalFailure = true
+	}
+	return &rv, nil
+}
+
+// writeResult writes the current result of the given task to disk. Assumes the
+// caller holds b.mtx.
+func (b *LocalBackend) writeResult(t *localTask) error {
+	return util.WithWriteFile(path.Join(b.taskDir(t.result.TaskId), LOCAL_RESULT_FILE), func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(t.result)
+	})
+}
+
+// copyResult returns a copy of the given SwarmingRpcsTaskResult, so that
+// callers don't race with updates to the original.
+func copyResult(r *swarming_api.SwarmingRpcsTaskResult) *swarming_api.SwarmingRpcsTaskResult {
+	rv := new(swarming_api.SwarmingRpcsTaskResult)
+	*rv = *r
+	rv.Tags = util.CopyStringSlice(r.Tags)
+	if r.OutputsRef != nil {
+		outputs := *r.OutputsRef
+		rv.OutputsRef = &outputs
+	}
+	return rv
+}
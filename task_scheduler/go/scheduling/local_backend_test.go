@@ -0,0 +1,186 @@
+package scheduling
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path"
+	"sync"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+// recordingPubSubHandler records the messages it receives.
+type recordingPubSubHandler struct {
+	mtx  sync.Mutex
+	msgs []*swarming.PubSubTaskMessage
+}
+
+func (h *recordingPubSubHandler) HandleSwarmingPubSub(msg *swarming.PubSubTaskMessage) bool {
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	h.msgs = append(h.msgs, msg)
+	return true
+}
+
+// waitForLocalTask waits for the given task to leave the PENDING and RUNNING
+// states and returns its result.
+func waitForLocalTask(t *testing.T, b *LocalBackend, id string) *swarming_api.SwarmingRpcsTaskResult {
+	for i := 0; i < 100; i++ {
+		res, err := b.GetTaskResult(id)
+		assert.NoError(t, err)
+		if res.State != swarming.TASK_STATE_PENDING && res.State != swarming.TASK_STATE_RUNNING {
+			return res
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.FailNow(t, "Timed out waiting for local task %s", id)
+	return nil
+}
+
+func TestLocalBackend(t *testing.T) {
+	testutils.MediumTest(t)
+
+	wd, cleanup := testutils.TempDir(t)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	_, err := NewLocalBackend(ctx, wd, 0, nil)
+	assert.EqualError(t, err, "LocalBackend requires at least one bot, not 0")
+	b, err := NewLocalBackend(ctx, path.Join(wd, "backend"), 1, []string{"pool:Skia", "os:Linux"})
+	assert.NoError(t, err)
+	h := &recordingPubSubHandler{}
+	b.SetPubSubHandler(h)
+	assert.Equal(t, LOCAL_ISOLATE_SERVER, b.IsolateServerURL())
+
+	// Bot discovery.
+	bots, err := b.GetFreeBots([]string{"Skia"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bots))
+	assert.Equal(t, "local-0", bots[0].BotId)
+	assert.Equal(t, []string{"id:local-0", "os:Linux", "pool:Skia"}, swarming.BotDimensionsToStringSlice(bots[0].Dimensions))
+	bots, err = b.GetFreeBots([]string{"SkiaInternal"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(bots))
+
+	// Isolate inputs.
+	src := path.Join(wd, "src")
+	assert.NoError(t, os.MkdirAll(src, os.ModePerm))
+	assert.NoError(t, ioutil.WriteFile(path.Join(src, "input.txt"), []byte("hello"), 0644))
+	hashes, err := b.IsolateTasks(ctx, []*isolate.Task{{BaseDir: src, IsolateFile: "unused.isolate"}})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(hashes))
+	_, err = b.IsolateTasks(ctx, []*isolate.Task{{BaseDir: src}})
+	assert.EqualError(t, err, "IsolateFile is required.")
+
+	// Trigger a task.
+	request := func(cmd ...string) *swarming_api.SwarmingRpcsNewTaskRequest {
+		return &swarming_api.SwarmingRpcsNewTaskRequest{
+			Name: "my-task",
+			Properties: &swarming_api.SwarmingRpcsTaskProperties{
+				Command: cmd,
+				Dimensions: []*swarming_api.SwarmingRpcsStringPair{
+					{Key: "pool", Value: "Skia"},
+				},
+				Env: []*swarming_api.SwarmingRpcsStringPair{
+					{Key: "GREETING", Value: "hi"},
+				},
+				InputsRef: &swarming_api.SwarmingRpcsFilesRef{
+					Isolated: hashes[0],
+				},
+			},
+			PubsubUserdata: "my-task-id",
+			Tags:           []string{"sk_id:my-task-id"},
+		}
+	}
+	resp, err := b.TriggerTask(request("sh", "-c", "cat input.txt > "+specs.PLACEHOLDER_ISOLATED_OUTDIR+"/out.txt && echo $GREETING >> "+specs.PLACEHOLDER_ISOLATED_OUTDIR+"/out.txt"))
+	assert.NoError(t, err)
+	_, err = swarming.ParseTimestamp(resp.Request.CreatedTs)
+	assert.NoError(t, err)
+	res := waitForLocalTask(t, b, resp.TaskId)
+	assert.Equal(t, swarming.TASK_STATE_COMPLETED, res.State)
+	assert.False(t, res.Failure)
+	assert.Equal(t, "local-0", res.BotId)
+	assert.Equal(t, []string{"sk_id:my-task-id"}, res.Tags)
+	assert.NotEqual(t, "", res.StartedTs)
+	assert.NotEqual(t, "", res.CompletedTs)
+	out, err := ioutil.ReadFile(path.Join(wd, "backend", "isolated", res.OutputsRef.Isolated, "out.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hellohi\n", string(out))
+
+	// The outputs may be used as inputs to another task.
+	hashes, err = b.IsolateTasks(ctx, []*isolate.Task{{BaseDir: src, Deps: []string{res.OutputsRef.Isolated}, IsolateFile: "unused.isolate"}})
+	assert.NoError(t, err)
+	resp, err = b.TriggerTask(request("sh", "-c", "grep hello out.txt && exit 3"))
+	assert.NoError(t, err)
+	res = waitForLocalTask(t, b, resp.TaskId)
+	assert.Equal(t, swarming.TASK_STATE_COMPLETED, res.State)
+	assert.True(t, res.Failure)
+	assert.Equal(t, int64(3), res.ExitCode)
+
+	// No bot matches.
+	req := request("true")
+	req.Properties.Dimensions = append(req.Properties.Dimensions, &swarming_api.SwarmingRpcsStringPair{Key: "os", Value: "Mac"})
+	_, err = b.TriggerTask(req)
+	assert.EqualError(t, err, "No local bot has dimensions matching task \"my-task\"")
+
+	// Cancel a running task, then a pending task.
+	running, err := b.TriggerTask(request("sleep", "60"))
+	assert.NoError(t, err)
+	pending, err := b.TriggerTask(request("true"))
+	assert.NoError(t, err)
+	bots, err = b.GetFreeBots([]string{"Skia"})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(bots))
+	res, err = b.GetTaskResult(pending.TaskId)
+	assert.NoError(t, err)
+	assert.Equal(t, swarming.TASK_STATE_PENDING, res.State)
+	assert.NoError(t, b.CancelTask(pending.TaskId))
+	assert.NoError(t, b.CancelTask(running.TaskId))
+	assert.Equal(t, swarming.TASK_STATE_KILLED, waitForLocalTask(t, b, running.TaskId).State)
+	assert.Equal(t, swarming.TASK_STATE_CANCELED, waitForLocalTask(t, b, pending.TaskId).State)
+	assert.EqualError(t, b.CancelTask("bogus"), "No such task: bogus")
+	assert.NoError(t, b.CancelTask(running.TaskId))
+
+	// Finished tasks are no longer kept in memory and their bots are free.
+	b.mtx.Lock()
+	assert.Equal(t, 0, len(b.tasks))
+	assert.Equal(t, 0, len(b.running))
+	b.mtx.Unlock()
+	bots, err = b.GetFreeBots([]string{"Skia"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(bots))
+
+	// Results are available after a restart.
+	b2, err := NewLocalBackend(ctx, path.Join(wd, "backend"), 1, []string{"pool:Skia"})
+	assert.NoError(t, err)
+	res, err = b2.GetTaskResult(resp.TaskId)
+	assert.NoError(t, err)
+	assert.Equal(t, swarming.TASK_STATE_COMPLETED, res.State)
+	assert.Equal(t, int64(3), res.ExitCode)
+	_, err = b2.GetTaskResult("bogus")
+	assert.Error(t, err)
+
+	// The handler was notified of each task which ran.
+	assert.NoError(t, testutils.EventuallyConsistent(10*time.Second, func() error {
+		h.mtx.Lock()
+		defer h.mtx.Unlock()
+		if len(h.msgs) != 3 {
+			return testutils.TryAgainErr
+		}
+		return nil
+	}))
+	h.mtx.Lock()
+	defer h.mtx.Unlock()
+	for _, msg := range h.msgs {
+		assert.Equal(t, "my-task-id", msg.UserData)
+	}
+}
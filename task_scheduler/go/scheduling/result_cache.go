@@ -0,0 +1,200 @@
+package scheduling
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/metrics2"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+// resultCacheKey returns the key used to find previous Tasks whose results
+// may be reused in place of running this candidate, or the empty string if
+// the candidate may not use the result cache. The candidate must already have
+// been isolated.
+func (c *taskCandidate) resultCacheKey() (string, error) {
+	if !c.TaskSpec.CacheResults || c.IsForceRun() {
+		return "", nil
+	}
+	if c.IsolatedInput == "" {
+		return "", fmt.Errorf("Candidate %s@%s has not been isolated.", c.Name, c.Revision)
+	}
+	// The isolated inputs include the outputs of any dependencies. Sort
+	// the dimensions so that reordering them in the TaskSpec does not
+	// affect the key.
+	spec := c.TaskSpec.Copy()
+	sort.Strings(spec.Dimensions)
+	b, err := json.Marshal(struct {
+		Repo          string          `json:"repo"`
+		Name          string          `json:"name"`
+		IsolatedInput string          `json:"isolatedInput"`
+		TaskSpec      *specs.TaskSpec `json:"taskSpec"`
+	}{
+		Repo:          c.Repo,
+		Name:          c.Name,
+		IsolatedInput: c.IsolatedInput,
+		TaskSpec:      spec,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Failed to encode result cache key: %s", err)
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// getResultCache returns successful Tasks within the scheduling window whose
+// results may be reused, keyed by ResultCacheKey. If none of the given
+// candidates use the result cache, returns nil.
+func (s *TaskScheduler) getResultCache(candidates []*taskCandidate) (map[string]*db.Task, error) {
+	defer metrics2.FuncTimer().Stop()
+	needed := false
+	for _, c := range candidates {
+		if c.TaskSpec.CacheResults {
+			needed = true
+			break
+		}
+	}
+	if !needed {
+		return nil, nil
+	}
+	tasks, err := s.tCache.GetTasksFromDateRange(s.window.EarliestStart(), time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("Failed to load result cache: %s", err)
+	}
+	rv := map[string]*db.Task{}
+	for _, t := range tasks {
+		if t.ResultCacheKey == "" || !t.Success() || t.IsolatedOutput == "" {
+			continue
+		}
+		// Prefer the earliest Task, which is most likely to be the
+		// one which actually ran.
+		if prev, ok := rv[t.ResultCacheKey]; !ok || t.Created.Before(prev.Created) {
+			rv[t.ResultCacheKey] = t
+		}
+	}
+	return rv, nil
+}
+
+// makeCachedTask returns a Task for the given candidate which reuses the
+// results of the given previous Task instead of running.
+func makeCachedTask(c *taskCandidate, prev *db.Task, now time.Time) *db.Task {
+	t := c.MakeTask()
+	t.CachedFrom = prev.Id
+	if prev.CachedFrom != "" {
+		t.CachedFrom = prev.CachedFrom
+	}
+	t.Created = now
+	t.Started = now
+	t.Finished = now
+	t.IsolatedOutput = prev.IsolatedOutput
+	t.ResultCacheKey = prev.ResultCacheKey
+	t.Status = db.TASK_STATUS_SUCCESS
+	return t
+}
+
+// resultCacheInputsId returns an identifier for the inputs of the given
+// candidate, used to remember its result cache key between scheduling cycles
+// so that it only has to be isolated once to compute the key.
+func resultCacheInputsId(c *taskCandidate) string {
+	return c.MakeId() + "|" + strings.Join(c.IsolatedHashes, ",")
+}
+
+// resultCacheCandidatesToIsolate returns the candidates which need to be
+// isolated to compute their result cache keys, ie. those whose keys are not
+// yet known and which are about to be matched to the given free bots. The
+// remaining candidates are isolated in a later cycle, when they reach the
+// front of the queue.
+func resultCacheCandidatesToIsolate(bots []*swarming_api.SwarmingRpcsBotInfo, candidates []*taskCandidate, keys map[string]string) []*taskCandidate {
+	unknown := []*taskCandidate{}
+	for _, c := range candidates {
+		if _, ok := keys[resultCacheInputsId(c)]; !ok && c.IsolatedInput == "" {
+			unknown = append(unknown, c)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	rv, _ := matchCandidatesToBots(bots, unknown, nil)
+	return rv
+}
+
+// findCachedResults returns Tasks which reuse previous results for those
+// candidates in the queue which hit the result cache, keyed by TaskKey. It
+// runs before candidates are matched to bots, so that cache hits never occupy
+// a bot. Candidates whose result cache key is not yet known are isolated to
+// compute it if they are about to be matched to one of the given free bots;
+// those which fail to isolate are treated as misses.
+func (s *TaskScheduler) findCachedResults(ctx context.Context, bots []*swarming_api.SwarmingRpcsBotInfo, queue []*taskCandidate) (map[db.TaskKey]*db.Task, error) {
+	defer metrics2.FuncTimer().Stop()
+	candidates := []*taskCandidate{}
+	for _, c := range queue {
+		if c.TaskSpec.CacheResults && !c.IsForceRun() {
+			candidates = append(candidates, c)
+		}
+	}
+	if len(candidates) == 0 {
+		s.resultCacheKeys = nil
+		return nil, nil
+	}
+	resultCache, err := s.getResultCache(candidates)
+	if err != nil {
+		return nil, err
+	}
+
+	// Isolate the candidates whose keys are not yet known.
+	keys := make(map[string]string, len(candidates))
+	for _, c := range candidates {
+		id := resultCacheInputsId(c)
+		if key, ok := s.resultCacheKeys[id]; ok {
+			keys[id] = key
+		}
+	}
+	if toIsolate := resultCacheCandidatesToIsolate(bots, candidates, keys); len(toIsolate) > 0 {
+		errCh := make(chan error)
+		go func() {
+			for err := range errCh {
+				sklog.Errorf("Failed to isolate candidates for the result cache: %s", err)
+			}
+		}()
+		for range s.isolateCandidates(ctx, toIsolate, errCh) {
+		}
+		close(errCh)
+	}
+
+	rv := map[db.TaskKey]*db.Task{}
+	now := time.Now()
+	for _, c := range candidates {
+		id := resultCacheInputsId(c)
+		key, ok := keys[id]
+		if !ok {
+			if c.IsolatedInput == "" {
+				continue
+			}
+			key, err = c.resultCacheKey()
+			if err != nil {
+				return nil, err
+			}
+			keys[id] = key
+		}
+		prev, ok := resultCache[key]
+		if !ok {
+			continue
+		}
+		t := makeCachedTask(c, prev, now)
+		if err := s.db.AssignId(t); err != nil {
+			return nil, fmt.Errorf("Failed to create cached task: %s", err)
+		}
+		sklog.Infof("Reusing results of task %s for %s@%s", t.CachedFrom, c.Name, c.Revision)
+		rv[c.TaskKey] = t
+	}
+	// Only keep the keys of candidates which are still in the queue.
+	s.resultCacheKeys = keys
+	return rv, nil
+}
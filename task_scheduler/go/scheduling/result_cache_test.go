@@ -0,0 +1,183 @@
+package scheduling
+
+import (
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/specs"
+)
+
+func makeCacheableCandidate(revision, isolatedInput string) *taskCandidate {
+	return &taskCandidate{
+		Commits:       []string{revision},
+		IsolatedInput: isolatedInput,
+		Jobs:          []string{"job"},
+		Score:         1.0,
+		TaskKey: db.TaskKey{
+			RepoState: db.RepoState{
+				Repo:     "skia.git",
+				Revision: revision,
+			},
+			Name: "Build",
+		},
+		TaskSpec: &specs.TaskSpec{
+			CacheResults: true,
+			Dimensions:   []string{"os:Ubuntu", "pool:Skia"},
+			Isolate:      "compile.isolate",
+		},
+	}
+}
+
+func TestResultCacheKey(t *testing.T) {
+	testutils.SmallTest(t)
+
+	c1 := makeCacheableCandidate("abc", "isolated1")
+	k1, err := c1.resultCacheKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, "", k1)
+
+	// The revision does not affect the key, nor does the order of the
+	// dimensions.
+	c2 := makeCacheableCandidate("def", "isolated1")
+	c2.TaskSpec.Dimensions = []string{"pool:Skia", "os:Ubuntu"}
+	k2, err := c2.resultCacheKey()
+	assert.NoError(t, err)
+	assert.Equal(t, k1, k2)
+
+	// Different inputs or dimensions produce different keys.
+	c2 = makeCacheableCandidate("def", "isolated2")
+	k2, err = c2.resultCacheKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k2)
+	c2 = makeCacheableCandidate("def", "isolated1")
+	c2.TaskSpec.Dimensions = []string{"os:Mac", "pool:Skia"}
+	k2, err = c2.resultCacheKey()
+	assert.NoError(t, err)
+	assert.NotEqual(t, k1, k2)
+
+	// Candidates which don't use the cache have no key.
+	c2 = makeCacheableCandidate("def", "isolated1")
+	c2.TaskSpec.CacheResults = false
+	k2, err = c2.resultCacheKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "", k2)
+	c2 = makeCacheableCandidate("def", "isolated1")
+	c2.ForcedJobId = "forced"
+	k2, err = c2.resultCacheKey()
+	assert.NoError(t, err)
+	assert.Equal(t, "", k2)
+
+	// The candidate must have been isolated.
+	_, err = makeCacheableCandidate("def", "").resultCacheKey()
+	assert.EqualError(t, err, "Candidate Build@def has not been isolated.")
+}
+
+func TestResultCacheHit(t *testing.T) {
+	ctx, _, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	c1 := makeCacheableCandidate("abc", "isolated1")
+	key, err := c1.resultCacheKey()
+	assert.NoError(t, err)
+
+	// No previous successful Tasks.
+	cache, err := s.getResultCache([]*taskCandidate{c1})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(cache))
+
+	// Add a successful Task with the same inputs, plus a failed one.
+	now := time.Now()
+	prev := c1.MakeTask()
+	prev.Created = now.Add(-time.Hour)
+	prev.IsolatedOutput = "output"
+	prev.ResultCacheKey = key
+	prev.Status = db.TASK_STATUS_SUCCESS
+	prev.SwarmingTaskId = "swarming1"
+	failed := c1.MakeTask()
+	failed.Created = now.Add(-time.Minute)
+	failed.ResultCacheKey = key
+	failed.Status = db.TASK_STATUS_FAILURE
+	failed.SwarmingTaskId = "swarming2"
+	assert.NoError(t, d.PutTasks([]*db.Task{prev, failed}))
+	assert.NoError(t, s.tCache.Update())
+
+	// The cache isn't loaded if no candidates use it.
+	c1.TaskSpec.CacheResults = false
+	cache, err = s.getResultCache([]*taskCandidate{c1})
+	assert.NoError(t, err)
+	assert.Nil(t, cache)
+	c1.TaskSpec.CacheResults = true
+	cache, err = s.getResultCache([]*taskCandidate{c1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cache))
+	assert.Equal(t, prev.Id, cache[key].Id)
+
+	// A candidate at a different commit with the same inputs reuses the
+	// results instead of being matched to a bot. Candidates which don't
+	// use the cache are ignored.
+	c2 := makeCacheableCandidate("def", "isolated1")
+	c3 := makeCacheableCandidate("ghi", "isolated2")
+	c3.TaskSpec.CacheResults = false
+	cached, err := s.findCachedResults(ctx, nil, []*taskCandidate{c2, c3})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cached))
+	hit := cached[c2.TaskKey]
+	assert.NotEqual(t, "", hit.Id)
+	assert.Equal(t, prev.Id, hit.CachedFrom)
+	assert.Equal(t, "def", hit.Revision)
+	assert.Equal(t, []string{"def"}, hit.Commits)
+	assert.Equal(t, db.TASK_STATUS_SUCCESS, hit.Status)
+	assert.Equal(t, "output", hit.IsolatedOutput)
+	assert.Equal(t, key, hit.ResultCacheKey)
+	assert.True(t, hit.Fake())
+	assert.NoError(t, hit.Validate())
+
+	// The key is remembered, so the candidate doesn't need to be isolated
+	// again in the next cycle.
+	c2 = makeCacheableCandidate("def", "")
+	cached, err = s.findCachedResults(ctx, nil, []*taskCandidate{c2})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(cached))
+	assert.Equal(t, prev.Id, cached[c2.TaskKey].CachedFrom)
+	assert.Equal(t, "", c2.IsolatedInput)
+
+	// Hits on a cached Task refer back to the Task which actually ran.
+	hit2 := makeCachedTask(c2, hit, now)
+	assert.Equal(t, prev.Id, hit2.CachedFrom)
+}
+
+func TestResultCacheCandidatesToIsolate(t *testing.T) {
+	testutils.SmallTest(t)
+
+	// c1 has already been isolated and c2's key is already known.
+	c1 := makeCacheableCandidate("abc", "isolated1")
+	c2 := makeCacheableCandidate("def", "")
+	c3 := makeCacheableCandidate("ghi", "")
+	c3.Score = 3.0
+	c4 := makeCacheableCandidate("jkl", "")
+	c4.Score = 2.0
+	c5 := makeCacheableCandidate("mno", "")
+	c5.TaskSpec.Dimensions = []string{"os:Mac", "pool:Skia"}
+	candidates := []*taskCandidate{c1, c2, c3, c4, c5}
+	keys := map[string]string{
+		resultCacheInputsId(c2): "key",
+	}
+
+	// Nothing is isolated without free bots.
+	assert.Equal(t, 0, len(resultCacheCandidatesToIsolate(nil, candidates, keys)))
+
+	// Only as many candidates as there are matching bots are isolated.
+	bots := []*swarming_api.SwarmingRpcsBotInfo{
+		makeSwarmingBot("bot1", []string{"os:Ubuntu", "pool:Skia"}),
+	}
+	assert.Equal(t, []*taskCandidate{c3}, resultCacheCandidatesToIsolate(bots, candidates, keys))
+	bots = append(bots, makeSwarmingBot("bot2", []string{"os:Mac", "pool:Skia"}))
+	assert.Equal(t, []*taskCandidate{c3, c5}, resultCacheCandidatesToIsolate(bots, candidates, keys))
+
+	// Nothing is isolated if all keys are known.
+	assert.Equal(t, 0, len(resultCacheCandidatesToIsolate(bots, []*taskCandidate{c1, c2}, keys)))
+}
@@ -0,0 +1,537 @@
+package scheduling
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	swarming_api "go.chromium.org/luci/common/api/swarming/swarming/v1"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+)
+
+/*
+	Offline scheduling simulator for TaskScheduler.
+
+	The Simulator replays a recorded history of Jobs, bot availability and task
+	durations through the TaskScheduler's candidate scoring and bot matching,
+	using simulated time. It never talks to Swarming or Isolate; tasks "run" for
+	their recorded median duration on the bot chosen by the scheduler.
+*/
+
+const (
+	// SIMULATOR_DEFAULT_TASK_DURATION is the simulated duration of tasks
+	// whose TaskSpec has no recorded history.
+	SIMULATOR_DEFAULT_TASK_DURATION = 10 * time.Minute
+
+	// SIMULATOR_DEFAULT_TICK is the default amount of simulated time
+	// between scheduling loops.
+	SIMULATOR_DEFAULT_TICK = time.Minute
+
+	// SIMULATOR_ISOLATED_OUTPUT is used as the isolated output of every
+	// successful simulated task, so that dependent tasks may be scheduled.
+	SIMULATOR_ISOLATED_OUTPUT = "simulated-isolated-output"
+)
+
+// SimulatedBot describes a bot which was available during the recorded
+// history.
+type SimulatedBot struct {
+	Id string `json:"id"`
+
+	// Dimensions are in "key:value" form, as in TaskSpec.Dimensions.
+	Dimensions []string `json:"dimensions"`
+
+	// FirstSeen and LastSeen bound the period during which the bot is
+	// considered to be available for scheduling.
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// available returns true iff the bot is available at the given time.
+func (b *SimulatedBot) available(now time.Time) bool {
+	return !now.Before(b.FirstSeen) && !now.After(b.LastSeen)
+}
+
+// botInfo returns a Swarming bot description for the SimulatedBot.
+func (b *SimulatedBot) botInfo() (*swarming_api.SwarmingRpcsBotInfo, error) {
+	dims := make([]*swarming_api.SwarmingRpcsStringListPair, 0, len(b.Dimensions))
+	for _, d := range b.Dimensions {
+		split := strings.SplitN(d, ":", 2)
+		if len(split) != 2 {
+			return nil, fmt.Errorf("Dimension %q for bot %s does not contain a colon!", d, b.Id)
+		}
+		dims = append(dims, &swarming_api.SwarmingRpcsStringListPair{
+			Key:   split[0],
+			Value: []string{split[1]},
+		})
+	}
+	return &swarming_api.SwarmingRpcsBotInfo{
+		BotId:      b.Id,
+		Dimensions: dims,
+	}, nil
+}
+
+// SimulatorHistory is the recorded history replayed by a Simulator.
+type SimulatorHistory struct {
+	// Bots are the bots which may run simulated tasks.
+	Bots []*SimulatedBot
+
+	// DefaultDuration is the simulated duration of tasks whose TaskSpec
+	// does not appear in Durations.
+	DefaultDuration time.Duration
+
+	// Durations are the simulated durations of tasks, keyed by TaskSpec
+	// name.
+	Durations map[string]time.Duration
+
+	// Jobs are inserted into the DB when the simulated time reaches their
+	// Created timestamp.
+	Jobs []*db.Job
+
+	// Results are the recorded statuses of the first attempt at each
+	// TaskKey, with ForcedJobId cleared. Tasks which do not appear here,
+	// and all retries, succeed.
+	Results map[db.TaskKey]db.TaskStatus
+
+	// Start and End bound the simulated time period.
+	Start time.Time
+	End   time.Time
+}
+
+// resultKey returns the key used for the given TaskKey in
+// SimulatorHistory.Results.
+func resultKey(k db.TaskKey) db.TaskKey {
+	rv := k.Copy()
+	rv.ForcedJobId = ""
+	return rv
+}
+
+// LoadSimulatorHistory reads the Tasks and Jobs created within the given time
+// range from the given DB, eg. a restored backup, and derives a
+// SimulatorHistory from them. Bot dimensions are derived from the TaskSpecs of
+// the tasks which ran on each bot. Try jobs are not included, since simulating
+// them would require applying patches.
+func (s *TaskScheduler) LoadSimulatorHistory(ctx context.Context, tr db.TaskReader, jr db.JobReader, start, end time.Time) (*SimulatorHistory, error) {
+	tasks, err := tr.GetTasksFromDateRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+	jobs, err := jr.GetJobsFromDateRange(start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	rv := &SimulatorHistory{
+		Bots:            []*SimulatedBot{},
+		DefaultDuration: SIMULATOR_DEFAULT_TASK_DURATION,
+		Durations:       map[string]time.Duration{},
+		Jobs:            make([]*db.Job, 0, len(jobs)),
+		Results:         map[db.TaskKey]db.TaskStatus{},
+		Start:           start,
+		End:             end,
+	}
+	for _, j := range jobs {
+		if j.IsTryJob() {
+			continue
+		}
+		rv.Jobs = append(rv.Jobs, j)
+	}
+
+	bots := map[string]*SimulatedBot{}
+	botDims := map[string]util.StringSet{}
+	specDims := map[string][]string{}
+	durations := map[string][]time.Duration{}
+	for _, t := range tasks {
+		if t.IsTryJob() || t.Fake() {
+			continue
+		}
+		if t.Attempt == 0 && t.Done() {
+			rv.Results[resultKey(t.TaskKey)] = t.Status
+		}
+		if util.TimeIsZero(t.Started) || util.TimeIsZero(t.Finished) || t.SwarmingBotId == "" {
+			continue
+		}
+		if t.Status == db.TASK_STATUS_SUCCESS || t.Status == db.TASK_STATUS_FAILURE {
+			durations[t.Name] = append(durations[t.Name], t.Finished.Sub(t.Started))
+		}
+
+		dims, ok := specDims[t.Name]
+		if !ok {
+			spec, err := s.taskCfgCache.GetTaskSpec(ctx, t.RepoState, t.Name)
+			if err != nil {
+				sklog.Warningf("Failed to obtain TaskSpec for %s @ %s; ignoring it for bot dimensions: %s", t.Name, t.Revision, err)
+				specDims[t.Name] = nil
+				continue
+			}
+			dims = spec.Dimensions
+			specDims[t.Name] = dims
+		}
+		if dims == nil {
+			continue
+		}
+		b, ok := bots[t.SwarmingBotId]
+		if !ok {
+			b = &SimulatedBot{
+				Id:        t.SwarmingBotId,
+				FirstSeen: t.Started,
+				LastSeen:  t.Finished,
+			}
+			bots[b.Id] = b
+			botDims[b.Id] = util.StringSet{}
+		}
+		if t.Started.Before(b.FirstSeen) {
+			b.FirstSeen = t.Started
+		}
+		if t.Finished.After(b.LastSeen) {
+			b.LastSeen = t.Finished
+		}
+		botDims[b.Id].AddLists(dims)
+	}
+	for id, b := range bots {
+		b.Dimensions = botDims[id].Keys()
+		sort.Strings(b.Dimensions)
+		rv.Bots = append(rv.Bots, b)
+	}
+	sort.Slice(rv.Bots, func(i, j int) bool {
+		return rv.Bots[i].Id < rv.Bots[j].Id
+	})
+	for name, d := range durations {
+		rv.Durations[name] = medianDuration(d)
+	}
+	sklog.Infof("Loaded simulator history with %d jobs, %d bots and durations for %d task specs.", len(rv.Jobs), len(rv.Bots), len(rv.Durations))
+	return rv, nil
+}
+
+// medianDuration returns the median of the given durations, which must not be
+// empty. The slice is sorted in place.
+func medianDuration(d []time.Duration) time.Duration {
+	sort.Slice(d, func(i, j int) bool {
+		return d[i] < d[j]
+	})
+	return d[len(d)/2]
+}
+
+// SimulatorResults contains the statistics gathered during a simulation.
+type SimulatorResults struct {
+	// BlamelistSizes are the blamelist lengths of all triggered tasks,
+	// excluding forced tasks.
+	BlamelistSizes []int
+
+	// BotAvailable and BotBusy are the amounts of simulated time during
+	// which each bot was available and busy, respectively, keyed by bot
+	// ID.
+	BotAvailable map[string]time.Duration
+	BotBusy      map[string]time.Duration
+
+	// QueueLatencies are the amounts of time each triggered task spent in
+	// the queue before being matched to a bot.
+	QueueLatencies []time.Duration
+
+	// RemainingCandidates is the length of the queue at the end of the
+	// simulation.
+	RemainingCandidates int
+
+	// TasksTriggered is the number of tasks triggered.
+	TasksTriggered int
+}
+
+// Utilization returns the fraction of the available bot time which was spent
+// running tasks.
+func (r *SimulatorResults) Utilization() float64 {
+	var available, busy time.Duration
+	for _, d := range r.BotAvailable {
+		available += d
+	}
+	for _, d := range r.BotBusy {
+		busy += d
+	}
+	if available == 0 {
+		return 0.0
+	}
+	return float64(busy) / float64(available)
+}
+
+// Report writes a human-readable summary of the SimulatorResults.
+func (r *SimulatorResults) Report(w io.Writer) error {
+	latencies := make([]time.Duration, len(r.QueueLatencies))
+	copy(latencies, r.QueueLatencies)
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	blamelists := make([]int, len(r.BlamelistSizes))
+	copy(blamelists, r.BlamelistSizes)
+	sort.Ints(blamelists)
+
+	var totalLatency time.Duration
+	for _, l := range latencies {
+		totalLatency += l
+	}
+	totalCommits := 0
+	for _, b := range blamelists {
+		totalCommits += b
+	}
+
+	lines := []string{
+		fmt.Sprintf("Tasks triggered:         %d", r.TasksTriggered),
+		fmt.Sprintf("Candidates remaining:    %d", r.RemainingCandidates),
+	}
+	if len(latencies) > 0 {
+		lines = append(lines,
+			fmt.Sprintf("Queue latency (mean):    %s", totalLatency/time.Duration(len(latencies))),
+			fmt.Sprintf("Queue latency (median):  %s", latencies[len(latencies)/2]),
+			fmt.Sprintf("Queue latency (90th):    %s", latencies[len(latencies)*9/10]),
+			fmt.Sprintf("Queue latency (max):     %s", latencies[len(latencies)-1]),
+		)
+	}
+	if len(blamelists) > 0 {
+		lines = append(lines,
+			fmt.Sprintf("Blamelist size (mean):   %.2f", float64(totalCommits)/float64(len(blamelists))),
+			fmt.Sprintf("Blamelist size (median): %d", blamelists[len(blamelists)/2]),
+			fmt.Sprintf("Blamelist size (max):    %d", blamelists[len(blamelists)-1]),
+		)
+	}
+	lines = append(lines, fmt.Sprintf("Bot utilization:         %.1f%%", 100.0*r.Utilization()))
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// simulatedTask tracks a task which is "running" in the simulation.
+type simulatedTask struct {
+	bot      string
+	finishAt time.Time
+}
+
+// Simulator replays a SimulatorHistory through a TaskScheduler.
+type Simulator struct {
+	history *SimulatorHistory
+	s       *TaskScheduler
+	tick    time.Duration
+}
+
+// NewSimulator returns a Simulator instance. The TaskScheduler should use an
+// empty DB, and its window should include the whole of the simulated period.
+// The TaskScheduler should not be started.
+func NewSimulator(s *TaskScheduler, history *SimulatorHistory, tick time.Duration) *Simulator {
+	if tick == 0 {
+		tick = SIMULATOR_DEFAULT_TICK
+	}
+	return &Simulator{
+		history: history,
+		s:       s,
+		tick:    tick,
+	}
+}
+
+// duration returns the simulated duration of a task with the given name.
+func (sim *Simulator) duration(name string) time.Duration {
+	if d, ok := sim.history.Durations[name]; ok {
+		return d
+	}
+	return sim.history.DefaultDuration
+}
+
+// result returns the simulated final status of the given task.
+func (sim *Simulator) result(t *db.Task) db.TaskStatus {
+	if t.Attempt == 0 {
+		if status, ok := sim.history.Results[resultKey(t.TaskKey)]; ok {
+			return status
+		}
+	}
+	return db.TASK_STATUS_SUCCESS
+}
+
+// finishTasks marks all running tasks which should have finished by the given
+// time as finished.
+func (sim *Simulator) finishTasks(now time.Time, running map[string]*simulatedTask) error {
+	finished := []*db.Task{}
+	for id, st := range running {
+		if st.finishAt.After(now) {
+			continue
+		}
+		t, err := sim.s.db.GetTaskById(id)
+		if err != nil {
+			return err
+		} else if t == nil {
+			return fmt.Errorf("Simulated task %s is missing from the DB.", id)
+		}
+		t.Status = sim.result(t)
+		t.Finished = st.finishAt
+		if t.Status == db.TASK_STATUS_SUCCESS {
+			t.IsolatedOutput = SIMULATOR_ISOLATED_OUTPUT
+		}
+		finished = append(finished, t)
+		delete(running, id)
+	}
+	if len(finished) == 0 {
+		return nil
+	}
+	return sim.s.db.PutTasks(finished)
+}
+
+// newJob returns a copy of the given recorded Job, reset to its initial state.
+func newJob(j *db.Job) *db.Job {
+	rv := j.Copy()
+	rv.BuildbucketBuildId = 0
+	rv.BuildbucketLeaseKey = 0
+	rv.DbModified = time.Time{}
+	rv.Finished = time.Time{}
+	rv.Id = ""
+	rv.Status = db.JOB_STATUS_IN_PROGRESS
+	rv.Tasks = map[string][]*db.TaskSummary{}
+	return rv
+}
+
+// Run runs the simulation and returns the gathered statistics.
+func (sim *Simulator) Run(ctx context.Context) (*SimulatorResults, error) {
+	s := sim.s
+	rv := &SimulatorResults{
+		BlamelistSizes: []int{},
+		BotAvailable:   map[string]time.Duration{},
+		BotBusy:        map[string]time.Duration{},
+		QueueLatencies: []time.Duration{},
+	}
+
+	jobs := make([]*db.Job, len(sim.history.Jobs))
+	copy(jobs, sim.history.Jobs)
+	sort.Sort(db.JobSlice(jobs))
+	nextJob := 0
+
+	botInfos := make(map[string]*swarming_api.SwarmingRpcsBotInfo, len(sim.history.Bots))
+	for _, b := range sim.history.Bots {
+		info, err := b.botInfo()
+		if err != nil {
+			return nil, err
+		}
+		botInfos[b.Id] = info
+	}
+
+	// running contains the tasks which are currently running, keyed by ID.
+	running := map[string]*simulatedTask{}
+	// firstQueued tracks when each candidate first appeared in the queue.
+	firstQueued := map[db.TaskKey]time.Time{}
+	for now := sim.history.Start; !now.After(sim.history.End); now = now.Add(sim.tick) {
+		// Finish any tasks which should have completed by now.
+		if err := sim.finishTasks(now, running); err != nil {
+			return nil, err
+		}
+		busy := util.StringSet{}
+		for _, st := range running {
+			busy[st.bot] = true
+		}
+
+		// Insert any Jobs which have been created by now.
+		newJobs := []*db.Job{}
+		for ; nextJob < len(jobs) && !jobs[nextJob].Created.After(now); nextJob++ {
+			newJobs = append(newJobs, newJob(jobs[nextJob]))
+		}
+		if len(newJobs) > 0 {
+			if err := s.db.PutJobs(newJobs); err != nil {
+				return nil, err
+			}
+		}
+		if err := s.tCache.Update(); err != nil {
+			return nil, err
+		}
+		if err := s.jCache.Update(); err != nil {
+			return nil, err
+		}
+		if err := s.updateUnfinishedJobs(); err != nil {
+			return nil, err
+		}
+
+		// Regenerate the queue.
+		queue, err := s.regenerateTaskQueue(ctx, now)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range queue {
+			if _, ok := firstQueued[c.TaskKey]; !ok {
+				firstQueued[c.TaskKey] = now
+			}
+		}
+
+		// Find the free bots.
+		bots := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(sim.history.Bots))
+		for _, b := range sim.history.Bots {
+			if b.available(now) && !busy[b.Id] {
+				bots = append(bots, botInfos[b.Id])
+			}
+		}
+
+		// Match bots to candidates and "trigger" the tasks.
+		fs, err := s.newFairShare(ctx)
+		if err != nil {
+			return nil, err
+		}
+		schedule, chosen := matchCandidatesToBots(bots, queue, fs)
+		if err := sim.trigger(ctx, now, schedule, chosen, firstQueued, running, rv); err != nil {
+			return nil, err
+		}
+		rv.RemainingCandidates = len(queue) - len(schedule)
+
+		// Record bot utilization for this tick.
+		for _, st := range running {
+			busy[st.bot] = true
+		}
+		for _, b := range sim.history.Bots {
+			if b.available(now) {
+				rv.BotAvailable[b.Id] += sim.tick
+				if busy[b.Id] {
+					rv.BotBusy[b.Id] += sim.tick
+				}
+			}
+		}
+	}
+	return rv, nil
+}
+
+// trigger inserts tasks for the given candidates into the DB, as if they had
+// started running on the chosen bots at the given time, and records them in
+// the SimulatorResults.
+func (sim *Simulator) trigger(ctx context.Context, now time.Time, schedule []*taskCandidate, chosen map[db.TaskKey]string, firstQueued map[db.TaskKey]time.Time, running map[string]*simulatedTask, rv *SimulatorResults) error {
+	if len(schedule) == 0 {
+		return nil
+	}
+	insert := map[string]map[string][]*db.Task{}
+	triggered := make([]*db.Task, 0, len(schedule))
+	for _, c := range schedule {
+		t := c.MakeTask()
+		t.Created = now
+		t.Started = now
+		t.Status = db.TASK_STATUS_RUNNING
+		t.SwarmingBotId = chosen[c.TaskKey]
+		t.SwarmingTaskId = fmt.Sprintf("simulated-%d", rv.TasksTriggered+len(triggered))
+		byRepo, ok := insert[t.Repo]
+		if !ok {
+			byRepo = map[string][]*db.Task{}
+			insert[t.Repo] = byRepo
+		}
+		byRepo[t.Name] = append(byRepo[t.Name], t)
+		triggered = append(triggered, t)
+
+		rv.QueueLatencies = append(rv.QueueLatencies, now.Sub(firstQueued[c.TaskKey]))
+		delete(firstQueued, c.TaskKey)
+	}
+	if err := sim.s.AddTasks(ctx, insert); err != nil {
+		return err
+	}
+	for _, t := range triggered {
+		running[t.Id] = &simulatedTask{
+			bot:      t.SwarmingBotId,
+			finishAt: now.Add(sim.duration(t.Name)),
+		}
+		if !t.IsForceRun() && !t.IsTryJob() {
+			rv.BlamelistSizes = append(rv.BlamelistSizes, len(t.Commits))
+		}
+	}
+	rv.TasksTriggered += len(triggered)
+	return nil
+}
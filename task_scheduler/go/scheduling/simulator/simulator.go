@@ -0,0 +1,138 @@
+package main
+
+/*
+	Offline scheduling simulator for TaskScheduler.
+
+	Replays the Jobs, bot availability and task durations recorded in a Task
+	Scheduler DB backup through the TaskScheduler's candidate scoring and bot
+	matching, and reports queue latency, blamelist sizes and bot utilization.
+	Swarming and Isolate are replaced with in-memory fakes. Use this to compare
+	the effects of scoring changes before deploying them.
+
+	Example:
+	  simulator --backup /path/to/task_scheduler.bdb \
+	    --repo https://skia.googlesource.com/skia.git \
+	    --begin 2018-04-01T00:00:00Z --period 24h
+*/
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"time"
+
+	"go.skia.org/infra/go/common"
+	"go.skia.org/infra/go/gerrit"
+	"go.skia.org/infra/go/git/repograph"
+	"go.skia.org/infra/go/isolate"
+	"go.skia.org/infra/go/mockhttpclient"
+	"go.skia.org/infra/go/sklog"
+	"go.skia.org/infra/go/swarming"
+	"go.skia.org/infra/go/util"
+	"go.skia.org/infra/task_scheduler/go/db"
+	"go.skia.org/infra/task_scheduler/go/db/local_db"
+	"go.skia.org/infra/task_scheduler/go/scheduling"
+	"go.skia.org/infra/task_scheduler/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/tryjobs"
+)
+
+var (
+	backup          = flag.String("backup", local_db.DB_FILENAME, "Task Scheduler DB backup file from which to read the recorded history. This file may be modified; use a copy.")
+	beginStr        = flag.String("begin", "", "Beginning of the time range to simulate; default (now - period). Format is "+time.RFC3339+".")
+	defaultDuration = flag.Duration("default_task_duration", scheduling.SIMULATOR_DEFAULT_TASK_DURATION, "Simulated duration of tasks with no recorded history.")
+	fairShareConfig = flag.String("fair_share_config", "", "Optional JSON file containing fair-share weights for repos, job categories and TaskSpec name prefixes.")
+	period          = flag.Duration("period", 24*time.Hour, "Duration of the time range to simulate.")
+	repoUrls        = common.NewMultiStringFlag("repo", nil, "Repositories for which to schedule tasks. The checkouts must contain all recorded commits.")
+	scoreDecay24Hr  = flag.Float64("scoreDecay24Hr", 0.9, "Task candidate scores are penalized using linear time decay. This is the desired value after 24 hours. Setting it to 1.0 causes commits not to be prioritized according to commit time.")
+	tick            = flag.Duration("tick", scheduling.SIMULATOR_DEFAULT_TICK, "Amount of simulated time between scheduling loops.")
+	workdir         = flag.String("workdir", "simulator_workdir", "Working directory to use.")
+)
+
+func main() {
+	defer common.LogPanic()
+	common.Init()
+
+	if len(*repoUrls) == 0 {
+		sklog.Fatal("At least one --repo is required.")
+	}
+
+	begin := time.Now().UTC().Add(-*period)
+	if *beginStr != "" {
+		parsed, err := time.Parse(time.RFC3339, *beginStr)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		begin = parsed.UTC()
+	}
+	end := begin.Add(*period)
+
+	ctx := context.Background()
+	wd := *workdir
+	if err := os.MkdirAll(wd, os.ModePerm); err != nil {
+		sklog.Fatal(err)
+	}
+
+	// Check out the repos.
+	repos, err := repograph.NewMap(ctx, []string(*repoUrls), wd)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	if err := repos.Update(ctx); err != nil {
+		sklog.Fatal(err)
+	}
+
+	// Create a TaskScheduler which uses fakes for everything but the repos.
+	// Its window must include the whole simulated time range.
+	d := db.NewInMemoryDB()
+	isolateClient, err := isolate.NewClient(wd, isolate.ISOLATE_SERVER_URL_FAKE)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	swarmingClient := testutils.NewTestClient()
+	urlMock := mockhttpclient.NewURLMock()
+	gitcookies := path.Join(wd, "gitcookies_fake")
+	if err := ioutil.WriteFile(gitcookies, []byte(".googlesource.com\tTRUE\t/\tTRUE\t123\to\tgit-user.google.com=abc123"), os.ModePerm); err != nil {
+		sklog.Fatal(err)
+	}
+	g, err := gerrit.NewGerrit("https://fake-skia-review.googlesource.com", gitcookies, urlMock.Client())
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	windowPeriod := time.Now().Sub(begin) + 24*time.Hour
+	s, err := scheduling.NewTaskScheduler(ctx, d, windowPeriod, 0, wd, "fake.server", repos, scheduling.NewSwarmingBackend(isolateClient, swarmingClient), http.DefaultClient, *scoreDecay24Hr, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, map[string]string{}, swarming.POOLS_PUBLIC, "", "", g)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	if *fairShareConfig != "" {
+		cfg, err := scheduling.ReadFairShareConfig(*fairShareConfig)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		s.SetFairShareConfig(cfg)
+	}
+
+	// Load the recorded history.
+	recorded, err := local_db.NewDB(local_db.DB_NAME, *backup)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	defer util.Close(recorded)
+	history, err := s.LoadSimulatorHistory(ctx, recorded, recorded, begin, end)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	history.DefaultDuration = *defaultDuration
+
+	// Run the simulation.
+	sklog.Infof("Simulating %s to %s...", begin, end)
+	results, err := scheduling.NewSimulator(s, history, *tick).Run(ctx)
+	if err != nil {
+		sklog.Fatal(err)
+	}
+	if err := results.Report(os.Stdout); err != nil {
+		sklog.Fatal(err)
+	}
+}
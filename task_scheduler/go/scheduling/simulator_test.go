@@ -0,0 +1,97 @@
+package scheduling
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	assert "github.com/stretchr/testify/require"
+	"go.skia.org/infra/go/testutils"
+	"go.skia.org/infra/task_scheduler/go/db"
+	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
+)
+
+func TestMedianDuration(t *testing.T) {
+	testutils.SmallTest(t)
+	assert.Equal(t, time.Second, medianDuration([]time.Duration{time.Second}))
+	assert.Equal(t, 2*time.Second, medianDuration([]time.Duration{3 * time.Second, time.Second, 2 * time.Second}))
+	assert.Equal(t, 3*time.Second, medianDuration([]time.Duration{4 * time.Second, time.Second, 3 * time.Second, 2 * time.Second}))
+}
+
+func TestSimulator(t *testing.T) {
+	ctx, gb, _, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	rs2 := getRS2(t, ctx, gb)
+
+	// Create Jobs for every JobSpec at both commits.
+	start := time.Now()
+	jobs := []*db.Job{}
+	for _, rs := range []db.RepoState{rs1, rs2} {
+		cfg, err := s.taskCfgCache.ReadTasksCfg(ctx, rs)
+		assert.NoError(t, err)
+		for name := range cfg.Jobs {
+			j, err := s.taskCfgCache.MakeJob(ctx, rs, name)
+			assert.NoError(t, err)
+			j.Created = start
+			jobs = append(jobs, j)
+		}
+	}
+	assert.Equal(t, 5, len(jobs))
+
+	end := start.Add(2 * time.Hour)
+	history := &SimulatorHistory{
+		Bots: []*SimulatedBot{
+			{
+				Id:         "linux",
+				Dimensions: []string{"os:Ubuntu", "pool:Skia"},
+				FirstSeen:  start,
+				LastSeen:   end,
+			},
+			{
+				Id:         "android",
+				Dimensions: []string{"device_type:grouper", "os:Android", "pool:Skia"},
+				FirstSeen:  start,
+				LastSeen:   end,
+			},
+		},
+		DefaultDuration: 20 * time.Minute,
+		Durations: map[string]time.Duration{
+			specs_testutils.BuildTask: 10 * time.Minute,
+		},
+		Jobs:    jobs,
+		Results: map[db.TaskKey]db.TaskStatus{},
+		Start:   start,
+		End:     end,
+	}
+	res, err := NewSimulator(s, history, 5*time.Minute).Run(ctx)
+	assert.NoError(t, err)
+
+	// Build@c1, Build@c2, Test@c1, Test@c2, Perf@c2.
+	assert.Equal(t, 5, res.TasksTriggered)
+	assert.Equal(t, 0, res.RemainingCandidates)
+	assert.Equal(t, 5, len(res.QueueLatencies))
+	assert.Equal(t, 5, len(res.BlamelistSizes))
+	totalCommits := 0
+	for _, b := range res.BlamelistSizes {
+		totalCommits += b
+	}
+	// Build@c2 and Test@c2 run first and cover both commits; Build@c1,
+	// Test@c1 and Perf@c2 each cover a single commit.
+	assert.Equal(t, 7, totalCommits)
+	assert.Equal(t, 2*time.Hour+5*time.Minute, res.BotAvailable["linux"])
+	assert.Equal(t, 20*time.Minute, res.BotBusy["linux"])
+	u := res.Utilization()
+	assert.True(t, u > 0.0 && u < 1.0)
+
+	// All of the Jobs should have finished.
+	assert.NoError(t, s.jCache.Update())
+	unfinished, err := s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, len(unfinished))
+
+	var buf bytes.Buffer
+	assert.NoError(t, res.Report(&buf))
+	assert.Contains(t, buf.String(), "Tasks triggered:         5")
+}
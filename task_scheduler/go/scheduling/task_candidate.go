@@ -26,6 +26,7 @@ type taskCandidate struct {
 	// could be inherited from any matching Job. Therefore, this should be
 	// used for non-critical, informational purposes only.
 	BuildbucketBuildId int64     `json:"buildbucketBuildId"`
+	Category           string    `json:"category"`
 	Commits            []string  `json:"commits"`
 	IsolatedInput      string    `json:"isolatedInput"`
 	IsolatedHashes     []string  `json:"isolatedHashes"`
@@ -37,6 +38,11 @@ type taskCandidate struct {
 	StealingFromId     string    `json:"stealingFromId"`
 	db.TaskKey
 	TaskSpec *specs.TaskSpec `json:"taskSpec"`
+
+	// JobDeadline is the earliest deadline of any of the candidate's Jobs,
+	// and JobDeadlineAllowed is the time that Job was given to finish.
+	JobDeadline        time.Time     `json:"jobDeadline"`
+	JobDeadlineAllowed time.Duration `json:"jobDeadlineAllowed"`
 }
 
 // Copy returns a copy of the taskCandidate.
@@ -44,10 +50,13 @@ func (c *taskCandidate) Copy() *taskCandidate {
 	return &taskCandidate{
 		Attempt:            c.Attempt,
 		BuildbucketBuildId: c.BuildbucketBuildId,
+		Category:           c.Category,
 		Commits:            util.CopyStringSlice(c.Commits),
 		IsolatedInput:      c.IsolatedInput,
 		IsolatedHashes:     util.CopyStringSlice(c.IsolatedHashes),
 		JobCreated:         c.JobCreated,
+		JobDeadline:        c.JobDeadline,
+		JobDeadlineAllowed: c.JobDeadlineAllowed,
 		Jobs:               util.CopyStringSlice(c.Jobs),
 		ParentTaskIds:      util.CopyStringSlice(c.ParentTaskIds),
 		RetryOf:            c.RetryOf,
@@ -17,10 +17,13 @@ func TestCopyTaskCandidate(t *testing.T) {
 	v := &taskCandidate{
 		Attempt:            3,
 		BuildbucketBuildId: 8888,
+		Category:           FAIR_SHARE_CATEGORY_TRY,
 		Commits:            []string{"a", "b"},
 		IsolatedInput:      "lonely-parameter",
 		IsolatedHashes:     []string{"browns"},
 		JobCreated:         time.Now(),
+		JobDeadline:        time.Now().Add(time.Hour),
+		JobDeadlineAllowed: 2 * time.Hour,
 		Jobs:               []string{"123abc", "456def"},
 		ParentTaskIds:      []string{"38", "39", "40"},
 		RetryOf:            "41",
@@ -26,6 +26,7 @@ import (
 	"go.skia.org/infra/go/timeout"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/dag"
 	"go.skia.org/infra/task_scheduler/go/db"
 	"go.skia.org/infra/task_scheduler/go/db/local_db"
 	"go.skia.org/infra/task_scheduler/go/specs"
@@ -45,6 +46,10 @@ const (
 	// lower than tryjob tasks that haven't run yet.
 	CANDIDATE_SCORE_TRY_JOB_RETRY_MULTIPLIER = 0.75
 
+	// DURATION_STATS_TTL is how long the task duration statistics used by
+	// GetJobDAG are cached before they are recomputed from the DB.
+	DURATION_STATS_TTL = 30 * time.Minute
+
 	// MAX_BLAMELIST_COMMITS is the maximum number of commits which are
 	// allowed in a task blamelist before we stop tracing commit history.
 	MAX_BLAMELIST_COMMITS = 500
@@ -77,13 +82,22 @@ var (
 
 // TaskScheduler is a struct used for scheduling tasks on bots.
 type TaskScheduler struct {
+	backend             Backend
 	bl                  *blacklist.Blacklist
-	busyBots            *busyBots
+	blacklistNotify     func(*blacklist.Rule) error
+	blacklistNotifyMtx  sync.Mutex
 	candidateMetrics    map[string]metrics2.Int64Metric
 	candidateMetricsMtx sync.Mutex
 	db                  db.DB
 	depotToolsDir       string
-	isolate             *isolate.Client
+	durationStats       map[durationStatsKey]*cachedDurationStats
+	durationStatsMtx    sync.Mutex
+	fairShareCfg        *FairShareConfig
+	fairShareMtx        sync.RWMutex
+	fairShareStatus     []*FairShareGroupStatus // protected by queueMtx.
+	flakeMetrics        map[flakeRateKey]metrics2.Float64Metric
+	flakeMtx            sync.Mutex
+	flakeQuarantineCfg  *FlakeQuarantineConfig // protected by flakeMtx.
 	jCache              db.JobCache
 	lastScheduled       time.Time // protected by queueMtx.
 
@@ -97,7 +111,9 @@ type TaskScheduler struct {
 	queue            []*taskCandidate // protected by queueMtx.
 	queueMtx         sync.RWMutex
 	repos            repograph.Map
-	swarming         swarming.ApiClient
+	resultCacheKeys  map[string]string // Only used by scheduleTasks.
+	sloMetrics       map[jobSLOKey]metrics2.Float64Metric
+	sloMtx           sync.Mutex
 	taskCfgCache     *specs.TaskCfgCache
 	tCache           db.TaskCache
 	timeDecayAmt24Hr float64
@@ -106,7 +122,7 @@ type TaskScheduler struct {
 	workdir          string
 }
 
-func NewTaskScheduler(ctx context.Context, d db.DB, period time.Duration, numCommits int, workdir, host string, repos repograph.Map, isolateClient *isolate.Client, swarmingClient swarming.ApiClient, c *http.Client, timeDecayAmt24Hr float64, buildbucketApiUrl, trybotBucket string, projectRepoMapping map[string]string, pools []string, pubsubTopic, depotTools string, gerrit gerrit.GerritInterface) (*TaskScheduler, error) {
+func NewTaskScheduler(ctx context.Context, d db.DB, period time.Duration, numCommits int, workdir, host string, repos repograph.Map, backend Backend, c *http.Client, timeDecayAmt24Hr float64, buildbucketApiUrl, trybotBucket string, projectRepoMapping map[string]string, pools []string, pubsubTopic, depotTools string, gerrit gerrit.GerritInterface) (*TaskScheduler, error) {
 	bl, err := blacklist.FromFile(path.Join(workdir, "blacklist.json"))
 	if err != nil {
 		return nil, fmt.Errorf("Failed to create blacklist from file: %s", err)
@@ -143,12 +159,13 @@ func NewTaskScheduler(ctx context.Context, d db.DB, period time.Duration, numCom
 	}
 
 	s := &TaskScheduler{
+		backend:          backend,
 		bl:               bl,
-		busyBots:         newBusyBots(),
 		candidateMetrics: map[string]metrics2.Int64Metric{},
 		db:               d,
 		depotToolsDir:    depotTools,
-		isolate:          isolateClient,
+		durationStats:    map[durationStatsKey]*cachedDurationStats{},
+		flakeMetrics:     map[flakeRateKey]metrics2.Float64Metric{},
 		jCache:           jCache,
 		newTasks:         map[db.RepoState]util.StringSet{},
 		newTasksMtx:      sync.RWMutex{},
@@ -158,7 +175,7 @@ func NewTaskScheduler(ctx context.Context, d db.DB, period time.Duration, numCom
 		queue:            []*taskCandidate{},
 		queueMtx:         sync.RWMutex{},
 		repos:            repos,
-		swarming:         swarmingClient,
+		sloMetrics:       map[jobSLOKey]metrics2.Float64Metric{},
 		taskCfgCache:     taskCfgCache,
 		tCache:           tCache,
 		timeDecayAmt24Hr: timeDecayAmt24Hr,
@@ -191,13 +208,38 @@ func (s *TaskScheduler) Start(ctx context.Context, beforeMainLoop func()) {
 			lvUpdate.Reset()
 		}
 	})
+	lvBlacklist := metrics2.NewLiveness("last_successful_blacklist_cleanup")
+	go util.RepeatCtx(5*time.Minute, ctx, func() {
+		if err := s.cleanupBlacklist(time.Now()); err != nil {
+			sklog.Errorf("Failed to clean up blacklist: %s", err)
+		} else {
+			lvBlacklist.Reset()
+		}
+	})
+	lvFlakes := metrics2.NewLiveness("last_successful_flake_rate_update")
+	go util.RepeatCtx(5*time.Minute, ctx, func() {
+		if err := s.updateFlakeRates(time.Now()); err != nil {
+			sklog.Errorf("Failed to update flake rates: %s", err)
+		} else {
+			lvFlakes.Reset()
+		}
+	})
+	lvSLOs := metrics2.NewLiveness("last_successful_job_slo_update")
+	go util.RepeatCtx(5*time.Minute, ctx, func() {
+		if err := s.updateJobSLOs(time.Now()); err != nil {
+			sklog.Errorf("Failed to update job SLOs: %s", err)
+		} else {
+			lvSLOs.Reset()
+		}
+	})
 }
 
 // TaskSchedulerStatus is a struct which provides status information about the
 // TaskScheduler.
 type TaskSchedulerStatus struct {
-	LastScheduled time.Time        `json:"last_scheduled"`
-	TopCandidates []*taskCandidate `json:"top_candidates"`
+	FairShare     []*FairShareGroupStatus `json:"fair_share"`
+	LastScheduled time.Time               `json:"last_scheduled"`
+	TopCandidates []*taskCandidate        `json:"top_candidates"`
 }
 
 // Status returns the current status of the TaskScheduler.
@@ -212,7 +254,13 @@ func (s *TaskScheduler) Status() *TaskSchedulerStatus {
 	for _, c := range s.queue[:n] {
 		candidates = append(candidates, c.Copy())
 	}
+	fairShare := make([]*FairShareGroupStatus, 0, len(s.fairShareStatus))
+	for _, st := range s.fairShareStatus {
+		cpy := *st
+		fairShare = append(fairShare, &cpy)
+	}
 	return &TaskSchedulerStatus{
+		FairShare:     fairShare,
 		LastScheduled: s.lastScheduled,
 		TopCandidates: candidates,
 	}
@@ -288,14 +336,77 @@ func (s *TaskScheduler) CancelJob(id string) (*db.Job, error) {
 	if j.Done() {
 		return nil, fmt.Errorf("Job %s is already finished with status %s", id, j.Status)
 	}
-	j.Status = db.JOB_STATUS_CANCELED
-	if err := s.jobFinished(j); err != nil {
+	if err := s.cancelJobs([]*db.Job{j}); err != nil {
 		return nil, err
 	}
-	if err := s.db.PutJob(j); err != nil {
-		return nil, err
+	return j, nil
+}
+
+// cancelJobs marks the given unfinished Jobs as canceled, stores them, and
+// cancels their tasks which are no longer needed by any other Job.
+func (s *TaskScheduler) cancelJobs(jobs []*db.Job) error {
+	for _, j := range jobs {
+		j.Status = db.JOB_STATUS_CANCELED
+		if err := s.jobFinished(j); err != nil {
+			return err
+		}
+	}
+	if err := s.db.PutJobs(jobs); err != nil {
+		return err
+	}
+	if err := s.jCache.Update(); err != nil {
+		return err
+	}
+	// Cancel the tasks only after all of the Jobs are finished, so that
+	// tasks shared by several of the Jobs are canceled as well.
+	canceled := map[string]bool{}
+	for _, j := range jobs {
+		s.cancelUnneededTasks(j, canceled)
+	}
+	return nil
+}
+
+// cancelUnneededTasks cancels the unfinished tasks of the given finished Job
+// in the Backend, unless they are still needed by another unfinished Job.
+// The IDs of canceled tasks are added to canceled, and tasks already in it
+// are skipped. Errors are logged, since the Job itself is already finished;
+// the results of canceled tasks are picked up like any other task results.
+func (s *TaskScheduler) cancelUnneededTasks(j *db.Job, canceled map[string]bool) {
+	for _, summaries := range j.Tasks {
+		for _, summary := range summaries {
+			t, err := s.tCache.GetTaskMaybeExpired(summary.Id)
+			if err != nil {
+				sklog.Errorf("Failed to retrieve task %s of canceled job %s: %s", summary.Id, j.Id, err)
+				continue
+			}
+			if t.Done() || t.SwarmingTaskId == "" || canceled[t.Id] {
+				continue
+			}
+			needed := false
+			for _, jobId := range t.Jobs {
+				if jobId == j.Id {
+					continue
+				}
+				other, err := s.jCache.GetJobMaybeExpired(jobId)
+				if err != nil {
+					sklog.Errorf("Failed to retrieve job %s of task %s: %s", jobId, t.Id, err)
+					needed = true
+					break
+				}
+				if !other.Done() {
+					needed = true
+					break
+				}
+			}
+			if needed {
+				continue
+			}
+			canceled[t.Id] = true
+			if err := s.backend.CancelTask(t.SwarmingTaskId); err != nil {
+				sklog.Errorf("Failed to cancel task %s (%s) of canceled job %s: %s", t.Id, t.SwarmingTaskId, j.Id, err)
+			}
+		}
 	}
-	return j, s.jCache.Update()
 }
 
 // ComputeBlamelist computes the blamelist for a new task, specified by name,
@@ -412,12 +523,25 @@ func ComputeBlamelist(ctx context.Context, cache db.TaskCache, repo *repograph.G
 func (s *TaskScheduler) findTaskCandidatesForJobs(ctx context.Context, unfinishedJobs []*db.Job) (map[db.TaskKey]*taskCandidate, error) {
 	defer metrics2.FuncTimer().Stop()
 
+	// Job categories are only needed for fair-share scheduling.
+	s.fairShareMtx.RLock()
+	needCategories := s.fairShareCfg != nil && len(s.fairShareCfg.Categories) > 0
+	s.fairShareMtx.RUnlock()
+
 	// Get the repo+commit+taskspecs for each job.
 	candidates := map[db.TaskKey]*taskCandidate{}
 	for _, j := range unfinishedJobs {
 		if !s.window.TestTime(j.Repo, j.Created) {
 			continue
 		}
+		category := ""
+		if needCategories {
+			var err error
+			category, err = s.jobCategory(ctx, j)
+			if err != nil {
+				return nil, err
+			}
+		}
 		for tsName := range j.Dependencies {
 			key := j.MakeTaskKey(tsName)
 			c, ok := candidates[key]
@@ -431,12 +555,15 @@ func (s *TaskScheduler) findTaskCandidatesForJobs(ctx context.Context, unfinishe
 					// could be inherited from any matching Job. Therefore, this should be
 					// used for non-critical, informational purposes only.
 					BuildbucketBuildId: j.BuildbucketBuildId,
+					Category:           category,
 					JobCreated:         j.Created,
 					Jobs:               []string{},
 					TaskKey:            key,
 					TaskSpec:           spec,
 				}
 				candidates[key] = c
+			} else {
+				c.Category = mergeCategories(c.Category, category)
 			}
 			c.Jobs = util.InsertStringSorted(c.Jobs, j.Id)
 			// Use the earliest JobCreated time, which will
@@ -444,6 +571,12 @@ func (s *TaskScheduler) findTaskCandidatesForJobs(ctx context.Context, unfinishe
 			if c.JobCreated.After(j.Created) {
 				c.JobCreated = j.Created
 			}
+			// Use the earliest deadline, which is the most
+			// urgent.
+			if !util.TimeIsZero(j.Deadline) && (util.TimeIsZero(c.JobDeadline) || j.Deadline.Before(c.JobDeadline)) {
+				c.JobDeadline = j.Deadline
+				c.JobDeadlineAllowed = j.Deadline.Sub(j.Created)
+			}
 		}
 	}
 	sklog.Infof("Found %d task candidates for %d unfinished jobs.", len(candidates), len(unfinishedJobs))
@@ -459,7 +592,7 @@ func (s *TaskScheduler) filterTaskCandidates(preFilterCandidates map[db.TaskKey]
 	total := 0
 	for _, c := range preFilterCandidates {
 		// Reject blacklisted tasks.
-		if rule := s.bl.MatchRule(c.Name, c.Revision); rule != "" {
+		if rule := s.bl.MatchRule(c.Repo, c.Name, c.Revision); rule != "" {
 			sklog.Warningf("Skipping blacklisted task candidate: %s @ %s due to rule %q", c.Name, c.Revision, rule)
 			continue
 		}
@@ -552,6 +685,7 @@ func (s *TaskScheduler) processTaskCandidate(ctx context.Context, c *taskCandida
 		for i := 0; i < c.Attempt; i++ {
 			c.Score *= CANDIDATE_SCORE_TRY_JOB_RETRY_MULTIPLIER
 		}
+		c.Score *= deadlineMultiplier(now, c.JobDeadline, c.JobDeadlineAllowed)
 		return nil
 	}
 
@@ -587,6 +721,7 @@ func (s *TaskScheduler) processTaskCandidate(ctx context.Context, c *taskCandida
 
 	if c.IsForceRun() {
 		c.Score = CANDIDATE_SCORE_FORCE_RUN + now.Sub(c.JobCreated).Hours()
+		c.Score *= deadlineMultiplier(now, c.JobDeadline, c.JobDeadlineAllowed)
 		return nil
 	}
 
@@ -613,6 +748,9 @@ func (s *TaskScheduler) processTaskCandidate(ctx context.Context, c *taskCandida
 	}
 	score *= decay
 
+	// Prioritize tasks for Jobs which are approaching their deadlines.
+	score *= deadlineMultiplier(now, c.JobDeadline, c.JobDeadlineAllowed)
+
 	c.Score = score
 	return nil
 }
@@ -818,9 +956,18 @@ func (s *TaskScheduler) regenerateTaskQueue(ctx context.Context, now time.Time)
 
 // getCandidatesToSchedule matches the list of free Swarming bots to task
 // candidates in the queue and returns the candidates which should be run.
-// Assumes that the tasks are sorted in decreasing order by score.
-func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate) []*taskCandidate {
+// Assumes that the tasks are sorted in decreasing order by score. If fs is not
+// nil, bots are divided among the fair-share groups according to their weights.
+func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate, fs *fairShare) []*taskCandidate {
 	defer metrics2.FuncTimer().Stop()
+	rv, _ := matchCandidatesToBots(bots, tasks, fs)
+	return rv
+}
+
+// matchCandidatesToBots implements getCandidatesToSchedule. In addition to the
+// candidates which should be run, it returns the ID of the bot chosen for each
+// of them, keyed by TaskKey.
+func matchCandidatesToBots(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*taskCandidate, fs *fairShare) ([]*taskCandidate, map[db.TaskKey]string) {
 	// Create a bots-by-swarming-dimension mapping.
 	botsByDim := map[string]util.StringSet{}
 	for _, b := range bots {
@@ -840,7 +987,9 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 	// match so that less-specialized tasks don't "steal" more-specialized
 	// bots which they don't actually need.
 	rv := make([]*taskCandidate, 0, len(bots))
-	for _, c := range tasks {
+	chosen := make(map[db.TaskKey]string, len(bots))
+	queue := fs.queue(tasks)
+	for c := queue.Pop(); c != nil; c = queue.Pop() {
 		// TODO(borenet): Make this threshold configurable.
 		if c.Score <= 0.0 {
 			sklog.Warningf("candidate %s @ %s has a score of %2f; skipping (%d commits).", c.Name, c.Revision, c.Score, len(c.Commits))
@@ -876,6 +1025,8 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 
 			// Add the task to the scheduling list.
 			rv = append(rv, c)
+			chosen[c.TaskKey] = bot
+			queue.Triggered(c)
 
 			// If we've exhausted the bot list, stop here.
 			if len(botsByDim) == 0 {
@@ -884,7 +1035,7 @@ func getCandidatesToSchedule(bots []*swarming_api.SwarmingRpcsBotInfo, tasks []*
 		}
 	}
 	sort.Sort(taskCandidateSlice(rv))
-	return rv
+	return rv, chosen
 }
 
 // isolateTasks sets up the given RepoState and isolates the given
@@ -900,7 +1051,7 @@ func (s *TaskScheduler) isolateTasks(ctx context.Context, rs db.RepoState, candi
 		for _, c := range candidates {
 			tasks = append(tasks, c.MakeIsolateTask(infraBotsDir, baseDir))
 		}
-		hashes, err := s.isolate.IsolateTasks(ctx, tasks)
+		hashes, err := s.backend.IsolateTasks(ctx, tasks)
 		if err != nil {
 			return err
 		}
@@ -923,14 +1074,30 @@ func (s *TaskScheduler) isolateCandidates(ctx context.Context, candidates []*tas
 
 	// First, group by RepoState since we have to isolate the code at
 	// that state for each task.
+	// Candidates which were already isolated to compute their result
+	// cache keys are passed through.
 	byRepoState := map[db.RepoState][]*taskCandidate{}
+	alreadyIsolated := []*taskCandidate{}
 	for _, c := range candidates {
+		if c.IsolatedInput != "" {
+			alreadyIsolated = append(alreadyIsolated, c)
+			continue
+		}
 		byRepoState[c.RepoState] = append(byRepoState[c.RepoState], c)
 	}
 
 	// Isolate the tasks by commit.
 	isolated := make(chan *taskCandidate)
 	var wg sync.WaitGroup
+	if len(alreadyIsolated) > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for _, c := range alreadyIsolated {
+				isolated <- c
+			}
+		}()
+	}
 	for rs, candidates := range byRepoState {
 		wg.Add(1)
 		go func(rs db.RepoState, candidates []*taskCandidate) {
@@ -966,12 +1133,18 @@ func (s *TaskScheduler) triggerTasks(isolated <-chan *taskCandidate, errCh chan<
 		wg.Add(1)
 		go func(candidate *taskCandidate) {
 			defer wg.Done()
+			cacheKey, err := candidate.resultCacheKey()
+			if err != nil {
+				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
+				return
+			}
 			t := candidate.MakeTask()
+			t.ResultCacheKey = cacheKey
 			if err := s.db.AssignId(t); err != nil {
 				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
 				return
 			}
-			req, err := candidate.MakeTaskRequest(t.Id, s.isolate.ServerURL(), s.pubsubTopic)
+			req, err := candidate.MakeTaskRequest(t.Id, s.backend.IsolateServerURL(), s.pubsubTopic)
 			if err != nil {
 				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
 				return
@@ -979,7 +1152,7 @@ func (s *TaskScheduler) triggerTasks(isolated <-chan *taskCandidate, errCh chan<
 			var resp *swarming_api.SwarmingRpcsTaskRequestMetadata
 			if err := timeout.Run(func() error {
 				var err error
-				resp, err = s.swarming.TriggerTask(req)
+				resp, err = s.backend.TriggerTask(req)
 				return err
 			}, time.Minute); err != nil {
 				errCh <- fmt.Errorf("Failed to trigger task: %s", err)
@@ -1007,7 +1180,25 @@ func (s *TaskScheduler) triggerTasks(isolated <-chan *taskCandidate, errCh chan<
 func (s *TaskScheduler) scheduleTasks(ctx context.Context, bots []*swarming_api.SwarmingRpcsBotInfo, queue []*taskCandidate) error {
 	defer metrics2.FuncTimer().Stop()
 	// Match free bots with tasks.
-	schedule := getCandidatesToSchedule(bots, queue)
+	fs, err := s.newFairShare(ctx)
+	if err != nil {
+		return err
+	}
+	// Resolve result cache hits first, so that they don't occupy bots.
+	cached, err := s.findCachedResults(ctx, bots, queue)
+	if err != nil {
+		return err
+	}
+	uncached := queue
+	if len(cached) > 0 {
+		uncached = make([]*taskCandidate, 0, len(queue)-len(cached))
+		for _, c := range queue {
+			if _, ok := cached[c.TaskKey]; !ok {
+				uncached = append(uncached, c)
+			}
+		}
+	}
+	schedule := getCandidatesToSchedule(bots, uncached, fs)
 
 	// Setup the error channel.
 	errs := []error{}
@@ -1027,10 +1218,10 @@ func (s *TaskScheduler) scheduleTasks(ctx context.Context, bots []*swarming_api.
 	// Trigger Swarming tasks.
 	triggered := s.triggerTasks(isolated, errCh)
 
-	// Collect the tasks we triggered.
+	// Collect the tasks we triggered, along with the cache hits.
 	numTriggered := 0
 	insert := map[string]map[string][]*db.Task{}
-	for t := range triggered {
+	add := func(t *db.Task) {
 		byRepo, ok := insert[t.Repo]
 		if !ok {
 			byRepo = map[string][]*db.Task{}
@@ -1039,6 +1230,12 @@ func (s *TaskScheduler) scheduleTasks(ctx context.Context, bots []*swarming_api.
 		byRepo[t.Name] = append(byRepo[t.Name], t)
 		numTriggered++
 	}
+	for _, t := range cached {
+		add(t)
+	}
+	for t := range triggered {
+		add(t)
+	}
 	close(errCh)
 	errWg.Wait()
 
@@ -1086,6 +1283,7 @@ func (s *TaskScheduler) scheduleTasks(ctx context.Context, bots []*swarming_api.
 	defer s.queueMtx.Unlock()
 	s.queue = queue
 	s.lastScheduled = time.Now()
+	s.fairShareStatus = fs.Status()
 
 	if len(errs) > 0 {
 		rvErr := "Got failures: "
@@ -1134,6 +1332,23 @@ func (s *TaskScheduler) RecurseAllBranches(ctx context.Context, fn func(string,
 	return nil
 }
 
+// changedFiles returns the paths of the files changed by the given commit,
+// relative to the repo root. Merge commits are compared against each of their
+// parents.
+func changedFiles(ctx context.Context, repo *repograph.Graph, hash string) ([]string, error) {
+	output, err := repo.Repo().Git(ctx, "diff-tree", "--no-commit-id", "--name-only", "-r", "-m", "--root", hash)
+	if err != nil {
+		return nil, err
+	}
+	rv := []string{}
+	for _, line := range strings.Split(output, "\n") {
+		if line != "" {
+			rv = append(rv, line)
+		}
+	}
+	return rv, nil
+}
+
 // gatherNewJobs finds and inserts Jobs for all new commits.
 func (s *TaskScheduler) gatherNewJobs(ctx context.Context) error {
 	defer metrics2.FuncTimer().Stop()
@@ -1156,6 +1371,16 @@ func (s *TaskScheduler) gatherNewJobs(ctx context.Context) error {
 		if err != nil {
 			return false, err
 		}
+		// The changed files are only needed for path filters, so find
+		// them lazily and share them between Jobs.
+		var files []string
+		var filesErr error
+		getFiles := func() ([]string, error) {
+			if files == nil && filesErr == nil {
+				files, filesErr = changedFiles(ctx, r, c.Hash)
+			}
+			return files, filesErr
+		}
 		for name, spec := range cfg.Jobs {
 			shouldRun := false
 			if !util.In(spec.Trigger, specs.PERIODIC_TRIGGERS) {
@@ -1171,7 +1396,7 @@ func (s *TaskScheduler) gatherNewJobs(ctx context.Context) error {
 				}
 			}
 			if shouldRun {
-				j, err := s.taskCfgCache.MakeJob(ctx, rs, name)
+				j, err := s.taskCfgCache.MakeJobForChangedFiles(ctx, rs, name, getFiles)
 				if err != nil {
 					return false, err
 				}
@@ -1239,7 +1464,7 @@ func (s *TaskScheduler) MainLoop(ctx context.Context) error {
 		defer wg1.Done()
 
 		var err error
-		bots, err = getFreeSwarmingBots(s.swarming, s.busyBots, s.pools)
+		bots, err = s.backend.GetFreeBots(s.pools)
 		if err != nil {
 			e1 = err
 			return
@@ -1354,6 +1579,41 @@ func (ts *TaskScheduler) GetBlacklist() *blacklist.Blacklist {
 	return ts.bl
 }
 
+// SetBlacklistExpiryNotifier sets a function which is called for each expired
+// blacklist Rule which has an Owner, after the Rule is removed.
+func (s *TaskScheduler) SetBlacklistExpiryNotifier(notify func(*blacklist.Rule) error) {
+	s.blacklistNotifyMtx.Lock()
+	defer s.blacklistNotifyMtx.Unlock()
+	s.blacklistNotify = notify
+}
+
+// cleanupBlacklist removes expired Rules from the blacklist and notifies their
+// owners. Expired flake quarantine Rules are kept until the window no longer
+// contains any tasks from before they expired, so that those tasks don't cause
+// the TaskSpec to be quarantined again.
+func (s *TaskScheduler) cleanupBlacklist(now time.Time) error {
+	defer metrics2.FuncTimer().Stop()
+	earliest := s.window.EarliestStart()
+	removed, err := s.bl.RemoveExpiredRules(now, func(r *blacklist.Rule) bool {
+		return r.AddedBy == FLAKE_QUARANTINE_USER && r.Expires.After(earliest)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed to remove expired blacklist rules: %s", err)
+	}
+	s.blacklistNotifyMtx.Lock()
+	notify := s.blacklistNotify
+	s.blacklistNotifyMtx.Unlock()
+	for _, r := range removed {
+		sklog.Infof("Removed expired blacklist rule %q", r.Name)
+		if r.Owner != "" && notify != nil {
+			if err := notify(r); err != nil {
+				sklog.Errorf("Failed to notify %s of expired blacklist rule %q: %s", r.Owner, r.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
 // testedness computes the total "testedness" of a set of commits covered by a
 // task whose blamelist included N commits. The "testedness" of a task spec at a
 // given commit is defined as follows:
@@ -1410,69 +1670,6 @@ func testednessIncrease(blamelistLength, stoleFromBlamelistLength int) float64 {
 	}
 }
 
-// getFreeSwarmingBots returns a slice of free swarming bots.
-func getFreeSwarmingBots(s swarming.ApiClient, busy *busyBots, pools []string) ([]*swarming_api.SwarmingRpcsBotInfo, error) {
-	defer metrics2.FuncTimer().Stop()
-
-	// Query for free Swarming bots and pending Swarming tasks in all pools.
-	var wg sync.WaitGroup
-	bots := []*swarming_api.SwarmingRpcsBotInfo{}
-	pending := []*swarming_api.SwarmingRpcsTaskResult{}
-	errs := []error{}
-	var mtx sync.Mutex
-	t := time.Time{}
-	for _, pool := range pools {
-		// Free bots.
-		wg.Add(1)
-		go func(pool string) {
-			defer wg.Done()
-			b, err := s.ListFreeBots(pool)
-			mtx.Lock()
-			defer mtx.Unlock()
-			if err != nil {
-				errs = append(errs, err)
-			} else {
-				bots = append(bots, b...)
-			}
-		}(pool)
-
-		// Pending tasks.
-		wg.Add(1)
-		go func(pool string) {
-			defer wg.Done()
-			t, err := s.ListTaskResults(t, t, []string{fmt.Sprintf("pool:%s", pool)}, "PENDING", false)
-			mtx.Lock()
-			defer mtx.Unlock()
-			if err != nil {
-				errs = append(errs, err)
-			} else {
-				pending = append(pending, t...)
-			}
-		}(pool)
-	}
-
-	wg.Wait()
-	if len(errs) > 0 {
-		return nil, fmt.Errorf("Got errors loading bots and tasks from Swarming: %v", errs)
-	}
-
-	rv := make([]*swarming_api.SwarmingRpcsBotInfo, 0, len(bots))
-	for _, bot := range bots {
-		if bot.IsDead {
-			continue
-		}
-		if bot.Quarantined {
-			continue
-		}
-		if bot.TaskId != "" {
-			continue
-		}
-		rv = append(rv, bot)
-	}
-	busy.RefreshTasks(pending)
-	return busy.Filter(rv), nil
-}
-
 // updateUnfinishedTasks queries Swarming for all unfinished tasks and updates
 // their status in the DB.
 func (s *TaskScheduler) updateUnfinishedTasks() error {
@@ -1498,7 +1695,7 @@ func (s *TaskScheduler) updateUnfinishedTasks() error {
 		wg.Add(1)
 		go func(idx int, t *db.Task) {
 			defer wg.Done()
-			swarmTask, err := s.swarming.GetTask(t.SwarmingTaskId, false)
+			swarmTask, err := s.backend.GetTaskResult(t.SwarmingTaskId)
 			if err != nil {
 				errs[idx] = fmt.Errorf("Failed to update unfinished task; failed to get updated task from swarming: %s", err)
 				return
@@ -1537,6 +1734,7 @@ func (s *TaskScheduler) updateUnfinishedJobs() error {
 		return err
 	}
 
+	now := time.Now()
 	modifiedJobs := make([]*db.Job, 0, len(jobs))
 	modifiedTasks := make(map[string]*db.Task, len(jobs))
 	for _, j := range jobs {
@@ -1547,9 +1745,10 @@ func (s *TaskScheduler) updateUnfinishedJobs() error {
 		summaries := make(map[string][]*db.TaskSummary, len(tasks))
 		for k, v := range tasks {
 			cpy := make([]*db.TaskSummary, 0, len(v))
-			for _, t := range v {
+			for i, t := range v {
 				if existing := modifiedTasks[t.Id]; existing != nil {
 					t = existing
+					v[i] = t
 				}
 				cpy = append(cpy, t.MakeTaskSummary())
 				// The Jobs list is always sorted.
@@ -1559,16 +1758,30 @@ func (s *TaskScheduler) updateUnfinishedJobs() error {
 					modifiedTasks[t.Id] = t
 				}
 			}
+			for _, t := range classifyFlakyTasks(v) {
+				modifiedTasks[t.Id] = t
+			}
 			summaries[k] = cpy
 		}
+		modified := false
 		if !reflect.DeepEqual(summaries, j.Tasks) {
 			j.Tasks = summaries
 			j.Status = j.DeriveStatus()
-			if j.Done() {
-				if err := s.jobFinished(j); err != nil {
-					return err
-				}
+			modified = true
+		}
+		if modified && j.Done() {
+			if err := s.jobFinished(j); err != nil {
+				return err
 			}
+		}
+		// Jobs keep running after their deadlines; the missed deadline is
+		// only recorded.
+		if !j.DeadlineExceeded && j.PastDeadline(now) {
+			sklog.Warningf("Job %s (%s @ %s) exceeded its deadline of %s", j.Id, j.Name, j.Revision, j.Deadline)
+			j.DeadlineExceeded = true
+			modified = true
+		}
+		if modified {
 			modifiedJobs = append(modifiedJobs, j)
 		}
 	}
@@ -1618,6 +1831,65 @@ func (s *TaskScheduler) GetTask(id string) (*db.Task, error) {
 	return s.tCache.GetTaskMaybeExpired(id)
 }
 
+// durationStatsKey identifies the task duration statistics of a repo over a
+// given period.
+type durationStatsKey struct {
+	repo   string
+	period time.Duration
+}
+
+// cachedDurationStats are task duration statistics computed at a given time.
+type cachedDurationStats struct {
+	stats    map[string]*dag.DurationStats
+	computed time.Time
+}
+
+// getDurationStats returns the duration statistics of the tasks in the given
+// repo which were created within the given period before now. They are shared
+// by all JobSpecs in the repo and cached for DURATION_STATS_TTL, since they
+// are computed from all tasks in the period.
+func (s *TaskScheduler) getDurationStats(repo string, now time.Time, period time.Duration) (map[string]*dag.DurationStats, error) {
+	s.durationStatsMtx.Lock()
+	defer s.durationStatsMtx.Unlock()
+	key := durationStatsKey{repo: repo, period: period}
+	if c, ok := s.durationStats[key]; ok && now.Sub(c.computed) < DURATION_STATS_TTL {
+		return c.stats, nil
+	}
+	tasks, err := s.db.GetTasksFromDateRange(now.Add(-period), now)
+	if err != nil {
+		return nil, err
+	}
+	repoTasks := make([]*db.Task, 0, len(tasks))
+	for _, t := range tasks {
+		if t.Repo == repo {
+			repoTasks = append(repoTasks, t)
+		}
+	}
+	stats := dag.GetDurationStats(repoTasks)
+	s.durationStats[key] = &cachedDurationStats{
+		stats:    stats,
+		computed: now,
+	}
+	return stats, nil
+}
+
+// GetJobDAG returns the TaskSpec dependency graph for the given JobSpec at the
+// given RepoState, annotated with the median durations of tasks in the same
+// repo which were created within the given period before now. The durations
+// may be up to DURATION_STATS_TTL old.
+func (s *TaskScheduler) GetJobDAG(ctx context.Context, rs db.RepoState, name string, now time.Time, period time.Duration) (*dag.JobDAG, error) {
+	defer metrics2.FuncTimer().Stop()
+	cfg, err := s.taskCfgCache.ReadTasksCfg(ctx, rs)
+	if err != nil {
+		return nil, err
+	}
+	stats, err := s.getDurationStats(rs.Repo, now, period)
+	if err != nil {
+		return nil, err
+	}
+	return dag.New(cfg, rs, name, stats)
+}
+
 // addTasksSingleTaskSpec computes the blamelist for each task in tasks, all of
 // which must have the same Repo and Name fields, and inserts/updates them in
 // the TaskDB. Also adjusts blamelists of existing tasks.
@@ -1875,7 +2147,7 @@ func (s *TaskScheduler) HandleSwarmingPubSub(msg *swarming.PubSubTaskMessage) bo
 	}
 
 	// Obtain the Swarming task data.
-	res, err := s.swarming.GetTask(msg.SwarmingTaskId, false)
+	res, err := s.backend.GetTaskResult(msg.SwarmingTaskId)
 	if err != nil {
 		sklog.Errorf("pubsub: Failed to retrieve task from Swarming: %s", err)
 		return true
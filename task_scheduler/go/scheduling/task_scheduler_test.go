@@ -29,6 +29,7 @@ import (
 	"go.skia.org/infra/go/testutils"
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/dag"
 	"go.skia.org/infra/task_scheduler/go/db"
 	"go.skia.org/infra/task_scheduler/go/specs"
 	specs_testutils "go.skia.org/infra/task_scheduler/go/specs/testutils"
@@ -204,7 +205,7 @@ func setup(t *testing.T) (context.Context, *git_testutils.GitBuilder, db.DB, *sw
 	assert.NoError(t, ioutil.WriteFile(gitcookies, []byte(".googlesource.com\tTRUE\t/\tTRUE\t123\to\tgit-user.google.com=abc123"), os.ModePerm))
 	g, err := gerrit.NewGerrit(fakeGerritUrl, gitcookies, urlMock.Client())
 	assert.NoError(t, err)
-	s, err := NewTaskScheduler(ctx, d, time.Duration(math.MaxInt64), 0, tmp, "fake.server", repos, isolateClient, swarmingClient, urlMock.Client(), 1.0, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, projectRepoMapping, swarming.POOLS_PUBLIC, "", depotTools, g)
+	s, err := NewTaskScheduler(ctx, d, time.Duration(math.MaxInt64), 0, tmp, "fake.server", repos, NewSwarmingBackend(isolateClient, swarmingClient), urlMock.Client(), 1.0, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, projectRepoMapping, swarming.POOLS_PUBLIC, "", depotTools, g)
 	assert.NoError(t, err)
 	return ctx, gb, d, swarmingClient, s, urlMock, func() {
 		testutils.RemoveAll(t, tmp)
@@ -284,6 +285,61 @@ func TestGatherNewJobs(t *testing.T) {
 	testGatherNewJobs(72)
 }
 
+func TestGatherNewJobsPathFilters(t *testing.T) {
+	ctx, gb, _, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	assert.NoError(t, s.gatherNewJobs(ctx))
+
+	// Restrict the Perf task to commits which change files under perf/.
+	cfg, err := specs.ReadTasksCfg(gb.Dir())
+	assert.NoError(t, err)
+	cfg.Tasks[specs_testutils.PerfTask].Paths = &specs.PathFilter{
+		Include: []string{"perf/**"},
+	}
+	cfgBytes, err := specs.EncodeTasksCfg(cfg)
+	assert.NoError(t, err)
+	gb.Add(ctx, "infra/bots/tasks.json", string(cfgBytes))
+	c3 := gb.CommitMsg(ctx, "Add path filter")
+	gb.AddGen(ctx, "perf/perf.txt")
+	c4 := gb.CommitMsg(ctx, "Change perf")
+	assert.NoError(t, s.updateRepos(ctx))
+	assert.NoError(t, s.gatherNewJobs(ctx))
+
+	getJob := func(name, revision string) *db.Job {
+		jobs, err := s.jCache.GetJobsByRepoState(name, db.RepoState{
+			Repo:     gb.RepoUrl(),
+			Revision: revision,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(jobs))
+		return jobs[0]
+	}
+
+	// The Perf Job at c3 was skipped entirely, and is therefore finished.
+	j := getJob(specs_testutils.PerfTask, c3)
+	assert.Equal(t, db.JOB_STATUS_SUCCESS, j.Status)
+	assert.True(t, j.Done())
+	assert.Equal(t, 0, len(j.Dependencies))
+	deepequal.AssertDeepEqual(t, []string{specs_testutils.BuildTask, specs_testutils.PerfTask}, j.SkippedTaskSpecs)
+
+	// Jobs without path filters are unaffected.
+	j = getJob(specs_testutils.BuildTask, c3)
+	assert.False(t, j.Done())
+	assert.Equal(t, 0, len(j.SkippedTaskSpecs))
+
+	// The Perf Job at c4 runs normally.
+	j = getJob(specs_testutils.PerfTask, c4)
+	assert.False(t, j.Done())
+	assert.Equal(t, 2, len(j.Dependencies))
+	assert.Equal(t, 0, len(j.SkippedTaskSpecs))
+
+	// The skipped Job should not cause us to revisit old commits.
+	unfinished, err := s.jCache.UnfinishedJobs()
+	assert.NoError(t, err)
+	assert.Equal(t, 5+2+3, len(unfinished))
+}
+
 func TestFindTaskCandidatesForJobs(t *testing.T) {
 	ctx, gb, _, _, s, _, cleanup := setup(t)
 	defer cleanup()
@@ -1466,44 +1522,44 @@ func makeSwarmingBot(id string, dims []string) *swarming_api.SwarmingRpcsBotInfo
 func TestGetCandidatesToSchedule(t *testing.T) {
 	testutils.MediumTest(t)
 	// Empty lists.
-	rv := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{})
+	rv := getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	t1 := makeTaskCandidate("task1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	b1 := makeSwarmingBot("bot1", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Single match.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 
 	// No match.
 	t1.TaskSpec.Dimensions[0] = "k:v2"
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1}, nil)
 	assert.Equal(t, 0, len(rv))
 
 	// Add a task candidate to match b1.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 := makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Switch the task order.
 	t1 = makeTaskCandidate("task1", []string{"k:v2"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Make both tasks match the bot, ensure that we pick the first one.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", []string{"k:v"})
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t1, t2}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1}, []*taskCandidate{t2, t1}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t2}, rv)
 
 	// Multiple dimensions. Ensure that different permutations of the bots
@@ -1518,21 +1574,21 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	// is first in sorted order. The second task does not get scheduled
 	// because there is no bot available which can run it.
 	// TODO(borenet): Use a more optimal solution to avoid this case.
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t1, t2}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1}, rv)
 	// In these two cases, the task with more dimensions has the higher
 	// priority. Both tasks get scheduled.
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t2, t1}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 	t1 = makeTaskCandidate("task1", []string{"k:v"})
 	t2 = makeTaskCandidate("task2", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b2, b1}, []*taskCandidate{t2, t1}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t2, t1}, rv)
 
 	// Matching dimensions. More bots than tasks.
@@ -1541,14 +1597,14 @@ func TestGetCandidatesToSchedule(t *testing.T) {
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 := makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2, b3}, []*taskCandidate{t1, t2}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 
 	// More tasks than bots.
 	t1 = makeTaskCandidate("task1", dims)
 	t2 = makeTaskCandidate("task2", dims)
 	t3 = makeTaskCandidate("task3", dims)
-	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3})
+	rv = getCandidatesToSchedule([]*swarming_api.SwarmingRpcsBotInfo{b1, b2}, []*taskCandidate{t1, t2, t3}, nil)
 	deepequal.AssertDeepEqual(t, []*taskCandidate{t1, t2}, rv)
 }
 
@@ -1970,7 +2026,7 @@ func testMultipleCandidatesBackfillingEachOtherSetup(t *testing.T) (context.Cont
 	g, err := gerrit.NewGerrit(fakeGerritUrl, gitcookies, urlMock.Client())
 	assert.NoError(t, err)
 
-	s, err := NewTaskScheduler(ctx, d, time.Duration(math.MaxInt64), 0, workdir, "fake.server", repos, isolateClient, swarmingClient, mockhttpclient.NewURLMock().Client(), 1.0, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, projectRepoMapping, swarming.POOLS_PUBLIC, "", depotTools, g)
+	s, err := NewTaskScheduler(ctx, d, time.Duration(math.MaxInt64), 0, workdir, "fake.server", repos, NewSwarmingBackend(isolateClient, swarmingClient), mockhttpclient.NewURLMock().Client(), 1.0, tryjobs.API_URL_TESTING, tryjobs.BUCKET_TESTING, projectRepoMapping, swarming.POOLS_PUBLIC, "", depotTools, g)
 	assert.NoError(t, err)
 
 	mockTasks := []*swarming_api.SwarmingRpcsTaskRequestMetadata{}
@@ -3580,3 +3636,89 @@ func TestIsolateTaskFailed(t *testing.T) {
 	sort.Strings(t1.Commits)
 	deepequal.AssertDeepEqual(t, expect1, t1.Commits)
 }
+
+func TestCleanupBlacklist(t *testing.T) {
+	_, _, _, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	now := time.Now()
+	add := func(r *blacklist.Rule) {
+		r.TaskSpecPatterns = []string{".*"}
+		assert.NoError(t, s.bl.AddRule(r, s.repos))
+	}
+	add(&blacklist.Rule{
+		AddedBy: "me@google.com",
+		Expires: now.Add(-time.Minute),
+		Name:    "expired",
+		Owner:   "you@google.com",
+	})
+	add(&blacklist.Rule{
+		AddedBy: "me@google.com",
+		Expires: now.Add(-time.Minute),
+		Name:    "expired-no-owner",
+	})
+	add(&blacklist.Rule{
+		AddedBy: "me@google.com",
+		Expires: now.Add(time.Minute),
+		Name:    "not-expired",
+		Owner:   "you@google.com",
+	})
+	add(&blacklist.Rule{
+		AddedBy: FLAKE_QUARANTINE_USER,
+		Expires: now.Add(-time.Minute),
+		Name:    quarantineRuleName("Build"),
+	})
+
+	notified := []string{}
+	s.SetBlacklistExpiryNotifier(func(r *blacklist.Rule) error {
+		notified = append(notified, r.Name)
+		return nil
+	})
+	assert.NoError(t, s.cleanupBlacklist(now))
+	deepequal.AssertDeepEqual(t, []string{"expired"}, notified)
+	_, err := s.bl.GetRule("expired")
+	assert.Equal(t, blacklist.ERR_NO_SUCH_RULE, err)
+	_, err = s.bl.GetRule("expired-no-owner")
+	assert.Equal(t, blacklist.ERR_NO_SUCH_RULE, err)
+	_, err = s.bl.GetRule("not-expired")
+	assert.NoError(t, err)
+
+	// Expired quarantine rules are kept while the window still contains
+	// tasks from before they expired.
+	_, err = s.bl.GetRule(quarantineRuleName("Build"))
+	assert.NoError(t, err)
+}
+
+func TestGetJobDAG(t *testing.T) {
+	ctx, gb, d, _, s, _, cleanup := setup(t)
+	defer cleanup()
+
+	rs1 := getRS1(t, ctx, gb)
+	now := time.Now()
+	addTask := func(duration time.Duration) {
+		task := makeTask(specs_testutils.BuildTask, rs1.Repo, rs1.Revision)
+		task.Created = now.Add(-time.Hour)
+		task.Started = task.Created
+		task.Finished = task.Started.Add(duration)
+		task.Status = db.TASK_STATUS_SUCCESS
+		assert.NoError(t, d.PutTask(task))
+	}
+	addTask(10 * time.Minute)
+
+	check := func(now time.Time, median time.Duration, samples int) {
+		jobDAG, err := s.GetJobDAG(ctx, rs1, specs_testutils.BuildTask, now, dag.DEFAULT_HISTORY)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, len(jobDAG.Nodes))
+		assert.Equal(t, median, jobDAG.Nodes[0].Duration)
+		assert.Equal(t, samples, jobDAG.Nodes[0].Samples)
+	}
+	check(now, 10*time.Minute, 1)
+
+	// The durations are cached, so new tasks aren't reflected right away.
+	addTask(20 * time.Minute)
+	addTask(30 * time.Minute)
+	check(now.Add(time.Minute), 10*time.Minute, 1)
+
+	// The durations are recomputed once they expire.
+	check(now.Add(DURATION_STATS_TTL), 20*time.Minute, 3)
+}
@@ -12,6 +12,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -152,6 +153,14 @@ func (c *TasksCfg) Validate() error {
 			return err
 		}
 	}
+	for name, j := range c.Jobs {
+		if err := j.Paths.Validate(); err != nil {
+			return fmt.Errorf("Invalid path filter for job %s: %s", name, err)
+		}
+		if j.Deadline < 0 {
+			return fmt.Errorf("Invalid deadline for job %s: %s", name, j.Deadline)
+		}
+	}
 
 	if err := findCycles(c.Tasks, c.Jobs); err != nil {
 		return err
@@ -163,6 +172,13 @@ func (c *TasksCfg) Validate() error {
 // TaskSpec is a struct which describes a Swarming task to run.
 // Be sure to add any new fields to the Copy() method.
 type TaskSpec struct {
+	// CacheResults indicates that, if a previous task for this TaskSpec
+	// succeeded with identical isolated inputs and dimensions, its results
+	// may be reused instead of running the task again. Tasks which depend
+	// on the commit being tested, other than through their isolated
+	// inputs, should not set this.
+	CacheResults bool `json:"cache_results,omitempty"`
+
 	// Caches are named Swarming caches which should be used for this task.
 	Caches []*Cache `json:"caches,omitempty"`
 
@@ -219,6 +235,11 @@ type TaskSpec struct {
 	// these is missing.
 	Outputs []string `json:"outputs,omitempty"`
 
+	// Paths restricts the task to commits which change matching files. It
+	// only applies when the task is listed directly in a JobSpec; tasks
+	// which are needed as dependencies of other tasks always run.
+	Paths *PathFilter `json:"paths,omitempty"`
+
 	// Priority indicates the relative priority of the task, with 0 < p <= 1
 	Priority float64 `json:"priority"`
 
@@ -249,6 +270,10 @@ func (t *TaskSpec) Validate(cfg *TasksCfg) error {
 		return fmt.Errorf("Isolate file is required.")
 	}
 
+	if err := t.Paths.Validate(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -287,6 +312,7 @@ func (t *TaskSpec) Copy() *TaskSpec {
 	extraTags := util.CopyStringMap(t.ExtraTags)
 	outputs := util.CopyStringSlice(t.Outputs)
 	return &TaskSpec{
+		CacheResults:     t.CacheResults,
 		Caches:           caches,
 		CipdPackages:     cipdPackages,
 		Command:          cmd,
@@ -302,6 +328,7 @@ func (t *TaskSpec) Copy() *TaskSpec {
 		Isolate:          t.Isolate,
 		MaxAttempts:      t.MaxAttempts,
 		Outputs:          outputs,
+		Paths:            t.Paths.Copy(),
 		Priority:         t.Priority,
 		ServiceAccount:   t.ServiceAccount,
 	}
@@ -324,9 +351,17 @@ type CipdPackage struct {
 // JobSpec is a struct which describes a set of TaskSpecs to run as part of a
 // larger effort.
 type JobSpec struct {
-	Priority  float64  `json:"priority"`
-	TaskSpecs []string `json:"tasks"`
-	Trigger   string   `json:"trigger,omitempty"`
+	// Deadline is the amount of time after its creation by which a Job
+	// is expected to finish. Tasks for Jobs which are approaching their
+	// deadlines are prioritized, and Jobs which are not finished by their
+	// deadlines are marked as such. Zero means no deadline.
+	Deadline time.Duration `json:"deadline_ns,omitempty"`
+
+	// Paths restricts the job to commits which change matching files.
+	Paths     *PathFilter `json:"paths,omitempty"`
+	Priority  float64     `json:"priority"`
+	TaskSpecs []string    `json:"tasks"`
+	Trigger   string      `json:"trigger,omitempty"`
 }
 
 // Copy returns a copy of the JobSpec.
@@ -337,6 +372,8 @@ func (j *JobSpec) Copy() *JobSpec {
 		copy(taskSpecs, j.TaskSpecs)
 	}
 	return &JobSpec{
+		Deadline:  j.Deadline,
+		Paths:     j.Paths.Copy(),
 		Priority:  j.Priority,
 		TaskSpecs: taskSpecs,
 		Trigger:   j.Trigger,
@@ -378,6 +415,136 @@ func (j *JobSpec) GetTaskSpecDAG(cfg *TasksCfg) (map[string][]string, error) {
 	return rv, nil
 }
 
+// HasPathFilters returns true iff the JobSpec or any of the TaskSpecs it lists
+// directly has a PathFilter.
+func (j *JobSpec) HasPathFilters(cfg *TasksCfg) bool {
+	if j.Paths != nil {
+		return true
+	}
+	for _, name := range j.TaskSpecs {
+		if spec, ok := cfg.Tasks[name]; ok && spec.Paths != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// GetTaskSpecDAGForFiles is like GetTaskSpecDAG, but omits TaskSpecs whose
+// PathFilters do not match any of the given changed files, along with any of
+// their dependencies which are not needed by other TaskSpecs. If the JobSpec's
+// own PathFilter does not match, the DAG is empty. Also returns the sorted
+// names of the omitted TaskSpecs.
+func (j *JobSpec) GetTaskSpecDAGForFiles(cfg *TasksCfg, files []string) (map[string][]string, []string, error) {
+	all, err := j.GetTaskSpecDAG(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	roots := []string{}
+	if j.Paths.Match(files) {
+		for _, name := range j.TaskSpecs {
+			if cfg.Tasks[name].Paths.Match(files) {
+				roots = append(roots, name)
+			}
+		}
+	}
+	rv, err := (&JobSpec{TaskSpecs: roots}).GetTaskSpecDAG(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	skipped := []string{}
+	for name := range all {
+		if _, ok := rv[name]; !ok {
+			skipped = append(skipped, name)
+		}
+	}
+	sort.Strings(skipped)
+	return rv, skipped, nil
+}
+
+// PathFilter restricts a TaskSpec or JobSpec to commits which change files
+// matching its patterns. A file matches if it matches any of the Include
+// patterns (or Include is empty) and none of the Exclude patterns. Patterns
+// use path.Match syntax and are relative to the repo root; in addition, a
+// pattern ending in "/**" matches everything under that directory.
+type PathFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Copy returns a copy of the PathFilter.
+func (f *PathFilter) Copy() *PathFilter {
+	if f == nil {
+		return nil
+	}
+	return &PathFilter{
+		Include: util.CopyStringSlice(f.Include),
+		Exclude: util.CopyStringSlice(f.Exclude),
+	}
+}
+
+// Validate returns an error if the PathFilter is not valid. A nil PathFilter
+// is valid.
+func (f *PathFilter) Validate() error {
+	if f == nil {
+		return nil
+	}
+	if len(f.Include) == 0 && len(f.Exclude) == 0 {
+		return fmt.Errorf("Path filters must include and/or exclude at least one pattern.")
+	}
+	for _, pattern := range append(util.CopyStringSlice(f.Include), f.Exclude...) {
+		if _, err := path.Match(strings.TrimSuffix(pattern, "/**"), ""); err != nil {
+			return fmt.Errorf("Invalid path pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// matchPathPattern returns true iff the file matches the pattern.
+func matchPathPattern(pattern, file string) bool {
+	if strings.HasSuffix(pattern, "/**") {
+		dir := strings.TrimSuffix(pattern, "/**")
+		for d := path.Dir(file); d != "." && d != "/"; d = path.Dir(d) {
+			if match, _ := path.Match(dir, d); match {
+				return true
+			}
+		}
+		return false
+	}
+	match, _ := path.Match(pattern, file)
+	return match
+}
+
+// Match returns true iff any of the given changed files matches the
+// PathFilter. A nil PathFilter matches everything.
+func (f *PathFilter) Match(files []string) bool {
+	if f == nil {
+		return true
+	}
+	for _, file := range files {
+		included := len(f.Include) == 0
+		for _, pattern := range f.Include {
+			if matchPathPattern(pattern, file) {
+				included = true
+				break
+			}
+		}
+		if !included {
+			continue
+		}
+		excluded := false
+		for _, pattern := range f.Exclude {
+			if matchPathPattern(pattern, file) {
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			return true
+		}
+	}
+	return false
+}
+
 // TasksCfgFileHash represents the SHA-1 checksum of the contents of
 // TASKS_CFG_FILE.
 type TasksCfgFileHash [sha1.Size]byte
@@ -747,6 +914,21 @@ func (c *TaskCfgCache) GetJobSpec(ctx context.Context, rs db.RepoState, name str
 // MakeJob is a helper function which retrieves the given JobSpec at the given
 // RepoState and uses it to create a Job instance.
 func (c *TaskCfgCache) MakeJob(ctx context.Context, rs db.RepoState, name string) (*db.Job, error) {
+	return c.makeJob(ctx, rs, name, nil)
+}
+
+// MakeJobForChangedFiles is like MakeJob, but applies the PathFilters of the
+// JobSpec and its TaskSpecs to the files changed at the RepoState, as returned
+// by getFiles. getFiles is only called if there are PathFilters to apply.
+// Skipped TaskSpecs are recorded in the Job. If everything is skipped, the
+// Job has no dependencies and is created in the finished, successful state.
+func (c *TaskCfgCache) MakeJobForChangedFiles(ctx context.Context, rs db.RepoState, name string, getFiles func() ([]string, error)) (*db.Job, error) {
+	return c.makeJob(ctx, rs, name, getFiles)
+}
+
+// makeJob implements MakeJob and MakeJobForChangedFiles. PathFilters are only
+// applied if getFiles is not nil.
+func (c *TaskCfgCache) makeJob(ctx context.Context, rs db.RepoState, name string, getFiles func() ([]string, error)) (*db.Job, error) {
 	cfg, err := c.ReadTasksCfg(ctx, rs)
 	if err != nil {
 		return nil, err
@@ -755,19 +937,41 @@ func (c *TaskCfgCache) MakeJob(ctx context.Context, rs db.RepoState, name string
 	if !ok {
 		return nil, fmt.Errorf("No such job: %s", name)
 	}
-	deps, err := spec.GetTaskSpecDAG(cfg)
-	if err != nil {
-		return nil, err
+	var deps map[string][]string
+	var skipped []string
+	if getFiles != nil && spec.HasPathFilters(cfg) {
+		files, err := getFiles()
+		if err != nil {
+			return nil, fmt.Errorf("Failed to obtain changed files for %s: %s", rs, err)
+		}
+		deps, skipped, err = spec.GetTaskSpecDAGForFiles(cfg, files)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		deps, err = spec.GetTaskSpecDAG(cfg)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return &db.Job{
-		Created:      time.Now(),
-		Dependencies: deps,
-		Name:         name,
-		Priority:     spec.Priority,
-		RepoState:    rs,
-		Tasks:        map[string][]*db.TaskSummary{},
-	}, nil
+	j := &db.Job{
+		Created:          time.Now(),
+		Dependencies:     deps,
+		Name:             name,
+		Priority:         spec.Priority,
+		RepoState:        rs,
+		SkippedTaskSpecs: skipped,
+		Tasks:            map[string][]*db.TaskSummary{},
+	}
+	if spec.Deadline > 0 {
+		j.Deadline = j.Created.Add(spec.Deadline)
+	}
+	if len(deps) == 0 {
+		j.Status = db.JOB_STATUS_SUCCESS
+		j.Finished = j.Created
+	}
+	return j, nil
 }
 
 // Cleanup removes cache entries which are outside of our scheduling window.
@@ -34,6 +34,7 @@ var (
 func TestCopyTaskSpec(t *testing.T) {
 	testutils.SmallTest(t)
 	v := &TaskSpec{
+		CacheResults: true,
 		Caches: []*Cache{
 			&Cache{
 				Name: "cache-me",
@@ -62,10 +63,14 @@ func TestCopyTaskSpec(t *testing.T) {
 		ExtraTags: map[string]string{
 			"dummy_tag": "dummy_val",
 		},
-		IoTimeout:      10 * time.Minute,
-		Isolate:        "abc123",
-		MaxAttempts:    5,
-		Outputs:        []string{"out"},
+		IoTimeout:   10 * time.Minute,
+		Isolate:     "abc123",
+		MaxAttempts: 5,
+		Outputs:     []string{"out"},
+		Paths: &PathFilter{
+			Include: []string{"src/**"},
+			Exclude: []string{"src/README.md"},
+		},
 		Priority:       19.0,
 		ServiceAccount: "fake-account@gmail.com",
 	}
@@ -75,6 +80,10 @@ func TestCopyTaskSpec(t *testing.T) {
 func TestCopyJobSpec(t *testing.T) {
 	testutils.SmallTest(t)
 	v := &JobSpec{
+		Deadline: 2 * time.Hour,
+		Paths: &PathFilter{
+			Include: []string{"src/**"},
+		},
 		TaskSpecs: []string{"Build", "Test"},
 		Trigger:   "trigger-name",
 		Priority:  753,
@@ -625,6 +634,81 @@ func TestGetTaskSpecDAG(t *testing.T) {
 	}, []string{"a", "g"})
 }
 
+func TestPathFilter(t *testing.T) {
+	testutils.SmallTest(t)
+
+	var f *PathFilter
+	assert.NoError(t, f.Validate())
+	assert.True(t, f.Match(nil))
+	assert.EqualError(t, (&PathFilter{}).Validate(), "Path filters must include and/or exclude at least one pattern.")
+	assert.EqualError(t, (&PathFilter{Exclude: []string{"a/["}}).Validate(), `Invalid path pattern "a/[": syntax error in pattern`)
+
+	f = &PathFilter{
+		Include: []string{"src/**", "*.gn"},
+		Exclude: []string{"src/docs/**", "src/*.md"},
+	}
+	assert.NoError(t, f.Validate())
+	assert.False(t, f.Match(nil))
+	assert.True(t, f.Match([]string{"src/core/a.cpp"}))
+	assert.True(t, f.Match([]string{"BUILD.gn"}))
+	assert.False(t, f.Match([]string{"tools/BUILD.gn"}))
+	assert.False(t, f.Match([]string{"src/docs/a.cpp", "src/README.md"}))
+	assert.True(t, f.Match([]string{"src/README.md", "src/a.cpp"}))
+	assert.False(t, f.Match([]string{"srcfile"}))
+
+	// Exclude-only filters match any file which isn't excluded.
+	f = &PathFilter{
+		Exclude: []string{"infra/**"},
+	}
+	assert.False(t, f.Match([]string{"infra/bots/tasks.json"}))
+	assert.True(t, f.Match([]string{"infra/bots/tasks.json", "DEPS"}))
+}
+
+func TestGetTaskSpecDAGForFiles(t *testing.T) {
+	testutils.SmallTest(t)
+	cfg, err := ParseTasksCfg(makeTasksCfg(t, map[string][]string{
+		"build": {},
+		"test":  {"build"},
+		"perf":  {"build"},
+		"docs":  {},
+	}, map[string][]string{
+		"j": {"test", "perf", "docs"},
+	}))
+	assert.NoError(t, err)
+	j := cfg.Jobs["j"]
+	assert.False(t, j.HasPathFilters(cfg))
+	cfg.Tasks["perf"].Paths = &PathFilter{Include: []string{"src/**"}}
+	cfg.Tasks["docs"].Paths = &PathFilter{Include: []string{"docs/**"}}
+	assert.True(t, j.HasPathFilters(cfg))
+
+	test := func(files []string, expectDAG map[string][]string, expectSkipped []string) {
+		dag, skipped, err := j.GetTaskSpecDAGForFiles(cfg, files)
+		assert.NoError(t, err)
+		deepequal.AssertDeepEqual(t, expectDAG, dag)
+		deepequal.AssertDeepEqual(t, expectSkipped, skipped)
+	}
+
+	test([]string{"src/a.cpp", "docs/a.md"}, map[string][]string{
+		"build": {},
+		"test":  {"build"},
+		"perf":  {"build"},
+		"docs":  {},
+	}, []string{})
+	test([]string{"docs/a.md"}, map[string][]string{
+		"build": {},
+		"test":  {"build"},
+		"docs":  {},
+	}, []string{"perf"})
+	test([]string{"DEPS"}, map[string][]string{
+		"build": {},
+		"test":  {"build"},
+	}, []string{"docs", "perf"})
+
+	// The JobSpec's own PathFilter applies to all of its TaskSpecs.
+	j.Paths = &PathFilter{Exclude: []string{"docs/**"}}
+	test([]string{"docs/a.md"}, map[string][]string{}, []string{"build", "docs", "perf", "test"})
+}
+
 func TestTaskCfgCacheSerialization(t *testing.T) {
 	testutils.LargeTest(t)
 
@@ -6,9 +6,12 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html"
 	"html/template"
+	"io/ioutil"
 	"net"
 	"net/http"
+	"os"
 	"os/user"
 	"path"
 	"path/filepath"
@@ -20,6 +23,7 @@ import (
 	"go.skia.org/infra/go/cleanup"
 	"go.skia.org/infra/go/common"
 	"go.skia.org/infra/go/depot_tools"
+	"go.skia.org/infra/go/email"
 	"go.skia.org/infra/go/gerrit"
 	"go.skia.org/infra/go/git/repograph"
 	"go.skia.org/infra/go/httputils"
@@ -33,6 +37,7 @@ import (
 	"go.skia.org/infra/go/util"
 	"go.skia.org/infra/go/webhook"
 	"go.skia.org/infra/task_scheduler/go/blacklist"
+	"go.skia.org/infra/task_scheduler/go/dag"
 	"go.skia.org/infra/task_scheduler/go/db"
 	"go.skia.org/infra/task_scheduler/go/db/local_db"
 	"go.skia.org/infra/task_scheduler/go/db/recovery"
@@ -46,6 +51,13 @@ const (
 	// APP_NAME is the name of this app.
 	APP_NAME = "task_scheduler"
 
+	EMAIL_DISPLAY_NAME     = "Task Scheduler"
+	GMAIL_TOKEN_CACHE_FILE = "google_email_token.data"
+
+	// Supported values for --backend.
+	BACKEND_LOCAL    = "local"
+	BACKEND_SWARMING = "swarming"
+
 	PUBSUB_SUBSCRIBER_TASK_SCHEDULER          = "task-scheduler"
 	PUBSUB_SUBSCRIBER_TASK_SCHEDULER_INTERNAL = "task-scheduler-internal"
 )
@@ -78,6 +90,7 @@ var (
 	host           = flag.String("host", "localhost", "HTTP service host")
 	port           = flag.String("port", ":8000", "HTTP service port for the web server (e.g., ':8000')")
 	dbPort         = flag.String("db_port", ":8008", "HTTP service port for the database RPC server (e.g., ':8008')")
+	fairShareCfg   = flag.String("fair_share_config", "", "Optional JSON file containing fair-share weights for repos, job categories and TaskSpec name prefixes. If not provided, all task candidates compete on score alone.")
 	isolateServer  = flag.String("isolate_server", isolate.ISOLATE_SERVER_URL, "Which Isolate server to use.")
 	local          = flag.Bool("local", false, "Whether we're running on a dev machine vs in production.")
 	repoUrls       = common.NewMultiStringFlag("repo", nil, "Repositories for which to schedule tasks.")
@@ -93,6 +106,14 @@ var (
 	workdir        = flag.String("workdir", "workdir", "Working directory to use.")
 	promPort       = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
 
+	backendType        = flag.String("backend", BACKEND_SWARMING, fmt.Sprintf("Where to run tasks; %q to run on Swarming, or %q to run as subprocesses on this machine.", BACKEND_SWARMING, BACKEND_LOCAL))
+	localBots          = flag.Int("local_bots", 1, "Number of tasks which may run concurrently with --backend=local.")
+	localBotDimensions = common.NewMultiStringFlag("local_bot_dimension", nil, "Dimensions, in \"key:value\" form, of the bots used with --backend=local. Must include a \"pool\" dimension matching --pool.")
+
+	flakeQuarantineDuration  = flag.Duration("flake_quarantine_duration", 24*time.Hour, "How long to blacklist TaskSpecs which are automatically quarantined for flakiness.")
+	flakeQuarantineMinTasks  = flag.Int("flake_quarantine_min_tasks", 10, "Minimum number of finished tasks within the time window before a TaskSpec may be automatically quarantined for flakiness.")
+	flakeQuarantineThreshold = flag.Float64("flake_quarantine_threshold", 0.0, "Automatically blacklist TaskSpecs whose flake rate within the time window is at least this fraction, between zero and one. Zero disables automatic quarantine.")
+
 	pubsubTopicName      = flag.String("pubsub_topic", swarming.PUBSUB_TOPIC_SWARMING_TASKS, "Pub/Sub topic to use for Swarming tasks.")
 	pubsubSubscriberName = flag.String("pubsub_subscriber", PUBSUB_SUBSCRIBER_TASK_SCHEDULER, "Pub/Sub subscriber name.")
 )
@@ -235,12 +256,20 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 		}
 		defer util.Close(r.Body)
 		rule.AddedBy = login.LoggedInAs(r)
+		if rule.Owner == "" {
+			rule.Owner = rule.AddedBy
+		}
 		if len(rule.Commits) == 2 {
 			rangeRule, err := blacklist.NewCommitRangeRule(context.Background(), rule.Name, rule.AddedBy, rule.Description, rule.TaskSpecPatterns, rule.Commits[0], rule.Commits[1], repos)
 			if err != nil {
 				httputils.ReportError(w, r, err, fmt.Sprintf("Failed to create commit range rule: %s", err))
 				return
 			}
+			rangeRule.Bug = rule.Bug
+			rangeRule.Expires = rule.Expires
+			rangeRule.Owner = rule.Owner
+			rangeRule.Starts = rule.Starts
+			rangeRule.Window = rule.Window
 			rule = *rangeRule
 		}
 		if err := ts.GetBlacklist().AddRule(&rule, repos); err != nil {
@@ -254,6 +283,46 @@ func jsonBlacklistHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// newGMail returns a GMail instance using credentials from metadata.
+func newGMail(homeDir string) (*email.GMail, error) {
+	clientId, err := metadata.ProjectGet(metadata.GMAIL_CLIENT_ID)
+	if err != nil {
+		return nil, err
+	}
+	clientSecret, err := metadata.ProjectGet(metadata.GMAIL_CLIENT_SECRET)
+	if err != nil {
+		return nil, err
+	}
+	cachedToken, err := metadata.ProjectGet(metadata.GMAIL_CACHED_TOKEN)
+	if err != nil {
+		return nil, err
+	}
+	tokenFile, err := filepath.Abs(path.Join(homeDir, GMAIL_TOKEN_CACHE_FILE))
+	if err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(tokenFile, []byte(cachedToken), os.ModePerm); err != nil {
+		return nil, fmt.Errorf("Failed to cache token: %s", err)
+	}
+	return email.NewGMail(clientId, clientSecret, tokenFile)
+}
+
+// blacklistExpiryEmailer returns a function which emails the owner of an
+// expired blacklist Rule.
+func blacklistExpiryEmailer(gmail *email.GMail, serverURL string) func(*blacklist.Rule) error {
+	return func(r *blacklist.Rule) error {
+		subject := fmt.Sprintf("Task Scheduler blacklist rule %q has expired", r.Name)
+		bug := ""
+		if r.Bug != "" {
+			bug = fmt.Sprintf("Bug: %s<br/>", html.EscapeString(r.Bug))
+		}
+		body := fmt.Sprintf(`The blacklist rule %q, which you own, expired at %s and has been removed from the <a href="%s/blacklist">Task Scheduler blacklist</a>.<br/><br/>
+Description: %s<br/>
+%sIf the rule is still needed, please add it again with a new expiration time.`, html.EscapeString(r.Name), r.Expires.UTC().Format(time.RFC1123), serverURL, html.EscapeString(r.Description), bug)
+		return gmail.Send(EMAIL_DISPLAY_NAME, []string{r.Owner}, subject, body)
+	}
+}
+
 func jsonTriggerHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "https://status.skia.org")
@@ -345,6 +414,34 @@ func jsonCancelJobHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// jsonBatchJobsHandler cancels or re-triggers all Jobs matching a query. If
+// the request is a dry run, the Jobs which would be affected are returned
+// without making any changes.
+func jsonBatchJobsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !login.IsGoogler(r) {
+		httputils.ReportError(w, r, nil, "Cannot modify jobs; user is not a logged-in Googler.")
+		return
+	}
+
+	var req scheduling.BatchJobRequest
+	defer util.Close(r.Body)
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to decode request body: %s", err))
+		return
+	}
+	req.User = login.LoggedInAs(r)
+	res, err := ts.BatchJobs(context.Background(), &req)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to %s jobs: %s", req.Action, err))
+		return
+	}
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		httputils.ReportError(w, r, err, "Failed to encode response.")
+		return
+	}
+}
+
 func jobHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
 
@@ -529,6 +626,48 @@ func jsonTaskSearchHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// jsonJobDAGHandler returns the TaskSpec dependency graph for a JobSpec at a
+// given RepoState, annotated with historical task durations and the critical
+// path, in JSON or DOT format.
+func jsonJobDAGHandler(w http.ResponseWriter, r *http.Request) {
+	var params struct {
+		db.RepoState
+		Format string `json:"format"`
+		Job    string `json:"job"`
+	}
+	if err := httputils.ParseFormValues(r, &params); err != nil {
+		httputils.ReportError(w, r, err, "Failed to parse request parameters.")
+		return
+	}
+	if !params.RepoState.Valid() || params.Job == "" {
+		err := fmt.Errorf("repo, revision, and job are required.")
+		httputils.ReportError(w, r, err, err.Error())
+		return
+	}
+	d, err := ts.GetJobDAG(context.Background(), params.RepoState, params.Job, time.Now(), dag.DEFAULT_HISTORY)
+	if err != nil {
+		httputils.ReportError(w, r, err, fmt.Sprintf("Failed to obtain job DAG: %s", err))
+		return
+	}
+	switch params.Format {
+	case dag.FORMAT_DOT:
+		w.Header().Set("Content-Type", "text/vnd.graphviz")
+		if err := d.WriteDOT(w); err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Failed to write response: %s", err))
+			return
+		}
+	case "", dag.FORMAT_JSON:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(d); err != nil {
+			httputils.ReportError(w, r, err, fmt.Sprintf("Failed to encode response: %s", err))
+			return
+		}
+	default:
+		err := fmt.Errorf("Unknown format %q", params.Format)
+		httputils.ReportError(w, r, err, err.Error())
+	}
+}
+
 // jsonTaskCandidateSearchHandler allows for searching task candidates based on
 // their TaskKey.
 func jsonTaskCandidateSearchHandler(w http.ResponseWriter, r *http.Request) {
@@ -564,6 +703,8 @@ func runServer(serverURL string, taskDb db.RemoteDB) {
 	r.HandleFunc("/json/blacklist", jsonBlacklistHandler).Methods(http.MethodPost, http.MethodDelete)
 	r.HandleFunc("/json/job/{id}", jsonJobHandler)
 	r.HandleFunc("/json/job/{id}/cancel", jsonCancelJobHandler).Methods(http.MethodPost)
+	r.HandleFunc("/json/jobDAG", jsonJobDAGHandler)
+	r.HandleFunc("/json/jobs/batch", jsonBatchJobsHandler).Methods(http.MethodPost)
 	r.HandleFunc("/json/jobs/search", jsonJobSearchHandler)
 	r.HandleFunc("/json/task", jsonTaskHandler).Methods(http.MethodPost, http.MethodPut)
 	r.HandleFunc("/json/task/{id}", jsonGetTaskHandler)
@@ -637,16 +778,6 @@ func main() {
 		sklog.Fatal(err)
 	}
 
-	// Initialize Isolate client.
-	isolateServerUrl := *isolateServer
-	if *local {
-		isolateServerUrl = isolate.ISOLATE_SERVER_URL_FAKE
-	}
-	isolateClient, err := isolate.NewClient(wdAbs, isolateServerUrl)
-	if err != nil {
-		sklog.Fatal(err)
-	}
-
 	// Gerrit API client.
 	user, err := user.Current()
 	if err != nil {
@@ -681,26 +812,53 @@ func main() {
 		sklog.Fatal(err)
 	}
 
-	// Initialize Swarming client.
-	var swarm swarming.ApiClient
-	if *local {
-		swarmTestClient := testutils.NewTestClient()
-		swarmTestClient.MockBots(testutils.MockSwarmingBotsForAllTasksForTesting(ctx, repos))
-		go testutils.PeriodicallyUpdateMockTasksForTesting(swarmTestClient)
-		swarm = swarmTestClient
-	} else {
-		tp := httputils.NewBackOffTransport().(*httputils.BackOffTransport)
-		tp.Transport.Dial = func(network, addr string) (net.Conn, error) {
-			return net.DialTimeout(network, addr, 3*time.Minute)
+	// Initialize the task execution backend.
+	var backend scheduling.Backend
+	var localBackend *scheduling.LocalBackend
+	switch *backendType {
+	case BACKEND_LOCAL:
+		if len(*localBotDimensions) == 0 {
+			sklog.Fatalf("--local_bot_dimension is required with --backend=%s", BACKEND_LOCAL)
 		}
-		swarmClient, err := auth.NewClientWithTransport(*local, oauthCacheFile, "", tp, swarming.AUTH_SCOPE)
+		localBackend, err = scheduling.NewLocalBackend(ctx, path.Join(wdAbs, "local_backend"), *localBots, *localBotDimensions)
 		if err != nil {
 			sklog.Fatal(err)
 		}
-		swarm, err = swarming.NewApiClient(swarmClient, *swarmingServer)
+		backend = localBackend
+	case BACKEND_SWARMING:
+		// Initialize Isolate client.
+		isolateServerUrl := *isolateServer
+		if *local {
+			isolateServerUrl = isolate.ISOLATE_SERVER_URL_FAKE
+		}
+		isolateClient, err := isolate.NewClient(wdAbs, isolateServerUrl)
 		if err != nil {
 			sklog.Fatal(err)
 		}
+
+		var swarm swarming.ApiClient
+		if *local {
+			swarmTestClient := testutils.NewTestClient()
+			swarmTestClient.MockBots(testutils.MockSwarmingBotsForAllTasksForTesting(ctx, repos))
+			go testutils.PeriodicallyUpdateMockTasksForTesting(swarmTestClient)
+			swarm = swarmTestClient
+		} else {
+			tp := httputils.NewBackOffTransport().(*httputils.BackOffTransport)
+			tp.Transport.Dial = func(network, addr string) (net.Conn, error) {
+				return net.DialTimeout(network, addr, 3*time.Minute)
+			}
+			swarmClient, err := auth.NewClientWithTransport(*local, oauthCacheFile, "", tp, swarming.AUTH_SCOPE)
+			if err != nil {
+				sklog.Fatal(err)
+			}
+			swarm, err = swarming.NewApiClient(swarmClient, *swarmingServer)
+			if err != nil {
+				sklog.Fatal(err)
+			}
+		}
+		backend = scheduling.NewSwarmingBackend(isolateClient, swarm)
+	default:
+		sklog.Fatalf("Unknown --backend %q", *backendType)
 	}
 
 	// Start DB backup.
@@ -730,13 +888,45 @@ func main() {
 	if *local {
 		serverURL = "http://" + *host + *port
 	}
-	if err := swarming.InitPubSub(serverURL, *pubsubTopicName, *pubsubSubscriberName); err != nil {
-		sklog.Fatal(err)
+	if localBackend == nil {
+		if err := swarming.InitPubSub(serverURL, *pubsubTopicName, *pubsubSubscriberName); err != nil {
+			sklog.Fatal(err)
+		}
 	}
-	ts, err = scheduling.NewTaskScheduler(ctx, tsDb, period, *commitWindow, wdAbs, serverURL, repos, isolateClient, swarm, httpClient, *scoreDecay24Hr, tryjobs.API_URL_PROD, *tryJobBucket, common.PROJECT_REPO_MAPPING, *swarmingPools, *pubsubTopicName, depotTools, gerrit)
+	ts, err = scheduling.NewTaskScheduler(ctx, tsDb, period, *commitWindow, wdAbs, serverURL, repos, backend, httpClient, *scoreDecay24Hr, tryjobs.API_URL_PROD, *tryJobBucket, common.PROJECT_REPO_MAPPING, *swarmingPools, *pubsubTopicName, depotTools, gerrit)
 	if err != nil {
 		sklog.Fatal(err)
 	}
+	if localBackend != nil {
+		localBackend.SetPubSubHandler(ts)
+	}
+	if *fairShareCfg != "" {
+		cfg, err := scheduling.ReadFairShareConfig(*fairShareCfg)
+		if err != nil {
+			sklog.Fatal(err)
+		}
+		ts.SetFairShareConfig(cfg)
+	}
+	if *flakeQuarantineThreshold > 0.0 {
+		cfg := &scheduling.FlakeQuarantineConfig{
+			Threshold: *flakeQuarantineThreshold,
+			MinTasks:  *flakeQuarantineMinTasks,
+			Duration:  *flakeQuarantineDuration,
+		}
+		if err := cfg.Validate(); err != nil {
+			sklog.Fatal(err)
+		}
+		ts.SetFlakeQuarantineConfig(cfg)
+	}
+	// Notifying owners of expired blacklist rules is optional. Expired rules
+	// are still removed if GMail is not available.
+	if !*local {
+		if gmail, err := newGMail(user.HomeDir); err != nil {
+			sklog.Errorf("Failed to set up GMail; owners of expired blacklist rules will not be notified: %s", err)
+		} else {
+			ts.SetBlacklistExpiryNotifier(blacklistExpiryEmailer(gmail, serverURL))
+		}
+	}
 
 	sklog.Infof("Created task scheduler. Starting loop.")
 	ts.Start(ctx, b.Tick)
@@ -24,6 +24,9 @@ type TestClient struct {
 
 	triggerFailure map[string]bool
 	triggerMtx     sync.Mutex
+
+	canceled    []string
+	canceledMtx sync.Mutex
 }
 
 func NewTestClient() *TestClient {
@@ -151,9 +154,19 @@ func (c *TestClient) ListTaskResults(start, end time.Time, tags []string, state
 }
 
 func (c *TestClient) CancelTask(id string) error {
+	c.canceledMtx.Lock()
+	defer c.canceledMtx.Unlock()
+	c.canceled = append(c.canceled, id)
 	return nil
 }
 
+// CanceledTasks returns the IDs of the tasks passed to CancelTask, in order.
+func (c *TestClient) CanceledTasks() []string {
+	c.canceledMtx.Lock()
+	defer c.canceledMtx.Unlock()
+	return util.CopyStringSlice(c.canceled)
+}
+
 // md5Tags returns a MD5 hash of the task tags, excluding task ID.
 func md5Tags(tags []string) string {
 	filtered := make([]string, 0, len(tags))
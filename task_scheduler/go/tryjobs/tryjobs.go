@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -342,6 +343,23 @@ func (t *TryJobIntegrator) tryLeaseBuild(id int64, now time.Time) (int64, error)
 	return resp.Build.LeaseKey, nil
 }
 
+// getPatchFiles returns the paths of the files changed by the given patch.
+func (t *TryJobIntegrator) getPatchFiles(issue int64, patchset string) ([]string, error) {
+	files, err := t.gerrit.GetFileNames(issue, patchset)
+	if err != nil {
+		return nil, err
+	}
+	rv := make([]string, 0, len(files))
+	for _, f := range files {
+		// Skip Gerrit's magic files, eg. "/COMMIT_MSG".
+		if !strings.HasPrefix(f, "/") {
+			rv = append(rv, f)
+		}
+	}
+	sort.Strings(rv)
+	return rv, nil
+}
+
 func (t *TryJobIntegrator) getJobToSchedule(ctx context.Context, b *buildbucket_api.ApiCommonBuildMessage, now time.Time) (*db.Job, error) {
 	// Parse the build parameters.
 	var params buildbucket.Parameters
@@ -381,8 +399,16 @@ func (t *TryJobIntegrator) getJobToSchedule(ctx context.Context, b *buildbucket_
 		return nil, t.remoteCancelBuild(b.Id, fmt.Sprintf("Invalid RepoState: %s", rs))
 	}
 
-	// Create a Job.
-	j, err := t.taskCfgCache.MakeJob(ctx, rs, params.BuilderName)
+	// Create a Job. Path filters are relative to the top-level repo, so
+	// they are only applied when the patch is for that repo.
+	var j *db.Job
+	if patchRepoUrl == topRepoUrl {
+		j, err = t.taskCfgCache.MakeJobForChangedFiles(ctx, rs, params.BuilderName, func() ([]string, error) {
+			return t.getPatchFiles(int64(issue), patchset)
+		})
+	} else {
+		j, err = t.taskCfgCache.MakeJob(ctx, rs, params.BuilderName)
+	}
 	if err != nil {
 		return nil, t.remoteCancelBuild(b.Id, fmt.Sprintf("Failed to obtain JobSpec: %s; \n\n%v", err, params))
 	}
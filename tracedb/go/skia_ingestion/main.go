@@ -7,11 +7,15 @@ import (
 	"context"
 	"flag"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"strings"
 	"time"
 
+	gstorage "cloud.google.com/go/storage"
+	"github.com/gorilla/mux"
 	"google.golang.org/api/option"
 	storage "google.golang.org/api/storage/v1"
 
@@ -25,7 +29,8 @@ import (
 	"go.skia.org/infra/go/sharedconfig"
 	"go.skia.org/infra/go/sklog"
 	"go.skia.org/infra/go/util"
-	_ "go.skia.org/infra/golden/go/goldingestion"
+	goldconfig "go.skia.org/infra/golden/go/config"
+	"go.skia.org/infra/golden/go/goldingestion"
 	_ "go.skia.org/infra/golden/go/pdfingestion"
 )
 
@@ -39,6 +44,13 @@ var (
 	projectID          = flag.String("project_id", common.PROJECT_ID, "GCP project ID.")
 	promPort           = flag.String("prom_port", ":20000", "Metrics service address (e.g., ':10110')")
 	serviceAccountFile = flag.String("service_account_file", "", "Credentials file for service account.")
+
+	// Flags for uploading Gold results via HTTP.
+	pushPort             = flag.String("push_port", "", "HTTP service address to accept uploads of Gold results (e.g., ':8000'). If empty uploads are disabled.")
+	pushAuthorizedEmails = flag.String("push_authorized_emails", "", "Comma-separated list of service accounts that are allowed to upload results. Ignored if local is true.")
+	pushGSBucket         = flag.String("push_gs_bucket", "skia-infra-gm", "Google storage bucket where uploaded images are stored.")
+	pushGSDir            = flag.String("push_gs_dir", "dm-images-v1", "Directory in push_gs_bucket where uploaded images are stored.")
+	pushImageDir         = flag.String("push_image_dir", "", "Local directory where uploaded images are stored. If set, push_gs_bucket is not used.")
 )
 
 func main() {
@@ -107,6 +119,11 @@ func main() {
 		oneIngester.Start(ctx)
 	}
 
+	// Accept uploads of Gold results if requested.
+	if *pushPort != "" {
+		startPushServer(ctx, ingesters, client)
+	}
+
 	// Enable the memory profiler if memProfile was set.
 	if *memProfile > 0 {
 		writeProfileFn := func() {
@@ -132,3 +149,45 @@ func main() {
 	// Run the ingesters forever.
 	select {}
 }
+
+// startPushServer serves the HTTP endpoint that accepts uploads of Gold
+// results and feeds them to the Gold ingester.
+func startPushServer(ctx context.Context, ingesters []*ingestion.Ingester, client *http.Client) {
+	var goldIngester *ingestion.Ingester
+	for _, oneIngester := range ingesters {
+		if oneIngester.ID() == goldconfig.CONSTRUCTOR_GOLD {
+			goldIngester = oneIngester
+		}
+	}
+	if goldIngester == nil {
+		sklog.Fatalf("Uploading results requires the '%s' ingester.", goldconfig.CONSTRUCTOR_GOLD)
+	}
+
+	var imageStore goldingestion.ImageStore
+	if *pushImageDir != "" {
+		var err error
+		if imageStore, err = goldingestion.NewFileImageStore(*pushImageDir); err != nil {
+			sklog.Fatalf("Unable to create image directory %s: %s", *pushImageDir, err)
+		}
+	} else {
+		storageClient, err := gstorage.NewClient(ctx, option.WithHTTPClient(client))
+		if err != nil {
+			sklog.Fatalf("Unable to create storage client: %s", err)
+		}
+		imageStore = goldingestion.NewGCSImageStore(storageClient, *pushGSBucket, *pushGSDir)
+	}
+
+	authFn := goldingestion.BearerTokenAuth(strings.Split(*pushAuthorizedEmails, ","))
+	if *local {
+		authFn = func(r *http.Request) (string, error) {
+			return "local", nil
+		}
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/push", goldingestion.NewPushHandler(goldIngester, imageStore, authFn)).Methods("POST")
+	go func() {
+		sklog.Infof("Accepting uploads on http://127.0.0.1%s/push", *pushPort)
+		sklog.Fatal(http.ListenAndServe(*pushPort, router))
+	}()
+}